@@ -4,9 +4,6 @@ import (
 	"backend/helper"
 	"context"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // AdminStats represents dashboard statistics for admin
@@ -17,6 +14,7 @@ type AdminStats struct {
 	TotalHospitals    int `json:"totalHospitals"`
 	TotalPatients     int `json:"totalPatients"`
 	CancelRequests    int `json:"cancelRequests"`
+	LateCancellations int `json:"lateCancellations"`
 }
 
 // AdminUser represents an admin user
@@ -30,70 +28,68 @@ type AdminUser struct {
 	LastName  string    `bson:"lastName" json:"lastName"`
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	Tombstone `bson:",inline"`
 }
 
 // GetDashboardStats retrieves statistics for the admin dashboard
-func GetDashboardStats(client *mongo.Client) (AdminStats, error) {
+func GetDashboardStats(ctx context.Context, app *App) (AdminStats, error) {
 	var stats AdminStats
 
 	// Get total appointments
-	appointmentsCollection := client.Database("healthcare").Collection("appointments")
-	totalAppointments, err := appointmentsCollection.CountDocuments(context.TODO(), bson.D{})
+	totalAppointments, err := app.Store.Appointments.Count(ctx)
 	if err != nil {
 		return stats, err
 	}
 	stats.TotalAppointments = int(totalAppointments)
 
 	// Get today's appointments
-	today := time.Now().Format("2006-01-02")
-	todayFilter := bson.D{{Key: "appointmentTime.date", Value: today}}
-	todayAppointments, err := appointmentsCollection.CountDocuments(context.TODO(), todayFilter)
+	today := time.Now().In(defaultLocation(app)).Format("2006-01-02")
+	todayAppointments, err := app.Store.Appointments.CountByDate(ctx, today)
 	if err != nil {
 		return stats, err
 	}
 	stats.TodayAppointments = int(todayAppointments)
 
 	// Get total doctors
-	doctorsCollection := client.Database("healthcare").Collection("doctors")
-	totalDoctors, err := doctorsCollection.CountDocuments(context.TODO(), bson.D{})
+	totalDoctors, err := app.Store.Doctors.Count(ctx)
 	if err != nil {
 		return stats, err
 	}
 	stats.TotalDoctors = int(totalDoctors)
 
 	// Get total hospitals
-	hospitalsCollection := client.Database("healthcare").Collection("hospitals")
-	totalHospitals, err := hospitalsCollection.CountDocuments(context.TODO(), bson.D{})
+	totalHospitals, err := app.Store.Hospitals.Count(ctx)
 	if err != nil {
 		return stats, err
 	}
 	stats.TotalHospitals = int(totalHospitals)
 
 	// Get total patients (users with role 'patient')
-	usersCollection := client.Database("users").Collection("users")
-	patientFilter := bson.D{{Key: "role", Value: "patient"}}
-	totalPatients, err := usersCollection.CountDocuments(context.TODO(), patientFilter)
+	totalPatients, err := app.Store.Users.CountByRole(ctx, "patient")
 	if err != nil {
 		return stats, err
 	}
 	stats.TotalPatients = int(totalPatients)
 
 	// Get cancel requests
-	requestsCollection := client.Database("healthcare").Collection("requests")
-	pendingFilter := bson.D{{Key: "status", Value: "pending"}}
-	cancelRequests, err := requestsCollection.CountDocuments(context.TODO(), pendingFilter)
+	cancelRequests, err := app.Store.Requests.CountByStatus(ctx, RequestPending)
 	if err != nil {
 		return stats, err
 	}
 	stats.CancelRequests = int(cancelRequests)
 
+	// Get cancellations inside the cancellation window
+	late, err := ListAppointments(ctx, app, AppointmentFilter{LateCancelled: true}, ListOptions{Limit: 1})
+	if err != nil {
+		return stats, err
+	}
+	stats.LateCancellations = int(late.Total)
+
 	return stats, nil
 }
 
 // CreateAdminUser creates a new admin user
-func CreateAdminUser(client *mongo.Client, adminData AdminUser) error {
-	collection := client.Database("users").Collection("users")
-
+func CreateAdminUser(ctx context.Context, app *App, adminData AdminUser) error {
 	// Hash the password
 	hashedPassword, err := HashPassword(adminData.Password)
 	if err != nil {
@@ -106,75 +102,83 @@ func CreateAdminUser(client *mongo.Client, adminData AdminUser) error {
 	adminData.Role = "admin"
 	adminData.CreatedAt = time.Now()
 	adminData.UpdatedAt = time.Now()
+	adminData.Tombstone = Tombstone{}
 
-	_, err = collection.InsertOne(context.TODO(), adminData)
-	return err
+	if err := app.Store.Users.InsertAdmin(ctx, adminData); err != nil {
+		return err
+	}
+	recordAudit(ctx, app, AuditCreate, TargetUser, adminData.UserCode, nil, adminData)
+	return nil
 }
 
 // GetAllAdminUsers retrieves all admin users
-func GetAllAdminUsers(client *mongo.Client) ([]AdminUser, error) {
-	collection := client.Database("users").Collection("users")
-	filter := bson.D{{Key: "role", Value: "admin"}}
-
-	cursor, err := collection.Find(context.TODO(), filter)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.TODO())
-
-	var admins []AdminUser
-	if err = cursor.All(context.TODO(), &admins); err != nil {
-		return nil, err
-	}
-
-	return admins, nil
+func GetAllAdminUsers(ctx context.Context, app *App) ([]AdminUser, error) {
+	return app.Store.Users.ListAdmins(ctx)
 }
 
 // UpdateAdminUser updates an admin user
-func UpdateAdminUser(client *mongo.Client, userCode string, adminData AdminUser) error {
-	collection := client.Database("users").Collection("users")
-
-	filter := bson.D{{Key: "userCode", Value: userCode}}
-	update := bson.M{
-		"$set": bson.M{
-			"email":     adminData.Email,
-			"firstName": adminData.FirstName,
-			"lastName":  adminData.LastName,
-			"updatedAt": time.Now(),
-		},
-	}
-
+func UpdateAdminUser(ctx context.Context, app *App, userCode string, adminData AdminUser) error {
 	// Only update password if provided
 	if adminData.Password != "" {
 		hashedPassword, err := HashPassword(adminData.Password)
 		if err != nil {
 			return err
 		}
-		update["$set"].(bson.M)["password"] = hashedPassword
+		adminData.Password = hashedPassword
 	}
 
-	_, err := collection.UpdateOne(context.TODO(), filter, update)
-	return err
+	before, err := getAdminUser(ctx, app, userCode)
+	if err != nil {
+		return err
+	}
+	if err := app.Store.Users.UpdateAdmin(ctx, userCode, adminData); err != nil {
+		return err
+	}
+
+	after := *before
+	after.Email = adminData.Email
+	after.FirstName = adminData.FirstName
+	after.LastName = adminData.LastName
+	if adminData.Password != "" {
+		after.Password = adminData.Password
+	}
+	recordAudit(ctx, app, AuditUpdate, TargetUser, userCode, before, after)
+	return nil
 }
 
 // DeleteAdminUser deletes an admin user
-func DeleteAdminUser(client *mongo.Client, userCode string) error {
-	collection := client.Database("users").Collection("users")
-	filter := bson.D{
-		{Key: "userCode", Value: userCode},
-		{Key: "role", Value: "admin"},
+func DeleteAdminUser(ctx context.Context, app *App, userCode string) error {
+	before, err := getAdminUser(ctx, app, userCode)
+	if err != nil {
+		return err
+	}
+	if err := app.Store.Users.DeleteAdmin(ctx, userCode); err != nil {
+		return err
 	}
+	recordAudit(ctx, app, AuditDelete, TargetUser, userCode, before, nil)
+	return nil
+}
 
-	_, err := collection.DeleteOne(context.TODO(), filter)
-	return err
+// getAdminUser finds one admin among the few there are
+func getAdminUser(ctx context.Context, app *App, userCode string) (*AdminUser, error) {
+	admins, err := app.Store.Users.ListAdmins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, admin := range admins {
+		if admin.UserCode == userCode {
+			return &admin, nil
+		}
+	}
+	return nil, ErrNotFound
 }
 
 // GetSystemHealth returns system health information
-func GetSystemHealth(client *mongo.Client) (map[string]interface{}, error) {
+func GetSystemHealth(ctx context.Context, app *App) (map[string]interface{}, error) {
 	health := make(map[string]interface{})
 
 	// Check database connection
-	err := client.Ping(context.TODO(), nil)
+	err := app.Store.Ping(ctx)
 	if err != nil {
 		health["database"] = "disconnected"
 		health["status"] = "unhealthy"
@@ -186,7 +190,7 @@ func GetSystemHealth(client *mongo.Client) (map[string]interface{}, error) {
 	health["timestamp"] = time.Now()
 
 	// Get database stats
-	stats, err := GetDashboardStats(client)
+	stats, err := GetDashboardStats(ctx, app)
 	if err == nil {
 		health["stats"] = stats
 	}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"backend/config"
+	"backend/google"
+	"backend/helper"
+	"log"
+)
+
+// App bundles the dependencies shared by the api functions.
+// Calendar, Mailer and Notifier are optional, a nil value disables the integration.
+type App struct {
+	Store    *Store
+	Config   *config.Config
+	Calendar *google.GoogleCalendarService
+	Mailer   *helper.Mailer
+	Notifier Notifier
+}
+
+// Notifier pushes real-time messages to the connected clients of a user
+type Notifier interface {
+	SendToUser(userCode string, message []byte)
+}
+
+// NewApp wires the store and the external services described by cfg
+func NewApp(cfg *config.Config, store *Store) *App {
+	app := &App{
+		Store:  store,
+		Config: cfg,
+		Mailer: helper.NewMailer(cfg.Mail),
+	}
+
+	// Initialize Google Calendar service if enabled
+	if cfg.Google.Enabled {
+		calendarService, err := google.NewGoogleCalendarService(cfg.Google)
+		if err != nil {
+			log.Println("Failed to initialize Google Calendar service:", err)
+		} else {
+			app.Calendar = calendarService
+			log.Println("Google Calendar service initialized successfully")
+		}
+	}
+
+	return app
+}
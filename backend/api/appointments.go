@@ -1,27 +1,43 @@
 package api
 
 import (
-	"backend/google"
 	"backend/helper"
 	"context"
 	"errors"
+	"fmt"
 	"log"
-	"os"
 	"sort"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Appointment struct {
-	AppointmentCode string          `bson:"appointmentCode" json:"appointmentCode"`
+	AppointmentCode string `bson:"appointmentCode" json:"appointmentCode"`
+	// AppointmentTime is the wall clock time in TimeZone, StartsAt the same instant in UTC
 	AppointmentTime AppointmentTime `bson:"appointmentTime" json:"appointmentTime"`
-	DoctorCode      string          `bson:"doctorCode" json:"doctorCode"`
-	UserCode        string          `bson:"userCode" json:"userCode"`
-	CalendarEventID string          `bson:"calendarEventID,omitempty" json:"calendarEventID,omitempty"`
-	CreatedAt       time.Time       `bson:"createdAt" json:"createdAt"`
-	UpdatedAt       time.Time       `bson:"updatedAt" json:"updatedAt"`
+	StartsAt        time.Time       `bson:"startsAt" json:"startsAt"`
+	// TimeZone is the IANA zone of the doctor's hospital when the appointment was booked
+	TimeZone        string `bson:"timeZone" json:"timeZone"`
+	DoctorCode      string `bson:"doctorCode" json:"doctorCode"`
+	UserCode        string `bson:"userCode" json:"userCode"`
+	CalendarEventID string `bson:"calendarEventID,omitempty" json:"calendarEventID,omitempty"`
+	// Duration is the length in minutes, fixed when the appointment is booked
+	Duration      int               `bson:"duration,omitempty" json:"duration,omitempty"`
+	Status        AppointmentStatus `bson:"status" json:"status"`
+	StatusHistory []StatusChange    `bson:"statusHistory" json:"statusHistory"`
+	// Conflict is set when a blackout added after booking falls on the appointment
+	Conflict *ScheduleConflict `bson:"conflict,omitempty" json:"conflict,omitempty"`
+	// SeriesCode links the occurrences of a recurring series
+	SeriesCode string `bson:"seriesCode,omitempty" json:"seriesCode,omitempty"`
+	// CancelledLate is set when the patient cancelled inside the cancellation window
+	CancelledLate bool `bson:"cancelledLate,omitempty" json:"cancelledLate,omitempty"`
+	// HoldCode names the slot hold a new booking takes over, it is not stored
+	HoldCode string `bson:"-" json:"holdCode,omitempty"`
+	// EncounterNote is the doctor's note of a past visit, set when the reader may see it
+	EncounterNote *EncounterNote `bson:"-" json:"encounterNote,omitempty"`
+	CreatedAt     time.Time      `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time      `bson:"updatedAt" json:"updatedAt"`
+	Version       int64          `bson:"version" json:"version"`
+	Tombstone     `bson:",inline"`
 }
 
 type AppointmentTime struct {
@@ -58,271 +74,433 @@ type EnhancedAppointment struct {
 	UpdatedAt        time.Time `json:"updatedAt"`
 }
 
-var calendarService *google.GoogleCalendarService
-var useGoogleCalendar = false
-
-func init() {
-	// Initialize Google Calendar service if enabled
-	if os.Getenv("USE_GOOGLE_CALENDAR") == "true" {
-		var err error
-		calendarService, err = google.NewGoogleCalendarService()
-		if err != nil {
-			log.Println("Failed to initialize Google Calendar service:", err)
-		} else {
-			useGoogleCalendar = true
-			log.Println("Google Calendar service initialized successfully")
-		}
+// CreateAppointment books the appointment and returns it with its generated code.
+// A *SlotConflictError is returned when the slot is already taken and a
+// *PolicyViolation when the booking breaks the booking policy. An appointment with a
+// HoldCode takes over the slot of that hold, ErrHoldExpired or ErrHoldMismatch is
+// returned when the hold does not keep the slot for the patient. A matching hold is
+// used up even when the booking fails.
+func CreateAppointment(ctx context.Context, app *App, appointment Appointment) (*Appointment, error) {
+	if appointment.HoldCode == "" {
+		return createAppointment(ctx, app, appointment, "")
 	}
-}
 
-// CheckUserAppointmentLimit validates if a user can make a new appointment
-// Users are limited to 3 appointments per week
-func CheckUserAppointmentLimit(client *mongo.Client, userCode string) error {
-	// Calculate the date range for the last 7 days
-	now := time.Now()
-	oneWeekAgo := now.AddDate(0, 0, -7)
-
-	// Get user's appointments from the last 7 days
-	collection := client.Database("healthcare").Collection("appointments")
-
-	// Create filter for appointments in the last week
-	filter := bson.M{
-		"userCode": userCode,
-		"createdAt": bson.M{
-			"$gte": oneWeekAgo,
-		},
+	hold, err := takeHold(ctx, app, appointment)
+	if err != nil {
+		return nil, err
 	}
-
-	cursor, err := collection.Find(context.TODO(), filter)
+	appointment.HoldCode = ""
+	appointment.Duration = hold.Minutes
+	created, err := createAppointment(ctx, app, appointment, slotHolderCode(hold.HoldCode))
 	if err != nil {
-		log.Printf("Error querying appointments for user %s: %v", userCode, err)
-		return err
+		// Without a booking the held slot is free for everybody again
+		releaseLocks(ctx, app, slotLocksFor(hold.heldAppointment()), hold.DoctorCode, hold.AppointmentTime)
+		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	return created, nil
+}
 
-	var recentAppointments []Appointment
-	err = cursor.All(context.TODO(), &recentAppointments)
+// createAppointment books the appointment. A non-empty heldBy names the holder of a
+// slot hold that the appointment takes over instead of claiming a free slot.
+func createAppointment(ctx context.Context, app *App, appointment Appointment, heldBy string) (*Appointment, error) {
+	b, err := newBooking(ctx, app, appointment, heldBy != "")
 	if err != nil {
-		log.Printf("Error reading appointments for user %s: %v", userCode, err)
-		return err
+		return nil, err
 	}
 
-	appointmentCount := len(recentAppointments)
-	log.Printf("User %s has %d appointments in the last 7 days", userCode, appointmentCount)
+	// Reserve the slot first so a losing request never reaches the calendar or the database
+	if heldBy != "" {
+		err = app.Store.Slots.Transfer(ctx, slotLocksFor(b.appointment)[0], heldBy)
+	} else {
+		err = claimSlot(ctx, app, b.doctor, b.appointment)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	// Check if user has reached the limit
-	if appointmentCount >= 3 {
-		return errors.New("appointment limit exceeded: you can only have 3 appointments per week. Please wait before scheduling another appointment")
+	if err := b.save(ctx, app); err != nil {
+		return nil, err
 	}
+	b.confirm(ctx, app)
+	return &b.appointment, nil
+}
 
-	return nil
+// booking is a new appointment with the records its calendar event and email need
+type booking struct {
+	appointment Appointment
+	user        *User
+	doctor      *Doctor
+	hospital    *Hospital
 }
 
-func CreateAppointment(client *mongo.Client, appointment Appointment) error {
-	// Check appointment limit before proceeding
-	err := CheckUserAppointmentLimit(client, appointment.UserCode)
-	if err != nil {
-		log.Printf("Appointment limit check failed for user %s: %v", appointment.UserCode, err)
-		return err
+// newBooking fills in the fields of a new appointment and checks it against the
+// working hours of the doctor and the booking policy. held keeps the length of an
+// appointment that takes over a held slot.
+func newBooking(ctx context.Context, app *App, appointment Appointment, held bool) (*booking, error) {
+	if err := validSlotTime(appointment.AppointmentTime); err != nil {
+		return nil, err
 	}
 
-	collection := client.Database("healthcare").Collection("appointments")
 	appointment.AppointmentCode = helper.GenerateID(8)
 	appointment.CreatedAt = time.Now()
 	appointment.UpdatedAt = time.Now()
+	appointment.Version = 0
+	appointment.Status = StatusBooked
+	appointment.StatusHistory = []StatusChange{{To: StatusBooked, At: appointment.CreatedAt.UTC().Truncate(time.Millisecond), By: appointment.UserCode}}
+	appointment.Conflict = nil
+	appointment.Tombstone = Tombstone{}
 
 	// Get user and doctor details for email and calendar
-	user, err := GetUser(client, appointment.UserCode)
+	user, err := GetUser(ctx, app, appointment.UserCode)
 	if err != nil {
 		log.Println("Error getting user:", err)
-		return err
+		return nil, err
 	}
 
-	doctor, err := GetDoctor(client, appointment.DoctorCode)
+	doctor, err := GetDoctor(ctx, app, appointment.DoctorCode)
 	if err != nil {
 		log.Println("Error getting doctor:", err)
-		return err
+		return nil, err
 	}
 
-	hospital, err := GetHospital(client, doctor.HospitalCode)
+	hospital, err := GetHospital(ctx, app, doctor.HospitalCode)
 	if err != nil {
 		log.Println("Error getting hospital:", err)
-		return err
+		return nil, err
+	}
+	if err := appointment.placeIn(app, hospitalZone(app, hospital)); err != nil {
+		return nil, err
+	}
+	if !isFuture(app, appointment, time.Now()) {
+		return nil, fmt.Errorf("%w: %s %s is in the past", ErrInvalidSlot, appointment.AppointmentTime.Date, appointment.AppointmentTime.Time)
 	}
 
+	// A held slot keeps the length it was held with
+	if !held || appointment.Duration == 0 {
+		appointment.Duration = slotLength(app, doctor)
+	}
+	if err := checkWorkingTime(ctx, app, doctor, appointment); err != nil {
+		return nil, err
+	}
+	if err := checkBookingPolicy(ctx, app, appointment, doctor, ""); err != nil {
+		log.Printf("Booking policy check failed for user %s: %v", appointment.UserCode, err)
+		return nil, err
+	}
+	return &booking{appointment: appointment, user: user, doctor: doctor, hospital: hospital}, nil
+}
+
+// save adds the appointment, whose slot is already claimed, to Google Calendar and
+// stores it. The slot is released when it cannot be stored.
+func (b *booking) save(ctx context.Context, app *App) error {
 	// Add to Google Calendar if enabled
-	if useGoogleCalendar {
-		// Parse date and time
-		dateTime := appointment.AppointmentTime.Date + "T" + appointment.AppointmentTime.Time + ":00"
-		startTime, err := time.Parse("2006-01-02T15:04:05", dateTime)
+	if app.Calendar != nil {
+		startTime, endTime, err := calendarSpan(app, b.appointment)
 		if err != nil {
 			log.Println("Error parsing appointment time:", err)
 		} else {
-			// Calculate end time (adding 15 minutes to start time)
-			endTime := startTime.Add(15 * time.Minute)
-
 			// Create calendar event
-			calendarID := os.Getenv("GOOGLE_CALENDAR_ID")
-			if calendarID == "" {
-				calendarID = "primary"
-			}
+			summary := "Medical Appointment with Dr. " + b.doctor.DoctorName
+			description := "Patient: " + b.user.UserCode + "\nDoctor: " + b.doctor.DoctorName
+			location := b.hospital.HospitalName
 
-			summary := "Medical Appointment with Dr. " + doctor.DoctorName
-			description := "Patient: " + user.UserCode + "\nDoctor: " + doctor.DoctorName
-			location := hospital.HospitalName
-
-			event, err := calendarService.AddAppointmentToCalendar(calendarID, summary, description, location, startTime, endTime)
+			event, err := app.Calendar.AddAppointmentToCalendar(ctx, app.Config.Google.CalendarID, summary, description, location, startTime, endTime)
 			if err != nil {
 				log.Println("Error adding to Google Calendar:", err)
 			} else {
 				// Store event ID in appointment
-				appointment.CalendarEventID = event.Id
+				b.appointment.CalendarEventID = event.Id
 				log.Println("Added appointment to Google Calendar, EventID:", event.Id)
 			}
 		}
 	}
 
 	// Save appointment to database
-	_, err = collection.InsertOne(context.TODO(), appointment)
+	err := app.Store.Appointments.Insert(ctx, b.appointment)
 	if err != nil {
+		// The request may already be cancelled, the slot must be freed regardless
+		if releaseErr := app.Store.Slots.Release(context.WithoutCancel(ctx), slotLocksFor(b.appointment)); releaseErr != nil {
+			log.Println("Error releasing slot:", releaseErr)
+		}
 		return err
 	}
+	recordAudit(ctx, app, AuditCreate, TargetAppointment, b.appointment.AppointmentCode, nil, b.appointment)
+	return nil
+}
 
-	// Format date for display
-	displayDate := appointment.AppointmentTime.Date
-	if t, err := time.Parse("2006-01-02", appointment.AppointmentTime.Date); err == nil {
-		displayDate = t.Format("02/01/2006") // DD/MM/YYYY format
+// confirm emails the patient the booked appointment
+func (b *booking) confirm(ctx context.Context, app *App) {
+	if app.Mailer == nil {
+		return
 	}
-
-	// Send email notification using our MailerSend service
-	err = helper.SendAppointmentConfirmationEmail(
-		user.Email,
-		user.UserCode, // Using UserCode since we don't have a separate name field
-		doctor.DoctorName,
-		hospital.HospitalName,
-		displayDate,
-		appointment.AppointmentTime.Time,
+	// The appointment is saved, so the email must not be cut short if the client goes away
+	err := app.Mailer.SendAppointmentConfirmationEmail(
+		context.WithoutCancel(ctx),
+		b.user.Email,
+		b.user.UserCode, // Using UserCode since we don't have a separate name field
+		b.doctor.DoctorName,
+		b.hospital.HospitalName,
+		displayDate(b.appointment.AppointmentTime.Date),
+		b.appointment.AppointmentTime.Time,
 	)
 	if err != nil {
 		log.Println("Error sending email notification:", err)
 		// Continue despite email error - don't fail the appointment creation
 	} else {
-		log.Println("Appointment confirmation email sent successfully to:", user.Email)
+		log.Println("Appointment confirmation email sent successfully to:", b.user.Email)
 	}
+}
 
-	return nil
+// calendarSpan returns the start and end of the calendar event of the appointment, in
+// the time zone of the appointment
+func calendarSpan(app *App, appointment Appointment) (time.Time, time.Time, error) {
+	startTime, err := appointment.Start(app)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return startTime, startTime.Add(time.Duration(appointmentMinutes(appointment)) * time.Minute), nil
 }
 
-func DeleteAppointment(client *mongo.Client, appointmentCode string) error {
+// DeleteAppointment soft deletes the appointment, frees its slot and notifies the patient.
+// An appointment that was already cancelled or is over is only deleted.
+func DeleteAppointment(ctx context.Context, app *App, appointmentCode, deletedBy string) error {
 	// Get appointment details before deletion
-	appointment, err := GetAppointment(client, appointmentCode)
+	appointment, err := GetAppointment(ctx, app, appointmentCode)
 	if err != nil {
 		return err
 	}
+	open := !appointment.CurrentStatus().IsFinal()
+	if open {
+		removeCalendarEvent(ctx, app, appointment)
+	}
 
-	// Get user and doctor details for email
-	user, err := GetUser(client, appointment.UserCode)
+	// Now delete the appointment
+	tombstone := newTombstone(deletedBy)
+	err = app.Store.Appointments.SoftDelete(ctx, appointmentCode, tombstone)
 	if err != nil {
-		log.Println("Error getting user:", err)
-		// Continue despite error
+		return err
 	}
+	deleted := *appointment
+	deleted.Tombstone = tombstone
+	recordAudit(ctx, app, AuditDelete, TargetAppointment, appointmentCode, appointment, deleted)
+
+	if open {
+		releaseSlot(ctx, app, appointment)
+		notifyCancellation(ctx, app, appointment)
+	}
+	return nil
+}
 
-	doctor, err := GetDoctor(client, appointment.DoctorCode)
+// removeCalendarEvent takes the appointment off Google Calendar when it is there
+func removeCalendarEvent(ctx context.Context, app *App, appointment *Appointment) {
+	if app.Calendar == nil || appointment.CalendarEventID == "" {
+		return
+	}
+	err := app.Calendar.DeleteAppointmentFromCalendar(ctx, app.Config.Google.CalendarID, appointment.CalendarEventID)
 	if err != nil {
-		log.Println("Error getting doctor:", err)
-		// Continue despite error
+		log.Println("Error removing from Google Calendar:", err)
+	} else {
+		log.Println("Removed appointment from Google Calendar, EventID:", appointment.CalendarEventID)
 	}
+}
 
-	// Remove from Google Calendar if enabled
-	if useGoogleCalendar && appointment.CalendarEventID != "" {
-		calendarID := os.Getenv("GOOGLE_CALENDAR_ID")
-		if calendarID == "" {
-			calendarID = "primary"
-		}
+// releaseSlot frees the slot of the appointment for other patients, even if the
+// request was cancelled meanwhile, and offers it to the waitlist
+func releaseSlot(ctx context.Context, app *App, appointment *Appointment) {
+	releaseLocks(ctx, app, slotLocksFor(*appointment), appointment.DoctorCode, appointment.AppointmentTime)
+}
 
-		err := calendarService.DeleteAppointmentFromCalendar(calendarID, appointment.CalendarEventID)
-		if err != nil {
-			log.Println("Error removing from Google Calendar:", err)
-		} else {
-			log.Println("Removed appointment from Google Calendar, EventID:", appointment.CalendarEventID)
-		}
+// releaseLocks frees locks and offers the slot of the doctor at the given time to the waitlist
+func releaseLocks(ctx context.Context, app *App, locks []SlotLock, doctorCode string, at AppointmentTime) {
+	if len(locks) == 0 {
+		return
 	}
+	if err := app.Store.Slots.Release(context.WithoutCancel(ctx), locks); err != nil {
+		log.Println("Error releasing slot:", err)
+		return
+	}
+	offerFreedSlot(ctx, app, doctorCode, at)
+}
 
-	// Now delete the appointment
-	collection := client.Database("healthcare").Collection("appointments")
-	_, err = collection.DeleteOne(context.TODO(), bson.M{"appointmentCode": appointmentCode})
+// notifyCancellation emails the patient that the appointment was cancelled
+func notifyCancellation(ctx context.Context, app *App, appointment *Appointment) {
+	if app.Mailer == nil {
+		return
+	}
+
+	// The user, doctor or hospital may be on their way out themselves
+	user, err := app.Store.Users.Lookup(ctx, appointment.UserCode)
 	if err != nil {
-		return err
+		log.Println("Error getting user:", err)
+		return
+	}
+	doctor, err := app.Store.Doctors.Lookup(ctx, appointment.DoctorCode)
+	if err != nil {
+		log.Println("Error getting doctor:", err)
+		return
+	}
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		log.Println("Error getting hospital:", err)
+		return
 	}
 
-	// If we have user and doctor, send cancellation emails
-	if user != nil && doctor != nil {
-		hospital, err := GetHospital(client, doctor.HospitalCode)
-		if err != nil {
-			log.Println("Error getting hospital:", err)
-			// Continue despite error
-		}
+	err = app.Mailer.SendAppointmentCancellationEmail(
+		context.WithoutCancel(ctx),
+		user.Email,
+		user.UserCode,
+		doctor.DoctorName,
+		hospital.HospitalName,
+		displayDate(appointment.AppointmentTime.Date),
+		appointment.AppointmentTime.Time,
+	)
+	if err != nil {
+		log.Println("Error sending cancellation email:", err)
+	} else {
+		log.Println("Cancellation email sent successfully to:", user.Email)
+	}
+}
 
-		if hospital != nil {
-			// Format date for display
-			displayDate := appointment.AppointmentTime.Date
-			if t, err := time.Parse("2006-01-02", appointment.AppointmentTime.Date); err == nil {
-				displayDate = t.Format("02/01/2006") // DD/MM/YYYY format
-			}
+// RestoreAppointment brings back a soft deleted appointment and claims its slot again,
+// unless it was cancelled or is over. A *SlotConflictError is returned when the slot
+// was booked in the meantime, the appointment then stays deleted. Its calendar event
+// is not recreated.
+func RestoreAppointment(ctx context.Context, app *App, appointmentCode string) error {
+	appointment, err := app.Store.Appointments.Restore(ctx, appointmentCode)
+	if err != nil {
+		return err
+	}
 
-			// Send cancellation email
-			err = helper.SendAppointmentCancellationEmail(
-				user.Email,
-				user.UserCode,
-				doctor.DoctorName,
-				hospital.HospitalName,
-				displayDate,
-				appointment.AppointmentTime.Time,
-			)
-			if err != nil {
-				log.Println("Error sending cancellation email:", err)
-			} else {
-				log.Println("Cancellation email sent successfully to:", user.Email)
+	if !appointment.CurrentStatus().IsFinal() {
+		doctor, err := GetDoctor(ctx, app, appointment.DoctorCode)
+		if err == nil {
+			err = claimSlot(ctx, app, doctor, *appointment)
+		}
+		if err != nil {
+			if deleteErr := app.Store.Appointments.SoftDelete(context.WithoutCancel(ctx), appointmentCode, appointment.Tombstone); deleteErr != nil {
+				log.Println("Error deleting appointment again:", deleteErr)
 			}
+			return err
 		}
 	}
 
+	restored := *appointment
+	restored.Tombstone = Tombstone{}
+	// The calendar event was removed on delete
+	if restored.CalendarEventID != "" {
+		restored.CalendarEventID = ""
+		restored.UpdatedAt = time.Now()
+		restored.Version++
+		if err := app.Store.Appointments.Replace(ctx, restored, appointment.Version); err != nil {
+			log.Println("Error clearing calendar event:", err)
+		}
+	}
+	recordAudit(ctx, app, AuditRestore, TargetAppointment, appointmentCode, appointment, restored)
 	return nil
 }
 
-func UpdateAppointment(client *mongo.Client, appointment Appointment) {
-	collection := client.Database("healthcare").Collection("appointments")
-	appointment.UpdatedAt = time.Now()
+// UpdateAppointment replaces the stored appointment. Moving it to another slot
+// claims the new slot first, so it fails with a *SlotConflictError if that one is taken
+// and with ErrInvalidSlot if the doctor does not work then. An appointment that was
+// cancelled or is over cannot be moved, ErrCannotReschedule is returned for it.
+// The length stays the one it was booked with, the time zone follows the doctor's hospital.
+// appointment.Version must be the version the caller read, ErrVersionConflict is
+// returned when the appointment was changed since.
+func UpdateAppointment(ctx context.Context, app *App, appointment Appointment) (*Appointment, error) {
+	existing, err := app.Store.Appointments.Get(ctx, appointment.AppointmentCode)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Version != appointment.Version {
+		return nil, ErrVersionConflict
+	}
 
-	_, err := collection.ReplaceOne(
-		context.TODO(),
-		bson.M{"appointmentCode": appointment.AppointmentCode},
-		appointment,
-	)
+	appointment.Duration = existing.Duration
+	moved := appointment.AppointmentTime != existing.AppointmentTime || appointment.DoctorCode != existing.DoctorCode
+	if status := existing.CurrentStatus(); moved && status.IsFinal() {
+		return nil, fmt.Errorf("%w: it is %s", ErrCannotReschedule, status)
+	}
+	var doctor *Doctor
+	if moved {
+		if err := validSlotTime(appointment.AppointmentTime); err != nil {
+			return nil, err
+		}
+		doctor, err = GetDoctor(ctx, app, appointment.DoctorCode)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkWorkingTime(ctx, app, doctor, appointment); err != nil {
+			return nil, err
+		}
+		if err := appointment.placeIn(app, doctorZone(ctx, app, doctor)); err != nil {
+			return nil, err
+		}
+		if !isFuture(app, appointment, time.Now()) {
+			return nil, fmt.Errorf("%w: %s %s is in the past", ErrInvalidSlot, appointment.AppointmentTime.Date, appointment.AppointmentTime.Time)
+		}
+		// The new slot is clear of blackouts
+		appointment.Conflict = nil
+	} else {
+		appointment.StartsAt = existing.StartsAt
+		appointment.TimeZone = existing.TimeZone
+	}
+
+	// A move to an overlapping slot only claims and releases the pieces that change
+	oldLocks := slotLocksFor(*existing)
+	newLocks := slotLocksFor(appointment)
+	claimed := lockDifference(newLocks, oldLocks)
+	released := lockDifference(oldLocks, newLocks)
+	if len(claimed) > 0 {
+		if err := claimLocks(ctx, app, doctor, appointment, claimed); err != nil {
+			return nil, err
+		}
+	}
 
+	// The status only moves through TransitionAppointment
+	appointment.Status = existing.Status
+	appointment.StatusHistory = existing.StatusHistory
+	version := appointment.Version
+	appointment.Version = version + 1
+	appointment.UpdatedAt = time.Now()
+	appointment.Tombstone = Tombstone{}
+
+	err = app.Store.Appointments.Replace(ctx, appointment, version)
 	if err != nil {
 		log.Println("Error updating appointment:", err)
+		if len(claimed) > 0 {
+			app.Store.Slots.Release(context.WithoutCancel(ctx), claimed)
+		}
+		return nil, err
 	}
-}
+	recordAudit(ctx, app, AuditUpdate, TargetAppointment, appointment.AppointmentCode, existing, appointment)
 
-func GetAllAppointments(client *mongo.Client) []Appointment {
-	collection := client.Database("healthcare").Collection("appointments")
+	releaseLocks(ctx, app, released, existing.DoctorCode, existing.AppointmentTime)
+	return &appointment, nil
+}
 
-	filter := bson.D{{}}
+func GetAllAppointments(ctx context.Context, app *App) []Appointment {
+	appointments, _ := app.Store.Appointments.List(ctx)
 
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
+	return appointments
+}
 
-	var appointments []Appointment
-	cursor.All(context.TODO(), &appointments)
+// ListAppointments returns one page of appointments matching filter
+func ListAppointments(ctx context.Context, app *App, filter AppointmentFilter, opts ListOptions) (*Page[Appointment], error) {
+	query, err := newListQuery(opts, appointmentSortFields, "-date,-time", "appointmentCode")
+	if err != nil {
+		return nil, err
+	}
 
-	return appointments
+	appointments, total, err := app.Store.Appointments.Find(ctx, filter, query)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(appointments, total, query), nil
 }
 
-func GetAllAppointmentsEnhanced(client *mongo.Client, limit int) []EnhancedAppointment {
+func GetAllAppointmentsEnhanced(ctx context.Context, app *App, limit int) []EnhancedAppointment {
 	log.Printf("GetAllAppointmentsEnhanced called with limit: %d", limit)
 
-	appointments := GetAllAppointments(client)
+	appointments := GetAllAppointments(ctx, app)
 	log.Printf("Found %d appointments", len(appointments))
 
 	// Sort by creation date (newest first) and limit
@@ -337,6 +515,18 @@ func GetAllAppointmentsEnhanced(client *mongo.Client, limit int) []EnhancedAppoi
 
 	var enhancedAppointments []EnhancedAppointment
 
+	// Appointments with a cancel request still waiting for an answer
+	cancelRequested := map[string]bool{}
+	requests, err := app.Store.Requests.List(ctx)
+	if err != nil {
+		log.Println("Error getting cancel requests:", err)
+	}
+	for _, request := range requests {
+		if request.Status == "pending" {
+			cancelRequested[request.AppointmentCode] = true
+		}
+	}
+
 	// Field mapping for specialties
 	fieldMapping := map[int]string{
 		0: "Genel Tıp",
@@ -359,21 +549,21 @@ func GetAllAppointmentsEnhanced(client *mongo.Client, limit int) []EnhancedAppoi
 			AppointmentCode: appointment.AppointmentCode,
 			Date:            appointment.AppointmentTime.Date,
 			StartTime:       appointment.AppointmentTime.Time,
-			EndTime:         "",          // Will calculate if needed
-			Status:          "SCHEDULED", // Default status
-			CancelRequested: false,       // Default value
+			EndTime:         "", // Will calculate if needed
+			Status:          string(appointment.CurrentStatus()),
+			CancelRequested: cancelRequested[appointment.AppointmentCode],
 			CreatedAt:       appointment.CreatedAt,
 			UpdatedAt:       appointment.UpdatedAt,
 		}
 
-		// Calculate end time (15 minutes after start time)
+		// Calculate end time from the length the appointment was booked with
 		if startTime, err := time.Parse("15:04", appointment.AppointmentTime.Time); err == nil {
-			endTime := startTime.Add(15 * time.Minute)
+			endTime := startTime.Add(time.Duration(appointmentMinutes(appointment)) * time.Minute)
 			enhanced.EndTime = endTime.Format("15:04")
 		}
 
 		// Get user details
-		if user, err := GetUser(client, appointment.UserCode); err == nil {
+		if user, err := app.Store.Users.Lookup(ctx, appointment.UserCode); err == nil {
 			// Split userCode or use email as name fallback
 			enhanced.PatientFirstName = user.UserCode // Using userCode as first name for now
 			enhanced.PatientLastName = ""
@@ -384,7 +574,7 @@ func GetAllAppointmentsEnhanced(client *mongo.Client, limit int) []EnhancedAppoi
 		}
 
 		// Get doctor details
-		if doctor, err := GetDoctor(client, appointment.DoctorCode); err == nil {
+		if doctor, err := app.Store.Doctors.Lookup(ctx, appointment.DoctorCode); err == nil {
 			enhanced.DoctorName = doctor.DoctorName
 			enhanced.DoctorFirstName = doctor.DoctorName // Using full name as first name
 			enhanced.DoctorLastName = ""
@@ -398,7 +588,7 @@ func GetAllAppointmentsEnhanced(client *mongo.Client, limit int) []EnhancedAppoi
 			log.Printf("Found doctor: %s, field: %s", doctor.DoctorName, enhanced.FieldName)
 
 			// Get hospital details
-			if hospital, err := GetHospital(client, doctor.HospitalCode); err == nil {
+			if hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode); err == nil {
 				enhanced.HospitalName = hospital.HospitalName
 				log.Printf("Found hospital: %s", hospital.HospitalName)
 			} else {
@@ -415,38 +605,43 @@ func GetAllAppointmentsEnhanced(client *mongo.Client, limit int) []EnhancedAppoi
 	return enhancedAppointments
 }
 
-func GetAppointment(client *mongo.Client, appointmentCode string) (*Appointment, error) {
-	collection := client.Database("healthcare").Collection("appointments")
+// displayDate formats a YYYY-MM-DD date as DD/MM/YYYY for emails
+func displayDate(date string) string {
+	if t, err := time.Parse("2006-01-02", date); err == nil {
+		return t.Format("02/01/2006")
+	}
+	return date
+}
 
-	filter := bson.D{{Key: "appointmentCode", Value: appointmentCode}}
-	var appointment Appointment
-	err := collection.FindOne(context.TODO(), filter).Decode(&appointment)
+func GetAppointment(ctx context.Context, app *App, appointmentCode string) (*Appointment, error) {
+	appointment, err := app.Store.Appointments.Get(ctx, appointmentCode)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == ErrNotFound {
 			return nil, errors.New("appointment not found")
 		}
 		return nil, err
 	}
-	return &appointment, nil
+	return appointment, nil
 }
 
-func GetAppointmentDetails(client *mongo.Client, appointmentCode string) (*AppointmentDetails, error) {
-	appointment, err := GetAppointment(client, appointmentCode)
+func GetAppointmentDetails(ctx context.Context, app *App, appointmentCode string) (*AppointmentDetails, error) {
+	appointment, err := GetAppointment(ctx, app, appointmentCode)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := GetUser(client, appointment.UserCode)
+	// Past appointments keep pointing at deleted users, doctors and hospitals
+	user, err := app.Store.Users.Lookup(ctx, appointment.UserCode)
 	if err != nil {
 		return nil, err
 	}
 
-	doctor, err := GetDoctor(client, appointment.DoctorCode)
+	doctor, err := app.Store.Doctors.Lookup(ctx, appointment.DoctorCode)
 	if err != nil {
 		return nil, err
 	}
 
-	hospital, err := GetHospital(client, doctor.HospitalCode)
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
 	if err != nil {
 		return nil, err
 	}
@@ -462,40 +657,24 @@ func GetAppointmentDetails(client *mongo.Client, appointmentCode string) (*Appoi
 	return details, nil
 }
 
-func GetAppointmentsByDoctorCode(client *mongo.Client, doctorCode string) []Appointment {
-	collection := client.Database("healthcare").Collection("appointments")
-
-	filter := bson.D{{Key: "doctorCode", Value: doctorCode}}
-
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
-
-	var appointments []Appointment
-	cursor.All(context.TODO(), &appointments)
+func GetAppointmentsByDoctorCode(ctx context.Context, app *App, doctorCode string) []Appointment {
+	appointments, _ := app.Store.Appointments.ListByDoctor(ctx, doctorCode)
 	return appointments
 }
 
-func GetAppointmentsByUserCode(client *mongo.Client, userCode string) []Appointment {
-	collection := client.Database("healthcare").Collection("appointments")
-
-	filter := bson.D{{Key: "userCode", Value: userCode}}
-
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
-
-	var appointments []Appointment
-	cursor.All(context.TODO(), &appointments)
+func GetAppointmentsByUserCode(ctx context.Context, app *App, userCode string) []Appointment {
+	appointments, _ := app.Store.Appointments.ListByUser(ctx, userCode)
 	return appointments
 }
 
-func GetFutureAppointmentsByUserCode(client *mongo.Client, userCode string) []Appointment {
-	allAppointments := GetAppointmentsByUserCode(client, userCode)
+func GetFutureAppointmentsByUserCode(ctx context.Context, app *App, userCode string) []Appointment {
+	allAppointments := GetAppointmentsByUserCode(ctx, app, userCode)
 
 	var futureAppointments []Appointment
-	currentDate := time.Now().Format("2006-01-02")
+	now := time.Now()
 
 	for _, appointment := range allAppointments {
-		if appointment.AppointmentTime.Date >= currentDate {
+		if isFuture(app, appointment, now) {
 			futureAppointments = append(futureAppointments, appointment)
 		}
 	}
@@ -503,17 +682,22 @@ func GetFutureAppointmentsByUserCode(client *mongo.Client, userCode string) []Ap
 	return futureAppointments
 }
 
-func GetPastAppointmentsByUserCode(client *mongo.Client, userCode string) []Appointment {
-	allAppointments := GetAppointmentsByUserCode(client, userCode)
+// GetPastAppointmentsByUserCode returns the past appointments of the patient, with the
+// encounter notes the reader may see
+func GetPastAppointmentsByUserCode(ctx context.Context, app *App, userCode string, reader NoteReader) []Appointment {
+	allAppointments := GetAppointmentsByUserCode(ctx, app, userCode)
 
 	var pastAppointments []Appointment
-	currentDate := time.Now().Format("2006-01-02")
+	now := time.Now()
 
 	for _, appointment := range allAppointments {
-		if appointment.AppointmentTime.Date < currentDate {
+		if !isFuture(app, appointment, now) {
 			pastAppointments = append(pastAppointments, appointment)
 		}
 	}
+	if err := attachEncounterNotes(ctx, app, pastAppointments, reader); err != nil {
+		log.Println("Error getting encounter notes:", err)
+	}
 
 	return pastAppointments
 }
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBookingPolicy(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Hospitals.Insert(ctx, Hospital{HospitalCode: 2, HospitalName: "Diğer Hastane"})
+	app.Store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc2", DoctorName: "Other Doctor", HospitalCode: 2, WorkHours: WorkHours{Start: "09:00", End: "17:00"}})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	day := func(days int) string {
+		return time.Now().AddDate(0, 0, days).Format("2006-01-02")
+	}
+	book := func(userCode, doctorCode, date, at string) (*Appointment, error) {
+		return CreateAppointment(ctx, app, Appointment{DoctorCode: doctorCode, UserCode: userCode, AppointmentTime: AppointmentTime{Date: date, Time: at}})
+	}
+	wantViolation := func(err error, code string) {
+		t.Helper()
+		var violation *PolicyViolation
+		if !errors.As(err, &violation) || violation.Code != code {
+			t.Fatalf("want a %s violation, got %v", code, err)
+		}
+	}
+
+	// The default policy allows three upcoming appointments
+	for _, at := range []string{"10:00", "10:15", "10:30"} {
+		if _, err := book("patient1", "doc1", day(1), at); err != nil {
+			t.Fatalf("booking %s: %v", at, err)
+		}
+	}
+	_, err := book("patient1", "doc1", day(2), "10:00")
+	wantViolation(err, ReasonMaxActive)
+
+	policy := BookingPolicy{
+		Rules: PolicyRules{
+			OnePerDoctorPerDay: true,
+			FieldCooldownDays:  7,
+			MinLeadMinutes:     48 * 60,
+			MaxHorizonDays:     30,
+		},
+		// No limits at the second hospital
+		Hospitals: []HospitalPolicy{{HospitalCode: 2}},
+	}
+	if _, err := UpdateBookingPolicy(ctx, app, policy); err != nil {
+		t.Fatalf("update policy: %v", err)
+	}
+	if _, err := UpdateBookingPolicy(ctx, app, policy); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("want a stale policy rejected, got %v", err)
+	}
+	invalid := BookingPolicy{Rules: PolicyRules{MaxHorizonDays: -1}, Version: 1}
+	if _, err := UpdateBookingPolicy(ctx, app, invalid); !errors.Is(err, ErrInvalidBookingPolicy) {
+		t.Fatalf("want a negative limit rejected, got %v", err)
+	}
+
+	_, err = book("patient2", "doc1", day(1), "10:00")
+	wantViolation(err, ReasonMinLeadTime)
+	_, err = book("patient2", "doc1", day(40), "10:00")
+	wantViolation(err, ReasonBeyondHorizon)
+
+	first, err := book("patient2", "doc1", day(3), "10:00")
+	if err != nil {
+		t.Fatalf("book within the policy: %v", err)
+	}
+	_, err = book("patient2", "doc1", day(3), "11:00")
+	wantViolation(err, ReasonSameDoctorSameDay)
+	_, err = book("patient2", "doc1", day(5), "10:00")
+	wantViolation(err, ReasonFieldCooldown)
+
+	// Moving an appointment does not collide with itself
+	if _, err := RescheduleAppointment(ctx, app, first.AppointmentCode, AppointmentTime{Date: day(3), Time: "11:00"}); err != nil {
+		t.Fatalf("reschedule on the same day: %v", err)
+	}
+	_, err = RescheduleAppointment(ctx, app, first.AppointmentCode, AppointmentTime{Date: day(40), Time: "10:00"})
+	wantViolation(err, ReasonBeyondHorizon)
+
+	if _, err := book("patient2", "doc2", day(1), "10:00"); err != nil {
+		t.Fatalf("the hospital override should lift the rules: %v", err)
+	}
+}
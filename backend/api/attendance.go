@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// Attendance counts how often a patient came to or missed their appointments. It
+// grows as doctors mark appointments checked in or no-show.
+type Attendance struct {
+	UserCode string `bson:"userCode" json:"userCode"`
+	Attended int    `bson:"attended" json:"attended"`
+	NoShows  int    `bson:"noShows" json:"noShows"`
+	// SuspendedUntil blocks new bookings of the patient while it is in the future
+	SuspendedUntil *time.Time `bson:"suspendedUntil,omitempty" json:"suspendedUntil,omitempty"`
+	Penalties      []Penalty  `bson:"penalties" json:"penalties"`
+	UpdatedAt      time.Time  `bson:"updatedAt" json:"updatedAt"`
+	Version        int64      `bson:"version" json:"version"`
+}
+
+// Penalty is a booking suspension applied for NoShows missed appointments within
+// WindowDays
+type Penalty struct {
+	NoShows    int       `bson:"noShows" json:"noShows"`
+	WindowDays int       `bson:"windowDays" json:"windowDays"`
+	From       time.Time `bson:"from" json:"from"`
+	Until      time.Time `bson:"until" json:"until"`
+	// LiftedBy is the admin who ended the suspension early
+	LiftedBy string     `bson:"liftedBy,omitempty" json:"liftedBy,omitempty"`
+	LiftedAt *time.Time `bson:"liftedAt,omitempty" json:"liftedAt,omitempty"`
+}
+
+// Suspended reports whether the patient is barred from booking at now
+func (a Attendance) Suspended(now time.Time) bool {
+	return a.SuspendedUntil != nil && now.Before(*a.SuspendedUntil)
+}
+
+// GetAttendance returns the attendance of the patient, empty when nothing was recorded yet
+func GetAttendance(ctx context.Context, app *App, userCode string) (*Attendance, error) {
+	attendance, err := app.Store.Attendance.Get(ctx, userCode)
+	if errors.Is(err, ErrNotFound) {
+		return &Attendance{UserCode: userCode, Penalties: []Penalty{}}, nil
+	}
+	return attendance, err
+}
+
+// LiftSuspension ends the booking suspension of the patient early. A patient who is
+// not suspended is returned unchanged.
+func LiftSuspension(ctx context.Context, app *App, userCode, liftedBy string) (*Attendance, error) {
+	return updateAttendance(ctx, app, userCode, func(attendance *Attendance, now time.Time) bool {
+		if !attendance.Suspended(now) {
+			return false
+		}
+		attendance.SuspendedUntil = nil
+		if len(attendance.Penalties) > 0 {
+			last := &attendance.Penalties[len(attendance.Penalties)-1]
+			last.LiftedBy = liftedBy
+			last.LiftedAt = &now
+		}
+		return true
+	})
+}
+
+// checkSuspension returns a *PolicyViolation when the patient is suspended from booking
+func checkSuspension(ctx context.Context, app *App, userCode string, now time.Time) error {
+	attendance, err := GetAttendance(ctx, app, userCode)
+	if err != nil {
+		return err
+	}
+	if !attendance.Suspended(now) {
+		return nil
+	}
+	return &PolicyViolation{
+		Code:    ReasonSuspended,
+		Message: fmt.Sprintf("booking is suspended until %s after missed appointments", attendance.SuspendedUntil.In(defaultLocation(app)).Format("2006-01-02 15:04")),
+	}
+}
+
+// recordAttendance counts the check-in or no-show of the appointment for its patient.
+// A no-show that reaches the no-show penalty of the booking policy suspends the
+// patient, who is told over WebSocket and email. Failures are only logged, the
+// status change itself has already been stored.
+func recordAttendance(ctx context.Context, app *App, appointment Appointment, status AppointmentStatus) {
+	var penalty NoShowPenalty
+	recent := 0
+	if status == StatusNoShow {
+		policy, err := GetBookingPolicy(ctx, app)
+		if err != nil {
+			log.Println("Error getting booking policy:", err)
+		} else {
+			penalty = policy.NoShowPenalty
+		}
+		if penalty.NoShows > 0 {
+			recent, err = recentNoShows(ctx, app, appointment.UserCode, penalty.WindowDays)
+			if err != nil {
+				log.Println("Error counting no-shows:", err)
+				penalty = NoShowPenalty{}
+			}
+		}
+	}
+
+	suspended := false
+	attendance, err := updateAttendance(ctx, app, appointment.UserCode, func(attendance *Attendance, now time.Time) bool {
+		suspended = false
+		if status != StatusNoShow {
+			attendance.Attended++
+			return true
+		}
+		attendance.NoShows++
+		if penalty.NoShows > 0 && recent >= penalty.NoShows && !attendance.Suspended(now) {
+			until := now.AddDate(0, 0, penalty.SuspensionDays)
+			attendance.SuspendedUntil = &until
+			attendance.Penalties = append(attendance.Penalties, Penalty{
+				NoShows:    recent,
+				WindowDays: penalty.WindowDays,
+				From:       now,
+				Until:      until,
+			})
+			suspended = true
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Error recording attendance of user %s: %v", appointment.UserCode, err)
+		return
+	}
+	if suspended {
+		notifySuspension(ctx, app, *attendance)
+	}
+}
+
+// recentNoShows counts the no-shows of the patient within the last days days
+func recentNoShows(ctx context.Context, app *App, userCode string, days int) (int, error) {
+	appointments, err := app.Store.Appointments.ListByUser(ctx, userCode)
+	if err != nil {
+		return 0, err
+	}
+	since := time.Now().In(defaultLocation(app)).AddDate(0, 0, -days).Format("2006-01-02")
+	count := 0
+	for _, appointment := range appointments {
+		if appointment.CurrentStatus() == StatusNoShow && appointment.AppointmentTime.Date >= since {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// updateAttendance applies change to the attendance of the patient and stores it
+// when change reports a change, retrying on concurrent writes
+func updateAttendance(ctx context.Context, app *App, userCode string, change func(*Attendance, time.Time) bool) (*Attendance, error) {
+	for attempt := 1; ; attempt++ {
+		existing, err := GetAttendance(ctx, app, userCode)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		updated := *existing
+		updated.Penalties = slices.Clone(existing.Penalties)
+		if !change(&updated, now) {
+			return existing, nil
+		}
+		updated.UpdatedAt = now
+		updated.Version = existing.Version + 1
+
+		err = app.Store.Attendance.Replace(ctx, updated, existing.Version)
+		if errors.Is(err, ErrVersionConflict) && attempt < transitionRetries {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if existing.Version == 0 {
+			recordAudit(ctx, app, AuditCreate, TargetAttendance, userCode, nil, updated)
+		} else {
+			recordAudit(ctx, app, AuditUpdate, TargetAttendance, userCode, existing, updated)
+		}
+		return &updated, nil
+	}
+}
+
+// notifySuspension tells the patient about the booking suspension over WebSocket and email
+func notifySuspension(ctx context.Context, app *App, attendance Attendance) {
+	until := attendance.SuspendedUntil.In(defaultLocation(app))
+	penalty := attendance.Penalties[len(attendance.Penalties)-1]
+
+	if app.Notifier != nil {
+		notification, _ := json.Marshal(map[string]interface{}{
+			"type":      "bookingSuspended",
+			"message":   fmt.Sprintf("Gelmediğiniz %d randevu nedeniyle %s tarihine kadar yeni randevu alamazsınız", penalty.NoShows, displayDate(until.Format("2006-01-02"))),
+			"noShows":   penalty.NoShows,
+			"until":     attendance.SuspendedUntil,
+			"title":     "Randevu Kısıtlaması",
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+		app.Notifier.SendToUser(attendance.UserCode, notification)
+	}
+
+	if app.Mailer == nil {
+		return
+	}
+	user, err := app.Store.Users.Lookup(ctx, attendance.UserCode)
+	if err != nil {
+		log.Println("Error getting user:", err)
+		return
+	}
+	err = app.Mailer.SendBookingSuspensionEmail(context.WithoutCancel(ctx), user.Email, user.UserCode, penalty.NoShows, displayDate(until.Format("2006-01-02"))+" "+until.Format("15:04"))
+	if err != nil {
+		log.Println("Error sending suspension email:", err)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps the WebSocket messages sent to each user
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (n *recordingNotifier) SendToUser(userCode string, message []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var decoded struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(message, &decoded)
+	n.messages[userCode] = append(n.messages[userCode], decoded.Type)
+}
+
+func TestNoShowPenalty(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	notifier := &recordingNotifier{messages: map[string][]string{}}
+	app.Notifier = notifier
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+
+	policy := DefaultBookingPolicy()
+	policy.NoShowPenalty = NoShowPenalty{NoShows: 2, WindowDays: 30, SuspensionDays: 7}
+	if _, err := UpdateBookingPolicy(ctx, app, policy); err != nil {
+		t.Fatalf("update policy: %v", err)
+	}
+
+	day := func(days int) string {
+		return time.Now().AddDate(0, 0, days).Format("2006-01-02")
+	}
+	for code, date := range map[string]string{"old": day(-60), "past1": day(-3), "past2": day(-2), "past3": day(-1), "future": day(2)} {
+		app.Store.Appointments.Insert(ctx, Appointment{AppointmentCode: code, DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: "10:00"}})
+	}
+	mark := func(code string, status AppointmentStatus) {
+		t.Helper()
+		if _, err := TransitionAppointment(ctx, app, code, status, "doc1", ""); err != nil {
+			t.Fatalf("mark %s %s: %v", code, status, err)
+		}
+	}
+
+	if _, err := TransitionAppointment(ctx, app, "future", StatusNoShow, "doc1", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("want a no-show before the start rejected, got %v", err)
+	}
+
+	// The no-show outside the window does not count towards the penalty
+	mark("old", StatusNoShow)
+	mark("past1", StatusCheckedIn)
+	mark("past2", StatusNoShow)
+	attendance, _ := GetAttendance(ctx, app, "patient1")
+	if attendance.Attended != 1 || attendance.NoShows != 2 || attendance.Suspended(time.Now()) {
+		t.Fatalf("want one visit and two no-shows without a penalty: %+v", attendance)
+	}
+
+	mark("past3", StatusNoShow)
+	attendance, _ = GetAttendance(ctx, app, "patient1")
+	if !attendance.Suspended(time.Now()) || len(attendance.Penalties) != 1 || attendance.Penalties[0].NoShows != 2 {
+		t.Fatalf("want the patient suspended: %+v", attendance)
+	}
+	if messages := notifier.messages["patient1"]; len(messages) != 1 || messages[0] != "bookingSuspended" {
+		t.Fatalf("want one suspension message, got %v", messages)
+	}
+
+	booking := Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: day(3), Time: "10:00"}}
+	var violation *PolicyViolation
+	if _, err := CreateAppointment(ctx, app, booking); !errors.As(err, &violation) || violation.Code != ReasonSuspended {
+		t.Fatalf("want a suspended patient rejected, got %v", err)
+	}
+	if _, err := RescheduleAppointment(ctx, app, "future", AppointmentTime{Date: day(2), Time: "11:00"}); err != nil {
+		t.Fatalf("a suspended patient keeps their appointments: %v", err)
+	}
+
+	lifted, err := LiftSuspension(ctx, app, "patient1", "admin")
+	if err != nil || lifted.Suspended(time.Now()) || lifted.Penalties[0].LiftedBy != "admin" {
+		t.Fatalf("lift: %+v (%v)", lifted, err)
+	}
+	if _, err := CreateAppointment(ctx, app, booking); err != nil {
+		t.Fatalf("book after the suspension was lifted: %v", err)
+	}
+}
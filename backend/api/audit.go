@@ -0,0 +1,177 @@
+package api
+
+import (
+	"backend/helper"
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// Audit actions
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// Audit target types
+const (
+	TargetUser          = "user"
+	TargetUserProfile   = "userProfile"
+	TargetDoctor        = "doctor"
+	TargetHospital      = "hospital"
+	TargetAppointment   = "appointment"
+	TargetCancelRequest = "cancelRequest"
+	TargetWaitlist      = "waitlist"
+	TargetBlackout      = "blackout"
+	TargetBookingPolicy = "bookingPolicy"
+	TargetAttendance    = "attendance"
+	TargetEncounterNote = "encounterNote"
+)
+
+// redacted replaces the values of secret fields in audit changes
+const redacted = "[redacted]"
+
+// auditIgnoredFields change on every write and would only add noise to the diff
+var auditIgnoredFields = []string{"updatedAt", "version"}
+
+// auditSecretFields are recorded as changed without their values
+var auditSecretFields = []string{"password"}
+
+// Actor identifies who performs a write. The server attaches it to the request
+// context, writes made outside a request have an empty actor or a system one.
+type Actor struct {
+	UserCode string
+	Role     string
+	IP       string
+}
+
+// SystemActor performs the writes of background jobs
+var SystemActor = Actor{UserCode: "system", Role: "system"}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor attached to ctx, the zero Actor if there is none
+func ActorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
+
+// AuditEntry records one write. The audit log is append-only, the store has
+// no way to change or remove an entry once it is written.
+type AuditEntry struct {
+	ID         string        `bson:"_id" json:"id"`
+	Actor      string        `bson:"actor" json:"actor"`
+	ActorRole  string        `bson:"actorRole" json:"actorRole"`
+	IP         string        `bson:"ip" json:"ip"`
+	Action     string        `bson:"action" json:"action"`
+	TargetType string        `bson:"targetType" json:"targetType"`
+	TargetCode string        `bson:"targetCode" json:"targetCode"`
+	Changes    []FieldChange `bson:"changes" json:"changes"`
+	At         time.Time     `bson:"at" json:"at"`
+}
+
+// FieldChange is one field that differs between the before and after state of a write
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
+
+// recordAudit writes an audit entry for a write of the target. before is nil
+// for creations and after is nil for hard deletes. A failure is logged but does
+// not undo or fail the write, which has already happened.
+func recordAudit(ctx context.Context, app *App, action, targetType, targetCode string, before, after interface{}) {
+	actor := ActorFrom(ctx)
+	entry := AuditEntry{
+		ID:         helper.GenerateID(32),
+		Actor:      actor.UserCode,
+		ActorRole:  actor.Role,
+		IP:         actor.IP,
+		Action:     action,
+		TargetType: targetType,
+		TargetCode: targetCode,
+		Changes:    diffFields(before, after),
+		At:         time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := app.Store.Audit.Insert(context.WithoutCancel(ctx), entry); err != nil {
+		log.Printf("Error writing audit entry for %s %s %s: %v", action, targetType, targetCode, err)
+	}
+}
+
+// diffFields compares the JSON form of before and after field by field
+func diffFields(before, after interface{}) []FieldChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	var names []string
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		if slices.Contains(auditIgnoredFields, name) {
+			continue
+		}
+		oldValue, newValue := beforeFields[name], afterFields[name]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		if slices.Contains(auditSecretFields, name) {
+			oldValue, newValue = redactValue(oldValue), redactValue(newValue)
+		}
+		changes = append(changes, FieldChange{Field: name, Before: oldValue, After: newValue})
+	}
+	return changes
+}
+
+func auditFields(record interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if record == nil || reflect.ValueOf(record).IsZero() {
+		return fields
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+func redactValue(value interface{}) interface{} {
+	if value == nil || value == "" {
+		return value
+	}
+	return redacted
+}
+
+// ListAuditEntries returns one page of the audit log, newest first by default
+func ListAuditEntries(ctx context.Context, app *App, filter AuditFilter, opts ListOptions) (*Page[AuditEntry], error) {
+	query, err := newListQuery(opts, auditSortFields, "-at", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, total, err := app.Store.Audit.Find(ctx, filter, query)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(entries, total, query), nil
+}
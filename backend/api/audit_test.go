@@ -0,0 +1,49 @@
+package api
+
+import (
+	"backend/config"
+	"context"
+	"testing"
+)
+
+func TestAuditRecordsActorAndChanges(t *testing.T) {
+	store := NewMemoryStore()
+	app := &App{Store: store, Config: config.Defaults()}
+	ctx := WithActor(context.Background(), Actor{UserCode: "admin1", Role: "admin", IP: "10.0.0.7"})
+
+	store.Users.Insert(ctx, User{UserCode: "patient1", Password: "old-hash"})
+	if err := UpdateUserPassword(ctx, app, "patient1", "new-hash"); err != nil {
+		t.Fatalf("update password: %v", err)
+	}
+	if _, err := DeleteUser(ctx, app, "patient1", "admin1", PolicyBlock); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+
+	page, err := ListAuditEntries(ctx, app, AuditFilter{TargetType: TargetUser, TargetCode: "patient1"}, ListOptions{})
+	if err != nil || page.Total != 2 {
+		t.Fatalf("want 2 audit entries, got %+v (%v)", page, err)
+	}
+
+	entries := map[string]AuditEntry{}
+	for _, entry := range page.Items {
+		entries[entry.Action] = entry
+	}
+	update, deletion := entries[AuditUpdate], entries[AuditDelete]
+	if deletion.Actor != "admin1" || deletion.ActorRole != "admin" || deletion.IP != "10.0.0.7" {
+		t.Fatalf("actor not recorded: %+v", deletion)
+	}
+	if len(update.Changes) != 1 || update.Changes[0].Field != "password" {
+		t.Fatalf("want only the password change, got %+v", update.Changes)
+	}
+	if update.Changes[0].Before != redacted || update.Changes[0].After != redacted {
+		t.Fatalf("password values leaked into the audit log: %+v", update.Changes[0])
+	}
+
+	fields := map[string]bool{}
+	for _, change := range deletion.Changes {
+		fields[change.Field] = true
+	}
+	if !fields["deletedAt"] || !fields["deletedBy"] || len(fields) != 2 {
+		t.Fatalf("want the tombstone fields as the delete diff, got %+v", deletion.Changes)
+	}
+}
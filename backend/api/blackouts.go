@@ -0,0 +1,323 @@
+package api
+
+import (
+	"backend/helper"
+	"backend/scheduling"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// BlackoutKind tells who a blackout applies to
+type BlackoutKind string
+
+const (
+	// BlackoutLeave takes one doctor away, for a vacation or a sick leave
+	BlackoutLeave BlackoutKind = "leave"
+	// BlackoutClosure closes one hospital
+	BlackoutClosure BlackoutKind = "closure"
+	// BlackoutHoliday is a public holiday that closes every hospital
+	BlackoutHoliday BlackoutKind = "holiday"
+)
+
+// ErrInvalidBlackout is returned for a blackout with missing or malformed fields
+var ErrInvalidBlackout = errors.New("invalid blackout")
+
+// Blackout removes the dates from DateFrom to DateTo from the availability of the
+// doctors it applies to. Appointments booked before it was added are kept and
+// flagged with a ScheduleConflict.
+type Blackout struct {
+	BlackoutCode string       `bson:"blackoutCode" json:"blackoutCode"`
+	Kind         BlackoutKind `bson:"kind" json:"kind"`
+	DoctorCode   string       `bson:"doctorCode,omitempty" json:"doctorCode,omitempty"`
+	HospitalCode int          `bson:"hospitalCode,omitempty" json:"hospitalCode,omitempty"`
+	DateFrom     string       `bson:"dateFrom" json:"dateFrom"`
+	DateTo       string       `bson:"dateTo" json:"dateTo"`
+	// ClosedFrom closes a single day only from this time on, for half days
+	ClosedFrom string    `bson:"closedFrom,omitempty" json:"closedFrom,omitempty"`
+	Reason     string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedBy  string    `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// ScheduleConflict marks an appointment that a later blackout falls on
+type ScheduleConflict struct {
+	BlackoutCode string       `bson:"blackoutCode" json:"blackoutCode"`
+	Kind         BlackoutKind `bson:"kind" json:"kind"`
+	Reason       string       `bson:"reason,omitempty" json:"reason,omitempty"`
+	FlaggedAt    time.Time    `bson:"flaggedAt" json:"flaggedAt"`
+}
+
+// AppliesTo reports whether the blackout takes the doctor away
+func (b Blackout) AppliesTo(doctor Doctor) bool {
+	switch b.Kind {
+	case BlackoutLeave:
+		return b.DoctorCode == doctor.DoctorCode
+	case BlackoutClosure:
+		return b.HospitalCode == doctor.HospitalCode
+	case BlackoutHoliday:
+		return true
+	}
+	return false
+}
+
+// closures lists the closed dates of the blackout from the first to the last date
+func (b Blackout) closures(from, to string) []scheduling.Closure {
+	first, err := time.Parse(scheduling.DateLayout, max(b.DateFrom, from))
+	if err != nil {
+		return nil
+	}
+	last := min(b.DateTo, to)
+
+	var closures []scheduling.Closure
+	for day := first; day.Format(scheduling.DateLayout) <= last; day = day.AddDate(0, 0, 1) {
+		closures = append(closures, scheduling.Closure{Date: day.Format(scheduling.DateLayout), From: b.ClosedFrom, Reason: b.Reason})
+	}
+	return closures
+}
+
+// covers reports whether the blackout takes away some of the appointment's time
+func (b Blackout) covers(appointment Appointment) bool {
+	date := appointment.AppointmentTime.Date
+	if date < b.DateFrom || date > b.DateTo {
+		return false
+	}
+	if b.ClosedFrom == "" {
+		return true
+	}
+	start, err := scheduling.ParseClock(appointment.AppointmentTime.Time)
+	from, fromErr := scheduling.ParseClock(b.ClosedFrom)
+	return err != nil || fromErr != nil || start+appointmentMinutes(appointment) > from
+}
+
+func (b Blackout) validate() error {
+	switch b.Kind {
+	case BlackoutLeave:
+		if b.DoctorCode == "" {
+			return fmt.Errorf("%w: leave needs a doctorCode", ErrInvalidBlackout)
+		}
+	case BlackoutClosure:
+		if b.HospitalCode == 0 {
+			return fmt.Errorf("%w: closure needs a hospitalCode", ErrInvalidBlackout)
+		}
+	case BlackoutHoliday:
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidBlackout, b.Kind)
+	}
+
+	from, err := time.Parse(scheduling.DateLayout, b.DateFrom)
+	if err != nil {
+		return fmt.Errorf("%w: dateFrom %q", ErrInvalidBlackout, b.DateFrom)
+	}
+	to, err := time.Parse(scheduling.DateLayout, b.DateTo)
+	if err != nil || to.Before(from) {
+		return fmt.Errorf("%w: dateTo %q", ErrInvalidBlackout, b.DateTo)
+	}
+	if b.ClosedFrom != "" {
+		if _, err := scheduling.ParseClock(b.ClosedFrom); err != nil || b.DateFrom != b.DateTo {
+			return fmt.Errorf("%w: closedFrom needs a 15:04 time and a single day", ErrInvalidBlackout)
+		}
+	}
+	return nil
+}
+
+// availabilityFor is the doctor's availability between the first and the last date
+// with its leave, the closures of its hospital and the public holidays taken out
+func availabilityFor(ctx context.Context, app *App, doctor *Doctor, from, to string) (scheduling.Availability, error) {
+	availability := doctorAvailability(doctor)
+	blackouts, err := app.Store.Blackouts.ListFor(ctx, *doctor, from, to)
+	if err != nil {
+		return availability, err
+	}
+	var closures []scheduling.Closure
+	for _, blackout := range blackouts {
+		closures = append(closures, blackout.closures(from, to)...)
+	}
+	return availability.Without(closures), nil
+}
+
+// AddBlackout stores the blackout and flags the appointments it falls on, whose
+// codes it returns. They are neither cancelled nor moved, that is left to the
+// doctor or an admin.
+func AddBlackout(ctx context.Context, app *App, blackout Blackout) (*Blackout, []string, error) {
+	if err := blackout.validate(); err != nil {
+		return nil, nil, err
+	}
+	if blackout.Kind == BlackoutLeave {
+		if _, err := app.Store.Doctors.Get(ctx, blackout.DoctorCode); err != nil {
+			return nil, nil, err
+		}
+	}
+	if blackout.Kind == BlackoutClosure {
+		if _, err := app.Store.Hospitals.Get(ctx, blackout.HospitalCode); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	blackout.BlackoutCode = helper.GenerateID(8)
+	blackout.CreatedAt = time.Now()
+	if err := app.Store.Blackouts.Insert(ctx, blackout); err != nil {
+		return nil, nil, err
+	}
+	recordAudit(ctx, app, AuditCreate, TargetBlackout, blackout.BlackoutCode, nil, blackout)
+
+	flagged, err := flagConflicts(ctx, app, blackout)
+	if err != nil {
+		log.Printf("Error flagging appointments for blackout %s: %v", blackout.BlackoutCode, err)
+	}
+	return &blackout, flagged, nil
+}
+
+// DeleteBlackout removes the blackout and clears the conflicts it flagged
+func DeleteBlackout(ctx context.Context, app *App, blackoutCode string) (*Blackout, error) {
+	blackout, err := app.Store.Blackouts.Get(ctx, blackoutCode)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.Store.Blackouts.Delete(ctx, blackoutCode); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, app, AuditDelete, TargetBlackout, blackoutCode, blackout, nil)
+
+	appointments, err := app.Store.Appointments.ListBetween(ctx, blackout.DateFrom, blackout.DateTo)
+	if err != nil {
+		log.Printf("Error clearing conflicts of blackout %s: %v", blackoutCode, err)
+		return blackout, nil
+	}
+	for _, appointment := range appointments {
+		if appointment.Conflict != nil && appointment.Conflict.BlackoutCode == blackoutCode {
+			setConflict(ctx, app, appointment.AppointmentCode, nil)
+		}
+	}
+	return blackout, nil
+}
+
+// GetBlackout returns the blackout with the given code
+func GetBlackout(ctx context.Context, app *App, blackoutCode string) (*Blackout, error) {
+	return app.Store.Blackouts.Get(ctx, blackoutCode)
+}
+
+// ListBlackouts returns the blackouts matching filter, earliest first
+func ListBlackouts(ctx context.Context, app *App, filter BlackoutFilter) ([]Blackout, error) {
+	blackouts, err := app.Store.Blackouts.List(ctx, filter)
+	if blackouts == nil && err == nil {
+		blackouts = []Blackout{}
+	}
+	return blackouts, err
+}
+
+// ImportHolidays replaces the public holidays of year. Without holidays the built-in
+// Turkish calendar is used, which fails for years whose religious holidays it does
+// not know. Appointments on the new holidays are flagged.
+func ImportHolidays(ctx context.Context, app *App, year int, holidays []scheduling.Holiday, importedBy string) ([]Blackout, []string, error) {
+	if len(holidays) == 0 {
+		var known bool
+		holidays, known = scheduling.TurkishHolidays(year)
+		if !known {
+			return nil, nil, fmt.Errorf("%w: the religious holidays of %d are not built in, import them with the list", ErrInvalidBlackout, year)
+		}
+	}
+
+	prefix := fmt.Sprintf("%04d-", year)
+	blackouts := make([]Blackout, 0, len(holidays))
+	for _, holiday := range holidays {
+		if !strings.HasPrefix(holiday.Date, prefix) {
+			return nil, nil, fmt.Errorf("%w: %s is not in %d", ErrInvalidBlackout, holiday.Date, year)
+		}
+		blackout := Blackout{
+			BlackoutCode: helper.GenerateID(8),
+			Kind:         BlackoutHoliday,
+			DateFrom:     holiday.Date,
+			DateTo:       holiday.Date,
+			Reason:       holiday.Name,
+			CreatedBy:    importedBy,
+			CreatedAt:    time.Now(),
+		}
+		if holiday.HalfDay {
+			blackout.ClosedFrom = scheduling.HalfDayFrom
+		}
+		if err := blackout.validate(); err != nil {
+			return nil, nil, err
+		}
+		blackouts = append(blackouts, blackout)
+	}
+
+	replaced, err := app.Store.Blackouts.ReplaceHolidays(ctx, year, blackouts)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordAudit(ctx, app, AuditUpdate, TargetBlackout, fmt.Sprintf("holidays:%d", year), replaced, blackouts)
+
+	flagged := []string{}
+	for _, blackout := range blackouts {
+		codes, err := flagConflicts(ctx, app, blackout)
+		if err != nil {
+			log.Printf("Error flagging appointments for holiday %s: %v", blackout.DateFrom, err)
+		}
+		flagged = append(flagged, codes...)
+	}
+	return blackouts, flagged, nil
+}
+
+// flagConflicts sets a ScheduleConflict on the upcoming appointments the blackout
+// falls on and returns their codes
+func flagConflicts(ctx context.Context, app *App, blackout Blackout) ([]string, error) {
+	flagged := []string{}
+	appointments, err := app.Store.Appointments.ListBetween(ctx, blackout.DateFrom, blackout.DateTo)
+	if err != nil {
+		return flagged, err
+	}
+
+	doctors := map[string]*Doctor{}
+	conflict := &ScheduleConflict{
+		BlackoutCode: blackout.BlackoutCode,
+		Kind:         blackout.Kind,
+		Reason:       blackout.Reason,
+		FlaggedAt:    time.Now(),
+	}
+	for _, appointment := range onlyFuture(app, appointments) {
+		if !blackout.covers(appointment) {
+			continue
+		}
+		doctor, ok := doctors[appointment.DoctorCode]
+		if !ok {
+			doctor, err = app.Store.Doctors.Lookup(ctx, appointment.DoctorCode)
+			if err != nil {
+				log.Printf("Error getting doctor %s: %v", appointment.DoctorCode, err)
+			}
+			doctors[appointment.DoctorCode] = doctor
+		}
+		if doctor == nil || !blackout.AppliesTo(*doctor) {
+			continue
+		}
+		if setConflict(ctx, app, appointment.AppointmentCode, conflict) {
+			flagged = append(flagged, appointment.AppointmentCode)
+		}
+	}
+	return flagged, nil
+}
+
+// setConflict sets or clears the conflict of the appointment, retrying when it
+// is changed concurrently
+func setConflict(ctx context.Context, app *App, appointmentCode string, conflict *ScheduleConflict) bool {
+	for attempt := 1; ; attempt++ {
+		appointment, err := app.Store.Appointments.Get(ctx, appointmentCode)
+		if err != nil {
+			log.Printf("Error getting appointment %s: %v", appointmentCode, err)
+			return false
+		}
+		appointment.Conflict = conflict
+		_, err = UpdateAppointment(ctx, app, *appointment)
+		if errors.Is(err, ErrVersionConflict) && attempt < transitionRetries {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error flagging appointment %s: %v", appointmentCode, err)
+			return false
+		}
+		return true
+	}
+}
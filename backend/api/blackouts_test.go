@@ -0,0 +1,85 @@
+package api
+
+import (
+	"backend/scheduling"
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLeaveFlagsAndBlocksAppointments(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	first := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	last := time.Now().AddDate(0, 0, 4).Format("2006-01-02")
+	booked, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: last, Time: "10:00"}})
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+
+	leave, conflicts, err := AddBlackout(ctx, app, Blackout{Kind: BlackoutLeave, DoctorCode: "doc1", DateFrom: first, DateTo: last, Reason: "vacation"})
+	if err != nil {
+		t.Fatalf("add leave: %v", err)
+	}
+	if !slices.Equal(conflicts, []string{booked.AppointmentCode}) {
+		t.Fatalf("want the booked appointment flagged, got %v", conflicts)
+	}
+	flagged, _ := GetAppointment(ctx, app, booked.AppointmentCode)
+	if flagged.Conflict == nil || flagged.Conflict.BlackoutCode != leave.BlackoutCode {
+		t.Fatalf("want the conflict stored on the appointment, got %+v", flagged.Conflict)
+	}
+
+	if slots, err := GetDoctorFreeSlots(ctx, app, "doc1", first, last); err != nil || len(slots) != 0 {
+		t.Fatalf("want no free slots during the leave, got %d (%v)", len(slots), err)
+	}
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: AppointmentTime{Date: first, Time: "11:00"}}); err == nil {
+		t.Fatal("want booking during the leave rejected")
+	}
+
+	if _, err := DeleteBlackout(ctx, app, leave.BlackoutCode); err != nil {
+		t.Fatalf("delete leave: %v", err)
+	}
+	cleared, _ := GetAppointment(ctx, app, booked.AppointmentCode)
+	if cleared.Conflict != nil {
+		t.Fatalf("want the conflict cleared with the leave, got %+v", cleared.Conflict)
+	}
+	if slots, _ := GetDoctorFreeSlots(ctx, app, "doc1", first, first); len(slots) == 0 {
+		t.Fatal("want the slots back after the leave is removed")
+	}
+}
+
+func TestImportHolidaysHalfDay(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+
+	eve := time.Now().AddDate(0, 0, 3)
+	year := eve.Year()
+	holidays := []scheduling.Holiday{{Date: eve.Format("2006-01-02"), Name: "Arife", HalfDay: true}}
+	if _, _, err := ImportHolidays(ctx, app, year, holidays, "admin1"); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	// Importing again replaces the year instead of adding to it
+	if _, _, err := ImportHolidays(ctx, app, year, holidays, "admin1"); err != nil {
+		t.Fatalf("import again: %v", err)
+	}
+	stored, _ := ListBlackouts(ctx, app, BlackoutFilter{Kind: BlackoutHoliday})
+	if len(stored) != 1 {
+		t.Fatalf("want one holiday, got %+v", stored)
+	}
+
+	schedule, err := GetDoctorTimeSlots(ctx, app, "doc1", eve.Format("2006-01-02"))
+	if err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	if schedule.DoctorInfo.WorkEnd != scheduling.HalfDayFrom {
+		t.Fatalf("want the day to end at %s, got %+v", scheduling.HalfDayFrom, schedule.DoctorInfo)
+	}
+
+	if _, _, err := ImportHolidays(ctx, app, year+1, holidays, "admin1"); err == nil {
+		t.Fatal("want a holiday outside the year rejected")
+	}
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+)
+
+// BulkAction is what ClearDoctorDays does with the appointments of the doctor
+type BulkAction string
+
+const (
+	// BulkCancel cancels every appointment on behalf of the hospital
+	BulkCancel BulkAction = "cancel"
+	// BulkReassign moves every appointment to a free doctor of the same field in the
+	// same hospital, at the same date and time. The ones nobody can take are cancelled.
+	BulkReassign BulkAction = "reassign"
+)
+
+// maxBulkDays is the longest date range ClearDoctorDays takes at once
+const maxBulkDays = 31
+
+// ErrInvalidBulkRequest is returned for a bulk request with a missing or malformed field
+var ErrInvalidBulkRequest = errors.New("invalid bulk request")
+
+// BulkRequest names the doctor and the days whose appointments are cleared, for a
+// doctor who calls in sick
+type BulkRequest struct {
+	DoctorCode string     `json:"doctorCode"`
+	DateFrom   string     `json:"dateFrom"`
+	DateTo     string     `json:"dateTo"`
+	Action     BulkAction `json:"action"`
+	// Reason is the note of the cancellations and the leave
+	Reason string `json:"reason,omitempty"`
+	// DryRun only previews what would happen and changes nothing
+	DryRun bool `json:"dryRun"`
+	// Leave also adds a leave over the days, so their slots are neither booked again
+	// nor offered to the waitlist
+	Leave bool `json:"leave"`
+}
+
+// BulkChange is what happened, or would happen on a dry run, to one appointment
+type BulkChange struct {
+	AppointmentCode string          `json:"appointmentCode"`
+	UserCode        string          `json:"userCode"`
+	AppointmentTime AppointmentTime `json:"appointmentTime"`
+	ToDoctor        string          `json:"toDoctor,omitempty"`
+	ToDoctorName    string          `json:"toDoctorName,omitempty"`
+	// Message tells why a failed appointment was left as it is
+	Message string `json:"message,omitempty"`
+}
+
+// BulkResult sums up a bulk cancellation or reassignment
+type BulkResult struct {
+	DoctorCode string     `json:"doctorCode"`
+	DateFrom   string     `json:"dateFrom"`
+	DateTo     string     `json:"dateTo"`
+	Action     BulkAction `json:"action"`
+	DryRun     bool       `json:"dryRun"`
+	// BlackoutCode is the leave added with the request
+	BlackoutCode string       `json:"blackoutCode,omitempty"`
+	Cancelled    []BulkChange `json:"cancelled"`
+	Reassigned   []BulkChange `json:"reassigned"`
+	Failed       []BulkChange `json:"failed"`
+	// Patients is how many patients are affected, each gets a single notification
+	Patients int `json:"patients"`
+}
+
+func (r BulkRequest) validate() error {
+	if r.DoctorCode == "" {
+		return fmt.Errorf("%w: doctorCode is required", ErrInvalidBulkRequest)
+	}
+	if r.Action != BulkCancel && r.Action != BulkReassign {
+		return fmt.Errorf("%w: action must be %q or %q", ErrInvalidBulkRequest, BulkCancel, BulkReassign)
+	}
+	if err := checkDateRange(r.DateFrom, r.DateTo, maxBulkDays); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBulkRequest, err)
+	}
+	return nil
+}
+
+// ClearDoctorDays cancels or reassigns the upcoming appointments of the doctor from
+// DateFrom to DateTo and returns what happened to each. Reassignments only go to
+// doctors of the same field in the same hospital. Each patient gets one email and one
+// WebSocket message for all their appointments. A dry run returns the same summary
+// without changing anything.
+func ClearDoctorDays(ctx context.Context, app *App, request BulkRequest, clearedBy string) (*BulkResult, error) {
+	if err := request.validate(); err != nil {
+		return nil, err
+	}
+	doctor, err := GetDoctor(ctx, app, request.DoctorCode)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkResult{
+		DoctorCode: doctor.DoctorCode,
+		DateFrom:   request.DateFrom,
+		DateTo:     request.DateTo,
+		Action:     request.Action,
+		DryRun:     request.DryRun,
+		Cancelled:  []BulkChange{},
+		Reassigned: []BulkChange{},
+		Failed:     []BulkChange{},
+	}
+	// The leave flags the appointments, so they are read after it
+	if request.Leave && !request.DryRun {
+		leave, _, err := AddBlackout(ctx, app, Blackout{
+			Kind:       BlackoutLeave,
+			DoctorCode: doctor.DoctorCode,
+			DateFrom:   request.DateFrom,
+			DateTo:     request.DateTo,
+			Reason:     request.Reason,
+			CreatedBy:  clearedBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.BlackoutCode = leave.BlackoutCode
+	}
+
+	appointments, err := app.Store.Appointments.ListByDoctor(ctx, doctor.DoctorCode)
+	if err != nil {
+		return nil, err
+	}
+	affected := slices.DeleteFunc(onlyFuture(app, appointments), func(a Appointment) bool {
+		return a.AppointmentTime.Date < request.DateFrom || a.AppointmentTime.Date > request.DateTo
+	})
+	slices.SortFunc(affected, func(a, b Appointment) int {
+		return compareTimes(a.AppointmentTime, b.AppointmentTime)
+	})
+
+	var candidates []Doctor
+	if request.Action == BulkReassign {
+		candidates = colleagues(ctx, app, doctor)
+	}
+	// planned keeps the slot pieces a dry run has already handed out
+	planned := map[SlotLock]bool{}
+	for _, appointment := range affected {
+		change := BulkChange{
+			AppointmentCode: appointment.AppointmentCode,
+			UserCode:        appointment.UserCode,
+			AppointmentTime: appointment.AppointmentTime,
+		}
+
+		if request.Action == BulkReassign {
+			var replacement *Doctor
+			var err error
+			if request.DryRun {
+				replacement = planReassignment(ctx, app, appointment, candidates, planned)
+			} else {
+				replacement, err = reassignTo(ctx, app, appointment, candidates)
+			}
+			if err != nil {
+				change.Message = err.Error()
+				result.Failed = append(result.Failed, change)
+				continue
+			}
+			if replacement != nil {
+				change.ToDoctor = replacement.DoctorCode
+				change.ToDoctorName = replacement.DoctorName
+				result.Reassigned = append(result.Reassigned, change)
+				continue
+			}
+		}
+
+		if request.DryRun {
+			if status := appointment.CurrentStatus(); !status.CanMoveTo(StatusCancelledByHospital) {
+				change.Message = fmt.Sprintf("%s appointments cannot be cancelled", status)
+				result.Failed = append(result.Failed, change)
+				continue
+			}
+		} else if _, err := transitionAppointment(ctx, app, appointment.AppointmentCode, StatusCancelledByHospital, clearedBy, request.Reason, false); err != nil {
+			log.Printf("Error cancelling appointment %s: %v", appointment.AppointmentCode, err)
+			change.Message = err.Error()
+			result.Failed = append(result.Failed, change)
+			continue
+		}
+		result.Cancelled = append(result.Cancelled, change)
+	}
+
+	changes := bulkChangesByPatient(result)
+	result.Patients = len(changes)
+	if !request.DryRun {
+		for _, userCode := range slices.Sorted(maps.Keys(changes)) {
+			notifyBulkChanges(ctx, app, doctor, userCode, changes[userCode])
+		}
+	}
+	return result, nil
+}
+
+// colleagues lists the live doctors of the doctor's field in the doctor's hospital
+func colleagues(ctx context.Context, app *App, doctor *Doctor) []Doctor {
+	doctors, err := app.Store.Doctors.ListByHospital(ctx, doctor.HospitalCode)
+	if err != nil {
+		log.Printf("Error listing doctors of hospital %d: %v", doctor.HospitalCode, err)
+		return nil
+	}
+	return slices.DeleteFunc(doctors, func(d Doctor) bool {
+		return d.FieldCode != doctor.FieldCode || d.DoctorCode == doctor.DoctorCode
+	})
+}
+
+// planReassignment is reassignTo for a dry run. It only checks the slots of the
+// candidates and marks the pieces it hands out in planned.
+func planReassignment(ctx context.Context, app *App, appointment Appointment, candidates []Doctor, planned map[SlotLock]bool) *Doctor {
+	for _, doctor := range candidates {
+		moved := appointment
+		moved.DoctorCode = doctor.DoctorCode
+		locks := slotLocksFor(moved)
+		for i := range locks {
+			locks[i].AppointmentCode = ""
+		}
+		if slices.ContainsFunc(locks, func(l SlotLock) bool { return planned[l] }) {
+			continue
+		}
+		if checkWorkingTime(ctx, app, &doctor, moved) != nil || checkSlotFree(ctx, app, moved, nil) != nil {
+			continue
+		}
+		for _, lock := range locks {
+			planned[lock] = true
+		}
+		return &doctor
+	}
+	return nil
+}
+
+// patientChange is a cancelled or reassigned appointment of a patient
+type patientChange struct {
+	BulkChange
+	Cancelled bool
+}
+
+// bulkChangesByPatient groups the cancelled and reassigned appointments by patient,
+// in the order of their times
+func bulkChangesByPatient(result *BulkResult) map[string][]patientChange {
+	byPatient := map[string][]patientChange{}
+	for _, change := range result.Cancelled {
+		byPatient[change.UserCode] = append(byPatient[change.UserCode], patientChange{change, true})
+	}
+	for _, change := range result.Reassigned {
+		byPatient[change.UserCode] = append(byPatient[change.UserCode], patientChange{change, false})
+	}
+	for _, changes := range byPatient {
+		slices.SortFunc(changes, func(a, b patientChange) int {
+			return compareTimes(a.AppointmentTime, b.AppointmentTime)
+		})
+	}
+	return byPatient
+}
+
+// notifyBulkChanges tells the patient about all their changed appointments with the
+// doctor in one WebSocket message and one email
+func notifyBulkChanges(ctx context.Context, app *App, doctor *Doctor, userCode string, changes []patientChange) {
+	lines := make([]string, len(changes))
+	for i, change := range changes {
+		lines[i] = displayDate(change.AppointmentTime.Date) + " " + change.AppointmentTime.Time
+		if change.Cancelled {
+			lines[i] += " - iptal edildi"
+		} else {
+			lines[i] += " - Dr. " + change.ToDoctorName + " ile"
+		}
+	}
+
+	if app.Notifier != nil {
+		notification, _ := json.Marshal(map[string]interface{}{
+			"type":         "appointmentsChanged",
+			"message":      "Dr. " + doctor.DoctorName + " ile olan " + fmt.Sprint(len(changes)) + " randevunuzda değişiklik yapıldı",
+			"doctorCode":   doctor.DoctorCode,
+			"appointments": lines,
+			"title":        "Randevu Değişikliği",
+			"timestamp":    time.Now().Format(time.RFC3339),
+		})
+		app.Notifier.SendToUser(userCode, notification)
+	}
+
+	if app.Mailer == nil {
+		return
+	}
+	user, err := app.Store.Users.Lookup(ctx, userCode)
+	if err != nil {
+		log.Println("Error getting user:", err)
+		return
+	}
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		log.Println("Error getting hospital:", err)
+		return
+	}
+	err = app.Mailer.SendAppointmentChangesEmail(context.WithoutCancel(ctx), user.Email, user.UserCode, doctor.DoctorName, hospital.HospitalName, lines)
+	if err != nil {
+		log.Println("Error sending appointment changes email:", err)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClearDoctorDays(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc2", DoctorName: "Morning Doctor", HospitalCode: 1, WorkHours: WorkHours{Start: "09:00", End: "12:00"}})
+	app.Store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc3", DoctorName: "Other Field", HospitalCode: 1, FieldCode: 7, WorkHours: WorkHours{Start: "09:00", End: "17:00"}})
+	for _, userCode := range []string{"patient1", "patient2", "patient3"} {
+		app.Store.Users.Insert(ctx, User{UserCode: userCode, Role: "patient"})
+	}
+
+	date := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	book := func(doctorCode, userCode, at string) *Appointment {
+		appointment, err := CreateAppointment(ctx, app, Appointment{DoctorCode: doctorCode, UserCode: userCode, AppointmentTime: AppointmentTime{Date: date, Time: at}})
+		if err != nil {
+			t.Fatalf("book %s at %s: %v", userCode, at, err)
+		}
+		return appointment
+	}
+	morning := book("doc1", "patient1", "10:00")
+	busy := book("doc1", "patient2", "11:00")
+	afternoon := book("doc1", "patient1", "14:00")
+	book("doc2", "patient3", "11:00")
+
+	request := BulkRequest{DoctorCode: "doc1", DateFrom: date, DateTo: date, Action: BulkReassign, DryRun: true}
+	codes := func(changes []BulkChange) []string {
+		var codes []string
+		for _, change := range changes {
+			codes = append(codes, change.AppointmentCode)
+		}
+		return codes
+	}
+	check := func(result *BulkResult) {
+		t.Helper()
+		if len(result.Reassigned) != 1 || result.Reassigned[0].AppointmentCode != morning.AppointmentCode || result.Reassigned[0].ToDoctor != "doc2" {
+			t.Fatalf("want the morning appointment moved to doc2, got %+v", result.Reassigned)
+		}
+		// doc2 is busy at 11:00 and gone by 14:00, doc3 works in another field
+		if got := codes(result.Cancelled); len(got) != 2 || got[0] != busy.AppointmentCode || got[1] != afternoon.AppointmentCode {
+			t.Fatalf("want the other two cancelled, got %v", got)
+		}
+		if result.Patients != 2 || len(result.Failed) != 0 {
+			t.Fatalf("want two patients and no failures, got %+v", result)
+		}
+	}
+
+	preview, err := ClearDoctorDays(ctx, app, request, "admin")
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	check(preview)
+	if kept, _ := app.Store.Appointments.ListByDoctor(ctx, "doc1"); len(kept) != 3 || kept[0].CurrentStatus() != StatusBooked {
+		t.Fatalf("a dry run must change nothing: %+v", kept)
+	}
+
+	request.DryRun = false
+	request.Leave = true
+	result, err := ClearDoctorDays(ctx, app, request, "admin")
+	if err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	check(result)
+	if moved, _ := app.Store.Appointments.Get(ctx, morning.AppointmentCode); moved.DoctorCode != "doc2" || moved.Conflict != nil {
+		t.Fatalf("want the morning appointment with doc2, got %+v", moved)
+	}
+	if cancelled, _ := app.Store.Appointments.Get(ctx, busy.AppointmentCode); cancelled.CurrentStatus() != StatusCancelledByHospital {
+		t.Fatalf("want the busy slot cancelled, got %s", cancelled.CurrentStatus())
+	}
+	// The leave keeps the day from being booked again
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: AppointmentTime{Date: date, Time: "11:00"}}); !errors.Is(err, ErrInvalidSlot) {
+		t.Fatalf("want the day on leave, got %v", err)
+	}
+
+	request.Action = "postpone"
+	if _, err := ClearDoctorDays(ctx, app, request, "admin"); !errors.Is(err, ErrInvalidBulkRequest) {
+		t.Fatalf("want an unknown action refused, got %v", err)
+	}
+}
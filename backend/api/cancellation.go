@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCannotCancel is returned when a patient cancels an appointment that has started,
+// is over or was already cancelled
+var ErrCannotCancel = errors.New("appointment cannot be cancelled")
+
+// LateCancellationError is returned when a patient cancels inside the cancellation
+// window of the hospital. The appointment is kept and Request is filed for an admin
+// to approve instead.
+type LateCancellationError struct {
+	CutoffHours int
+	Request     AppointmentDeleteRequest
+}
+
+func (e *LateCancellationError) Error() string {
+	return fmt.Sprintf("appointments can only be cancelled up to %d hours before they start, a cancel request was filed", e.CutoffHours)
+}
+
+// CancelAppointment cancels the appointment on behalf of the patient. Inside the
+// cancellation window of the doctor's hospital it files a cancel request and returns
+// a *LateCancellationError, unless override is set for a doctor or admin. Either way
+// a cancellation inside the window is marked CancelledLate. The override only lifts the
+// window, an appointment that has started is never cancelled.
+func CancelAppointment(ctx context.Context, app *App, appointmentCode, cancelledBy, reason string, override bool) (*Appointment, error) {
+	return cancelAppointment(ctx, app, appointmentCode, cancelledBy, reason, override, true)
+}
+
+// cancelAppointment is CancelAppointment, notify false leaves the cancellation email
+// to the caller
+func cancelAppointment(ctx context.Context, app *App, appointmentCode, cancelledBy, reason string, override, notify bool) (*Appointment, error) {
+	appointment, err := app.Store.Appointments.Get(ctx, appointmentCode)
+	if err != nil {
+		return nil, err
+	}
+	status := appointment.CurrentStatus()
+	if !status.CanMoveTo(StatusCancelledByPatient) {
+		return nil, fmt.Errorf("%w: it is %s", ErrCannotCancel, status)
+	}
+	now := time.Now()
+	if !isFuture(app, *appointment, now) {
+		return nil, fmt.Errorf("%w: it has already started", ErrCannotCancel)
+	}
+
+	late, cutoff, err := cancelsLate(ctx, app, *appointment, now)
+	if err != nil {
+		return nil, err
+	}
+	if late && !override {
+		request, err := fileLateCancellation(ctx, app, *appointment, reason)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &LateCancellationError{CutoffHours: cutoff, Request: *request}
+	}
+	return transitionAppointment(ctx, app, appointmentCode, StatusCancelledByPatient, cancelledBy, reason, notify)
+}
+
+// cancelsLate reports whether cancelling the appointment now falls inside the
+// cancellation window of the doctor's hospital, and returns the window in hours
+func cancelsLate(ctx context.Context, app *App, appointment Appointment, now time.Time) (bool, int, error) {
+	doctor, err := app.Store.Doctors.Lookup(ctx, appointment.DoctorCode)
+	if err != nil {
+		return false, 0, err
+	}
+	policy, err := GetBookingPolicy(ctx, app)
+	if err != nil {
+		return false, 0, err
+	}
+	cutoff := policy.RulesFor(doctor.HospitalCode).CancelCutoffHours
+	if cutoff == 0 {
+		return false, 0, nil
+	}
+	return !isFuture(app, appointment, now.Add(time.Duration(cutoff)*time.Hour)), cutoff, nil
+}
+
+// fileLateCancellation files a cancel request for the patient, or returns the one
+// still pending for the appointment
+func fileLateCancellation(ctx context.Context, app *App, appointment Appointment, reason string) (*AppointmentDeleteRequest, error) {
+	requests, err := app.Store.Requests.ListByDoctor(ctx, appointment.DoctorCode)
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		if request.AppointmentCode == appointment.AppointmentCode && request.Status == RequestPending {
+			return &request, nil
+		}
+	}
+	return insertCancelRequest(ctx, app, AppointmentDeleteRequest{
+		AppointmentCode: appointment.AppointmentCode,
+		DoctorCode:      appointment.DoctorCode,
+		UserCode:        appointment.UserCode,
+		Reason:          reason,
+	})
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLateCancellation(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+
+	// A five day window keeps the test independent of the time of day
+	policy := DefaultBookingPolicy()
+	policy.Rules.CancelCutoffHours = 5 * 24
+	if _, err := UpdateBookingPolicy(ctx, app, policy); err != nil {
+		t.Fatalf("update policy: %v", err)
+	}
+
+	day := func(days int) string {
+		return time.Now().AddDate(0, 0, days).Format("2006-01-02")
+	}
+	book := func(date string) *Appointment {
+		t.Helper()
+		appointment, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: "10:00"}})
+		if err != nil {
+			t.Fatalf("book %s: %v", date, err)
+		}
+		return appointment
+	}
+
+	soon := book(day(3))
+	_, err := CancelAppointment(ctx, app, soon.AppointmentCode, "patient1", "sick", false)
+	var late *LateCancellationError
+	if !errors.As(err, &late) || late.Request.Status != RequestPending || late.Request.UserCode != "patient1" {
+		t.Fatalf("want a pending cancel request, got %v", err)
+	}
+	if kept, _ := GetAppointment(ctx, app, soon.AppointmentCode); kept.CurrentStatus() != StatusBooked {
+		t.Fatalf("a late cancellation must keep the appointment: %+v", kept)
+	}
+	_, err = CancelAppointment(ctx, app, soon.AppointmentCode, "patient1", "sick", false)
+	var again *LateCancellationError
+	if !errors.As(err, &again) || again.Request.RequestCode != late.Request.RequestCode {
+		t.Fatalf("want the pending request returned again, got %v", err)
+	}
+
+	if err := UpdateCancelRequestStatus(ctx, app, late.Request.RequestCode, RequestApproved, "admin"); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	approved, _ := GetAppointment(ctx, app, soon.AppointmentCode)
+	if approved.CurrentStatus() != StatusCancelledByPatient || !approved.CancelledLate {
+		t.Fatalf("approving must cancel late for the patient: %+v", approved)
+	}
+	if err := UpdateCancelRequestStatus(ctx, app, late.Request.RequestCode, RequestRejected, "admin"); !errors.Is(err, ErrRequestClosed) {
+		t.Fatalf("want a closed request kept, got %v", err)
+	}
+
+	overridden, err := CancelAppointment(ctx, app, book(day(4)).AppointmentCode, "admin", "", true)
+	if err != nil || !overridden.CancelledLate {
+		t.Fatalf("an override must cancel and record it as late: %+v (%v)", overridden, err)
+	}
+	early, err := CancelAppointment(ctx, app, book(day(10)).AppointmentCode, "patient1", "", false)
+	if err != nil || early.CancelledLate {
+		t.Fatalf("want an early cancellation: %+v (%v)", early, err)
+	}
+
+	page, err := ListAppointments(ctx, app, AppointmentFilter{LateCancelled: true}, ListOptions{})
+	if err != nil || page.Total != 2 {
+		t.Fatalf("want two late cancellations reported, got %+v (%v)", page, err)
+	}
+
+	app.Store.Appointments.Insert(ctx, Appointment{AppointmentCode: "past", DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: day(-1), Time: "10:00"}})
+	if _, err := CancelAppointment(ctx, app, "past", "patient1", "", false); !errors.Is(err, ErrCannotCancel) {
+		t.Fatalf("want a past appointment kept, got %v", err)
+	}
+}
+
+func TestCancelRequestAnsweredOnce(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	date := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	book := func(at string) (*Appointment, *AppointmentDeleteRequest) {
+		t.Helper()
+		appointment, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: at}})
+		if err != nil {
+			t.Fatalf("book %s: %v", at, err)
+		}
+		request, err := insertCancelRequest(ctx, app, AppointmentDeleteRequest{AppointmentCode: appointment.AppointmentCode, DoctorCode: "doc1", UserCode: "patient1"})
+		if err != nil {
+			t.Fatalf("file request: %v", err)
+		}
+		return appointment, request
+	}
+
+	// Two admins answer at once, the appointment must match the answer that is kept
+	appointment, request := book("10:00")
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	start := make(chan struct{})
+	for _, status := range []string{RequestApproved, RequestRejected} {
+		wg.Add(1)
+		go func(status string) {
+			defer wg.Done()
+			<-start
+			errs <- UpdateCancelRequestStatus(ctx, app, request.RequestCode, status, "admin")
+		}(status)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	answered := 0
+	for err := range errs {
+		if err == nil {
+			answered++
+		} else if !errors.Is(err, ErrRequestClosed) {
+			t.Fatalf("want the other answer refused as closed, got %v", err)
+		}
+	}
+	if answered != 1 {
+		t.Fatalf("want exactly one answer kept, got %d", answered)
+	}
+	stored, _ := app.Store.Requests.Get(ctx, request.RequestCode)
+	kept, _ := GetAppointment(ctx, app, appointment.AppointmentCode)
+	if cancelled := kept.CurrentStatus() == StatusCancelledByPatient; cancelled != (stored.Status == RequestApproved) {
+		t.Fatalf("request %s but the appointment is %s", stored.Status, kept.CurrentStatus())
+	}
+
+	// An approval the appointment cannot follow leaves the request pending
+	appointment, request = book("11:00")
+	if _, err := TransitionAppointment(ctx, app, appointment.AppointmentCode, StatusCancelledByHospital, "admin", ""); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if err := UpdateCancelRequestStatus(ctx, app, request.RequestCode, RequestApproved, "admin"); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("want the approval refused, got %v", err)
+	}
+	if reopened, _ := app.Store.Requests.Get(ctx, request.RequestCode); reopened.Status != RequestPending {
+		t.Fatalf("want the request reopened, got %+v", reopened)
+	}
+}
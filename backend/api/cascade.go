@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// DeletionPolicy decides what happens to the future appointments of a deleted
+// doctor, hospital or user. Past appointments are kept as history either way.
+type DeletionPolicy string
+
+const (
+	// PolicyBlock refuses the deletion while future appointments exist
+	PolicyBlock DeletionPolicy = "block"
+	// PolicyCancel cancels the future appointments and notifies their patients. They
+	// stay on record with a cancelled status.
+	PolicyCancel DeletionPolicy = "cancel"
+	// PolicyReassign moves each future appointment to a free doctor of the same
+	// field, in the same hospital first and then in the same district. The ones
+	// no doctor can take are cancelled.
+	PolicyReassign DeletionPolicy = "reassign"
+)
+
+// ErrInvalidPolicy is returned for an unknown deletion policy or one that does not
+// apply to the record
+var ErrInvalidPolicy = errors.New("invalid deletion policy")
+
+// ParseDeletionPolicy reads a policy name, an empty one means PolicyBlock
+func ParseDeletionPolicy(name string) (DeletionPolicy, error) {
+	switch policy := DeletionPolicy(name); policy {
+	case "":
+		return PolicyBlock, nil
+	case PolicyBlock, PolicyCancel, PolicyReassign:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidPolicy, name)
+	}
+}
+
+// DeletionBlockedError is returned under PolicyBlock when future appointments
+// depend on the record, nothing has been deleted then
+type DeletionBlockedError struct {
+	Appointments []string
+}
+
+func (e *DeletionBlockedError) Error() string {
+	return fmt.Sprintf("%d future appointments depend on it, cancel or reassign them first", len(e.Appointments))
+}
+
+// DeletionReport lists what a deletion did to the records depending on it
+type DeletionReport struct {
+	Policy DeletionPolicy `json:"policy"`
+	// Doctors deleted along with a hospital
+	Doctors    []string       `json:"doctors"`
+	Cancelled  []string       `json:"cancelled"`
+	Reassigned []Reassignment `json:"reassigned"`
+}
+
+// Reassignment is an appointment moved to another doctor at the same date and time
+type Reassignment struct {
+	AppointmentCode string `json:"appointmentCode"`
+	FromDoctor      string `json:"fromDoctor"`
+	ToDoctor        string `json:"toDoctor"`
+}
+
+func newDeletionReport(policy DeletionPolicy) *DeletionReport {
+	return &DeletionReport{Policy: policy, Doctors: []string{}, Cancelled: []string{}, Reassigned: []Reassignment{}}
+}
+
+// isFuture reports whether the appointment has not started yet
+func isFuture(app *App, appointment Appointment, now time.Time) bool {
+	start, err := appointment.Start(app)
+	if err != nil {
+		// Keep appointments with a malformed time on the safe side
+		return appointment.AppointmentTime.Date >= now.In(location(app, appointment.TimeZone)).Format("2006-01-02")
+	}
+	return !start.Before(now)
+}
+
+// futureAppointmentsOfDoctors lists the appointments of the doctors that have not started yet
+func futureAppointmentsOfDoctors(ctx context.Context, app *App, doctorCodes []string) ([]Appointment, error) {
+	var future []Appointment
+	for _, doctorCode := range doctorCodes {
+		appointments, err := app.Store.Appointments.ListByDoctor(ctx, doctorCode)
+		if err != nil {
+			return nil, err
+		}
+		future = append(future, onlyFuture(app, appointments)...)
+	}
+	return future, nil
+}
+
+// onlyFuture keeps the appointments that have neither started nor been cancelled
+func onlyFuture(app *App, appointments []Appointment) []Appointment {
+	now := time.Now()
+	return slices.DeleteFunc(appointments, func(a Appointment) bool {
+		return !isFuture(app, a, now) || a.CurrentStatus().IsFinal()
+	})
+}
+
+// checkPolicy fails with a *DeletionBlockedError when policy blocks on the appointments
+func checkPolicy(policy DeletionPolicy, appointments []Appointment) error {
+	if policy != PolicyBlock || len(appointments) == 0 {
+		return nil
+	}
+	codes := make([]string, len(appointments))
+	for i, appointment := range appointments {
+		codes[i] = appointment.AppointmentCode
+	}
+	return &DeletionBlockedError{Appointments: codes}
+}
+
+// resolveAppointments cancels or reassigns the future appointments of a deleted record
+// and adds them to the report. Cancelled ones move to the cancelled status, with the
+// reason as note. A failure on one appointment is logged and skipped.
+func resolveAppointments(ctx context.Context, app *App, policy DeletionPolicy, appointments []Appointment, cancelled AppointmentStatus, reason, deletedBy string, report *DeletionReport) {
+	for _, appointment := range appointments {
+		if policy == PolicyReassign {
+			if doctorCode, ok := reassignAppointment(ctx, app, appointment); ok {
+				report.Reassigned = append(report.Reassigned, Reassignment{
+					AppointmentCode: appointment.AppointmentCode,
+					FromDoctor:      appointment.DoctorCode,
+					ToDoctor:        doctorCode,
+				})
+				continue
+			}
+		}
+
+		if _, err := TransitionAppointment(ctx, app, appointment.AppointmentCode, cancelled, deletedBy, reason); err != nil {
+			log.Printf("Error cancelling appointment %s: %v", appointment.AppointmentCode, err)
+			continue
+		}
+		report.Cancelled = append(report.Cancelled, appointment.AppointmentCode)
+	}
+}
+
+// reassignAppointment moves the appointment to the first replacement doctor that
+// works and is free at its slot and returns that doctor's code
+func reassignAppointment(ctx context.Context, app *App, appointment Appointment) (string, bool) {
+	previous, err := app.Store.Doctors.Lookup(ctx, appointment.DoctorCode)
+	if err != nil {
+		log.Printf("Error getting doctor %s: %v", appointment.DoctorCode, err)
+		return "", false
+	}
+
+	doctor, err := reassignTo(ctx, app, appointment, replacementDoctors(ctx, app, previous))
+	if err != nil || doctor == nil {
+		return "", false
+	}
+	moved := appointment
+	moved.DoctorCode = doctor.DoctorCode
+	notifyReassignment(ctx, app, moved, previous, doctor)
+	return doctor.DoctorCode, true
+}
+
+// reassignTo moves the appointment to the first of the candidates that works and is
+// free at its slot and returns that doctor, nil when none is
+func reassignTo(ctx context.Context, app *App, appointment Appointment, candidates []Doctor) (*Doctor, error) {
+	for _, doctor := range candidates {
+		moved := appointment
+		moved.DoctorCode = doctor.DoctorCode
+		_, err := UpdateAppointment(ctx, app, moved)
+		var conflict *SlotConflictError
+		if errors.As(err, &conflict) || errors.Is(err, ErrInvalidSlot) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error reassigning appointment %s: %v", appointment.AppointmentCode, err)
+			return nil, err
+		}
+		return &doctor, nil
+	}
+	return nil, nil
+}
+
+// replacementDoctors lists the live doctors of the same field as doctor, those of
+// its own hospital first and then those of the other hospitals in its district
+func replacementDoctors(ctx context.Context, app *App, doctor *Doctor) []Doctor {
+	sameField := func(d Doctor) bool {
+		return d.FieldCode == doctor.FieldCode && d.DoctorCode != doctor.DoctorCode
+	}
+
+	var candidates []Doctor
+	if doctors, err := app.Store.Doctors.ListByHospital(ctx, doctor.HospitalCode); err == nil {
+		candidates = append(candidates, slices.DeleteFunc(doctors, func(d Doctor) bool { return !sameField(d) })...)
+	}
+
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		return candidates
+	}
+	hospitals, err := app.Store.Hospitals.ListByDistrict(ctx, hospital.DistrictCode)
+	if err != nil {
+		return candidates
+	}
+	for _, other := range hospitals {
+		if other.HospitalCode == doctor.HospitalCode || !slices.Contains(other.Fields, doctor.FieldCode) {
+			continue
+		}
+		if doctors, err := app.Store.Doctors.ListByHospital(ctx, other.HospitalCode); err == nil {
+			candidates = append(candidates, slices.DeleteFunc(doctors, func(d Doctor) bool { return !sameField(d) })...)
+		}
+	}
+	return candidates
+}
+
+// notifyReassignment emails the patient about the new doctor of the appointment
+func notifyReassignment(ctx context.Context, app *App, appointment Appointment, previous, doctor *Doctor) {
+	if app.Mailer == nil {
+		return
+	}
+	user, err := app.Store.Users.Lookup(ctx, appointment.UserCode)
+	if err != nil {
+		log.Println("Error getting user:", err)
+		return
+	}
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		log.Println("Error getting hospital:", err)
+		return
+	}
+
+	err = app.Mailer.SendAppointmentReassignmentEmail(
+		context.WithoutCancel(ctx),
+		user.Email,
+		user.UserCode,
+		previous.DoctorName,
+		doctor.DoctorName,
+		hospital.HospitalName,
+		displayDate(appointment.AppointmentTime.Date),
+		appointment.AppointmentTime.Time,
+	)
+	if err != nil {
+		log.Println("Error sending reassignment email:", err)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDeleteDoctorPolicies(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc2", DoctorName: "Other Doctor", HospitalCode: 1})
+	for _, userCode := range []string{"patient1", "patient2", "patient3"} {
+		app.Store.Users.Insert(ctx, User{UserCode: userCode, Role: "patient"})
+	}
+
+	day := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	book := func(doctorCode, userCode, at string) *Appointment {
+		appointment, err := CreateAppointment(ctx, app, Appointment{DoctorCode: doctorCode, UserCode: userCode, AppointmentTime: AppointmentTime{Date: day, Time: at}})
+		if err != nil {
+			t.Fatalf("booking %s at %s: %v", doctorCode, at, err)
+		}
+		return appointment
+	}
+	free := book("doc1", "patient1", "10:00")
+	taken := book("doc1", "patient2", "11:00")
+	book("doc2", "patient3", "11:00")
+
+	var blocked *DeletionBlockedError
+	if _, err := DeleteDoctor(ctx, app, "doc1", "admin1", PolicyBlock); !errors.As(err, &blocked) || len(blocked.Appointments) != 2 {
+		t.Fatalf("want the deletion blocked by 2 appointments, got %v", err)
+	}
+	if _, err := GetDoctor(ctx, app, "doc1"); err != nil {
+		t.Fatalf("blocked deletion removed the doctor: %v", err)
+	}
+
+	report, err := DeleteDoctor(ctx, app, "doc1", "admin1", PolicyReassign)
+	if err != nil {
+		t.Fatalf("reassign: %v", err)
+	}
+	want := []Reassignment{{AppointmentCode: free.AppointmentCode, FromDoctor: "doc1", ToDoctor: "doc2"}}
+	if !slices.Equal(report.Reassigned, want) || !slices.Equal(report.Cancelled, []string{taken.AppointmentCode}) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	moved, err := GetAppointmentDetails(ctx, app, free.AppointmentCode)
+	if err != nil || moved.Doctor.DoctorCode != "doc2" {
+		t.Fatalf("appointment not moved to doc2: %+v (%v)", moved, err)
+	}
+	if cancelled, err := GetAppointment(ctx, app, taken.AppointmentCode); err != nil || cancelled.Status != StatusCancelledByHospital {
+		t.Fatalf("appointment without a free doctor was not cancelled: %+v (%v)", cancelled, err)
+	}
+}
+
+func TestAppointmentDetailsOfDeletedDoctor(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	past := Appointment{AppointmentCode: "past1", DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: "2020-01-01", Time: "10:00"}}
+	app.Store.Appointments.Insert(ctx, past)
+
+	report, err := DeleteDoctor(ctx, app, "doc1", "admin1", PolicyBlock)
+	if err != nil || len(report.Cancelled) != 0 {
+		t.Fatalf("past appointments must not block or be cancelled: %+v (%v)", report, err)
+	}
+	details, err := GetAppointmentDetails(ctx, app, "past1")
+	if err != nil || details.Doctor.DoctorName != "Test Doctor" || details.Hospital.HospitalName != "Test Hastanesi" {
+		t.Fatalf("details of a past appointment lost the deleted doctor: %+v (%v)", details, err)
+	}
+}
@@ -1,15 +1,14 @@
 package api
 
 import (
+	"backend/config"
 	"backend/helper"
+	"backend/scheduling"
 	"context"
 	"errors"
 	"fmt"
 	"log"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Doctor struct {
@@ -18,8 +17,41 @@ type Doctor struct {
 	FieldCode    int       `bson:"field" json:"field"`
 	HospitalCode int       `bson:"hospitalCode" json:"hospitalCode"`
 	WorkHours    WorkHours `bson:"workHours" json:"workHours"`
-	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
-	UpdatedAt    time.Time `bson:"updatedAt" json:"updatedAt"`
+	// UserCode is the account the doctor signs in with, it writes the doctor's encounter notes
+	UserCode string `bson:"userCode,omitempty" json:"userCode,omitempty"`
+	// SlotMinutes is the length of the doctor's appointments, zero uses the field default
+	SlotMinutes int `bson:"slotMinutes,omitempty" json:"slotMinutes,omitempty"`
+	// Availability is the weekly template with its overrides, nil means WorkHours every day
+	Availability *scheduling.Availability `bson:"availability,omitempty" json:"availability,omitempty"`
+	CreatedAt    time.Time                `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time                `bson:"updatedAt" json:"updatedAt"`
+	Version      int64                    `bson:"version" json:"version"`
+	Tombstone    `bson:",inline"`
+}
+
+// ErrInvalidSlotLength is returned for a doctor slot length off the slot grid
+var ErrInvalidSlotLength = errors.New("invalid slot length")
+
+// ErrDoctorAccountTaken is returned when the user account already belongs to another doctor
+var ErrDoctorAccountTaken = errors.New("user account already belongs to another doctor")
+
+// CheckSlotMinutes accepts zero, which means the field default, or a usable appointment length
+func CheckSlotMinutes(minutes int) error {
+	if minutes != 0 && !config.ValidSlotMinutes(minutes) {
+		return fmt.Errorf("%w: %d minutes, use a multiple of %d up to 240", ErrInvalidSlotLength, minutes, config.SlotStep)
+	}
+	return nil
+}
+
+// CheckDoctorSchedule checks the slot length and the availability of the doctor
+func CheckDoctorSchedule(doctor Doctor) error {
+	if err := CheckSlotMinutes(doctor.SlotMinutes); err != nil {
+		return err
+	}
+	if doctor.Availability != nil {
+		return doctor.Availability.Validate()
+	}
+	return nil
 }
 
 type WorkHours struct {
@@ -27,102 +59,186 @@ type WorkHours struct {
 	End   string `bson:"end" json:"end"`
 }
 
-func CreateDoctor(client *mongo.Client, doctor Doctor) {
-	collection := client.Database("healthcare").Collection("doctors")
+// checkDoctorAccount fails with ErrDoctorAccountTaken when another doctor signs in
+// with the doctor's user account, note access follows that account
+func checkDoctorAccount(ctx context.Context, app *App, doctor Doctor) error {
+	if doctor.UserCode == "" {
+		return nil
+	}
+	other, err := app.Store.Doctors.GetByUser(ctx, doctor.UserCode)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if other.DoctorCode != doctor.DoctorCode {
+		return fmt.Errorf("%w: %s", ErrDoctorAccountTaken, doctor.UserCode)
+	}
+	return nil
+}
+
+func CreateDoctor(ctx context.Context, app *App, doctor Doctor) (*Doctor, error) {
 	doctor.DoctorCode = helper.GenerateID(6)
 	doctor.CreatedAt = time.Now()
 	doctor.UpdatedAt = time.Now()
-	DoctorCreationFieldCheck(client, doctor.HospitalCode, doctor.FieldCode)
-	collection.InsertOne(context.TODO(), doctor)
+	doctor.Version = 0
+	doctor.Tombstone = Tombstone{}
+	if err := checkDoctorAccount(ctx, app, doctor); err != nil {
+		return nil, err
+	}
+	if err := app.Store.Doctors.Insert(ctx, doctor); err != nil {
+		log.Println("Error creating doctor:", err)
+		return nil, err
+	}
+	DoctorCreationFieldCheck(ctx, app, doctor.HospitalCode, doctor.FieldCode)
+	recordAudit(ctx, app, AuditCreate, TargetDoctor, doctor.DoctorCode, nil, doctor)
+	return &doctor, nil
 }
 
-func DeleteDoctor(client *mongo.Client, doctorCode string) {
-	collection := client.Database("healthcare").Collection("doctors")
-	doctor, err := GetDoctor(client, doctorCode)
-	if err != nil || doctor == nil {
-		log.Println("Doctor not found:", err)
-		return
+// DeleteDoctor soft deletes the doctor and drops its field from the hospital
+// when no other doctor covers it. Its future appointments are handled by policy,
+// the doctor is deleted first so that no new ones are booked meanwhile.
+func DeleteDoctor(ctx context.Context, app *App, doctorCode, deletedBy string, policy DeletionPolicy) (*DeletionReport, error) {
+	doctor, err := app.Store.Doctors.Get(ctx, doctorCode)
+	if err != nil {
+		return nil, err
 	}
-	_, err = collection.DeleteOne(context.TODO(), bson.M{"doctorCode": doctorCode})
+	appointments, err := futureAppointmentsOfDoctors(ctx, app, []string{doctorCode})
 	if err != nil {
-		log.Println("Error deleting doctor:", err)
-		return
+		return nil, err
+	}
+	if err := checkPolicy(policy, appointments); err != nil {
+		return nil, err
 	}
-	DoctorDeletionFieldCheck(client, doctor.HospitalCode, doctor.FieldCode)
-}
 
-func UpdateDoctor(client *mongo.Client, updatedDoctor Doctor) {
-	collection := client.Database("healthcare").Collection("doctors")
-	DoctorUpdateFieldCheck(client, updatedDoctor)
-	updatedDoctor.UpdatedAt = time.Now()
-	_, err := collection.ReplaceOne(
-		context.TODO(),
-		bson.M{"doctorCode": updatedDoctor.DoctorCode},
-		updatedDoctor,
-	)
+	if err := deleteDoctor(ctx, app, doctor, newTombstone(deletedBy)); err != nil {
+		return nil, err
+	}
+	report := newDeletionReport(policy)
+	resolveAppointments(ctx, app, policy, appointments, StatusCancelledByHospital, "doctor deleted", deletedBy, report)
+	return report, nil
+}
 
+func deleteDoctor(ctx context.Context, app *App, doctor *Doctor, tombstone Tombstone) error {
+	err := app.Store.Doctors.SoftDelete(ctx, doctor.DoctorCode, tombstone)
 	if err != nil {
-		log.Println("Error updating hospital:", err)
+		log.Println("Error deleting doctor:", err)
+		return err
 	}
+
+	deleted := *doctor
+	deleted.Tombstone = tombstone
+	recordAudit(ctx, app, AuditDelete, TargetDoctor, doctor.DoctorCode, doctor, deleted)
+	DoctorDeletionFieldCheck(ctx, app, doctor.HospitalCode, doctor.FieldCode)
+	return nil
 }
 
-func GetAllDoctors(client *mongo.Client) []Doctor {
-	collection := client.Database("healthcare").Collection("doctors")
+// RestoreDoctor brings back a soft deleted doctor. The hospital of the doctor
+// must not be deleted, restore it first.
+func RestoreDoctor(ctx context.Context, app *App, doctorCode string) error {
+	doctor, err := app.Store.Doctors.Restore(ctx, doctorCode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := GetHospital(ctx, app, doctor.HospitalCode); err != nil {
+		// Put the tombstone back rather than leave a doctor without a hospital
+		if deleteErr := app.Store.Doctors.SoftDelete(ctx, doctorCode, doctor.Tombstone); deleteErr != nil {
+			log.Println("Error deleting doctor again:", deleteErr)
+		}
+		return fmt.Errorf("hospital %d of the doctor is deleted, restore it first", doctor.HospitalCode)
+	}
 
-	filter := bson.D{{}}
+	restored := *doctor
+	restored.Tombstone = Tombstone{}
+	recordAudit(ctx, app, AuditRestore, TargetDoctor, doctorCode, doctor, restored)
+	DoctorCreationFieldCheck(ctx, app, doctor.HospitalCode, doctor.FieldCode)
+	return nil
+}
+
+// UpdateDoctor replaces the doctor. updatedDoctor.Version must be the version the
+// caller read, ErrVersionConflict is returned when the doctor was changed since.
+func UpdateDoctor(ctx context.Context, app *App, updatedDoctor Doctor) (*Doctor, error) {
+	if err := CheckDoctorSchedule(updatedDoctor); err != nil {
+		return nil, err
+	}
+	if err := checkDoctorAccount(ctx, app, updatedDoctor); err != nil {
+		return nil, err
+	}
+	existing, err := app.Store.Doctors.Get(ctx, updatedDoctor.DoctorCode)
+	if err != nil {
+		return nil, err
+	}
+	version := updatedDoctor.Version
+	updatedDoctor.Version = version + 1
+	updatedDoctor.UpdatedAt = time.Now()
+	updatedDoctor.Tombstone = Tombstone{}
+	err = app.Store.Doctors.Replace(ctx, updatedDoctor, version)
 
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
+	if err != nil {
+		log.Println("Error updating doctor:", err)
+		return nil, err
+	}
+	recordAudit(ctx, app, AuditUpdate, TargetDoctor, updatedDoctor.DoctorCode, existing, updatedDoctor)
+	DoctorUpdateFieldCheck(ctx, app, *existing, updatedDoctor)
+	return &updatedDoctor, nil
+}
 
-	var doctors []Doctor
-	cursor.All(context.TODO(), &doctors)
+func GetAllDoctors(ctx context.Context, app *App) []Doctor {
+	doctors, _ := app.Store.Doctors.List(ctx)
 
 	return doctors
 }
 
-func GetDoctor(client *mongo.Client, doctorCode string) (*Doctor, error) {
-	collection := client.Database("healthcare").Collection("doctors")
+// ListDoctors returns one page of doctors matching filter
+func ListDoctors(ctx context.Context, app *App, filter DoctorFilter, opts ListOptions) (*Page[Doctor], error) {
+	query, err := newListQuery(opts, doctorSortFields, "doctorName", "doctorCode")
+	if err != nil {
+		return nil, err
+	}
 
-	filter := bson.D{{Key: "doctorCode", Value: doctorCode}}
-	var doctor Doctor
+	doctors, total, err := app.Store.Doctors.Find(ctx, filter, query)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(doctors, total, query), nil
+}
 
-	err := collection.FindOne(context.TODO(), filter).Decode(&doctor)
+func GetDoctor(ctx context.Context, app *App, doctorCode string) (*Doctor, error) {
+	doctor, err := app.Store.Doctors.Get(ctx, doctorCode)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == ErrNotFound {
 			return nil, errors.New("doctor not found")
 		}
 		return nil, err
 	}
 
-	return &doctor, nil
+	return doctor, nil
 }
 
-func GetDoctorsByHospitalCode(client *mongo.Client, hospitalCode int) ([]Doctor, error) {
-	collection := client.Database("healthcare").Collection("doctors")
-
-	filter := bson.D{{Key: "hospitalCode", Value: hospitalCode}}
+// IsDoctorAccount reports whether the user signs in as the doctor
+func IsDoctorAccount(ctx context.Context, app *App, userCode, doctorCode string) bool {
+	doctor, err := app.Store.Doctors.GetByUser(ctx, userCode)
+	return err == nil && doctor.DoctorCode == doctorCode
+}
 
-	cursor, err := collection.Find(context.TODO(), filter)
+func GetDoctorsByHospitalCode(ctx context.Context, app *App, hospitalCode int) ([]Doctor, error) {
+	doctors, err := app.Store.Doctors.ListByHospital(ctx, hospitalCode)
 	if err != nil {
 		return nil, fmt.Errorf("error finding doctors: %v", err)
 	}
-	defer cursor.Close(context.TODO())
-
-	var doctors []Doctor
-	for cursor.Next(context.TODO()) {
-		var doctor Doctor
-		if err := cursor.Decode(&doctor); err != nil {
-			return nil, fmt.Errorf("error decoding doctor: %v", err)
-		}
-		doctors = append(doctors, doctor)
-	}
 	return doctors, nil
 }
 
-func InsertManyDoctors(client *mongo.Client, doctors []interface{}) error {
-	collection := client.Database("healthcare").Collection("doctors")
-	_, err := collection.InsertMany(context.TODO(), doctors)
-	return err
+func InsertManyDoctors(ctx context.Context, app *App, doctors []Doctor) error {
+	if err := app.Store.Doctors.InsertMany(ctx, doctors); err != nil {
+		return err
+	}
+	for _, doctor := range doctors {
+		recordAudit(ctx, app, AuditCreate, TargetDoctor, doctor.DoctorCode, nil, doctor)
+	}
+	return nil
 }
 
 /* func AddAppointmentToDoctor(client *mongo.Client, doctorCode, appointmentCode string) error {
@@ -146,3 +262,19 @@ func RemoveAppointmentFromDoctor(client *mongo.Client, doctorCode, appointmentCo
 	}
 	return nil
 } */
+
+// SetDoctorAvailability replaces the weekly template and overrides of the doctor, nil
+// goes back to its work hours every day. Booked appointments are kept even when they
+// fall outside the new hours. A negative version replaces whatever version is stored.
+func SetDoctorAvailability(ctx context.Context, app *App, doctorCode string, availability *scheduling.Availability, version int64) (*Doctor, error) {
+	doctor, err := app.Store.Doctors.Get(ctx, doctorCode)
+	if err != nil {
+		return nil, err
+	}
+	if version < 0 {
+		version = doctor.Version
+	}
+	doctor.Availability = availability
+	doctor.Version = version
+	return UpdateDoctor(ctx, app, *doctor)
+}
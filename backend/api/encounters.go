@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoteAccess is returned when the user may not read or change the encounter note
+	ErrNoteAccess = errors.New("no access to the encounter note")
+	// ErrInvalidNote is returned for an empty or malformed encounter note, or one for a
+	// visit that has not started
+	ErrInvalidNote = errors.New("invalid encounter note")
+)
+
+// diagnosisCode matches an ICD-10 code such as J06 or J06.9
+var diagnosisCode = regexp.MustCompile(`^[A-Z][0-9]{2}(\.[0-9A-Z]{1,4})?$`)
+
+// Encounter is what the doctor recorded about a visit
+type Encounter struct {
+	Complaint string `bson:"complaint" json:"complaint"`
+	Findings  string `bson:"findings" json:"findings"`
+	// DiagnosisCodes are ICD-10 codes
+	DiagnosisCodes []string `bson:"diagnosisCodes" json:"diagnosisCodes"`
+	Plan           string   `bson:"plan" json:"plan"`
+}
+
+// EncounterRevision is an earlier version of an encounter note
+type EncounterRevision struct {
+	Version   int64 `bson:"version" json:"version"`
+	Encounter `bson:",inline"`
+	WrittenBy string    `bson:"writtenBy" json:"writtenBy"`
+	WrittenAt time.Time `bson:"writtenAt" json:"writtenAt"`
+}
+
+// EncounterNote is the note the doctor of an appointment wrote about the visit. The
+// patient and the doctor can read it, other doctors only once they are granted access.
+type EncounterNote struct {
+	AppointmentCode string `bson:"appointmentCode" json:"appointmentCode"`
+	DoctorCode      string `bson:"doctorCode" json:"doctorCode"`
+	UserCode        string `bson:"userCode" json:"userCode"`
+	Encounter       `bson:",inline"`
+	// Grants lists the other doctors allowed to read the note
+	Grants []string `bson:"grants" json:"grants"`
+	// History keeps every earlier version of the note, oldest first
+	History   []EncounterRevision `bson:"history" json:"history"`
+	WrittenBy string              `bson:"writtenBy" json:"writtenBy"`
+	CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time           `bson:"updatedAt" json:"updatedAt"`
+	Version   int64               `bson:"version" json:"version"`
+}
+
+// NoteReader is the signed in user an encounter note is read or shared by
+type NoteReader struct {
+	UserCode string
+	Role     string
+}
+
+// validate trims the encounter and checks its diagnosis codes
+func (e *Encounter) validate() error {
+	e.Complaint = strings.TrimSpace(e.Complaint)
+	e.Findings = strings.TrimSpace(e.Findings)
+	e.Plan = strings.TrimSpace(e.Plan)
+	codes := []string{}
+	for _, code := range e.DiagnosisCodes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !diagnosisCode.MatchString(code) {
+			return fmt.Errorf("%w: %q is not an ICD-10 code", ErrInvalidNote, code)
+		}
+		if !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	e.DiagnosisCodes = codes
+	if e.Complaint == "" && e.Findings == "" && e.Plan == "" && len(codes) == 0 {
+		return fmt.Errorf("%w: the note is empty", ErrInvalidNote)
+	}
+	return nil
+}
+
+// visitStarted reports whether the patient has been seen, so there is something to note
+func visitStarted(status AppointmentStatus) bool {
+	return status == StatusCheckedIn || status == StatusInProgress || status == StatusCompleted
+}
+
+// WriteEncounterNote writes the encounter note of the appointment, or a new version
+// of it that keeps the earlier one in the history. Only the doctor of the appointment
+// can write it, once the patient checked in. version must be the version the caller
+// read, zero for the first note, ErrVersionConflict is returned otherwise.
+func WriteEncounterNote(ctx context.Context, app *App, appointmentCode string, encounter Encounter, version int64, writtenBy string) (*EncounterNote, error) {
+	if err := encounter.validate(); err != nil {
+		return nil, err
+	}
+	appointment, err := app.Store.Appointments.Get(ctx, appointmentCode)
+	if err != nil {
+		return nil, err
+	}
+	doctor, err := app.Store.Doctors.GetByUser(ctx, writtenBy)
+	if errors.Is(err, ErrNotFound) || (err == nil && doctor.DoctorCode != appointment.DoctorCode) {
+		return nil, fmt.Errorf("%w: only the doctor of the appointment can write it", ErrNoteAccess)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status := appointment.CurrentStatus(); !visitStarted(status) {
+		return nil, fmt.Errorf("%w: the appointment is %s", ErrInvalidNote, status)
+	}
+
+	existing, err := app.Store.Encounters.Get(ctx, appointmentCode)
+	if errors.Is(err, ErrNotFound) {
+		existing = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	note := EncounterNote{
+		AppointmentCode: appointment.AppointmentCode,
+		DoctorCode:      appointment.DoctorCode,
+		UserCode:        appointment.UserCode,
+		Grants:          []string{},
+		History:         []EncounterRevision{},
+		CreatedAt:       now,
+	}
+	if existing != nil {
+		if existing.Version != version {
+			return nil, ErrVersionConflict
+		}
+		note = *existing
+		note.History = append(slices.Clone(existing.History), EncounterRevision{
+			Version:   existing.Version,
+			Encounter: existing.Encounter,
+			WrittenBy: existing.WrittenBy,
+			WrittenAt: existing.UpdatedAt,
+		})
+	}
+	note.Encounter = encounter
+	note.WrittenBy = writtenBy
+	note.UpdatedAt = now
+	note.Version = version + 1
+
+	if err := app.Store.Encounters.Replace(ctx, note, version); err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		recordAudit(ctx, app, AuditCreate, TargetEncounterNote, appointmentCode, nil, note)
+	} else {
+		recordAudit(ctx, app, AuditUpdate, TargetEncounterNote, appointmentCode, existing, note)
+	}
+	return &note, nil
+}
+
+// GetEncounterNote returns the encounter note of the appointment, ErrNoteAccess when
+// the reader may not see it
+func GetEncounterNote(ctx context.Context, app *App, appointmentCode string, reader NoteReader) (*EncounterNote, error) {
+	note, err := app.Store.Encounters.Get(ctx, appointmentCode)
+	if err != nil {
+		return nil, err
+	}
+	if !canReadNote(ctx, app, *note, reader) {
+		return nil, ErrNoteAccess
+	}
+	return note, nil
+}
+
+// GrantEncounterNote lets another doctor read the encounter note of the appointment.
+// The patient, the doctor who wrote it and admins can share it.
+func GrantEncounterNote(ctx context.Context, app *App, appointmentCode, doctorCode string, reader NoteReader) (*EncounterNote, error) {
+	if _, err := app.Store.Doctors.Get(ctx, doctorCode); err != nil {
+		return nil, err
+	}
+	return changeGrants(ctx, app, appointmentCode, reader, func(grants []string) []string {
+		if slices.Contains(grants, doctorCode) {
+			return grants
+		}
+		return append(grants, doctorCode)
+	})
+}
+
+// RevokeEncounterNote takes the access to the encounter note of the appointment back
+// from the doctor
+func RevokeEncounterNote(ctx context.Context, app *App, appointmentCode, doctorCode string, reader NoteReader) (*EncounterNote, error) {
+	return changeGrants(ctx, app, appointmentCode, reader, func(grants []string) []string {
+		return slices.DeleteFunc(grants, func(granted string) bool { return granted == doctorCode })
+	})
+}
+
+// changeGrants applies change to the grants of the note, retrying when the note is
+// written concurrently. The grants are not part of the note's history.
+func changeGrants(ctx context.Context, app *App, appointmentCode string, reader NoteReader, change func([]string) []string) (*EncounterNote, error) {
+	for attempt := 1; ; attempt++ {
+		note, err := app.Store.Encounters.Get(ctx, appointmentCode)
+		if err != nil {
+			return nil, err
+		}
+		if !canShareNote(ctx, app, *note, reader) {
+			return nil, ErrNoteAccess
+		}
+
+		updated := *note
+		updated.Grants = change(slices.Clone(note.Grants))
+		updated.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+		updated.Version = note.Version + 1
+		err = app.Store.Encounters.Replace(ctx, updated, note.Version)
+		if errors.Is(err, ErrVersionConflict) && attempt < transitionRetries {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		recordAudit(ctx, app, AuditUpdate, TargetEncounterNote, appointmentCode, note, updated)
+		return &updated, nil
+	}
+}
+
+// canReadNote reports whether the reader may see the note: admins, its patient, its
+// doctor and the doctors it was shared with
+func canReadNote(ctx context.Context, app *App, note EncounterNote, reader NoteReader) bool {
+	switch reader.Role {
+	case "admin":
+		return true
+	case "doctor":
+		doctor, err := app.Store.Doctors.GetByUser(ctx, reader.UserCode)
+		return err == nil && (doctor.DoctorCode == note.DoctorCode || slices.Contains(note.Grants, doctor.DoctorCode))
+	default:
+		return reader.UserCode == note.UserCode
+	}
+}
+
+// canShareNote reports whether the reader may change who the note is shared with:
+// admins, its patient and its doctor
+func canShareNote(ctx context.Context, app *App, note EncounterNote, reader NoteReader) bool {
+	if reader.Role == "doctor" {
+		return IsDoctorAccount(ctx, app, reader.UserCode, note.DoctorCode)
+	}
+	return canReadNote(ctx, app, note, reader)
+}
+
+// attachEncounterNotes sets the encounter notes the reader may see on the appointments
+func attachEncounterNotes(ctx context.Context, app *App, appointments []Appointment, reader NoteReader) error {
+	codes := make([]string, len(appointments))
+	for i, appointment := range appointments {
+		codes[i] = appointment.AppointmentCode
+	}
+	notes, err := app.Store.Encounters.ListByAppointments(ctx, codes)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		if !canReadNote(ctx, app, note, reader) {
+			continue
+		}
+		i := slices.IndexFunc(appointments, func(a Appointment) bool { return a.AppointmentCode == note.AppointmentCode })
+		if i >= 0 {
+			appointments[i].EncounterNote = &note
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncounterNotes(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	doctor, _ := app.Store.Doctors.Get(ctx, "doc1")
+	doctor.UserCode = "drone"
+	if _, err := UpdateDoctor(ctx, app, *doctor); err != nil {
+		t.Fatalf("link doctor account: %v", err)
+	}
+	app.Store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc2", DoctorName: "Second Opinion", HospitalCode: 1, UserCode: "drtwo", WorkHours: WorkHours{Start: "09:00", End: "17:00"}})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	app.Store.Appointments.Insert(ctx, Appointment{AppointmentCode: "visit", DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: yesterday, Time: "10:00"}})
+
+	encounter := Encounter{Complaint: "Sore throat", DiagnosisCodes: []string{"j06.9"}, Plan: "Rest"}
+	if _, err := WriteEncounterNote(ctx, app, "visit", encounter, 0, "drone"); !errors.Is(err, ErrInvalidNote) {
+		t.Fatalf("want no note before the visit started, got %v", err)
+	}
+	if _, err := TransitionAppointment(ctx, app, "visit", StatusCheckedIn, "drone", ""); err != nil {
+		t.Fatalf("check in: %v", err)
+	}
+	if _, err := WriteEncounterNote(ctx, app, "visit", encounter, 0, "drtwo"); !errors.Is(err, ErrNoteAccess) {
+		t.Fatalf("want another doctor refused, got %v", err)
+	}
+	if _, err := WriteEncounterNote(ctx, app, "visit", Encounter{DiagnosisCodes: []string{"sore"}}, 0, "drone"); !errors.Is(err, ErrInvalidNote) {
+		t.Fatalf("want a malformed code refused, got %v", err)
+	}
+
+	note, err := WriteEncounterNote(ctx, app, "visit", encounter, 0, "drone")
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if note.Version != 1 || note.DiagnosisCodes[0] != "J06.9" {
+		t.Fatalf("want version 1 with J06.9, got %+v", note)
+	}
+	if _, err := WriteEncounterNote(ctx, app, "visit", encounter, 0, "drone"); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("want a stale version refused, got %v", err)
+	}
+	encounter.Findings = "Red pharynx"
+	note, err = WriteEncounterNote(ctx, app, "visit", encounter, 1, "drone")
+	if err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if note.Version != 2 || len(note.History) != 1 || note.History[0].Version != 1 || note.History[0].Findings != "" {
+		t.Fatalf("want the first version kept in the history, got %+v", note)
+	}
+
+	patient := NoteReader{UserCode: "patient1", Role: "patient"}
+	past := GetPastAppointmentsByUserCode(ctx, app, "patient1", patient)
+	if len(past) != 1 || past[0].EncounterNote == nil || past[0].EncounterNote.Findings != "Red pharynx" {
+		t.Fatalf("want the patient to see the note, got %+v", past)
+	}
+
+	other := NoteReader{UserCode: "drtwo", Role: "doctor"}
+	if _, err := GetEncounterNote(ctx, app, "visit", other); !errors.Is(err, ErrNoteAccess) {
+		t.Fatalf("want the note hidden from another doctor, got %v", err)
+	}
+	if past := GetPastAppointmentsByUserCode(ctx, app, "patient1", other); past[0].EncounterNote != nil {
+		t.Fatalf("want the note left off for another doctor, got %+v", past[0].EncounterNote)
+	}
+	if _, err := GrantEncounterNote(ctx, app, "visit", "doc2", other); !errors.Is(err, ErrNoteAccess) {
+		t.Fatalf("want a doctor unable to grant themselves access, got %v", err)
+	}
+	if _, err := GrantEncounterNote(ctx, app, "visit", "doc2", patient); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	if _, err := GetEncounterNote(ctx, app, "visit", other); err != nil {
+		t.Fatalf("want the granted doctor to read the note, got %v", err)
+	}
+	if _, err := RevokeEncounterNote(ctx, app, "visit", "doc2", NoteReader{UserCode: "drone", Role: "doctor"}); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := GetEncounterNote(ctx, app, "visit", other); !errors.Is(err, ErrNoteAccess) {
+		t.Fatalf("want the access revoked, got %v", err)
+	}
+}
+
+func TestDoctorAccountUnique(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc2", DoctorName: "Second Opinion", HospitalCode: 1, UserCode: "drtwo"})
+
+	if _, err := CreateDoctor(ctx, app, Doctor{DoctorName: "Impostor", HospitalCode: 1, UserCode: "drtwo"}); !errors.Is(err, ErrDoctorAccountTaken) {
+		t.Fatalf("want a new doctor with a taken account refused, got %v", err)
+	}
+	doctor, _ := app.Store.Doctors.Get(ctx, "doc1")
+	doctor.UserCode = "drtwo"
+	if _, err := UpdateDoctor(ctx, app, *doctor); !errors.Is(err, ErrDoctorAccountTaken) {
+		t.Fatalf("want a doctor moved to a taken account refused, got %v", err)
+	}
+	if err := app.Store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc3", UserCode: "drtwo"}); !errors.Is(err, ErrDoctorAccountTaken) {
+		t.Fatalf("want the store to refuse a shared account, got %v", err)
+	}
+	if found, err := app.Store.Doctors.GetByUser(ctx, "drtwo"); err != nil || found.DoctorCode != "doc2" {
+		t.Fatalf("want the account kept by doc2, got %+v (%v)", found, err)
+	}
+
+	// Saving a doctor with its own account is no conflict
+	second, _ := app.Store.Doctors.Get(ctx, "doc2")
+	second.DoctorName = "Second Opinion, MD"
+	if _, err := UpdateDoctor(ctx, app, *second); err != nil {
+		t.Fatalf("update doc2: %v", err)
+	}
+}
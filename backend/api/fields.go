@@ -2,9 +2,10 @@ package api
 
 import (
 	"backend/helper"
+	"context"
+	"log"
 	"slices"
-
-	"go.mongodb.org/mongo-driver/mongo"
+	"strconv"
 )
 
 type Field struct {
@@ -12,12 +13,12 @@ type Field struct {
 	FieldName string `bson:"fieldName" json:"fieldName"`
 }
 
-func GetFieldsByProvince(client *mongo.Client, provinceCode int) []int {
+func GetFieldsByProvince(ctx context.Context, app *App, provinceCode int) []int {
 
 	var found [10]bool
 	var fields []int
 
-	hospitals := GetHospitalsByProvince(client, provinceCode)
+	hospitals := GetHospitalsByProvince(ctx, app, provinceCode)
 
 	for _, hospital := range hospitals {
 		for _, field := range hospital.Fields {
@@ -31,12 +32,12 @@ func GetFieldsByProvince(client *mongo.Client, provinceCode int) []int {
 	return fields
 }
 
-func GetFieldsByDistrict(client *mongo.Client, districtCode int) []int {
+func GetFieldsByDistrict(ctx context.Context, app *App, districtCode int) []int {
 
 	var found [10]bool
 	var fields []int
 
-	hospitals := GetHospitalsByDistrict(client, districtCode)
+	hospitals := GetHospitalsByDistrict(ctx, app, districtCode)
 
 	for _, hospital := range hospitals {
 		for _, field := range hospital.Fields {
@@ -50,12 +51,13 @@ func GetFieldsByDistrict(client *mongo.Client, districtCode int) []int {
 	return fields
 }
 
-func DoctorDeletionFieldCheck(client *mongo.Client, hospitalCode int, fieldCode int) {
-	hospital, err := GetHospital(client, hospitalCode)
-	if err != nil {
+// DoctorDeletionFieldCheck drops the field from the hospital when no doctor is left in it
+func DoctorDeletionFieldCheck(ctx context.Context, app *App, hospitalCode int, fieldCode int) {
+	hospital, err := GetHospital(ctx, app, hospitalCode)
+	if err != nil || !slices.Contains(hospital.Fields, fieldCode) {
 		return
 	}
-	doctors, err := GetDoctorsByHospitalCode(client, hospitalCode)
+	doctors, err := GetDoctorsByHospitalCode(ctx, app, hospitalCode)
 	if err != nil {
 		return
 	}
@@ -64,23 +66,37 @@ func DoctorDeletionFieldCheck(client *mongo.Client, hospitalCode int, fieldCode
 			return
 		}
 	}
-	hospital.Fields = helper.RemoveFromSlice(hospital.Fields, fieldCode)
-	UpdateHospital(client, *hospital)
+	if err := app.Store.Hospitals.RemoveField(ctx, hospitalCode, fieldCode); err != nil {
+		log.Println("Error removing field from hospital:", err)
+		return
+	}
+
+	updated := *hospital
+	updated.Fields = helper.RemoveFromSlice(slices.Clone(hospital.Fields), fieldCode)
+	recordAudit(ctx, app, AuditUpdate, TargetHospital, strconv.Itoa(hospitalCode), hospital, updated)
 }
 
-func DoctorCreationFieldCheck(client *mongo.Client, hospitalCode int, fieldCode int) {
-	hospital, _ := GetHospital(client, hospitalCode)
-	if !slices.Contains(hospital.Fields, fieldCode) {
-		hospital.Fields = append(hospital.Fields, fieldCode)
-		UpdateHospital(client, *hospital)
+// DoctorCreationFieldCheck adds the field of a new doctor to the hospital
+func DoctorCreationFieldCheck(ctx context.Context, app *App, hospitalCode int, fieldCode int) {
+	hospital, err := GetHospital(ctx, app, hospitalCode)
+	if err != nil || slices.Contains(hospital.Fields, fieldCode) {
+		return
+	}
+	if err := app.Store.Hospitals.AddField(ctx, hospitalCode, fieldCode); err != nil {
+		log.Println("Error adding field to hospital:", err)
+		return
 	}
+
+	updated := *hospital
+	updated.Fields = append(slices.Clone(hospital.Fields), fieldCode)
+	recordAudit(ctx, app, AuditUpdate, TargetHospital, strconv.Itoa(hospitalCode), hospital, updated)
 }
 
-func DoctorUpdateFieldCheck(client *mongo.Client, updatedDoctor Doctor) {
-	doctor, _ := GetDoctor(client, updatedDoctor.DoctorCode)
-	if doctor.FieldCode != updatedDoctor.FieldCode || doctor.HospitalCode != updatedDoctor.HospitalCode {
-		DoctorDeletionFieldCheck(client, doctor.HospitalCode, doctor.FieldCode)
-		DoctorCreationFieldCheck(client, updatedDoctor.HospitalCode, updatedDoctor.FieldCode)
+// DoctorUpdateFieldCheck moves the field of a doctor that changed field or hospital
+func DoctorUpdateFieldCheck(ctx context.Context, app *App, previous, updated Doctor) {
+	if previous.FieldCode != updated.FieldCode || previous.HospitalCode != updated.HospitalCode {
+		DoctorDeletionFieldCheck(ctx, app, previous.HospitalCode, previous.FieldCode)
+		DoctorCreationFieldCheck(ctx, app, updated.HospitalCode, updated.FieldCode)
 	}
 }
 
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"backend/helper"
+)
+
+var (
+	// ErrHoldExpired is returned when a slot hold ran out, was released or was already used
+	ErrHoldExpired = errors.New("slot hold expired")
+	// ErrHoldMismatch is returned when a booking names the hold of another slot or patient
+	ErrHoldMismatch = errors.New("slot hold does not match the appointment")
+)
+
+// SlotHold keeps a slot of a doctor for a patient until ExpiresAt, while they fill
+// in the booking. Nobody else can book a held slot. HoldCode is the token the
+// booking names to take the slot over.
+type SlotHold struct {
+	HoldCode        string          `bson:"holdCode" json:"holdCode"`
+	DoctorCode      string          `bson:"doctorCode" json:"doctorCode"`
+	UserCode        string          `bson:"userCode" json:"userCode"`
+	AppointmentTime AppointmentTime `bson:"appointmentTime" json:"appointmentTime"`
+	// Minutes is the length the slot is held with
+	Minutes   int       `bson:"minutes" json:"minutes"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// slotHolderCode is the holder name of the slot locks kept for a hold
+func slotHolderCode(holdCode string) string {
+	return "hold:" + holdCode
+}
+
+// isHoldLock reports whether the lock keeps a slot for a patient who has not booked
+// it yet, through a slot hold or a waitlist offer
+func isHoldLock(lock SlotLock) bool {
+	return strings.HasPrefix(lock.AppointmentCode, slotHolderCode("")) || strings.HasPrefix(lock.AppointmentCode, holdCode(""))
+}
+
+// heldAppointment is the appointment the hold keeps the slot for
+func (h SlotHold) heldAppointment() Appointment {
+	return Appointment{
+		AppointmentCode: slotHolderCode(h.HoldCode),
+		DoctorCode:      h.DoctorCode,
+		UserCode:        h.UserCode,
+		AppointmentTime: h.AppointmentTime,
+		Duration:        h.Minutes,
+	}
+}
+
+// HoldSlot keeps the slot for the patient for the configured hold TTL and returns
+// the hold with its token. A patient holds one slot at a time, an earlier hold is
+// released. A *SlotConflictError is returned when the slot is taken, the earlier hold
+// is then kept.
+func HoldSlot(ctx context.Context, app *App, hold SlotHold) (*SlotHold, error) {
+	if err := validSlotTime(hold.AppointmentTime); err != nil {
+		return nil, err
+	}
+	doctor, err := GetDoctor(ctx, app, hold.DoctorCode)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !isFuture(app, Appointment{AppointmentTime: hold.AppointmentTime, TimeZone: doctorZone(ctx, app, doctor)}, now) {
+		return nil, fmt.Errorf("%w: %s %s is in the past", ErrInvalidSlot, hold.AppointmentTime.Date, hold.AppointmentTime.Time)
+	}
+
+	hold.HoldCode = helper.GenerateID(32)
+	hold.Minutes = slotLength(app, doctor)
+	hold.CreatedAt = now.UTC().Truncate(time.Millisecond)
+	hold.ExpiresAt = hold.CreatedAt.Add(time.Duration(app.Config.Holds.TTL))
+	held := hold.heldAppointment()
+	if err := checkWorkingTime(ctx, app, doctor, held); err != nil {
+		return nil, err
+	}
+
+	// Holding the same slot again gives up the earlier hold of it first. The other
+	// holds are only released once the new slot is held, so a taken slot costs the
+	// patient nothing.
+	previous, err := app.Store.Holds.ListByUser(ctx, hold.UserCode)
+	if err != nil {
+		return nil, err
+	}
+	var others []SlotHold
+	for _, other := range previous {
+		if other.DoctorCode != hold.DoctorCode || other.AppointmentTime != hold.AppointmentTime {
+			others = append(others, other)
+			continue
+		}
+		if err := releaseHold(ctx, app, other); err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	if err := claimSlot(ctx, app, doctor, held); err != nil {
+		return nil, err
+	}
+	if err := app.Store.Holds.Insert(ctx, hold); err != nil {
+		if releaseErr := app.Store.Slots.Release(context.WithoutCancel(ctx), slotLocksFor(held)); releaseErr != nil {
+			log.Println("Error releasing held slot:", releaseErr)
+		}
+		return nil, err
+	}
+	for _, other := range others {
+		if err := releaseHold(ctx, app, other); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Error releasing earlier slot hold of user %s: %v", other.UserCode, err)
+		}
+	}
+	return &hold, nil
+}
+
+// GetSlotHold returns the hold with the token, ErrNotFound once it is gone
+func GetSlotHold(ctx context.Context, app *App, holdCode string) (*SlotHold, error) {
+	return app.Store.Holds.Get(ctx, holdCode)
+}
+
+// ReleaseSlotHold gives up the hold before it expires, its slot is free again
+func ReleaseSlotHold(ctx context.Context, app *App, holdCode string) error {
+	hold, err := app.Store.Holds.Get(ctx, holdCode)
+	if err != nil {
+		return err
+	}
+	return releaseHold(ctx, app, *hold)
+}
+
+// ExpireSlotHolds releases the holds that ran out before now and offers their slots
+// to the waitlist. It returns how many holds expired.
+func ExpireSlotHolds(ctx context.Context, app *App, now time.Time) (int, error) {
+	holds, err := app.Store.Holds.ListExpired(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, hold := range holds {
+		if err := releaseHold(ctx, app, hold); err != nil {
+			// A booking took the hold meanwhile
+			if !errors.Is(err, ErrNotFound) {
+				log.Printf("Error expiring slot hold of user %s: %v", hold.UserCode, err)
+			}
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// releaseHold removes the hold and frees its slot, ErrNotFound when a booking or the
+// sweeper took it first
+func releaseHold(ctx context.Context, app *App, hold SlotHold) error {
+	if err := app.Store.Holds.Delete(ctx, hold.HoldCode); err != nil {
+		return err
+	}
+	releaseLocks(ctx, app, slotLocksFor(hold.heldAppointment()), hold.DoctorCode, hold.AppointmentTime)
+	return nil
+}
+
+// takeHold checks that the hold named by the appointment keeps its slot for its
+// patient and takes it, so the sweeper cannot release the slot under the booking
+func takeHold(ctx context.Context, app *App, appointment Appointment) (*SlotHold, error) {
+	hold, err := app.Store.Holds.Get(ctx, appointment.HoldCode)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrHoldExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hold.DoctorCode != appointment.DoctorCode || hold.UserCode != appointment.UserCode || hold.AppointmentTime != appointment.AppointmentTime {
+		return nil, ErrHoldMismatch
+	}
+	if !hold.ExpiresAt.After(time.Now()) {
+		return nil, ErrHoldExpired
+	}
+	if err := app.Store.Holds.Delete(ctx, hold.HoldCode); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrHoldExpired
+		}
+		return nil, err
+	}
+	return hold, nil
+}
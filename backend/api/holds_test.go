@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSlotHold(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	date := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	at := func(clock string) AppointmentTime { return AppointmentTime{Date: date, Time: clock} }
+	hold := func(userCode, clock string) *SlotHold {
+		t.Helper()
+		held, err := HoldSlot(ctx, app, SlotHold{DoctorCode: "doc1", UserCode: userCode, AppointmentTime: at(clock)})
+		if err != nil {
+			t.Fatalf("hold %s: %v", clock, err)
+		}
+		return held
+	}
+	slot := func(clock string) TimeSlot {
+		t.Helper()
+		schedule, err := GetDoctorTimeSlots(ctx, app, "doc1", date)
+		if err != nil {
+			t.Fatalf("time slots: %v", err)
+		}
+		for _, slot := range schedule.TimeSlots {
+			if slot.StartTime == clock {
+				return slot
+			}
+		}
+		t.Fatalf("no slot at %s", clock)
+		return TimeSlot{}
+	}
+
+	first := hold("patient1", "10:00")
+	if s := slot("10:00"); s.Available || s.IsBooked || !s.IsHeld {
+		t.Fatalf("want the held slot unavailable: %+v", s)
+	}
+	var conflict *SlotConflictError
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: at("10:00")}); !errors.As(err, &conflict) {
+		t.Fatalf("want a held slot refused to others, got %v", err)
+	}
+
+	// A taken slot leaves the patient their hold, and the same slot can be held again
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: at("10:30")}); err != nil {
+		t.Fatalf("book 10:30: %v", err)
+	}
+	if _, err := HoldSlot(ctx, app, SlotHold{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: at("10:30")}); !errors.As(err, &conflict) {
+		t.Fatalf("want a booked slot refused, got %v", err)
+	}
+	if _, err := GetSlotHold(ctx, app, first.HoldCode); err != nil {
+		t.Fatalf("want the earlier hold kept, got %v", err)
+	}
+	first = hold("patient1", "10:00")
+
+	// A patient holds one slot at a time
+	second := hold("patient1", "11:00")
+	if s := slot("10:00"); !s.Available {
+		t.Fatalf("want the earlier hold released: %+v", s)
+	}
+	booking := Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: at("11:00"), HoldCode: first.HoldCode}
+	if _, err := CreateAppointment(ctx, app, booking); !errors.Is(err, ErrHoldExpired) {
+		t.Fatalf("want the released hold refused, got %v", err)
+	}
+	booking.HoldCode = second.HoldCode
+	booking.AppointmentTime = at("11:30")
+	if _, err := CreateAppointment(ctx, app, booking); !errors.Is(err, ErrHoldMismatch) {
+		t.Fatalf("want a hold of another slot refused, got %v", err)
+	}
+	booking.AppointmentTime = at("11:00")
+	created, err := CreateAppointment(ctx, app, booking)
+	if err != nil || created.HoldCode != "" {
+		t.Fatalf("book the held slot: %+v (%v)", created, err)
+	}
+	if s := slot("11:00"); !s.IsBooked || s.IsHeld {
+		t.Fatalf("want the held slot booked: %+v", s)
+	}
+	if _, err := CreateAppointment(ctx, app, booking); !errors.Is(err, ErrHoldExpired) {
+		t.Fatalf("want a used hold refused, got %v", err)
+	}
+
+	hold("patient2", "12:00")
+	if expired, err := ExpireSlotHolds(ctx, app, time.Now()); err != nil || expired != 0 {
+		t.Fatalf("want running holds kept, got %d (%v)", expired, err)
+	}
+	if expired, err := ExpireSlotHolds(ctx, app, time.Now().Add(time.Hour)); err != nil || expired != 1 {
+		t.Fatalf("want the expired hold swept, got %d (%v)", expired, err)
+	}
+	if s := slot("12:00"); !s.Available {
+		t.Fatalf("want the swept slot free again: %+v", s)
+	}
+}
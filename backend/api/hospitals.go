@@ -5,127 +5,192 @@ import (
 	"context"
 	"errors"
 	"log"
+	"strconv"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Hospital struct {
-	HospitalCode int       `bson:"hospitalCode" json:"hospitalCode"`
-	HospitalName string    `bson:"hospitalName" json:"hospitalName"`
-	DistrictCode int       `bson:"districtCode" json:"districtCode"`
-	ProvinceCode int       `bson:"provinceCode" json:"provinceCode"`
-	Fields       []int     `bson:"fields" json:"fields"`
-	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
-	UpdatedAt    time.Time `bson:"updatedAt" json:"updatedAt"`
+	HospitalCode int    `bson:"hospitalCode" json:"hospitalCode"`
+	HospitalName string `bson:"hospitalName" json:"hospitalName"`
+	DistrictCode int    `bson:"districtCode" json:"districtCode"`
+	ProvinceCode int    `bson:"provinceCode" json:"provinceCode"`
+	Fields       []int  `bson:"fields" json:"fields"`
+	// TimeZone is the IANA zone the appointment times of the hospital are given in,
+	// empty uses the configured default
+	TimeZone  string    `bson:"timeZone,omitempty" json:"timeZone,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	Version   int64     `bson:"version" json:"version"`
+	Tombstone `bson:",inline"`
 }
 
-func GetAllHospitals(client *mongo.Client) []Hospital {
-	collection := client.Database("healthcare").Collection("hospitals")
-
-	filter := bson.D{{}}
-
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
-
-	var hospitals []Hospital
-	cursor.All(context.TODO(), &hospitals)
+func GetAllHospitals(ctx context.Context, app *App) []Hospital {
+	hospitals, _ := app.Store.Hospitals.List(ctx)
 
 	return hospitals
 }
 
-func GetHospital(client *mongo.Client, hospitalCode int) (*Hospital, error) {
-	collection := client.Database("healthcare").Collection("hospitals")
+// ListHospitals returns one page of hospitals matching filter
+func ListHospitals(ctx context.Context, app *App, filter HospitalFilter, opts ListOptions) (*Page[Hospital], error) {
+	query, err := newListQuery(opts, hospitalSortFields, "hospitalName", "hospitalCode")
+	if err != nil {
+		return nil, err
+	}
 
-	filter := bson.D{{Key: "hospitalCode", Value: hospitalCode}}
-	var hospital Hospital
+	hospitals, total, err := app.Store.Hospitals.Find(ctx, filter, query)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(hospitals, total, query), nil
+}
 
-	err := collection.FindOne(context.TODO(), filter).Decode(&hospital)
+func GetHospital(ctx context.Context, app *App, hospitalCode int) (*Hospital, error) {
+	hospital, err := app.Store.Hospitals.Get(ctx, hospitalCode)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == ErrNotFound {
 			return nil, errors.New("no such doctor")
 		}
 		return nil, err
 	}
 
-	return &hospital, nil
+	return hospital, nil
 }
 
-func GetHospitalsByProvince(client *mongo.Client, provinceCode int) []Hospital {
-
-	collection := client.Database("healthcare").Collection("hospitals")
-
-	filter := bson.D{{Key: "provinceCode", Value: provinceCode}}
+func GetHospitalsByProvince(ctx context.Context, app *App, provinceCode int) []Hospital {
+	hospitals, err := app.Store.Hospitals.ListByProvince(ctx, provinceCode)
+	if err != nil {
+		log.Println("Error finding hospitals:", err)
+	}
 
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
+	return hospitals
+}
 
-	var hospitals []Hospital
-	for cursor.Next(context.TODO()) {
-		var hospital Hospital
-		if err := cursor.Decode(&hospital); err != nil {
-			log.Fatal(err)
-		}
-		hospitals = append(hospitals, hospital)
+func GetHospitalsByDistrict(ctx context.Context, app *App, districtCode int) []Hospital {
+	hospitals, err := app.Store.Hospitals.ListByDistrict(ctx, districtCode)
+	if err != nil {
+		log.Println("Error finding hospitals:", err)
 	}
 
 	return hospitals
 }
 
-func GetHospitalsByDistrict(client *mongo.Client, districtCode int) []Hospital {
-
-	collection := client.Database("healthcare").Collection("hospitals")
+// DeleteHospital soft deletes the hospital along with its doctors. They all
+// share one tombstone so RestoreHospital can tell them from doctors deleted before.
+// The future appointments of the doctors are handled by policy, reassigned ones
+// go to doctors of other hospitals in the district.
+func DeleteHospital(ctx context.Context, app *App, hospitalCode int, deletedBy string, policy DeletionPolicy) (*DeletionReport, error) {
+	hospital, err := app.Store.Hospitals.Get(ctx, hospitalCode)
+	if err != nil {
+		return nil, err
+	}
+	doctors, err := GetDoctorsByHospitalCode(ctx, app, hospitalCode)
+	if err != nil {
+		return nil, err
+	}
+	doctorCodes := make([]string, len(doctors))
+	for i, doctor := range doctors {
+		doctorCodes[i] = doctor.DoctorCode
+	}
+	appointments, err := futureAppointmentsOfDoctors(ctx, app, doctorCodes)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPolicy(policy, appointments); err != nil {
+		return nil, err
+	}
 
-	filter := bson.D{{Key: "districtCode", Value: districtCode}}
+	tombstone := newTombstone(deletedBy)
+	err = app.Store.Hospitals.SoftDelete(ctx, hospitalCode, tombstone)
+	if err != nil {
+		return nil, err
+	}
 
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
+	deleted := *hospital
+	deleted.Tombstone = tombstone
+	recordAudit(ctx, app, AuditDelete, TargetHospital, strconv.Itoa(hospitalCode), hospital, deleted)
 
-	var hospitals []Hospital
-	for cursor.Next(context.TODO()) {
-		var hospital Hospital
-		if err := cursor.Decode(&hospital); err != nil {
-			log.Fatal(err)
+	report := newDeletionReport(policy)
+	for _, doctor := range doctors {
+		if err := deleteDoctor(ctx, app, &doctor, tombstone); err != nil {
+			log.Printf("Error deleting doctor %s of hospital %d: %v", doctor.DoctorCode, hospitalCode, err)
+			continue
 		}
-		hospitals = append(hospitals, hospital)
+		report.Doctors = append(report.Doctors, doctor.DoctorCode)
 	}
-
-	return hospitals
+	resolveAppointments(ctx, app, policy, appointments, StatusCancelledByHospital, "hospital deleted", deletedBy, report)
+	return report, nil
 }
 
-func DeleteHospital(client *mongo.Client, hospitalCode int) {
-	collection := client.Database("healthcare").Collection("hospitals")
-	collection.FindOneAndDelete(context.TODO(), bson.D{{Key: "hospitalCode", Value: hospitalCode}})
-	doctors, err := GetDoctorsByHospitalCode(client, hospitalCode)
+// RestoreHospital brings back a soft deleted hospital and the doctors deleted with it
+func RestoreHospital(ctx context.Context, app *App, hospitalCode int) error {
+	hospital, err := app.Store.Hospitals.Restore(ctx, hospitalCode)
 	if err != nil {
-		return
+		return err
+	}
+	restored := *hospital
+	restored.Tombstone = Tombstone{}
+	recordAudit(ctx, app, AuditRestore, TargetHospital, strconv.Itoa(hospitalCode), hospital, restored)
+
+	doctors, err := app.Store.Doctors.ListDeletedByHospital(ctx, hospitalCode)
+	if err != nil {
+		return err
 	}
 	for _, doctor := range doctors {
-		DeleteDoctor(client, doctor.DoctorCode)
+		if !doctor.DeletedAt.Equal(*hospital.DeletedAt) {
+			continue
+		}
+		deleted, err := app.Store.Doctors.Restore(ctx, doctor.DoctorCode)
+		if err != nil {
+			log.Printf("Error restoring doctor %s of hospital %d: %v", doctor.DoctorCode, hospitalCode, err)
+			continue
+		}
+		restoredDoctor := *deleted
+		restoredDoctor.Tombstone = Tombstone{}
+		recordAudit(ctx, app, AuditRestore, TargetDoctor, doctor.DoctorCode, deleted, restoredDoctor)
+		DoctorCreationFieldCheck(ctx, app, hospitalCode, doctor.FieldCode)
 	}
+	return nil
+}
 
+// CheckHospital validates the time zone of a hospital before it is saved
+func CheckHospital(hospital Hospital) error {
+	return validTimeZone(hospital.TimeZone)
 }
 
-func CreateHospital(client *mongo.Client, hospital Hospital) {
-	collection := client.Database("healthcare").Collection("hospitals")
+func CreateHospital(ctx context.Context, app *App, hospital Hospital) {
 	hospital.HospitalCode = helper.GenerateIntID(5)
 	hospital.CreatedAt = time.Now()
 	hospital.UpdatedAt = time.Now()
-	collection.InsertOne(context.TODO(), hospital)
+	hospital.Version = 0
+	hospital.Tombstone = Tombstone{}
+	if err := app.Store.Hospitals.Insert(ctx, hospital); err != nil {
+		log.Println("Error creating hospital:", err)
+		return
+	}
+	recordAudit(ctx, app, AuditCreate, TargetHospital, strconv.Itoa(hospital.HospitalCode), nil, hospital)
 }
 
-func UpdateHospital(client *mongo.Client, hospital Hospital) {
-	collection := client.Database("healthcare").Collection("hospitals")
+// UpdateHospital replaces the hospital. hospital.Version must be the version the
+// caller read, ErrVersionConflict is returned when the hospital was changed since.
+func UpdateHospital(ctx context.Context, app *App, hospital Hospital) (*Hospital, error) {
+	if err := CheckHospital(hospital); err != nil {
+		return nil, err
+	}
+	existing, err := app.Store.Hospitals.Get(ctx, hospital.HospitalCode)
+	if err != nil {
+		return nil, err
+	}
+	version := hospital.Version
+	hospital.Version = version + 1
 	hospital.UpdatedAt = time.Now()
+	hospital.Tombstone = Tombstone{}
 
-	_, err := collection.ReplaceOne(
-		context.TODO(),
-		bson.M{"hospitalCode": hospital.HospitalCode},
-		hospital,
-	)
+	err = app.Store.Hospitals.Replace(ctx, hospital, version)
 
 	if err != nil {
 		log.Println("Error updating hospital:", err)
+		return nil, err
 	}
+	recordAudit(ctx, app, AuditUpdate, TargetHospital, strconv.Itoa(hospital.HospitalCode), existing, hospital)
+	return &hospital, nil
 }
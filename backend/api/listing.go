@@ -0,0 +1,248 @@
+package api
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidListOptions is wrapped by every error caused by bad paging, sorting or filter parameters
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// ListOptions carries the paging and sorting parameters of a list request
+type ListOptions struct {
+	Limit     int
+	PageToken string
+	// Sort is a comma separated list of fields, a leading '-' sorts descending
+	Sort string
+}
+
+// Page is one page of a list endpoint. NextPageToken is empty on the last page.
+type Page[T any] struct {
+	Items         []T    `json:"items"`
+	Total         int64  `json:"total"`
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// Deleted on the filters below lists only the soft deleted records instead of the live ones
+
+type UserFilter struct {
+	Role    string
+	Deleted bool
+}
+
+type DoctorFilter struct {
+	HospitalCode *int
+	FieldCode    *int
+	Deleted      bool
+}
+
+type HospitalFilter struct {
+	ProvinceCode *int
+	DistrictCode *int
+	FieldCode    *int
+	Deleted      bool
+}
+
+// AppointmentFilter selects appointments, DateFrom and DateTo are inclusive YYYY-MM-DD dates
+type AppointmentFilter struct {
+	DoctorCode string
+	UserCode   string
+	DateFrom   string
+	DateTo     string
+	Status     AppointmentStatus
+	// LateCancelled lists only the appointments the patient cancelled inside the cancellation window
+	LateCancelled bool
+	Deleted       bool
+}
+
+// BlackoutFilter selects blackouts, the dates select those overlapping the range
+type BlackoutFilter struct {
+	Kind         BlackoutKind
+	DoctorCode   string
+	HospitalCode int
+	DateFrom     string
+	DateTo       string
+}
+
+type RequestFilter struct {
+	DoctorCode string
+	Status     string
+}
+
+// AuditFilter selects audit entries, From and To bound the entry time and are ignored when zero
+type AuditFilter struct {
+	Actor      string
+	TargetType string
+	TargetCode string
+	From       time.Time
+	To         time.Time
+}
+
+// sortField maps a sortable JSON field to its document path and an in-memory comparison
+type sortField[T any] struct {
+	path    string
+	compare func(a, b T) int
+}
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// listQuery is the validated form of ListOptions handed to the stores
+type listQuery[T any] struct {
+	offset int
+	limit  int
+	sort   []sortKey
+	fields map[string]sortField[T]
+}
+
+// compare orders a and b by the query's sort keys
+func (q listQuery[T]) compare(a, b T) int {
+	for _, key := range q.sort {
+		c := q.fields[key.field].compare(a, b)
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+type pageToken struct {
+	Offset int `json:"o"`
+}
+
+func encodePageToken(offset int) string {
+	data, _ := json.Marshal(pageToken{Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(token string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed page token", ErrInvalidListOptions)
+	}
+	var decoded pageToken
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Offset < 0 {
+		return 0, fmt.Errorf("%w: malformed page token", ErrInvalidListOptions)
+	}
+	return decoded.Offset, nil
+}
+
+// newListQuery validates opts against the sortable fields. defaultSort is used when
+// no sort is requested and tiebreak, a unique field, keeps pages stable.
+func newListQuery[T any](opts ListOptions, fields map[string]sortField[T], defaultSort, tiebreak string) (listQuery[T], error) {
+	query := listQuery[T]{limit: opts.Limit, fields: fields}
+
+	if query.limit <= 0 {
+		query.limit = DefaultPageSize
+	}
+	if query.limit > MaxPageSize {
+		query.limit = MaxPageSize
+	}
+
+	if opts.PageToken != "" {
+		offset, err := decodePageToken(opts.PageToken)
+		if err != nil {
+			return query, err
+		}
+		query.offset = offset
+	}
+
+	sort := opts.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+	hasTiebreak := false
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key := sortKey{field: strings.TrimPrefix(field, "-"), desc: strings.HasPrefix(field, "-")}
+		if _, ok := fields[key.field]; !ok {
+			return query, fmt.Errorf("%w: cannot sort by %q", ErrInvalidListOptions, key.field)
+		}
+		hasTiebreak = hasTiebreak || key.field == tiebreak
+		query.sort = append(query.sort, key)
+	}
+	if !hasTiebreak {
+		query.sort = append(query.sort, sortKey{field: tiebreak})
+	}
+
+	return query, nil
+}
+
+// newPage wraps the items of one page and works out the token of the next one
+func newPage[T any](items []T, total int64, query listQuery[T]) *Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	page := &Page[T]{Items: items, Total: total}
+	if next := query.offset + len(items); len(items) > 0 && int64(next) < total {
+		page.NextPageToken = encodePageToken(next)
+	}
+	return page
+}
+
+func compareTime(a, b time.Time) int {
+	return a.Compare(b)
+}
+
+var userSortFields = map[string]sortField[User]{
+	"userCode":  {path: "userCode", compare: func(a, b User) int { return cmp.Compare(a.UserCode, b.UserCode) }},
+	"email":     {path: "email", compare: func(a, b User) int { return cmp.Compare(a.Email, b.Email) }},
+	"role":      {path: "role", compare: func(a, b User) int { return cmp.Compare(a.Role, b.Role) }},
+	"createdAt": {path: "createdAt", compare: func(a, b User) int { return compareTime(a.CreatedAt, b.CreatedAt) }},
+}
+
+var doctorSortFields = map[string]sortField[Doctor]{
+	"doctorCode":   {path: "doctorCode", compare: func(a, b Doctor) int { return cmp.Compare(a.DoctorCode, b.DoctorCode) }},
+	"doctorName":   {path: "doctorName", compare: func(a, b Doctor) int { return cmp.Compare(a.DoctorName, b.DoctorName) }},
+	"hospitalCode": {path: "hospitalCode", compare: func(a, b Doctor) int { return cmp.Compare(a.HospitalCode, b.HospitalCode) }},
+	"field":        {path: "field", compare: func(a, b Doctor) int { return cmp.Compare(a.FieldCode, b.FieldCode) }},
+	"createdAt":    {path: "createdAt", compare: func(a, b Doctor) int { return compareTime(a.CreatedAt, b.CreatedAt) }},
+}
+
+var hospitalSortFields = map[string]sortField[Hospital]{
+	"hospitalCode": {path: "hospitalCode", compare: func(a, b Hospital) int { return cmp.Compare(a.HospitalCode, b.HospitalCode) }},
+	"hospitalName": {path: "hospitalName", compare: func(a, b Hospital) int { return cmp.Compare(a.HospitalName, b.HospitalName) }},
+	"provinceCode": {path: "provinceCode", compare: func(a, b Hospital) int { return cmp.Compare(a.ProvinceCode, b.ProvinceCode) }},
+	"districtCode": {path: "districtCode", compare: func(a, b Hospital) int { return cmp.Compare(a.DistrictCode, b.DistrictCode) }},
+	"createdAt":    {path: "createdAt", compare: func(a, b Hospital) int { return compareTime(a.CreatedAt, b.CreatedAt) }},
+}
+
+var appointmentSortFields = map[string]sortField[Appointment]{
+	"appointmentCode": {path: "appointmentCode", compare: func(a, b Appointment) int { return cmp.Compare(a.AppointmentCode, b.AppointmentCode) }},
+	"date":            {path: "appointmentTime.date", compare: func(a, b Appointment) int { return cmp.Compare(a.AppointmentTime.Date, b.AppointmentTime.Date) }},
+	"time":            {path: "appointmentTime.time", compare: func(a, b Appointment) int { return cmp.Compare(a.AppointmentTime.Time, b.AppointmentTime.Time) }},
+	"doctorCode":      {path: "doctorCode", compare: func(a, b Appointment) int { return cmp.Compare(a.DoctorCode, b.DoctorCode) }},
+	"userCode":        {path: "userCode", compare: func(a, b Appointment) int { return cmp.Compare(a.UserCode, b.UserCode) }},
+	"createdAt":       {path: "createdAt", compare: func(a, b Appointment) int { return compareTime(a.CreatedAt, b.CreatedAt) }},
+}
+
+var requestSortFields = map[string]sortField[AppointmentDeleteRequest]{
+	"requestCode": {path: "requestCode", compare: func(a, b AppointmentDeleteRequest) int { return cmp.Compare(a.RequestCode, b.RequestCode) }},
+	"status":      {path: "status", compare: func(a, b AppointmentDeleteRequest) int { return cmp.Compare(a.Status, b.Status) }},
+	"createdAt":   {path: "createdAt", compare: func(a, b AppointmentDeleteRequest) int { return compareTime(a.CreatedAt, b.CreatedAt) }},
+}
+
+var auditSortFields = map[string]sortField[AuditEntry]{
+	"id":         {path: "_id", compare: func(a, b AuditEntry) int { return cmp.Compare(a.ID, b.ID) }},
+	"at":         {path: "at", compare: func(a, b AuditEntry) int { return compareTime(a.At, b.At) }},
+	"actor":      {path: "actor", compare: func(a, b AuditEntry) int { return cmp.Compare(a.Actor, b.Actor) }},
+	"targetType": {path: "targetType", compare: func(a, b AuditEntry) int { return cmp.Compare(a.TargetType, b.TargetType) }},
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"backend/config"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestListDoctorsPaging(t *testing.T) {
+	store := NewMemoryStore()
+	app := &App{Store: store, Config: config.Defaults()}
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		store.Doctors.Insert(ctx, Doctor{
+			DoctorCode:   fmt.Sprintf("doc%d", i),
+			DoctorName:   fmt.Sprintf("Doctor %d", 4-i),
+			HospitalCode: 1 + i%2,
+		})
+	}
+
+	hospital := 1
+	filter := DoctorFilter{HospitalCode: &hospital}
+	opts := ListOptions{Limit: 2, Sort: "doctorName"}
+
+	var names []string
+	for {
+		page, err := ListDoctors(ctx, app, filter, opts)
+		if err != nil {
+			t.Fatalf("list failed: %v", err)
+		}
+		if page.Total != 3 {
+			t.Fatalf("expected total 3, got %d", page.Total)
+		}
+		for _, doctor := range page.Items {
+			names = append(names, doctor.DoctorName)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+
+	want := []string{"Doctor 0", "Doctor 2", "Doctor 4"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+
+	if _, err := ListDoctors(ctx, app, filter, ListOptions{Sort: "password"}); !errors.Is(err, ErrInvalidListOptions) {
+		t.Errorf("unknown sort field should be rejected, got %v", err)
+	}
+	if _, err := ListDoctors(ctx, app, filter, ListOptions{PageToken: "???"}); !errors.Is(err, ErrInvalidListOptions) {
+		t.Errorf("malformed page token should be rejected, got %v", err)
+	}
+}
@@ -3,9 +3,6 @@ package api
 import (
 	"context"
 	"log"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Province struct {
@@ -14,41 +11,22 @@ type Province struct {
 }
 
 type District struct {
-	DistrictCode string `bson:"districtCode" json:"districtCode"`
+	DistrictCode int    `bson:"districtCode" json:"districtCode"`
 	DistrictName string `bson:"districtName" json:"districtName"`
 	ProvinceCode int    `bson:"provinceCode" json:"provinceCode"`
 }
 
-func GetAllProvinces(client *mongo.Client) []Province {
-	collection := client.Database("locations").Collection("provinces")
-
-	filter := bson.D{{}}
-
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
-
-	var provinces []Province
-	cursor.All(context.TODO(), &provinces)
+func GetAllProvinces(ctx context.Context, app *App) []Province {
+	provinces, _ := app.Store.Locations.ListProvinces(ctx)
 
 	return provinces
 }
 
-func GetDistrictsByProvince(client *mongo.Client, provinceCode int) []District {
-	collection := client.Database("locations").Collection("districts")
-
-	filter := bson.D{{Key: "provinceCode", Value: provinceCode}}
-
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
-
-	var districts []District
-	for cursor.Next(context.TODO()) {
-		var district District
-		if err := cursor.Decode(&district); err != nil {
-			log.Println("Cursor decoding error:", err)
-			return nil
-		}
-		districts = append(districts, district)
+func GetDistrictsByProvince(ctx context.Context, app *App, provinceCode int) []District {
+	districts, err := app.Store.Locations.ListDistrictsByProvince(ctx, provinceCode)
+	if err != nil {
+		log.Println("Cursor decoding error:", err)
+		return nil
 	}
 	return districts
 }
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Reason codes of a PolicyViolation
+const (
+	ReasonMaxActive         = "max_active_appointments"
+	ReasonFieldCooldown     = "field_cooldown"
+	ReasonSameDoctorSameDay = "same_doctor_same_day"
+	ReasonMinLeadTime       = "min_lead_time"
+	ReasonBeyondHorizon     = "beyond_booking_horizon"
+	ReasonSuspended         = "booking_suspended"
+)
+
+// ErrInvalidBookingPolicy is returned for a booking policy with negative limits or a
+// hospital listed twice
+var ErrInvalidBookingPolicy = errors.New("invalid booking policy")
+
+// PolicyViolation is returned when a booking breaks a rule of the booking policy.
+// Code is one of the Reason constants.
+type PolicyViolation struct {
+	Code    string
+	Message string
+}
+
+func (e *PolicyViolation) Error() string {
+	return e.Message
+}
+
+// PolicyRules are the limits a booking must respect, a zero value turns a rule off
+type PolicyRules struct {
+	// MaxActiveAppointments limits the upcoming booked or confirmed appointments of a patient
+	MaxActiveAppointments int `bson:"maxActiveAppointments" json:"maxActiveAppointments"`
+	// FieldCooldownDays keeps two appointments of a patient in the same field this many days apart
+	FieldCooldownDays int `bson:"fieldCooldownDays" json:"fieldCooldownDays"`
+	// OnePerDoctorPerDay forbids two appointments of a patient with one doctor on one day
+	OnePerDoctorPerDay bool `bson:"onePerDoctorPerDay" json:"onePerDoctorPerDay"`
+	// MinLeadMinutes is how long before it starts an appointment can be booked at the latest
+	MinLeadMinutes int `bson:"minLeadMinutes" json:"minLeadMinutes"`
+	// MaxHorizonDays is how many days ahead appointments can be booked
+	MaxHorizonDays int `bson:"maxHorizonDays" json:"maxHorizonDays"`
+	// CancelCutoffHours is how long before it starts a patient can cancel an appointment
+	// at the latest, later cancellations need the approval of an admin
+	CancelCutoffHours int `bson:"cancelCutoffHours" json:"cancelCutoffHours"`
+}
+
+// HospitalPolicy replaces the rules for the appointments of one hospital
+type HospitalPolicy struct {
+	HospitalCode int         `bson:"hospitalCode" json:"hospitalCode"`
+	Rules        PolicyRules `bson:"rules" json:"rules"`
+}
+
+// NoShowPenalty suspends the bookings of a patient for SuspensionDays after NoShows
+// missed appointments within WindowDays. A zero NoShows turns it off.
+type NoShowPenalty struct {
+	NoShows        int `bson:"noShows" json:"noShows"`
+	WindowDays     int `bson:"windowDays" json:"windowDays"`
+	SuspensionDays int `bson:"suspensionDays" json:"suspensionDays"`
+}
+
+// BookingPolicy holds the booking rules admins can edit. There is a single policy,
+// versioned like the other records.
+type BookingPolicy struct {
+	Rules         PolicyRules      `bson:"rules" json:"rules"`
+	Hospitals     []HospitalPolicy `bson:"hospitals" json:"hospitals"`
+	NoShowPenalty NoShowPenalty    `bson:"noShowPenalty" json:"noShowPenalty"`
+	UpdatedAt     time.Time        `bson:"updatedAt" json:"updatedAt"`
+	Version       int64            `bson:"version" json:"version"`
+}
+
+// DefaultBookingPolicy is used until an admin saves a policy. It limits the number of
+// upcoming appointments, like the weekly limit it replaced, and keeps the 24 hour
+// cancellation window the confirmation email promises.
+func DefaultBookingPolicy() BookingPolicy {
+	return BookingPolicy{
+		Rules:     PolicyRules{MaxActiveAppointments: 3, CancelCutoffHours: 24},
+		Hospitals: []HospitalPolicy{},
+	}
+}
+
+// RulesFor returns the rules for appointments at the hospital
+func (p BookingPolicy) RulesFor(hospitalCode int) PolicyRules {
+	for _, hospital := range p.Hospitals {
+		if hospital.HospitalCode == hospitalCode {
+			return hospital.Rules
+		}
+	}
+	return p.Rules
+}
+
+func (r PolicyRules) validate() error {
+	if r.MaxActiveAppointments < 0 || r.FieldCooldownDays < 0 || r.MinLeadMinutes < 0 || r.MaxHorizonDays < 0 || r.CancelCutoffHours < 0 {
+		return fmt.Errorf("%w: limits cannot be negative", ErrInvalidBookingPolicy)
+	}
+	return nil
+}
+
+func (p BookingPolicy) validate() error {
+	if err := p.Rules.validate(); err != nil {
+		return err
+	}
+	penalty := p.NoShowPenalty
+	if penalty.NoShows < 0 || penalty.WindowDays < 0 || penalty.SuspensionDays < 0 {
+		return fmt.Errorf("%w: no-show penalty limits cannot be negative", ErrInvalidBookingPolicy)
+	}
+	if penalty.NoShows > 0 && (penalty.WindowDays == 0 || penalty.SuspensionDays == 0) {
+		return fmt.Errorf("%w: a no-show penalty needs a window and a suspension", ErrInvalidBookingPolicy)
+	}
+	seen := map[int]bool{}
+	for _, hospital := range p.Hospitals {
+		if seen[hospital.HospitalCode] {
+			return fmt.Errorf("%w: hospital %d is listed twice", ErrInvalidBookingPolicy, hospital.HospitalCode)
+		}
+		seen[hospital.HospitalCode] = true
+		if err := hospital.Rules.validate(); err != nil {
+			return fmt.Errorf("hospital %d: %w", hospital.HospitalCode, err)
+		}
+	}
+	return nil
+}
+
+// GetBookingPolicy returns the stored booking policy or the default one
+func GetBookingPolicy(ctx context.Context, app *App) (*BookingPolicy, error) {
+	policy, err := app.Store.Policy.Get(ctx)
+	if errors.Is(err, ErrNotFound) {
+		defaults := DefaultBookingPolicy()
+		return &defaults, nil
+	}
+	return policy, err
+}
+
+// UpdateBookingPolicy replaces the booking policy. policy.Version must be the version
+// the caller read, ErrVersionConflict is returned when it was changed since.
+func UpdateBookingPolicy(ctx context.Context, app *App, policy BookingPolicy) (*BookingPolicy, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	if policy.Hospitals == nil {
+		policy.Hospitals = []HospitalPolicy{}
+	}
+	existing, err := GetBookingPolicy(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	version := policy.Version
+	policy.Version = version + 1
+	policy.UpdatedAt = time.Now()
+	if err := app.Store.Policy.Replace(ctx, policy, version); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, app, AuditUpdate, TargetBookingPolicy, "booking", existing, policy)
+	return &policy, nil
+}
+
+// checkBookingPolicy returns a *PolicyViolation when booking the appointment with the
+// doctor breaks a rule of the policy for the doctor's hospital. The appointment with
+// the code in moving is left out, so a reschedule does not collide with itself, and
+// so are the other occurrences of the appointment's series.
+func checkBookingPolicy(ctx context.Context, app *App, appointment Appointment, doctor *Doctor, moving string) error {
+	policy, err := GetBookingPolicy(ctx, app)
+	if err != nil {
+		return err
+	}
+	rules := policy.RulesFor(doctor.HospitalCode)
+
+	now := time.Now()
+	if moving == "" {
+		if err := checkSuspension(ctx, app, appointment.UserCode, now); err != nil {
+			return err
+		}
+	}
+	if appointment.TimeZone == "" {
+		appointment.TimeZone = doctorZone(ctx, app, doctor)
+	}
+	start, err := appointment.Start(app)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrInvalidSlot, appointment.AppointmentTime.Date, appointment.AppointmentTime.Time)
+	}
+	if rules.MinLeadMinutes > 0 && start.Before(now.Add(time.Duration(rules.MinLeadMinutes)*time.Minute)) {
+		return &PolicyViolation{
+			Code:    ReasonMinLeadTime,
+			Message: fmt.Sprintf("appointments must be booked at least %d minutes ahead", rules.MinLeadMinutes),
+		}
+	}
+	if rules.MaxHorizonDays > 0 && start.After(now.AddDate(0, 0, rules.MaxHorizonDays)) {
+		return &PolicyViolation{
+			Code:    ReasonBeyondHorizon,
+			Message: fmt.Sprintf("appointments can be booked at most %d days ahead", rules.MaxHorizonDays),
+		}
+	}
+
+	if rules.MaxActiveAppointments == 0 && rules.FieldCooldownDays == 0 && !rules.OnePerDoctorPerDay {
+		return nil
+	}
+	appointments, err := app.Store.Appointments.ListByUser(ctx, appointment.UserCode)
+	if err != nil {
+		log.Printf("Error querying appointments for user %s: %v", appointment.UserCode, err)
+		return err
+	}
+
+	active := 0
+	series := map[string]bool{}
+	for _, other := range onlyFuture(app, appointments) {
+		if other.AppointmentCode == moving {
+			continue
+		}
+		// The occurrences of a series are checked against other bookings, not each other
+		if other.SeriesCode != "" && other.SeriesCode == appointment.SeriesCode {
+			continue
+		}
+		// and a series counts once towards the active appointments
+		if other.SeriesCode == "" || !series[other.SeriesCode] {
+			active++
+		}
+		if other.SeriesCode != "" {
+			series[other.SeriesCode] = true
+		}
+
+		if rules.OnePerDoctorPerDay && other.DoctorCode == doctor.DoctorCode && other.AppointmentTime.Date == appointment.AppointmentTime.Date {
+			return &PolicyViolation{
+				Code:    ReasonSameDoctorSameDay,
+				Message: fmt.Sprintf("you already have an appointment with this doctor on %s", appointment.AppointmentTime.Date),
+			}
+		}
+		if rules.FieldCooldownDays > 0 && withinDays(other.AppointmentTime.Date, appointment.AppointmentTime.Date, rules.FieldCooldownDays) {
+			otherDoctor, err := app.Store.Doctors.Lookup(ctx, other.DoctorCode)
+			if err == nil && otherDoctor.FieldCode == doctor.FieldCode {
+				return &PolicyViolation{
+					Code:    ReasonFieldCooldown,
+					Message: fmt.Sprintf("appointments in the same field must be %d days apart, you have one on %s", rules.FieldCooldownDays, other.AppointmentTime.Date),
+				}
+			}
+		}
+	}
+
+	if moving == "" && rules.MaxActiveAppointments > 0 && active >= rules.MaxActiveAppointments {
+		return &PolicyViolation{
+			Code:    ReasonMaxActive,
+			Message: fmt.Sprintf("you can have at most %d upcoming appointments", rules.MaxActiveAppointments),
+		}
+	}
+	return nil
+}
+
+// withinDays reports whether the two dates are less than days days apart
+func withinDays(a, b string, days int) bool {
+	first, err := time.Parse("2006-01-02", a)
+	if err != nil {
+		return false
+	}
+	second, err := time.Parse("2006-01-02", b)
+	if err != nil {
+		return false
+	}
+	gap := first.Sub(second)
+	if gap < 0 {
+		gap = -gap
+	}
+	return gap < time.Duration(days)*24*time.Hour
+}
@@ -3,76 +3,128 @@ package api
 import (
 	"backend/helper"
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
+)
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+// Statuses of a cancel request
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestRejected = "rejected"
 )
 
+// ErrRequestClosed is returned when a cancel request that was already approved or
+// rejected is changed again
+var ErrRequestClosed = errors.New("cancel request is already closed")
+
 type AppointmentDeleteRequest struct {
-	RequestCode     string    `bson:"requestCode" json:"requestCode"`
-	AppointmentCode string    `bson:"appointmentCode" json:"appointmentCode"`
-	DoctorCode      string    `bson:"doctorCode" json:"doctorCode"`
-	Reason          string    `bson:"reason" json:"reason"`
-	Status          string    `bson:"status" json:"status"`
-	CreatedAt       time.Time `bson:"createdAt" json:"createdAt"`
-	UpdatedAt       time.Time `bson:"updatedAt" json:"updatedAt"`
+	RequestCode     string `bson:"requestCode" json:"requestCode"`
+	AppointmentCode string `bson:"appointmentCode" json:"appointmentCode"`
+	DoctorCode      string `bson:"doctorCode" json:"doctorCode"`
+	// UserCode is the patient who filed a late cancellation, empty when the doctor asked
+	UserCode  string    `bson:"userCode,omitempty" json:"userCode,omitempty"`
+	Reason    string    `bson:"reason" json:"reason"`
+	Status    string    `bson:"status" json:"status"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+func CreateAppointmentCancelRequest(ctx context.Context, app *App, deleteRequest AppointmentDeleteRequest) {
+	if _, err := insertCancelRequest(ctx, app, deleteRequest); err != nil {
+		log.Println("Error creating cancel request:", err)
+	}
 }
 
-func CreateAppointmentCancelRequest(client *mongo.Client, deleteRequest AppointmentDeleteRequest) {
-	collection := client.Database("healthcare").Collection("requests")
-	deleteRequest.DoctorCode = helper.GenerateID(5)
+// insertCancelRequest stores a new pending cancel request
+func insertCancelRequest(ctx context.Context, app *App, deleteRequest AppointmentDeleteRequest) (*AppointmentDeleteRequest, error) {
+	deleteRequest.RequestCode = helper.GenerateID(5)
+	deleteRequest.Status = RequestPending
 	deleteRequest.CreatedAt = time.Now()
 	deleteRequest.UpdatedAt = time.Now()
-	collection.InsertOne(context.TODO(), deleteRequest)
+	if err := app.Store.Requests.Insert(ctx, deleteRequest); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, app, AuditCreate, TargetCancelRequest, deleteRequest.RequestCode, nil, deleteRequest)
+	return &deleteRequest, nil
 }
 
-func GetAllAppointmentCancelRequests(client *mongo.Client) []AppointmentDeleteRequest {
-	collection := client.Database("healthcare").Collection("requests")
-
-	filter := bson.D{{}}
-
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
-
-	var requests []AppointmentDeleteRequest
-	cursor.All(context.TODO(), &requests)
+func GetAllAppointmentCancelRequests(ctx context.Context, app *App) []AppointmentDeleteRequest {
+	requests, _ := app.Store.Requests.List(ctx)
 
 	return requests
 }
 
-func GetAppointmentCancelRequestsByDoctorCode(client *mongo.Client, doctorCode string) []AppointmentDeleteRequest {
-	collection := client.Database("healthcare").Collection("requests")
-
-	filter := bson.D{{Key: "doctorCode", Value: doctorCode}}
+// ListAppointmentCancelRequests returns one page of cancel requests matching filter
+func ListAppointmentCancelRequests(ctx context.Context, app *App, filter RequestFilter, opts ListOptions) (*Page[AppointmentDeleteRequest], error) {
+	query, err := newListQuery(opts, requestSortFields, "-createdAt", "requestCode")
+	if err != nil {
+		return nil, err
+	}
 
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
+	requests, total, err := app.Store.Requests.Find(ctx, filter, query)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(requests, total, query), nil
+}
 
-	var requests []AppointmentDeleteRequest
-	for cursor.Next(context.TODO()) {
-		var request AppointmentDeleteRequest
-		if err := cursor.Decode(&requests); err != nil {
-			log.Println("Cursor decoding error:", err)
-			return nil
-		}
-		requests = append(requests, request)
+func GetAppointmentCancelRequestsByDoctorCode(ctx context.Context, app *App, doctorCode string) []AppointmentDeleteRequest {
+	requests, err := app.Store.Requests.ListByDoctor(ctx, doctorCode)
+	if err != nil {
+		log.Println("Cursor decoding error:", err)
+		return nil
 	}
 	return requests
 }
 
-func UpdateCancelRequestStatus(client *mongo.Client, requestID, status string) error {
-	collection := client.Database("healthcare").Collection("requests")
+// UpdateCancelRequestStatus approves or rejects a pending cancel request. Approving
+// cancels the appointment, on behalf of the patient when they filed the request and of
+// the hospital when the doctor did. The request is closed before the appointment is
+// cancelled, so of two admins answering it at once only one succeeds, and it is
+// reopened when the cancellation fails.
+func UpdateCancelRequestStatus(ctx context.Context, app *App, requestCode, status, changedBy string) error {
+	request, err := app.Store.Requests.Get(ctx, requestCode)
+	if err != nil {
+		return err
+	}
+	if request.Status != "" && request.Status != RequestPending {
+		return fmt.Errorf("%w: it was %s", ErrRequestClosed, request.Status)
+	}
 
-	filter := bson.M{"_id": requestID}
-	update := bson.M{"$set": bson.M{"status": status, "updatedAt": time.Now()}}
+	err = app.Store.Requests.UpdateStatus(ctx, requestCode, request.Status, status)
+	if errors.Is(err, ErrVersionConflict) {
+		return fmt.Errorf("%w: it was answered meanwhile", ErrRequestClosed)
+	}
+	if err != nil {
+		return err
+	}
 
-	_, err := collection.UpdateOne(context.TODO(), filter, update)
-	return err
+	if status == RequestApproved {
+		cancelled := StatusCancelledByHospital
+		if request.UserCode != "" {
+			cancelled = StatusCancelledByPatient
+		}
+		if _, err := TransitionAppointment(ctx, app, request.AppointmentCode, cancelled, changedBy, request.Reason); err != nil {
+			if reopenErr := app.Store.Requests.UpdateStatus(context.WithoutCancel(ctx), requestCode, status, request.Status); reopenErr != nil {
+				log.Println("Error reopening cancel request:", reopenErr)
+			}
+			return err
+		}
+	}
+
+	updated := *request
+	updated.Status = status
+	recordAudit(ctx, app, AuditUpdate, TargetCancelRequest, requestCode, request, updated)
+	return nil
 }
 
-func DeleteAppointmentCancelRequest(client *mongo.Client, requestCode string) {
-	collection := client.Database("healthcare").Collection("requests")
-	collection.FindOneAndDelete(context.TODO(), bson.D{{Key: "requestCode", Value: requestCode}})
+func DeleteAppointmentCancelRequest(ctx context.Context, app *App, requestCode string) {
+	if err := app.Store.Requests.Delete(ctx, requestCode); err != nil {
+		log.Println("Error deleting cancel request:", err)
+		return
+	}
+	recordAudit(ctx, app, AuditDelete, TargetCancelRequest, requestCode, nil, nil)
 }
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrCannotReschedule is returned for an appointment that has started, is over or
+// was cancelled
+var ErrCannotReschedule = errors.New("appointment cannot be rescheduled")
+
+// RescheduleAppointment moves the appointment to another slot of the same doctor and
+// keeps its code, status and creation time. The new time must respect the booking
+// policy, but the appointment does not count against the active limit again. A
+// *PolicyViolation is returned otherwise. The new slot is claimed before the old one is released, a
+// *SlotConflictError leaves the appointment where it was. The calendar event is
+// moved and the patient gets a single email.
+func RescheduleAppointment(ctx context.Context, app *App, appointmentCode string, to AppointmentTime) (*Appointment, error) {
+	return rescheduleAppointment(ctx, app, appointmentCode, to, true)
+}
+
+// rescheduleAppointment is RescheduleAppointment, notify false leaves the email to
+// the caller
+func rescheduleAppointment(ctx context.Context, app *App, appointmentCode string, to AppointmentTime, notify bool) (*Appointment, error) {
+	now := time.Now()
+	if err := validSlotTime(to); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		existing, err := app.Store.Appointments.Get(ctx, appointmentCode)
+		if err != nil {
+			return nil, err
+		}
+		status := existing.CurrentStatus()
+		if status != StatusBooked && status != StatusConfirmed {
+			return nil, fmt.Errorf("%w: it is %s", ErrCannotReschedule, status)
+		}
+		if !isFuture(app, *existing, now) {
+			return nil, fmt.Errorf("%w: it has already started", ErrCannotReschedule)
+		}
+		if existing.AppointmentTime == to {
+			return existing, nil
+		}
+
+		moved := *existing
+		moved.AppointmentTime = to
+		if !isFuture(app, moved, now) {
+			return nil, fmt.Errorf("%w: %s %s is in the past", ErrInvalidSlot, to.Date, to.Time)
+		}
+		doctor, err := app.Store.Doctors.Get(ctx, existing.DoctorCode)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBookingPolicy(ctx, app, moved, doctor, existing.AppointmentCode); err != nil {
+			return nil, err
+		}
+		updated, err := UpdateAppointment(ctx, app, moved)
+		if errors.Is(err, ErrVersionConflict) && attempt < transitionRetries {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		moveCalendarEvent(ctx, app, updated)
+		if notify {
+			notifyReschedule(ctx, app, existing.AppointmentTime, updated)
+		}
+		return updated, nil
+	}
+}
+
+// moveCalendarEvent moves the Google Calendar event of the appointment to its new time
+func moveCalendarEvent(ctx context.Context, app *App, appointment *Appointment) {
+	if app.Calendar == nil || appointment.CalendarEventID == "" {
+		return
+	}
+	startTime, endTime, err := calendarSpan(app, *appointment)
+	if err != nil {
+		log.Println("Error parsing appointment time:", err)
+		return
+	}
+	_, err = app.Calendar.MoveAppointmentInCalendar(context.WithoutCancel(ctx), app.Config.Google.CalendarID, appointment.CalendarEventID, startTime, endTime)
+	if err != nil {
+		log.Println("Error moving Google Calendar event:", err)
+	} else {
+		log.Println("Moved appointment in Google Calendar, EventID:", appointment.CalendarEventID)
+	}
+}
+
+// notifyReschedule emails the patient the new date and time of the appointment
+func notifyReschedule(ctx context.Context, app *App, previous AppointmentTime, appointment *Appointment) {
+	if app.Mailer == nil {
+		return
+	}
+	user, err := app.Store.Users.Lookup(ctx, appointment.UserCode)
+	if err != nil {
+		log.Println("Error getting user:", err)
+		return
+	}
+	doctor, err := app.Store.Doctors.Lookup(ctx, appointment.DoctorCode)
+	if err != nil {
+		log.Println("Error getting doctor:", err)
+		return
+	}
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		log.Println("Error getting hospital:", err)
+		return
+	}
+
+	err = app.Mailer.SendAppointmentRescheduleEmail(
+		context.WithoutCancel(ctx),
+		user.Email,
+		user.UserCode,
+		doctor.DoctorName,
+		hospital.HospitalName,
+		displayDate(previous.Date),
+		previous.Time,
+		displayDate(appointment.AppointmentTime.Date),
+		appointment.AppointmentTime.Time,
+	)
+	if err != nil {
+		log.Println("Error sending reschedule email:", err)
+	} else {
+		log.Println("Reschedule email sent successfully to:", user.Email)
+	}
+}
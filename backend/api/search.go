@@ -0,0 +1,192 @@
+package api
+
+import (
+	"backend/scheduling"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSearchDays is the date window of a slot search without an end date
+	defaultSearchDays = 14
+	// defaultSearchResults is how many slots a search returns without a limit
+	defaultSearchResults = 10
+	// maxSearchResults caps the limit of a slot search
+	maxSearchResults = 50
+)
+
+// ErrInvalidSearch is returned for a slot search without a field or a location
+var ErrInvalidSearch = errors.New("invalid slot search")
+
+// SlotSearch asks for the earliest free slots of a field. The location is the
+// hospital when given, else the district, else the province.
+type SlotSearch struct {
+	// FieldCode is nil when no field is given, field 0 is General Medicine
+	FieldCode    *int
+	ProvinceCode int
+	DistrictCode int
+	HospitalCode int
+	// DateFrom defaults to today and DateTo to defaultSearchDays days later
+	DateFrom string
+	DateTo   string
+	Limit    int
+}
+
+// SlotMatch is a free slot found by FindFirstAvailable
+type SlotMatch struct {
+	Date         string `json:"date"`
+	StartTime    string `json:"startTime"`
+	EndTime      string `json:"endTime"`
+	DoctorCode   string `json:"doctorCode"`
+	DoctorName   string `json:"doctorName"`
+	HospitalCode int    `json:"hospitalCode"`
+	HospitalName string `json:"hospitalName"`
+	DistrictCode int    `json:"districtCode"`
+	ProvinceCode int    `json:"provinceCode"`
+}
+
+// searchCandidate is a doctor of the searched field with its hours over the window
+type searchCandidate struct {
+	doctor       Doctor
+	hospital     Hospital
+	availability scheduling.Availability
+	minutes      int
+}
+
+// FindFirstAvailable returns the earliest free slots of the field across the doctors
+// of the location, ordered by date and time. Days are searched one after the other,
+// so the search stops as soon as enough slots are found.
+func FindFirstAvailable(ctx context.Context, app *App, search SlotSearch) ([]SlotMatch, error) {
+	now := time.Now()
+	if err := normalizeSearch(&search, now.In(defaultLocation(app))); err != nil {
+		return nil, err
+	}
+
+	candidates, err := searchCandidates(ctx, app, search)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []SlotMatch{}
+	first, _ := time.Parse("2006-01-02", search.DateFrom)
+	for day := first; day.Format("2006-01-02") <= search.DateTo && len(matches) < search.Limit; day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+
+		var found []SlotMatch
+		for _, candidate := range candidates {
+			locks := &lockedSpans{ctx: ctx, app: app, doctorCode: candidate.doctor.DoctorCode}
+			slots, _ := scheduling.Free(candidate.availability, date, date, candidate.minutes, locks.busy, now.In(location(app, hospitalZone(app, &candidate.hospital))))
+			if locks.err != nil {
+				return nil, locks.err
+			}
+			for _, slot := range slots {
+				found = append(found, SlotMatch{
+					Date:         slot.Date,
+					StartTime:    slot.Start,
+					EndTime:      slot.End,
+					DoctorCode:   candidate.doctor.DoctorCode,
+					DoctorName:   candidate.doctor.DoctorName,
+					HospitalCode: candidate.hospital.HospitalCode,
+					HospitalName: candidate.hospital.HospitalName,
+					DistrictCode: candidate.hospital.DistrictCode,
+					ProvinceCode: candidate.hospital.ProvinceCode,
+				})
+			}
+		}
+
+		slices.SortStableFunc(found, func(a, b SlotMatch) int {
+			if c := strings.Compare(a.StartTime, b.StartTime); c != 0 {
+				return c
+			}
+			return strings.Compare(a.DoctorName, b.DoctorName)
+		})
+		matches = append(matches, found...)
+	}
+
+	if len(matches) > search.Limit {
+		matches = matches[:search.Limit]
+	}
+	return matches, nil
+}
+
+// normalizeSearch checks the search and fills in the default window and limit
+func normalizeSearch(search *SlotSearch, now time.Time) error {
+	if search.FieldCode == nil {
+		return fmt.Errorf("%w: field is required", ErrInvalidSearch)
+	}
+	if search.HospitalCode == 0 && search.DistrictCode == 0 && search.ProvinceCode == 0 {
+		return fmt.Errorf("%w: a province, district or hospital is required", ErrInvalidSearch)
+	}
+
+	today := now.Format("2006-01-02")
+	if search.DateFrom == "" || search.DateFrom < today {
+		search.DateFrom = today
+	}
+	if search.DateTo == "" {
+		from, err := time.Parse("2006-01-02", search.DateFrom)
+		if err != nil {
+			return fmt.Errorf("%w: from %q is not a date", ErrInvalidSearch, search.DateFrom)
+		}
+		search.DateTo = from.AddDate(0, 0, defaultSearchDays-1).Format("2006-01-02")
+	}
+	if err := checkDateRange(search.DateFrom, search.DateTo, maxFreeSlotDays); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+
+	if search.Limit <= 0 {
+		search.Limit = defaultSearchResults
+	}
+	search.Limit = min(search.Limit, maxSearchResults)
+	return nil
+}
+
+// searchCandidates lists the live doctors of the field in the hospitals of the
+// location with their availability over the search window
+func searchCandidates(ctx context.Context, app *App, search SlotSearch) ([]searchCandidate, error) {
+	var hospitals []Hospital
+	switch {
+	case search.HospitalCode != 0:
+		hospital, err := app.Store.Hospitals.Get(ctx, search.HospitalCode)
+		if err != nil {
+			return nil, err
+		}
+		hospitals = []Hospital{*hospital}
+	case search.DistrictCode != 0:
+		hospitals = GetHospitalsByDistrict(ctx, app, search.DistrictCode)
+	default:
+		hospitals = GetHospitalsByProvince(ctx, app, search.ProvinceCode)
+	}
+
+	var candidates []searchCandidate
+	for _, hospital := range hospitals {
+		if !slices.Contains(hospital.Fields, *search.FieldCode) {
+			continue
+		}
+		doctors, err := app.Store.Doctors.ListByHospital(ctx, hospital.HospitalCode)
+		if err != nil {
+			return nil, err
+		}
+		for _, doctor := range doctors {
+			if doctor.FieldCode != *search.FieldCode {
+				continue
+			}
+			availability, err := availabilityFor(ctx, app, &doctor, search.DateFrom, search.DateTo)
+			if err != nil {
+				log.Printf("Error getting the blackouts of doctor %s: %v", doctor.DoctorCode, err)
+				continue
+			}
+			candidates = append(candidates, searchCandidate{
+				doctor:       doctor,
+				hospital:     hospital,
+				availability: availability,
+				minutes:      slotLength(app, &doctor),
+			})
+		}
+	}
+	return candidates, nil
+}
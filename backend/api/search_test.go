@@ -0,0 +1,76 @@
+package api
+
+import (
+	"backend/config"
+	"backend/scheduling"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFindFirstAvailable(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	app := &App{Store: store, Config: config.Defaults()}
+
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	date := tomorrow.Format("2006-01-02")
+	early := &scheduling.Availability{Weekly: []scheduling.WeekdayHours{{
+		Weekday:   tomorrow.Weekday(),
+		Intervals: []scheduling.Interval{{Start: "08:00", End: "09:00"}},
+	}}}
+	dawn := &scheduling.Availability{Weekly: []scheduling.WeekdayHours{{
+		Weekday:   tomorrow.Weekday(),
+		Intervals: []scheduling.Interval{{Start: "07:00", End: "09:00"}},
+	}}}
+
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 1, HospitalName: "Merkez", DistrictCode: 10, ProvinceCode: 1, Fields: []int{5, 6}})
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 2, HospitalName: "Sahil", DistrictCode: 10, ProvinceCode: 1, Fields: []int{5}})
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 3, HospitalName: "Uzak", DistrictCode: 20, ProvinceCode: 1, Fields: []int{5}})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "a", DoctorName: "A", FieldCode: 5, HospitalCode: 1})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "b", DoctorName: "B", FieldCode: 5, HospitalCode: 2, SlotMinutes: 30, Availability: early})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "c", DoctorName: "C", FieldCode: 5, HospitalCode: 3, Availability: dawn})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "d", DoctorName: "D", FieldCode: 6, HospitalCode: 1, Availability: dawn})
+	store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "a", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: "09:00"}}); err != nil {
+		t.Fatalf("book: %v", err)
+	}
+
+	field := 5
+	matches, err := FindFirstAvailable(ctx, app, SlotSearch{FieldCode: &field, DistrictCode: 10, DateFrom: date, Limit: 4})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	var got []string
+	for _, match := range matches {
+		got = append(got, match.DoctorCode+" "+match.Date+" "+match.StartTime)
+	}
+	want := []string{"b " + date + " 08:00", "b " + date + " 08:30", "a " + date + " 09:15", "a " + date + " 09:30"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// The whole province includes the doctor starting at 07:00
+	matches, _ = FindFirstAvailable(ctx, app, SlotSearch{FieldCode: &field, ProvinceCode: 1, DateFrom: date, Limit: 1})
+	if len(matches) != 1 || matches[0].DoctorCode != "c" || matches[0].HospitalName != "Uzak" {
+		t.Fatalf("want doctor c first in the province, got %+v", matches)
+	}
+
+	if _, err := FindFirstAvailable(ctx, app, SlotSearch{FieldCode: &field}); !errors.Is(err, ErrInvalidSearch) {
+		t.Fatalf("want a search without a location rejected, got %v", err)
+	}
+	if _, err := FindFirstAvailable(ctx, app, SlotSearch{DistrictCode: 10}); !errors.Is(err, ErrInvalidSearch) {
+		t.Fatalf("want a search without a field rejected, got %v", err)
+	}
+
+	// Field 0 is General Medicine
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 4, HospitalName: "Aile", DistrictCode: 30, ProvinceCode: 2, Fields: []int{0}})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "e", DoctorName: "E", FieldCode: 0, HospitalCode: 4, Availability: early})
+	general := 0
+	matches, err = FindFirstAvailable(ctx, app, SlotSearch{FieldCode: &general, HospitalCode: 4, DateFrom: date, Limit: 1})
+	if err != nil || len(matches) != 1 || matches[0].DoctorCode != "e" || matches[0].StartTime != "08:00" {
+		t.Fatalf("want General Medicine searchable, got %+v (%v)", matches, err)
+	}
+}
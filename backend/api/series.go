@@ -0,0 +1,455 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"backend/helper"
+)
+
+// SeriesFrequency is how often the occurrences of a recurring series repeat
+type SeriesFrequency string
+
+const (
+	SeriesWeekly   SeriesFrequency = "weekly"
+	SeriesBiweekly SeriesFrequency = "biweekly"
+)
+
+// days is the number of days between two occurrences, 0 for an unknown frequency
+func (f SeriesFrequency) days() int {
+	switch f {
+	case SeriesWeekly:
+		return 7
+	case SeriesBiweekly:
+		return 14
+	}
+	return 0
+}
+
+// maxSeriesOccurrences bounds the number of appointments a series books at once
+const maxSeriesOccurrences = 52
+
+// ErrInvalidSeries is returned for a series request with a bad rule
+var ErrInvalidSeries = errors.New("invalid appointment series")
+
+// SeriesRequest books the same time with a doctor every week or every other week,
+// starting at Start. The series ends after Count occurrences or on the last
+// occurrence up to Until, exactly one of them is set.
+type SeriesRequest struct {
+	DoctorCode string          `json:"doctorCode"`
+	UserCode   string          `json:"userCode"`
+	Start      AppointmentTime `json:"start"`
+	Frequency  SeriesFrequency `json:"frequency"`
+	Count      int             `json:"count,omitempty"`
+	Until      string          `json:"until,omitempty"`
+	// SkipConflicts books the occurrences that are free and reports the others
+	// instead of booking nothing
+	SkipConflicts bool `json:"skipConflicts,omitempty"`
+}
+
+// SeriesConflict is an occurrence of a series that cannot be booked or moved. Code is
+// slot_taken, invalid_slot or the reason of the broken booking policy rule.
+type SeriesConflict struct {
+	Date    string `json:"date"`
+	Time    string `json:"time"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SeriesConflictError is returned when occurrences of a series cannot be booked or
+// moved. Nothing was booked or moved.
+type SeriesConflictError struct {
+	Conflicts []SeriesConflict
+}
+
+func (e *SeriesConflictError) Error() string {
+	return fmt.Sprintf("%d occurrences of the series conflict", len(e.Conflicts))
+}
+
+// Series is the outcome of booking a recurring series
+type Series struct {
+	SeriesCode   string           `json:"seriesCode"`
+	Appointments []Appointment    `json:"appointments"`
+	Conflicts    []SeriesConflict `json:"conflicts"`
+}
+
+// SeriesCancellation is the outcome of cancelling the rest of a series. Requests are
+// the cancel requests filed for occurrences inside the cancellation window.
+type SeriesCancellation struct {
+	Cancelled []Appointment              `json:"cancelled"`
+	Requests  []AppointmentDeleteRequest `json:"requests"`
+}
+
+// dates lists the dates of the occurrences of the series
+func (r SeriesRequest) dates() ([]string, error) {
+	step := r.Frequency.days()
+	if step == 0 {
+		return nil, fmt.Errorf("%w: unknown frequency %q", ErrInvalidSeries, r.Frequency)
+	}
+	if (r.Count > 0) == (r.Until != "") {
+		return nil, fmt.Errorf("%w: set either a count or an end date", ErrInvalidSeries)
+	}
+	start, err := time.Parse("2006-01-02", r.Start.Date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSlot, r.Start.Date)
+	}
+	if r.Count > maxSeriesOccurrences {
+		return nil, fmt.Errorf("%w: at most %d occurrences", ErrInvalidSeries, maxSeriesOccurrences)
+	}
+
+	var dates []string
+	for day := start; r.Count == 0 || len(dates) < r.Count; day = day.AddDate(0, 0, step) {
+		date := day.Format("2006-01-02")
+		if r.Until != "" && date > r.Until {
+			break
+		}
+		if len(dates) == maxSeriesOccurrences {
+			return nil, fmt.Errorf("%w: at most %d occurrences", ErrInvalidSeries, maxSeriesOccurrences)
+		}
+		dates = append(dates, date)
+	}
+	if len(dates) == 0 {
+		return nil, fmt.Errorf("%w: the end date is before the start", ErrInvalidSeries)
+	}
+	return dates, nil
+}
+
+// CreateSeries books every occurrence of the series under one series code. Each
+// occurrence is checked against the working hours of the doctor, the booking policy
+// and the booked slots first, a *SeriesConflictError lists those that fail and
+// nothing is booked, unless SkipConflicts books the rest. The patient gets a single
+// email for the whole series.
+func CreateSeries(ctx context.Context, app *App, request SeriesRequest) (*Series, error) {
+	dates, err := request.dates()
+	if err != nil {
+		return nil, err
+	}
+
+	seriesCode := helper.GenerateID(8)
+	conflicts := []SeriesConflict{}
+	var bookings []*booking
+	for _, date := range dates {
+		at := AppointmentTime{Date: date, Time: request.Start.Time}
+		b, err := newBooking(ctx, app, Appointment{
+			DoctorCode:      request.DoctorCode,
+			UserCode:        request.UserCode,
+			AppointmentTime: at,
+			SeriesCode:      seriesCode,
+		}, false)
+		if err == nil {
+			err = checkSlotFree(ctx, app, b.appointment, nil)
+		}
+		if err != nil {
+			conflict, ok := seriesConflict(at, err)
+			if !ok {
+				return nil, err
+			}
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+		bookings = append(bookings, b)
+	}
+	if len(bookings) == 0 || (len(conflicts) > 0 && !request.SkipConflicts) {
+		return nil, &SeriesConflictError{Conflicts: conflicts}
+	}
+
+	// Another booking may take a slot between the check and the claim
+	var claimed []*booking
+	for _, b := range bookings {
+		err := claimSlot(ctx, app, b.doctor, b.appointment)
+		if err != nil {
+			conflict, ok := seriesConflict(b.appointment.AppointmentTime, err)
+			if ok && request.SkipConflicts {
+				conflicts = append(conflicts, conflict)
+				continue
+			}
+			releaseClaims(ctx, app, claimed)
+			if ok {
+				return nil, &SeriesConflictError{Conflicts: append(conflicts, conflict)}
+			}
+			return nil, err
+		}
+		claimed = append(claimed, b)
+	}
+	if len(claimed) == 0 {
+		return nil, &SeriesConflictError{Conflicts: conflicts}
+	}
+
+	series := &Series{SeriesCode: seriesCode, Appointments: []Appointment{}, Conflicts: conflicts}
+	for i, b := range claimed {
+		if err := b.save(ctx, app); err != nil {
+			// The occurrences already stored stay booked under the series code
+			releaseClaims(ctx, app, claimed[i+1:])
+			return nil, err
+		}
+		series.Appointments = append(series.Appointments, b.appointment)
+	}
+	notifySeries(ctx, app, series.Appointments, "Tekrarlayan Randevu Onayı")
+	return series, nil
+}
+
+// GetSeries returns the occurrences of the series in order, ErrNotFound when there
+// are none
+func GetSeries(ctx context.Context, app *App, seriesCode string) ([]Appointment, error) {
+	appointments, err := app.Store.Appointments.ListBySeries(ctx, seriesCode)
+	if err != nil {
+		return nil, err
+	}
+	if len(appointments) == 0 {
+		return nil, ErrNotFound
+	}
+	slices.SortFunc(appointments, func(a, b Appointment) int {
+		return compareTimes(a.AppointmentTime, b.AppointmentTime)
+	})
+	return appointments, nil
+}
+
+// CancelSeries cancels the occurrences of the series that have not started yet with
+// status. A patient cancellation goes through CancelAppointment, so occurrences inside
+// the cancellation window become cancel requests instead. Single occurrences are
+// cancelled like any other appointment.
+func CancelSeries(ctx context.Context, app *App, seriesCode string, status AppointmentStatus, cancelledBy, reason string) (*SeriesCancellation, error) {
+	if !status.IsCancelled() {
+		return nil, fmt.Errorf("%w: %s is not a cancellation", ErrInvalidTransition, status)
+	}
+	remaining, err := upcomingOccurrences(ctx, app, seriesCode)
+	if err != nil {
+		return nil, err
+	}
+	if len(remaining) == 0 {
+		return nil, fmt.Errorf("%w: the series has no upcoming occurrences", ErrCannotCancel)
+	}
+
+	result := &SeriesCancellation{Cancelled: []Appointment{}, Requests: []AppointmentDeleteRequest{}}
+	for _, occurrence := range remaining {
+		var cancelled *Appointment
+		if status == StatusCancelledByPatient {
+			cancelled, err = cancelAppointment(ctx, app, occurrence.AppointmentCode, cancelledBy, reason, false, false)
+		} else {
+			cancelled, err = transitionAppointment(ctx, app, occurrence.AppointmentCode, status, cancelledBy, reason, false)
+		}
+		var late *LateCancellationError
+		if errors.As(err, &late) {
+			result.Requests = append(result.Requests, late.Request)
+			continue
+		}
+		if err != nil {
+			notifySeries(ctx, app, result.Cancelled, "Tekrarlayan Randevu İptali")
+			return nil, err
+		}
+		result.Cancelled = append(result.Cancelled, *cancelled)
+	}
+	notifySeries(ctx, app, result.Cancelled, "Tekrarlayan Randevu İptali")
+	return result, nil
+}
+
+// RescheduleSeries moves the occurrences of the series that have not started yet. The
+// next occurrence moves to to and the later ones keep their distance to it, all at
+// the time of to. Every new slot is checked first and a *SeriesConflictError leaves
+// the whole series where it was. Single occurrences are rescheduled like any other
+// appointment.
+func RescheduleSeries(ctx context.Context, app *App, seriesCode string, to AppointmentTime) ([]Appointment, error) {
+	if err := validSlotTime(to); err != nil {
+		return nil, err
+	}
+	target, err := time.Parse("2006-01-02", to.Date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSlot, to.Date)
+	}
+	remaining, err := upcomingOccurrences(ctx, app, seriesCode)
+	if err != nil {
+		return nil, err
+	}
+	if len(remaining) == 0 {
+		return nil, fmt.Errorf("%w: the series has no upcoming occurrences", ErrCannotReschedule)
+	}
+	first, err := time.Parse("2006-01-02", remaining[0].AppointmentTime.Date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSlot, remaining[0].AppointmentTime.Date)
+	}
+	shift := int(target.Sub(first).Hours() / 24)
+
+	doctor, err := app.Store.Doctors.Get(ctx, remaining[0].DoctorCode)
+	if err != nil {
+		return nil, err
+	}
+	own := map[string]bool{}
+	for _, occurrence := range remaining {
+		own[occurrence.AppointmentCode] = true
+	}
+
+	now := time.Now()
+	moves := make([]AppointmentTime, len(remaining))
+	conflicts := []SeriesConflict{}
+	for i, occurrence := range remaining {
+		day, err := time.Parse("2006-01-02", occurrence.AppointmentTime.Date)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSlot, occurrence.AppointmentTime.Date)
+		}
+		moves[i] = AppointmentTime{Date: day.AddDate(0, 0, shift).Format("2006-01-02"), Time: to.Time}
+
+		moved := occurrence
+		moved.AppointmentTime = moves[i]
+		err = nil
+		if !isFuture(app, moved, now) {
+			err = fmt.Errorf("%w: %s %s is in the past", ErrInvalidSlot, moves[i].Date, moves[i].Time)
+		}
+		if err == nil {
+			err = checkWorkingTime(ctx, app, doctor, moved)
+		}
+		if err == nil {
+			err = checkBookingPolicy(ctx, app, moved, doctor, occurrence.AppointmentCode)
+		}
+		if err == nil {
+			err = checkSlotFree(ctx, app, moved, own)
+		}
+		if err != nil {
+			conflict, ok := seriesConflict(moves[i], err)
+			if !ok {
+				return nil, err
+			}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, &SeriesConflictError{Conflicts: conflicts}
+	}
+
+	// Moving later frees the slot of each occurrence before the one before it takes
+	// it, so go from the last occurrence, and from the first when moving earlier. The
+	// waitlist is only offered the slots still free once the whole series moved.
+	ctx, offer := holdOffers(ctx, app)
+	defer offer()
+	order := make([]int, len(remaining))
+	for i := range order {
+		order[i] = i
+	}
+	if compareTimes(to, remaining[0].AppointmentTime) > 0 {
+		slices.Reverse(order)
+	}
+	updated := make([]Appointment, len(remaining))
+	for _, i := range order {
+		appointment, err := rescheduleAppointment(ctx, app, remaining[i].AppointmentCode, moves[i], false)
+		if err != nil {
+			return nil, err
+		}
+		updated[i] = *appointment
+	}
+	notifySeries(ctx, app, updated, "Tekrarlayan Randevu Saati Değişikliği")
+	return updated, nil
+}
+
+// upcomingOccurrences lists the occurrences of the series that have not started and
+// are still open, in order
+func upcomingOccurrences(ctx context.Context, app *App, seriesCode string) ([]Appointment, error) {
+	appointments, err := GetSeries(ctx, app, seriesCode)
+	if err != nil {
+		return nil, err
+	}
+	return onlyFuture(app, appointments), nil
+}
+
+// checkSlotFree returns a *SlotConflictError when a piece of the appointment's slot is
+// locked by an appointment other than those in own
+func checkSlotFree(ctx context.Context, app *App, appointment Appointment, own map[string]bool) error {
+	locks, err := app.Store.Slots.ListByDoctorDate(ctx, appointment.DoctorCode, appointment.AppointmentTime.Date)
+	if err != nil {
+		return err
+	}
+	wanted := slotLocksFor(appointment)
+	for _, lock := range locks {
+		if own[lock.AppointmentCode] {
+			continue
+		}
+		if slices.ContainsFunc(wanted, func(w SlotLock) bool { return w.Time == lock.Time }) {
+			return &SlotConflictError{
+				DoctorCode: appointment.DoctorCode,
+				Date:       appointment.AppointmentTime.Date,
+				Time:       appointment.AppointmentTime.Time,
+			}
+		}
+	}
+	return nil
+}
+
+// seriesConflict describes why the occurrence at at cannot be booked, false for an
+// error that is not about the occurrence itself
+func seriesConflict(at AppointmentTime, err error) (SeriesConflict, bool) {
+	conflict := SeriesConflict{Date: at.Date, Time: at.Time, Message: err.Error()}
+	var slotTaken *SlotConflictError
+	var violation *PolicyViolation
+	switch {
+	case errors.As(err, &slotTaken):
+		conflict.Code = "slot_taken"
+	case errors.As(err, &violation):
+		conflict.Code = violation.Code
+		conflict.Message = violation.Message
+	case errors.Is(err, ErrInvalidSlot):
+		conflict.Code = "invalid_slot"
+	default:
+		return SeriesConflict{}, false
+	}
+	return conflict, true
+}
+
+// releaseClaims frees the slots claimed for bookings that will not be stored
+func releaseClaims(ctx context.Context, app *App, bookings []*booking) {
+	for _, b := range bookings {
+		if err := app.Store.Slots.Release(context.WithoutCancel(ctx), slotLocksFor(b.appointment)); err != nil {
+			log.Println("Error releasing slot:", err)
+		}
+	}
+}
+
+// compareTimes orders appointment times by date, then time
+func compareTimes(a, b AppointmentTime) int {
+	if a.Date != b.Date {
+		if a.Date < b.Date {
+			return -1
+		}
+		return 1
+	}
+	if a.Time < b.Time {
+		return -1
+	}
+	if a.Time > b.Time {
+		return 1
+	}
+	return 0
+}
+
+// notifySeries emails the patient a single summary of the occurrences of a series
+func notifySeries(ctx context.Context, app *App, appointments []Appointment, heading string) {
+	if app.Mailer == nil || len(appointments) == 0 {
+		return
+	}
+	first := appointments[0]
+	user, err := app.Store.Users.Lookup(ctx, first.UserCode)
+	if err != nil {
+		log.Println("Error getting user:", err)
+		return
+	}
+	doctor, err := app.Store.Doctors.Lookup(ctx, first.DoctorCode)
+	if err != nil {
+		log.Println("Error getting doctor:", err)
+		return
+	}
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		log.Println("Error getting hospital:", err)
+		return
+	}
+
+	occurrences := make([]string, len(appointments))
+	for i, appointment := range appointments {
+		occurrences[i] = displayDate(appointment.AppointmentTime.Date) + " " + appointment.AppointmentTime.Time
+	}
+	err = app.Mailer.SendAppointmentSeriesEmail(context.WithoutCancel(ctx), user.Email, user.UserCode, doctor.DoctorName, hospital.HospitalName, heading, occurrences)
+	if err != nil {
+		log.Println("Error sending series email:", err)
+	}
+}
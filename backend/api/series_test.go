@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAppointmentSeries(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	day := func(days int) string {
+		return time.Now().AddDate(0, 0, days).Format("2006-01-02")
+	}
+	book := func(userCode, date, at string) (*Appointment, error) {
+		return CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: userCode, AppointmentTime: AppointmentTime{Date: date, Time: at}})
+	}
+	times := func(appointments []Appointment) []AppointmentTime {
+		var at []AppointmentTime
+		for _, appointment := range appointments {
+			at = append(at, appointment.AppointmentTime)
+		}
+		return at
+	}
+
+	taken, err := book("patient2", day(17), "10:00")
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	request := SeriesRequest{DoctorCode: "doc1", UserCode: "patient1", Start: AppointmentTime{Date: day(3), Time: "10:00"}, Frequency: SeriesWeekly, Count: 4}
+
+	_, err = CreateSeries(ctx, app, request)
+	var conflict *SeriesConflictError
+	if !errors.As(err, &conflict) || len(conflict.Conflicts) != 1 || conflict.Conflicts[0].Date != day(17) || conflict.Conflicts[0].Code != "slot_taken" {
+		t.Fatalf("want the taken date reported, got %v", err)
+	}
+	if booked, _ := app.Store.Appointments.ListByUser(ctx, "patient1"); len(booked) != 0 {
+		t.Fatalf("a conflicting series must book nothing: %+v", booked)
+	}
+
+	request.SkipConflicts = true
+	series, err := CreateSeries(ctx, app, request)
+	if err != nil || len(series.Appointments) != 3 || len(series.Conflicts) != 1 {
+		t.Fatalf("want the free dates booked: %+v (%v)", series, err)
+	}
+
+	// The series counts once towards the three upcoming appointments
+	for _, date := range []string{day(30), day(31)} {
+		if _, err := book("patient1", date, "09:00"); err != nil {
+			t.Fatalf("book %s next to the series: %v", date, err)
+		}
+	}
+	var violation *PolicyViolation
+	if _, err := book("patient1", day(32), "09:00"); !errors.As(err, &violation) || violation.Code != ReasonMaxActive {
+		t.Fatalf("want the active limit reached, got %v", err)
+	}
+
+	// A week later the second occurrence would land on the taken slot
+	later := AppointmentTime{Date: day(10), Time: "10:00"}
+	if _, err := RescheduleSeries(ctx, app, series.SeriesCode, later); !errors.As(err, &conflict) || len(conflict.Conflicts) != 1 {
+		t.Fatalf("want the taken slot reported, got %v", err)
+	}
+	if kept, _ := GetSeries(ctx, app, series.SeriesCode); !slices.Equal(times(kept), times(series.Appointments)) {
+		t.Fatalf("a conflicting reschedule must move nothing: %+v", times(kept))
+	}
+
+	// Once it is free each occurrence moves into the slot the next one leaves
+	if _, err := TransitionAppointment(ctx, app, taken.AppointmentCode, StatusCancelledByHospital, "admin", ""); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	moved, err := RescheduleSeries(ctx, app, series.SeriesCode, later)
+	want := []AppointmentTime{{Date: day(10), Time: "10:00"}, {Date: day(17), Time: "10:00"}, {Date: day(31), Time: "10:00"}}
+	if err != nil || !slices.Equal(times(moved), want) {
+		t.Fatalf("want the series a week later: %+v (%v)", times(moved), err)
+	}
+
+	cancelled, err := CancelSeries(ctx, app, series.SeriesCode, StatusCancelledByPatient, "patient1", "")
+	if err != nil || len(cancelled.Cancelled) != 3 || len(cancelled.Requests) != 0 {
+		t.Fatalf("want the whole series cancelled: %+v (%v)", cancelled, err)
+	}
+	if _, err := book("patient2", day(17), "10:00"); err != nil {
+		t.Fatalf("the cancelled series must free its slots: %v", err)
+	}
+	if _, err := CancelSeries(ctx, app, series.SeriesCode, StatusCancelledByPatient, "patient1", ""); !errors.Is(err, ErrCannotCancel) {
+		t.Fatalf("want nothing left to cancel, got %v", err)
+	}
+}
+
+func TestRescheduleSeriesWaitlist(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	day := func(days int) string {
+		return time.Now().AddDate(0, 0, days).Format("2006-01-02")
+	}
+	series, err := CreateSeries(ctx, app, SeriesRequest{DoctorCode: "doc1", UserCode: "patient1", Start: AppointmentTime{Date: day(3), Time: "10:00"}, Frequency: SeriesWeekly, Count: 3})
+	if err != nil {
+		t.Fatalf("book the series: %v", err)
+	}
+	entry, err := JoinWaitlist(ctx, app, WaitlistRequest{UserCode: "patient2", DoctorCode: "doc1", DateTo: day(30)})
+	if err != nil {
+		t.Fatalf("join waitlist: %v", err)
+	}
+
+	// Each occurrence moves into the slot the next one leaves, the waitlist must not take it first
+	moved, err := RescheduleSeries(ctx, app, series.SeriesCode, AppointmentTime{Date: day(10), Time: "10:00"})
+	if err != nil || len(moved) != 3 || moved[2].AppointmentTime.Date != day(24) {
+		t.Fatalf("want the whole series a week later: %+v (%v)", moved, err)
+	}
+	offered, _ := GetWaitlistEntry(ctx, app, entry.EntryCode)
+	if offered.Status != WaitlistOffered || offered.Offer.AppointmentTime != (AppointmentTime{Date: day(3), Time: "10:00"}) {
+		t.Fatalf("want the slot left free offered to the waitlist: %+v", offered)
+	}
+}
@@ -0,0 +1,418 @@
+package api
+
+import (
+	"backend/config"
+	"backend/scheduling"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// legacySlotMinutes is the length of appointments booked before lengths were stored
+const legacySlotMinutes = 15
+
+// maxAlternativeSlots limits how many free slots a booking conflict suggests
+const maxAlternativeSlots = 5
+
+// alternativeSearchDays is how many following days are searched when the requested day is full
+const alternativeSearchDays = 7
+
+// SlotLock reserves one SlotStep long piece of a doctor's day for an appointment,
+// which holds one lock for every piece it covers. The slotLocks collection has a
+// unique index on doctorCode, date and time, which is what makes booking safe
+// against concurrent requests and appointments of different lengths.
+type SlotLock struct {
+	DoctorCode      string `bson:"doctorCode" json:"doctorCode"`
+	Date            string `bson:"date" json:"date"`
+	Time            string `bson:"time" json:"time"`
+	AppointmentCode string `bson:"appointmentCode" json:"appointmentCode"`
+}
+
+type TimeSlot struct {
+	SlotID    int    `json:"slotId"`
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+	Available bool   `json:"available"`
+	IsBooked  bool   `json:"isBooked"`
+	// IsHeld marks a slot kept for a patient who is still booking it
+	IsHeld     bool   `json:"isHeld"`
+	DoctorName string `json:"doctorName"`
+}
+
+type DoctorSchedule struct {
+	TimeSlots  []TimeSlot `json:"timeSlots"`
+	DoctorInfo DoctorInfo `json:"doctorInfo"`
+}
+
+type DoctorInfo struct {
+	DoctorName string `json:"doctorName"`
+	WorkStart  string `json:"workStart"`
+	WorkEnd    string `json:"workEnd"`
+}
+
+// SlotConflictError is returned when the requested slot was booked by someone else.
+// Alternatives holds the nearest free slots, possibly on later days.
+type SlotConflictError struct {
+	DoctorCode   string
+	Date         string
+	Time         string
+	Alternatives []AppointmentTime
+}
+
+func (e *SlotConflictError) Error() string {
+	return fmt.Sprintf("the slot %s %s is no longer available", e.Date, e.Time)
+}
+
+// ErrInvalidSlot is returned when the requested time is malformed, off the slot grid or already past
+var ErrInvalidSlot = errors.New("invalid appointment time")
+
+// slotLength is the appointment length of the doctor in minutes: its own, else the
+// default of its field, else the configured default
+func slotLength(app *App, doctor *Doctor) int {
+	if doctor.SlotMinutes > 0 {
+		return doctor.SlotMinutes
+	}
+	if minutes, ok := app.Config.Scheduling.FieldSlotMinutes[doctor.FieldCode]; ok {
+		return minutes
+	}
+	return app.Config.Scheduling.SlotMinutes
+}
+
+// appointmentMinutes is the length of the appointment in minutes
+func appointmentMinutes(appointment Appointment) int {
+	if appointment.Duration > 0 {
+		return appointment.Duration
+	}
+	return legacySlotMinutes
+}
+
+// validSlotTime checks that t is a date and a time on the SlotStep grid
+func validSlotTime(t AppointmentTime) error {
+	if _, err := time.Parse("2006-01-02", t.Date); err != nil {
+		return fmt.Errorf("%w: %s %s", ErrInvalidSlot, t.Date, t.Time)
+	}
+	minutes, err := scheduling.ParseClock(t.Time)
+	if err != nil || minutes%config.SlotStep != 0 {
+		return fmt.Errorf("%w: %s %s must start on a %d minute step", ErrInvalidSlot, t.Date, t.Time, config.SlotStep)
+	}
+	return nil
+}
+
+// slotLocksFor lists the locks of every piece of the day the appointment covers
+func slotLocksFor(appointment Appointment) []SlotLock {
+	lock := SlotLock{
+		DoctorCode:      appointment.DoctorCode,
+		Date:            appointment.AppointmentTime.Date,
+		Time:            appointment.AppointmentTime.Time,
+		AppointmentCode: appointment.AppointmentCode,
+	}
+	start, err := scheduling.ParseClock(appointment.AppointmentTime.Time)
+	if err != nil {
+		return []SlotLock{lock}
+	}
+
+	var locks []SlotLock
+	for at := start; at < start+appointmentMinutes(appointment); at += config.SlotStep {
+		lock.Time = scheduling.FormatClock(at)
+		locks = append(locks, lock)
+	}
+	return locks
+}
+
+// lockDifference lists the locks of a that cover a piece of the day b does not
+func lockDifference(a, b []SlotLock) []SlotLock {
+	return slices.DeleteFunc(slices.Clone(a), func(lock SlotLock) bool {
+		return slices.Contains(b, lock)
+	})
+}
+
+// claimSlot reserves the appointment's slot or returns a SlotConflictError with alternatives
+func claimSlot(ctx context.Context, app *App, doctor *Doctor, appointment Appointment) error {
+	return claimLocks(ctx, app, doctor, appointment, slotLocksFor(appointment))
+}
+
+// claimLocks claims locks for the appointment or returns a SlotConflictError with alternatives
+func claimLocks(ctx context.Context, app *App, doctor *Doctor, appointment Appointment, locks []SlotLock) error {
+	err := app.Store.Slots.Claim(ctx, locks)
+	if errors.Is(err, ErrSlotTaken) {
+		return &SlotConflictError{
+			DoctorCode:   appointment.DoctorCode,
+			Date:         appointment.AppointmentTime.Date,
+			Time:         appointment.AppointmentTime.Time,
+			Alternatives: findAlternativeSlots(ctx, app, doctor, appointment.AppointmentTime, appointmentMinutes(appointment)),
+		}
+	}
+	return err
+}
+
+// maxFreeSlotDays limits the date range of GetDoctorFreeSlots
+const maxFreeSlotDays = 31
+
+// doctorAvailability is the doctor's weekly template, or its work hours on every day
+// for doctors without one
+func doctorAvailability(doctor *Doctor) scheduling.Availability {
+	if doctor.Availability != nil {
+		return *doctor.Availability
+	}
+	start, end := doctor.WorkHours.Start, doctor.WorkHours.End
+	if start == "" {
+		start = "09:00"
+	}
+	if end == "" {
+		end = "17:00"
+	}
+	return scheduling.Daily(start, end)
+}
+
+// checkWorkingTime returns ErrInvalidSlot unless the doctor works for the whole
+// length of the appointment
+func checkWorkingTime(ctx context.Context, app *App, doctor *Doctor, appointment Appointment) error {
+	date := appointment.AppointmentTime.Date
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSlot, date)
+	}
+	start, err := scheduling.ParseClock(appointment.AppointmentTime.Time)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSlot, appointment.AppointmentTime.Time)
+	}
+	availability, err := availabilityFor(ctx, app, doctor, date, date)
+	if err != nil {
+		return err
+	}
+	slot := scheduling.Span{Start: start, End: start + appointmentMinutes(appointment)}
+	if !availability.Covers(day, slot) {
+		return fmt.Errorf("%w: %s %s is outside the working hours of the doctor", ErrInvalidSlot, date, appointment.AppointmentTime.Time)
+	}
+	return nil
+}
+
+// slotTimes lists the start times of the slots of the given length on date,
+// skipping those already past at now
+func slotTimes(availability scheduling.Availability, date string, minutes int, now time.Time) []string {
+	slots, err := scheduling.Free(availability, date, date, minutes, nil, now)
+	if err != nil {
+		return nil
+	}
+	times := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		times = append(times, slot.Start)
+	}
+	return times
+}
+
+// bookedSlots returns the pieces of the doctor's day locked by appointments, and
+// those held for patients who have not booked them yet
+func bookedSlots(ctx context.Context, app *App, doctorCode, date string) (map[string]bool, map[string]bool, error) {
+	locks, err := app.Store.Slots.ListByDoctorDate(ctx, doctorCode, date)
+	if err != nil {
+		return nil, nil, err
+	}
+	booked := make(map[string]bool)
+	held := make(map[string]bool)
+	for _, lock := range locks {
+		if isHoldLock(lock) {
+			held[lock.Time] = true
+		} else {
+			booked[lock.Time] = true
+		}
+	}
+	return booked, held, nil
+}
+
+// slotFree reports whether none of the pieces of a slot starting at start is booked
+func slotFree(booked map[string]bool, start string, minutes int) bool {
+	at, err := scheduling.ParseClock(start)
+	if err != nil {
+		return !booked[start]
+	}
+	for end := at + minutes; at < end; at += config.SlotStep {
+		if booked[scheduling.FormatClock(at)] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetDoctorTimeSlots lists the doctor's slots on date outside its blackouts and marks
+// the ones that overlap a booked appointment, whatever its length, or a held slot
+func GetDoctorTimeSlots(ctx context.Context, app *App, doctorCode, date string) (*DoctorSchedule, error) {
+	doctor, err := GetDoctor(ctx, app, doctorCode)
+	if err != nil {
+		return nil, err
+	}
+
+	booked, held, err := bookedSlots(ctx, app, doctorCode, date)
+	if err != nil {
+		return nil, err
+	}
+
+	availability, err := availabilityFor(ctx, app, doctor, date, date)
+	if err != nil {
+		return nil, err
+	}
+
+	// The day's working hours run from the start of the first interval to the end of the last
+	var workStart, workEnd string
+	if day, err := time.Parse("2006-01-02", date); err == nil {
+		if hours := availability.Hours(day); len(hours) > 0 {
+			workStart = scheduling.FormatClock(hours[0].Start)
+			workEnd = scheduling.FormatClock(hours[len(hours)-1].End)
+		}
+	}
+	schedule := &DoctorSchedule{
+		TimeSlots: []TimeSlot{},
+		DoctorInfo: DoctorInfo{
+			DoctorName: doctor.DoctorName,
+			WorkStart:  workStart,
+			WorkEnd:    workEnd,
+		},
+	}
+
+	minutes := slotLength(app, doctor)
+	for i, start := range slotTimes(availability, date, minutes, doctorNow(ctx, app, doctor)) {
+		startTime, _ := time.Parse("15:04", start)
+		isBooked := !slotFree(booked, start, minutes)
+		isHeld := !isBooked && !slotFree(held, start, minutes)
+		schedule.TimeSlots = append(schedule.TimeSlots, TimeSlot{
+			SlotID:     i + 1,
+			StartTime:  start,
+			EndTime:    startTime.Add(time.Duration(minutes) * time.Minute).Format("15:04"),
+			Available:  !isBooked && !isHeld,
+			IsBooked:   isBooked,
+			IsHeld:     isHeld,
+			DoctorName: doctor.DoctorName,
+		})
+	}
+
+	return schedule, nil
+}
+
+// GetDoctorFreeSlots lists the doctor's free slots from the first to the last date,
+// at most maxFreeSlotDays days
+func GetDoctorFreeSlots(ctx context.Context, app *App, doctorCode, from, to string) ([]scheduling.Slot, error) {
+	if err := checkDateRange(from, to, maxFreeSlotDays); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlot, err)
+	}
+
+	doctor, err := GetDoctor(ctx, app, doctorCode)
+	if err != nil {
+		return nil, err
+	}
+
+	availability, err := availabilityFor(ctx, app, doctor, from, to)
+	if err != nil {
+		return nil, err
+	}
+	locks := &lockedSpans{ctx: ctx, app: app, doctorCode: doctorCode}
+	slots, err := scheduling.Free(availability, from, to, slotLength(app, doctor), locks.busy, doctorNow(ctx, app, doctor))
+	if err != nil {
+		return nil, err
+	}
+	if locks.err != nil {
+		return nil, locks.err
+	}
+	return slots, nil
+}
+
+// checkDateRange checks that from and to are dates spanning 1 to days days
+func checkDateRange(from, to string, days int) error {
+	first, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return fmt.Errorf("from %q is not a date", from)
+	}
+	last, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return fmt.Errorf("to %q is not a date", to)
+	}
+	if last.Before(first) || last.Sub(first) >= time.Duration(days)*24*time.Hour {
+		return fmt.Errorf("the range must cover 1 to %d days", days)
+	}
+	return nil
+}
+
+// lockedSpans reads the locked pieces of a doctor's days for scheduling.Free. The
+// first store error is kept in err and its date counts as free.
+type lockedSpans struct {
+	ctx        context.Context
+	app        *App
+	doctorCode string
+	err        error
+}
+
+func (l *lockedSpans) busy(date string) []scheduling.Span {
+	locks, err := l.app.Store.Slots.ListByDoctorDate(l.ctx, l.doctorCode, date)
+	if err != nil {
+		if l.err == nil {
+			l.err = err
+		}
+		return nil
+	}
+	spans := make([]scheduling.Span, 0, len(locks))
+	for _, lock := range locks {
+		if at, err := scheduling.ParseClock(lock.Time); err == nil {
+			spans = append(spans, scheduling.Span{Start: at, End: at + config.SlotStep})
+		}
+	}
+	return spans
+}
+
+// findAlternativeSlots suggests the free slots of the given length closest to the
+// requested one on the same day, then the earliest free slots of the following days
+func findAlternativeSlots(ctx context.Context, app *App, doctor *Doctor, requested AppointmentTime, minutes int) []AppointmentTime {
+	alternatives := []AppointmentTime{}
+
+	day, err := time.Parse("2006-01-02", requested.Date)
+	if err != nil {
+		return alternatives
+	}
+	requestedAt, _ := time.Parse("15:04", requested.Time)
+	now := doctorNow(ctx, app, doctor)
+	availability, err := availabilityFor(ctx, app, doctor, requested.Date, day.AddDate(0, 0, alternativeSearchDays).Format("2006-01-02"))
+	if err != nil {
+		return alternatives
+	}
+
+	for offset := 0; offset <= alternativeSearchDays && len(alternatives) < maxAlternativeSlots; offset++ {
+		date := day.AddDate(0, 0, offset).Format("2006-01-02")
+
+		booked, held, err := bookedSlots(ctx, app, doctor.DoctorCode, date)
+		if err != nil {
+			return alternatives
+		}
+
+		var free []string
+		for _, start := range slotTimes(availability, date, minutes, now) {
+			if slotFree(booked, start, minutes) && slotFree(held, start, minutes) {
+				free = append(free, start)
+			}
+		}
+
+		if offset == 0 {
+			sort.SliceStable(free, func(i, j int) bool {
+				return slotDistance(free[i], requestedAt) < slotDistance(free[j], requestedAt)
+			})
+		}
+
+		for _, start := range free {
+			if len(alternatives) == maxAlternativeSlots {
+				break
+			}
+			alternatives = append(alternatives, AppointmentTime{Date: date, Time: start})
+		}
+	}
+
+	return alternatives
+}
+
+func slotDistance(start string, requestedAt time.Time) time.Duration {
+	startTime, _ := time.Parse("15:04", start)
+	distance := startTime.Sub(requestedAt)
+	if distance < 0 {
+		return -distance
+	}
+	return distance
+}
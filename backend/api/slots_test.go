@@ -0,0 +1,291 @@
+package api
+
+import (
+	"backend/config"
+	"backend/scheduling"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newBookingTestApp(t *testing.T) *App {
+	t.Helper()
+
+	store := NewMemoryStore()
+	ctx := context.Background()
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 1, HospitalName: "Test Hastanesi"})
+	store.Doctors.Insert(ctx, Doctor{
+		DoctorCode:   "doc1",
+		DoctorName:   "Test Doctor",
+		HospitalCode: 1,
+		WorkHours:    WorkHours{Start: "09:00", End: "17:00"},
+	})
+	return &App{Store: store, Config: config.Defaults()}
+}
+
+func TestCreateAppointmentConcurrentSlot(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+
+	const patients = 50
+	for i := 0; i < patients; i++ {
+		app.Store.Users.Insert(ctx, User{UserCode: fmt.Sprintf("patient%d", i), Role: "patient"})
+	}
+
+	date := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, patients)
+	start := make(chan struct{})
+	for i := 0; i < patients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			_, err := CreateAppointment(ctx, app, Appointment{
+				DoctorCode:      "doc1",
+				UserCode:        fmt.Sprintf("patient%d", i),
+				AppointmentTime: AppointmentTime{Date: date, Time: "10:00"},
+			})
+			errs <- err
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	booked := 0
+	for err := range errs {
+		var conflict *SlotConflictError
+		switch {
+		case err == nil:
+			booked++
+		case errors.As(err, &conflict):
+			if len(conflict.Alternatives) == 0 {
+				t.Errorf("conflict should suggest alternative slots")
+			}
+			for _, alternative := range conflict.Alternatives {
+				if alternative.Date == date && alternative.Time == "10:00" {
+					t.Errorf("the taken slot must not be suggested")
+				}
+			}
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if booked != 1 {
+		t.Fatalf("expected exactly one booking, got %d", booked)
+	}
+
+	appointments, _ := app.Store.Appointments.ListByDoctor(ctx, "doc1")
+	if len(appointments) != 1 {
+		t.Fatalf("expected one stored appointment, got %d", len(appointments))
+	}
+}
+
+func TestDeleteAppointmentReleasesSlot(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	date := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	slot := AppointmentTime{Date: date, Time: "11:30"}
+
+	first, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: slot})
+	if err != nil {
+		t.Fatalf("first booking failed: %v", err)
+	}
+
+	if err := DeleteAppointment(ctx, app, first.AppointmentCode, "admin"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: slot}); err != nil {
+		t.Fatalf("slot should be free again after delete: %v", err)
+	}
+}
+
+func TestPastSlotsRejected(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+
+	yesterday := AppointmentTime{Date: time.Now().AddDate(0, 0, -1).Format("2006-01-02"), Time: "10:00"}
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: yesterday}); !errors.Is(err, ErrInvalidSlot) {
+		t.Fatalf("want a booking in the past refused, got %v", err)
+	}
+
+	booked, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: time.Now().AddDate(0, 0, 1).Format("2006-01-02"), Time: "10:00"}})
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	moved := *booked
+	moved.AppointmentTime = yesterday
+	if _, err := UpdateAppointment(ctx, app, moved); !errors.Is(err, ErrInvalidSlot) {
+		t.Fatalf("want a move into the past refused, got %v", err)
+	}
+	if kept, _ := GetAppointment(ctx, app, booked.AppointmentCode); kept.AppointmentTime != booked.AppointmentTime {
+		t.Fatalf("refused move changed the appointment: %+v", kept)
+	}
+}
+
+func TestUpdateFinalAppointment(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	day := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	booked, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: day, Time: "10:00"}})
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	cancelled, err := TransitionAppointment(ctx, app, booked.AppointmentCode, StatusCancelledByPatient, "patient1", "")
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	moved := *cancelled
+	moved.AppointmentTime.Time = "11:00"
+	if _, err := UpdateAppointment(ctx, app, moved); !errors.Is(err, ErrCannotReschedule) {
+		t.Fatalf("want a cancelled appointment kept where it was, got %v", err)
+	}
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: moved.AppointmentTime}); err != nil {
+		t.Fatalf("want the slot left free, got %v", err)
+	}
+}
+
+func TestRescheduleAppointment(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	day := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	var booked []*Appointment
+	for _, at := range []string{"10:00", "10:15", "10:30"} {
+		appointment, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: day, Time: at}})
+		if err != nil {
+			t.Fatalf("booking %s: %v", at, err)
+		}
+		booked = append(booked, appointment)
+	}
+
+	// The active appointment limit is reached, rescheduling must still work
+	moved, err := RescheduleAppointment(ctx, app, booked[0].AppointmentCode, AppointmentTime{Date: day, Time: "11:00"})
+	if err != nil || moved.AppointmentCode != booked[0].AppointmentCode || moved.AppointmentTime.Time != "11:00" {
+		t.Fatalf("reschedule: %+v (%v)", moved, err)
+	}
+
+	var conflict *SlotConflictError
+	if _, err := RescheduleAppointment(ctx, app, booked[1].AppointmentCode, AppointmentTime{Date: day, Time: "11:00"}); !errors.As(err, &conflict) {
+		t.Fatalf("want a slot conflict, got %v", err)
+	}
+	if kept, _ := GetAppointment(ctx, app, booked[1].AppointmentCode); kept.AppointmentTime.Time != "10:15" {
+		t.Fatalf("failed reschedule moved the appointment: %+v", kept)
+	}
+
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: AppointmentTime{Date: day, Time: "10:00"}}); err != nil {
+		t.Fatalf("old slot not freed: %v", err)
+	}
+}
+
+func TestSlotLengthsOverlap(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Config.Scheduling.FieldSlotMinutes = map[int]int{0: 30}
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	date := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	long, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: "10:00"}})
+	if err != nil || long.Duration != 30 {
+		t.Fatalf("want a 30 minute appointment from the field default: %+v (%v)", long, err)
+	}
+
+	// The doctor switches to 10 minute follow-ups on the same day
+	doctor, _ := GetDoctor(ctx, app, "doc1")
+	doctor.SlotMinutes = 10
+	if _, err := UpdateDoctor(ctx, app, *doctor); err != nil {
+		t.Fatalf("update doctor: %v", err)
+	}
+
+	schedule, err := GetDoctorTimeSlots(ctx, app, "doc1", date)
+	if err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	booked := map[string]bool{}
+	for _, slot := range schedule.TimeSlots {
+		booked[slot.StartTime] = slot.IsBooked
+	}
+	if !booked["10:00"] || !booked["10:10"] || !booked["10:20"] || booked["10:30"] {
+		t.Fatalf("10 minute slots must be booked exactly under the 30 minute appointment: %+v", schedule.TimeSlots)
+	}
+
+	var conflict *SlotConflictError
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: AppointmentTime{Date: date, Time: "10:20"}}); !errors.As(err, &conflict) {
+		t.Fatalf("want an overlap conflict, got %v", err)
+	}
+	short, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: AppointmentTime{Date: date, Time: "10:30"}})
+	if err != nil || short.Duration != 10 {
+		t.Fatalf("want a 10 minute appointment right after: %+v (%v)", short, err)
+	}
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: AppointmentTime{Date: date, Time: "11:03"}}); !errors.Is(err, ErrInvalidSlot) {
+		t.Fatalf("want a time off the grid rejected, got %v", err)
+	}
+}
+
+func TestDoctorAvailability(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+
+	// Mornings with a break on the weekday of tomorrow, closed the day after
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	date := tomorrow.Format("2006-01-02")
+	dayAfter := tomorrow.AddDate(0, 0, 1).Format("2006-01-02")
+	availability := &scheduling.Availability{
+		Weekly: []scheduling.WeekdayHours{{
+			Weekday:   tomorrow.Weekday(),
+			Intervals: []scheduling.Interval{{Start: "08:00", End: "10:00"}},
+			Breaks:    []scheduling.Interval{{Start: "08:30", End: "09:00"}},
+		}},
+	}
+	if _, err := SetDoctorAvailability(ctx, app, "doc1", availability, -1); err != nil {
+		t.Fatalf("set availability: %v", err)
+	}
+	bad := &scheduling.Availability{Weekly: []scheduling.WeekdayHours{{Intervals: []scheduling.Interval{{Start: "10:00", End: "09:00"}}}}}
+	if _, err := SetDoctorAvailability(ctx, app, "doc1", bad, -1); !errors.Is(err, scheduling.ErrInvalid) {
+		t.Fatalf("want an invalid availability rejected, got %v", err)
+	}
+
+	slots, err := GetDoctorFreeSlots(ctx, app, "doc1", date, dayAfter)
+	if err != nil {
+		t.Fatalf("free slots: %v", err)
+	}
+	var starts []string
+	for _, slot := range slots {
+		starts = append(starts, slot.Start)
+	}
+	if fmt.Sprint(starts) != "[08:00 08:15 09:00 09:15 09:30 09:45]" {
+		t.Fatalf("want the morning around the break, got %v", slots)
+	}
+
+	for _, at := range []string{"08:30", "10:00", "16:00"} {
+		_, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: at}})
+		if !errors.Is(err, ErrInvalidSlot) {
+			t.Fatalf("want %s outside the hours rejected, got %v", at, err)
+		}
+	}
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: "09:00"}}); err != nil {
+		t.Fatalf("book within the hours: %v", err)
+	}
+	slots, _ = GetDoctorFreeSlots(ctx, app, "doc1", date, date)
+	if len(slots) != 5 {
+		t.Fatalf("want the booked slot gone, got %v", slots)
+	}
+}
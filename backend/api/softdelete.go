@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Tombstone marks a soft deleted user, doctor, hospital or appointment.
+// Tombstoned records are hidden from every read unless a list filter asks
+// for them, and are purged for good once the retention window has passed.
+type Tombstone struct {
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy string     `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+// IsDeleted reports whether the record carries a tombstone
+func (t Tombstone) IsDeleted() bool {
+	return t.DeletedAt != nil
+}
+
+func newTombstone(deletedBy string) Tombstone {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return Tombstone{DeletedAt: &now, DeletedBy: deletedBy}
+}
+
+// PurgeReport counts the records removed by PurgeDeleted
+type PurgeReport struct {
+	Users        int64 `json:"users"`
+	Doctors      int64 `json:"doctors"`
+	Hospitals    int64 `json:"hospitals"`
+	Appointments int64 `json:"appointments"`
+}
+
+// PurgeDeleted hard-deletes every record tombstoned before the given time.
+// The profile of a purged user goes with it.
+func PurgeDeleted(ctx context.Context, app *App, before time.Time) (PurgeReport, error) {
+	var report PurgeReport
+
+	userCodes, err := app.Store.Users.Purge(ctx, before)
+	if err != nil {
+		return report, err
+	}
+	report.Users = int64(len(userCodes))
+	for _, userCode := range userCodes {
+		recordAudit(ctx, app, AuditPurge, TargetUser, userCode, nil, nil)
+		if err := app.Store.UserInfo.Delete(ctx, userCode); err != nil {
+			log.Printf("Error deleting profile of purged user %s: %v", userCode, err)
+		}
+	}
+
+	doctorCodes, err := app.Store.Doctors.Purge(ctx, before)
+	if err != nil {
+		return report, err
+	}
+	report.Doctors = int64(len(doctorCodes))
+	for _, doctorCode := range doctorCodes {
+		recordAudit(ctx, app, AuditPurge, TargetDoctor, doctorCode, nil, nil)
+	}
+
+	hospitalCodes, err := app.Store.Hospitals.Purge(ctx, before)
+	if err != nil {
+		return report, err
+	}
+	report.Hospitals = int64(len(hospitalCodes))
+	for _, hospitalCode := range hospitalCodes {
+		recordAudit(ctx, app, AuditPurge, TargetHospital, strconv.Itoa(hospitalCode), nil, nil)
+	}
+
+	appointmentCodes, err := app.Store.Appointments.Purge(ctx, before)
+	if err != nil {
+		return report, err
+	}
+	report.Appointments = int64(len(appointmentCodes))
+	for _, appointmentCode := range appointmentCodes {
+		recordAudit(ctx, app, AuditPurge, TargetAppointment, appointmentCode, nil, nil)
+	}
+	return report, nil
+}
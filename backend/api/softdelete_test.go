@@ -0,0 +1,114 @@
+package api
+
+import (
+	"backend/config"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeleteHospitalRestoresOnlyItsCascade(t *testing.T) {
+	store := NewMemoryStore()
+	app := &App{Store: store, Config: config.Defaults()}
+	ctx := context.Background()
+
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 1, Fields: []int{1, 2}})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc1", HospitalCode: 1, FieldCode: 1})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc2", HospitalCode: 1, FieldCode: 2})
+
+	// doc2 was removed on its own before the hospital went
+	if _, err := DeleteDoctor(ctx, app, "doc2", "admin1", PolicyBlock); err != nil {
+		t.Fatalf("delete doctor: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := DeleteHospital(ctx, app, 1, "admin1", PolicyBlock); err != nil {
+		t.Fatalf("delete hospital: %v", err)
+	}
+
+	if _, err := GetHospital(ctx, app, 1); err == nil {
+		t.Fatal("deleted hospital is still readable")
+	}
+	if doctors, _ := GetDoctorsByHospitalCode(ctx, app, 1); len(doctors) != 0 {
+		t.Fatalf("deleted doctors are still listed: %v", doctors)
+	}
+	trash, err := ListDoctors(ctx, app, DoctorFilter{Deleted: true}, ListOptions{})
+	if err != nil || trash.Total != 2 {
+		t.Fatalf("want 2 deleted doctors, got %v (%v)", trash, err)
+	}
+	if trash.Items[0].DeletedBy != "admin1" {
+		t.Fatalf("deletedBy not recorded: %+v", trash.Items[0])
+	}
+
+	if err := RestoreHospital(ctx, app, 1); err != nil {
+		t.Fatalf("restore hospital: %v", err)
+	}
+	if _, err := GetDoctor(ctx, app, "doc1"); err != nil {
+		t.Fatalf("doctor deleted with the hospital was not restored: %v", err)
+	}
+	if _, err := GetDoctor(ctx, app, "doc2"); err == nil {
+		t.Fatal("doctor deleted before the hospital was restored with it")
+	}
+	if err := RestoreHospital(ctx, app, 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("restoring a live hospital should fail with ErrNotFound, got %v", err)
+	}
+}
+
+func TestRestoreAppointmentConflict(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Users.Insert(ctx, User{UserCode: "patient2", Role: "patient"})
+
+	slot := AppointmentTime{Date: time.Now().AddDate(0, 0, 1).Format("2006-01-02"), Time: "14:00"}
+	first, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: slot})
+	if err != nil {
+		t.Fatalf("first booking failed: %v", err)
+	}
+	if err := DeleteAppointment(ctx, app, first.AppointmentCode, "patient1"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient2", AppointmentTime: slot}); err != nil {
+		t.Fatalf("second booking failed: %v", err)
+	}
+
+	var conflict *SlotConflictError
+	if err := RestoreAppointment(ctx, app, first.AppointmentCode); !errors.As(err, &conflict) {
+		t.Fatalf("want a slot conflict, got %v", err)
+	}
+	if _, err := GetAppointment(ctx, app, first.AppointmentCode); err == nil {
+		t.Fatal("appointment should stay deleted after a failed restore")
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	store := NewMemoryStore()
+	app := &App{Store: store, Config: config.Defaults()}
+	ctx := context.Background()
+
+	store.Users.Insert(ctx, User{UserCode: "old"})
+	store.Users.Insert(ctx, User{UserCode: "recent"})
+	store.UserInfo.Insert(ctx, UserAdditionalInfo{UserCode: "old"})
+	if _, err := DeleteUser(ctx, app, "old", "admin1", PolicyBlock); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	cutoff := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, err := DeleteUser(ctx, app, "recent", "admin1", PolicyBlock); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+
+	report, err := PurgeDeleted(ctx, app, cutoff)
+	if err != nil || report.Users != 1 {
+		t.Fatalf("want 1 purged user, got %+v (%v)", report, err)
+	}
+	if _, err := store.UserInfo.Get(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("profile of the purged user was kept: %v", err)
+	}
+	if err := RestoreUser(ctx, app, "old"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("purged user could be restored: %v", err)
+	}
+	if err := RestoreUser(ctx, app, "recent"); err != nil {
+		t.Fatalf("user inside the retention window could not be restored: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// AppointmentStatus is a step in the lifecycle of an appointment
+type AppointmentStatus string
+
+const (
+	StatusBooked              AppointmentStatus = "booked"
+	StatusConfirmed           AppointmentStatus = "confirmed"
+	StatusCheckedIn           AppointmentStatus = "checked-in"
+	StatusInProgress          AppointmentStatus = "in-progress"
+	StatusCompleted           AppointmentStatus = "completed"
+	StatusNoShow              AppointmentStatus = "no-show"
+	StatusCancelledByPatient  AppointmentStatus = "cancelled-by-patient"
+	StatusCancelledByHospital AppointmentStatus = "cancelled-by-hospital"
+)
+
+// statusTransitions lists the statuses each status may move to. Final statuses
+// have no way out.
+var statusTransitions = map[AppointmentStatus][]AppointmentStatus{
+	StatusBooked:     {StatusConfirmed, StatusCheckedIn, StatusNoShow, StatusCancelledByPatient, StatusCancelledByHospital},
+	StatusConfirmed:  {StatusCheckedIn, StatusNoShow, StatusCancelledByPatient, StatusCancelledByHospital},
+	StatusCheckedIn:  {StatusInProgress, StatusCancelledByHospital},
+	StatusInProgress: {StatusCompleted},
+}
+
+// ErrInvalidTransition is returned when an appointment cannot move to the requested status
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// StatusChange is one entry of the status history of an appointment
+type StatusChange struct {
+	From AppointmentStatus `bson:"from,omitempty" json:"from,omitempty"`
+	To   AppointmentStatus `bson:"to" json:"to"`
+	At   time.Time         `bson:"at" json:"at"`
+	By   string            `bson:"by,omitempty" json:"by,omitempty"`
+	Note string            `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// ParseAppointmentStatus checks that name is a known status
+func ParseAppointmentStatus(name string) (AppointmentStatus, error) {
+	status := AppointmentStatus(name)
+	if _, ok := statusTransitions[status]; ok || status.IsFinal() {
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: unknown status %q", ErrInvalidTransition, name)
+}
+
+// IsFinal reports whether the appointment is over or cancelled
+func (s AppointmentStatus) IsFinal() bool {
+	switch s {
+	case StatusCompleted, StatusNoShow, StatusCancelledByPatient, StatusCancelledByHospital:
+		return true
+	}
+	return false
+}
+
+// IsCancelled reports whether the appointment was cancelled by either side
+func (s AppointmentStatus) IsCancelled() bool {
+	return s == StatusCancelledByPatient || s == StatusCancelledByHospital
+}
+
+// CanMoveTo reports whether the lifecycle allows going from s to next
+func (s AppointmentStatus) CanMoveTo(next AppointmentStatus) bool {
+	return slices.Contains(statusTransitions[s], next)
+}
+
+// StatusAllowedForRole reports whether a user of role may set the status. Patients
+// may only cancel, doctors and admins run the visit and may also cancel for the patient.
+func StatusAllowedForRole(role string, status AppointmentStatus) bool {
+	switch role {
+	case "admin", "doctor":
+		return true
+	default:
+		return status == StatusCancelledByPatient
+	}
+}
+
+// CurrentStatus is the status of the appointment, appointments stored before the
+// lifecycle existed count as booked
+func (a Appointment) CurrentStatus() AppointmentStatus {
+	if a.Status == "" {
+		return StatusBooked
+	}
+	return a.Status
+}
+
+// transitionRetries bounds how often a transition is retried after a concurrent write
+const transitionRetries = 3
+
+// TransitionAppointment moves the appointment to status and records the change in
+// its history. Cancelling frees the slot and notifies the patient, a patient
+// cancellation inside the cancellation window is marked CancelledLate. Check-ins and
+// no-shows count towards the attendance of the patient, no-shows only once the
+// appointment has started. A concurrent write makes it check the transition again on
+// the fresh appointment.
+func TransitionAppointment(ctx context.Context, app *App, appointmentCode string, status AppointmentStatus, changedBy, note string) (*Appointment, error) {
+	return transitionAppointment(ctx, app, appointmentCode, status, changedBy, note, true)
+}
+
+// transitionAppointment is TransitionAppointment, notify false leaves the
+// cancellation email to the caller
+func transitionAppointment(ctx context.Context, app *App, appointmentCode string, status AppointmentStatus, changedBy, note string, notify bool) (*Appointment, error) {
+	for attempt := 1; ; attempt++ {
+		appointment, err := app.Store.Appointments.Get(ctx, appointmentCode)
+		if err != nil {
+			return nil, err
+		}
+		from := appointment.CurrentStatus()
+		if !from.CanMoveTo(status) {
+			return nil, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, status)
+		}
+		if status == StatusNoShow && isFuture(app, *appointment, time.Now()) {
+			return nil, fmt.Errorf("%w: the appointment has not started yet", ErrInvalidTransition)
+		}
+
+		updated := *appointment
+		updated.Status = status
+		updated.StatusHistory = append(slices.Clone(appointment.StatusHistory), StatusChange{
+			From: from,
+			To:   status,
+			At:   time.Now().UTC().Truncate(time.Millisecond),
+			By:   changedBy,
+			Note: note,
+		})
+		if status == StatusCancelledByPatient {
+			late, _, err := cancelsLate(ctx, app, *appointment, time.Now())
+			if err != nil {
+				log.Println("Error checking the cancellation window:", err)
+			}
+			updated.CancelledLate = late
+		}
+		updated.UpdatedAt = time.Now()
+		updated.Version = appointment.Version + 1
+
+		err = app.Store.Appointments.Replace(ctx, updated, appointment.Version)
+		if errors.Is(err, ErrVersionConflict) && attempt < transitionRetries {
+			continue
+		}
+		if err != nil {
+			log.Println("Error updating appointment status:", err)
+			return nil, err
+		}
+
+		recordAudit(ctx, app, AuditUpdate, TargetAppointment, appointmentCode, appointment, updated)
+		if status.IsCancelled() {
+			removeCalendarEvent(ctx, app, &updated)
+			releaseSlot(ctx, app, &updated)
+			if notify {
+				notifyCancellation(ctx, app, &updated)
+			}
+		}
+		if status == StatusCheckedIn || status == StatusNoShow {
+			recordAttendance(ctx, app, updated, status)
+		}
+		return &updated, nil
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransitionAppointment(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+
+	slot := AppointmentTime{Date: time.Now().AddDate(0, 0, 1).Format("2006-01-02"), Time: "10:00"}
+	appointment, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: slot})
+	if err != nil || appointment.Status != StatusBooked {
+		t.Fatalf("new appointment not booked: %+v (%v)", appointment, err)
+	}
+
+	if _, err := TransitionAppointment(ctx, app, appointment.AppointmentCode, StatusCompleted, "doc1", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("want booked to completed rejected, got %v", err)
+	}
+
+	updated, err := TransitionAppointment(ctx, app, appointment.AppointmentCode, StatusCancelledByPatient, "patient1", "cannot make it")
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	last := updated.StatusHistory[len(updated.StatusHistory)-1]
+	if len(updated.StatusHistory) != 2 || last.From != StatusBooked || last.By != "patient1" || last.Note != "cannot make it" {
+		t.Fatalf("cancellation not in the history: %+v", updated.StatusHistory)
+	}
+	if _, err := TransitionAppointment(ctx, app, appointment.AppointmentCode, StatusConfirmed, "doc1", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("want a cancelled appointment to stay cancelled, got %v", err)
+	}
+
+	// The cancelled slot can be booked again
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: slot}); err != nil {
+		t.Fatalf("slot not freed by the cancellation: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by the stores when no document matches the lookup
+var ErrNotFound = errors.New("not found")
+
+// ErrVersionConflict is returned by the Replace methods when the record has been
+// changed since the caller read it
+var ErrVersionConflict = errors.New("record was changed by someone else")
+
+// ErrSlotTaken is returned by SlotStore.Claim when another appointment already holds the slot
+var ErrSlotTaken = errors.New("slot already taken")
+
+// Store groups the per-aggregate stores used by the api functions.
+// NewMongoStore backs it with MongoDB, NewMemoryStore keeps everything in memory
+// so booking rules and handlers can be exercised without a live cluster.
+type Store struct {
+	Users        UserStore
+	UserInfo     UserInfoStore
+	Doctors      DoctorStore
+	Hospitals    HospitalStore
+	Appointments AppointmentStore
+	Requests     RequestStore
+	Locations    LocationStore
+	Slots        SlotStore
+	Audit        AuditStore
+	Waitlist     WaitlistStore
+	Blackouts    BlackoutStore
+	Policy       PolicyStore
+	Attendance   AttendanceStore
+	Holds        HoldStore
+	Encounters   EncounterStore
+
+	ping func(ctx context.Context) error
+}
+
+// Ping checks that the underlying database is reachable
+func (s *Store) Ping(ctx context.Context) error {
+	if s.ping == nil {
+		return nil
+	}
+	return s.ping(ctx)
+}
+
+// UserStore persists users and admin users (both live in the users collection)
+type UserStore interface {
+	GetByCode(ctx context.Context, userCode string) (*User, error)
+	// Lookup reads a user even when it is soft deleted, for showing past records
+	Lookup(ctx context.Context, userCode string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Insert(ctx context.Context, user User) error
+	// SoftDelete tombstones a live user, ErrNotFound when there is none
+	SoftDelete(ctx context.Context, userCode string, tombstone Tombstone) error
+	// Restore lifts the tombstone of a deleted user and returns the user as it was deleted
+	Restore(ctx context.Context, userCode string) (*User, error)
+	// Purge removes the users deleted before the given time and returns their codes
+	Purge(ctx context.Context, before time.Time) ([]string, error)
+	List(ctx context.Context) ([]User, error)
+	Find(ctx context.Context, filter UserFilter, query listQuery[User]) ([]User, int64, error)
+	CountByRole(ctx context.Context, role string) (int64, error)
+	UpdatePassword(ctx context.Context, userCode, passwordHash string) error
+
+	InsertAdmin(ctx context.Context, admin AdminUser) error
+	ListAdmins(ctx context.Context) ([]AdminUser, error)
+	// UpdateAdmin overwrites email and names, and the password when admin.Password is not empty
+	UpdateAdmin(ctx context.Context, userCode string, admin AdminUser) error
+	DeleteAdmin(ctx context.Context, userCode string) error
+}
+
+// UserInfoStore persists the additional profile information of users
+type UserInfoStore interface {
+	Get(ctx context.Context, userCode string) (*UserAdditionalInfo, error)
+	Insert(ctx context.Context, info UserAdditionalInfo) error
+	// Update overwrites the profile fields of an existing record
+	Update(ctx context.Context, info UserAdditionalInfo) error
+	Delete(ctx context.Context, userCode string) error
+}
+
+// DoctorStore persists doctors. Like users, hospitals and appointments they are
+// soft deleted, every read except ListDeletedByHospital skips tombstoned ones.
+type DoctorStore interface {
+	Get(ctx context.Context, doctorCode string) (*Doctor, error)
+	// Lookup reads a doctor even when it is soft deleted, for showing past appointments
+	Lookup(ctx context.Context, doctorCode string) (*Doctor, error)
+	// GetByUser returns the live doctor that signs in as the user
+	GetByUser(ctx context.Context, userCode string) (*Doctor, error)
+	Insert(ctx context.Context, doctor Doctor) error
+	InsertMany(ctx context.Context, doctors []Doctor) error
+	// Replace overwrites the live doctor if it is still at version, the new
+	// state carries the next version. ErrVersionConflict when it has moved on.
+	Replace(ctx context.Context, doctor Doctor, version int64) error
+	SoftDelete(ctx context.Context, doctorCode string, tombstone Tombstone) error
+	Restore(ctx context.Context, doctorCode string) (*Doctor, error)
+	Purge(ctx context.Context, before time.Time) ([]string, error)
+	List(ctx context.Context) ([]Doctor, error)
+	Find(ctx context.Context, filter DoctorFilter, query listQuery[Doctor]) ([]Doctor, int64, error)
+	ListByHospital(ctx context.Context, hospitalCode int) ([]Doctor, error)
+	// ListDeletedByHospital lists the tombstoned doctors of a hospital
+	ListDeletedByHospital(ctx context.Context, hospitalCode int) ([]Doctor, error)
+	Count(ctx context.Context) (int64, error)
+}
+
+// HospitalStore persists hospitals
+type HospitalStore interface {
+	Get(ctx context.Context, hospitalCode int) (*Hospital, error)
+	// Lookup reads a hospital even when it is soft deleted, for showing past appointments
+	Lookup(ctx context.Context, hospitalCode int) (*Hospital, error)
+	Insert(ctx context.Context, hospital Hospital) error
+	// Replace overwrites the live hospital if it is still at version, see DoctorStore.Replace
+	Replace(ctx context.Context, hospital Hospital, version int64) error
+	// AddField and RemoveField change the fields of a live hospital in place,
+	// bumping its version when the field was missing or present respectively
+	AddField(ctx context.Context, hospitalCode, fieldCode int) error
+	RemoveField(ctx context.Context, hospitalCode, fieldCode int) error
+	SoftDelete(ctx context.Context, hospitalCode int, tombstone Tombstone) error
+	Restore(ctx context.Context, hospitalCode int) (*Hospital, error)
+	Purge(ctx context.Context, before time.Time) ([]int, error)
+	List(ctx context.Context) ([]Hospital, error)
+	Find(ctx context.Context, filter HospitalFilter, query listQuery[Hospital]) ([]Hospital, int64, error)
+	ListByProvince(ctx context.Context, provinceCode int) ([]Hospital, error)
+	ListByDistrict(ctx context.Context, districtCode int) ([]Hospital, error)
+	Count(ctx context.Context) (int64, error)
+}
+
+// AppointmentStore persists appointments
+type AppointmentStore interface {
+	Get(ctx context.Context, appointmentCode string) (*Appointment, error)
+	Insert(ctx context.Context, appointment Appointment) error
+	// Replace overwrites the live appointment if it is still at version, see DoctorStore.Replace
+	Replace(ctx context.Context, appointment Appointment, version int64) error
+	SoftDelete(ctx context.Context, appointmentCode string, tombstone Tombstone) error
+	Restore(ctx context.Context, appointmentCode string) (*Appointment, error)
+	Purge(ctx context.Context, before time.Time) ([]string, error)
+	List(ctx context.Context) ([]Appointment, error)
+	Find(ctx context.Context, filter AppointmentFilter, query listQuery[Appointment]) ([]Appointment, int64, error)
+	ListByDoctor(ctx context.Context, doctorCode string) ([]Appointment, error)
+	ListByUser(ctx context.Context, userCode string) ([]Appointment, error)
+	// ListBySeries returns the live occurrences of a recurring series
+	ListBySeries(ctx context.Context, seriesCode string) ([]Appointment, error)
+	// ListBetween returns the live appointments from the first to the last date
+	ListBetween(ctx context.Context, from, to string) ([]Appointment, error)
+	Count(ctx context.Context) (int64, error)
+	CountByDate(ctx context.Context, date string) (int64, error)
+}
+
+// RequestStore persists appointment cancel requests
+type RequestStore interface {
+	Insert(ctx context.Context, request AppointmentDeleteRequest) error
+	Get(ctx context.Context, requestCode string) (*AppointmentDeleteRequest, error)
+	List(ctx context.Context) ([]AppointmentDeleteRequest, error)
+	Find(ctx context.Context, filter RequestFilter, query listQuery[AppointmentDeleteRequest]) ([]AppointmentDeleteRequest, int64, error)
+	ListByDoctor(ctx context.Context, doctorCode string) ([]AppointmentDeleteRequest, error)
+	// UpdateStatus sets the status of the request if it still has from, ErrVersionConflict otherwise
+	UpdateStatus(ctx context.Context, requestCode, from, status string) error
+	Delete(ctx context.Context, requestCode string) error
+	CountByStatus(ctx context.Context, status string) (int64, error)
+}
+
+// SlotStore guarantees that a piece of a doctor's day is held by at most one appointment.
+// Claim must be atomic, of several concurrent claims on overlapping locks at most one
+// succeeds, and a failed claim holds none of its locks.
+type SlotStore interface {
+	// Claim takes all of locks or none, ErrSlotTaken when any of them is held
+	Claim(ctx context.Context, locks []SlotLock) error
+	Release(ctx context.Context, locks []SlotLock) error
+	// Transfer hands every lock heldBy holds on the doctor and date of lock over to
+	// lock.AppointmentCode, ErrSlotTaken when heldBy holds none
+	Transfer(ctx context.Context, lock SlotLock, heldBy string) error
+	ListByDoctorDate(ctx context.Context, doctorCode, date string) ([]SlotLock, error)
+}
+
+// WaitlistStore persists the waitlist. Replace only applies while the entry still has
+// the expected status, so an offer is made, accepted or expired exactly once.
+type WaitlistStore interface {
+	Insert(ctx context.Context, entry WaitlistEntry) error
+	Get(ctx context.Context, entryCode string) (*WaitlistEntry, error)
+	ListByUser(ctx context.Context, userCode string) ([]WaitlistEntry, error)
+	// ListWaiting returns the waiting entries a slot of the doctor on date can serve, oldest first
+	ListWaiting(ctx context.Context, doctor Doctor, date string) ([]WaitlistEntry, error)
+	// ListExpiredOffers returns the offered entries whose offer ran out before the given time
+	ListExpiredOffers(ctx context.Context, before time.Time) ([]WaitlistEntry, error)
+	// Replace stores entry if the stored one has status, ErrVersionConflict otherwise
+	Replace(ctx context.Context, entry WaitlistEntry, status WaitlistStatus) error
+	// ExpireWaiting ends the waiting entries whose date range ended before date
+	ExpireWaiting(ctx context.Context, date string) (int64, error)
+}
+
+// BlackoutStore persists doctor leave, hospital closures and public holidays
+type BlackoutStore interface {
+	Insert(ctx context.Context, blackout Blackout) error
+	Get(ctx context.Context, blackoutCode string) (*Blackout, error)
+	// Delete removes the blackout, ErrNotFound when there is none
+	Delete(ctx context.Context, blackoutCode string) error
+	List(ctx context.Context, filter BlackoutFilter) ([]Blackout, error)
+	// ListFor returns the blackouts applying to the doctor that overlap the dates
+	ListFor(ctx context.Context, doctor Doctor, from, to string) ([]Blackout, error)
+	// ReplaceHolidays swaps the holidays of year for the given ones and returns the old ones
+	ReplaceHolidays(ctx context.Context, year int, holidays []Blackout) ([]Blackout, error)
+}
+
+// PolicyStore keeps the single booking policy
+type PolicyStore interface {
+	// Get returns the policy, ErrNotFound while none was saved
+	Get(ctx context.Context) (*BookingPolicy, error)
+	// Replace stores policy if the stored one is at version, zero when none was saved
+	Replace(ctx context.Context, policy BookingPolicy, version int64) error
+}
+
+// AttendanceStore keeps one attendance record per patient
+type AttendanceStore interface {
+	// Get returns the attendance of the patient, ErrNotFound while none was recorded
+	Get(ctx context.Context, userCode string) (*Attendance, error)
+	// Replace stores attendance if the stored one is at version, zero when none was recorded
+	Replace(ctx context.Context, attendance Attendance, version int64) error
+}
+
+// HoldStore persists the slot holds taken during the booking flow
+type HoldStore interface {
+	Insert(ctx context.Context, hold SlotHold) error
+	Get(ctx context.Context, holdCode string) (*SlotHold, error)
+	ListByUser(ctx context.Context, userCode string) ([]SlotHold, error)
+	// ListExpired returns the holds that ran out before the given time
+	ListExpired(ctx context.Context, before time.Time) ([]SlotHold, error)
+	// Delete removes the hold, ErrNotFound when it is already gone, so only one of a
+	// booking and the sweeper can take it
+	Delete(ctx context.Context, holdCode string) error
+}
+
+// EncounterStore persists the encounter notes, at most one per appointment
+type EncounterStore interface {
+	// Get returns the note of the appointment, ErrNotFound while none was written
+	Get(ctx context.Context, appointmentCode string) (*EncounterNote, error)
+	// ListByAppointments returns the notes of those of the appointments that have one
+	ListByAppointments(ctx context.Context, appointmentCodes []string) ([]EncounterNote, error)
+	// Replace stores note if the stored one is at version, zero when none was written
+	Replace(ctx context.Context, note EncounterNote, version int64) error
+}
+
+// LocationStore reads the province and district reference data
+type LocationStore interface {
+	ListProvinces(ctx context.Context) ([]Province, error)
+	ListDistrictsByProvince(ctx context.Context, provinceCode int) ([]District, error)
+}
+
+// AuditStore keeps the audit log. It is append-only on purpose, there is
+// no method to change or remove an entry.
+type AuditStore interface {
+	Insert(ctx context.Context, entry AuditEntry) error
+	Find(ctx context.Context, filter AuditFilter, query listQuery[AuditEntry]) ([]AuditEntry, int64, error)
+}
@@ -0,0 +1,1048 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryStore returns a Store that keeps every aggregate in process memory.
+// It is meant for tests and local experiments, nothing is persisted.
+func NewMemoryStore() *Store {
+	return &Store{
+		Users:        &memoryUserStore{},
+		UserInfo:     &memoryUserInfoStore{},
+		Doctors:      &memoryDoctorStore{},
+		Hospitals:    &memoryHospitalStore{table: memoryTable[Hospital]{clone: cloneHospital}},
+		Appointments: &memoryAppointmentStore{},
+		Requests:     &memoryRequestStore{},
+		Locations:    &MemoryLocationStore{},
+		Slots:        &memorySlotStore{},
+		Audit:        &memoryAuditStore{table: memoryTable[AuditEntry]{clone: cloneAuditEntry}},
+		Waitlist:     &memoryWaitlistStore{table: memoryTable[WaitlistEntry]{clone: cloneWaitlistEntry}},
+		Blackouts:    &memoryBlackoutStore{},
+		Policy:       &memoryPolicyStore{},
+		Attendance:   &memoryAttendanceStore{table: memoryTable[Attendance]{clone: cloneAttendance}},
+		Holds:        &memoryHoldStore{},
+		Encounters:   &memoryEncounterStore{table: memoryTable[EncounterNote]{clone: cloneEncounterNote}},
+	}
+}
+
+// memoryTable is a mutex guarded slice that keeps insertion order like a collection scan would
+type memoryTable[T any] struct {
+	mu    sync.RWMutex
+	items []T
+	clone func(T) T
+}
+
+func (t *memoryTable[T]) copy(item T) T {
+	if t.clone != nil {
+		return t.clone(item)
+	}
+	return item
+}
+
+func (t *memoryTable[T]) find(match func(T) bool) (*T, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, item := range t.items {
+		if match(item) {
+			found := t.copy(item)
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (t *memoryTable[T]) filter(match func(T) bool) []T {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var items []T
+	for _, item := range t.items {
+		if match == nil || match(item) {
+			items = append(items, t.copy(item))
+		}
+	}
+	return items
+}
+
+func (t *memoryTable[T]) count(match func(T) bool) int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var n int64
+	for _, item := range t.items {
+		if match == nil || match(item) {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *memoryTable[T]) insert(items ...T) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, item := range items {
+		t.items = append(t.items, t.copy(item))
+	}
+}
+
+// insertUnless adds items only if no existing item conflicts with them, checked under the same lock
+func (t *memoryTable[T]) insertUnless(conflict func(T) bool, items ...T) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if slices.ContainsFunc(t.items, conflict) {
+		return false
+	}
+	for _, item := range items {
+		t.items = append(t.items, t.copy(item))
+	}
+	return true
+}
+
+// page returns the matching items ordered and cut by query, along with the number of matches
+func page[T any](items []T, query listQuery[T]) ([]T, int64) {
+	slices.SortStableFunc(items, query.compare)
+
+	total := int64(len(items))
+	start := min(query.offset, len(items))
+	end := min(start+query.limit, len(items))
+	return items[start:end], total
+}
+
+// update applies fn to the first matching item and reports whether there was one
+func (t *memoryTable[T]) update(match func(T) bool, fn func(*T)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.items {
+		if match(t.items[i]) {
+			fn(&t.items[i])
+			t.items[i] = t.copy(t.items[i])
+			return true
+		}
+	}
+	return false
+}
+
+// remove deletes the first matching item
+func (t *memoryTable[T]) remove(match func(T) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, item := range t.items {
+		if match(item) {
+			t.items = slices.Delete(t.items, i, i+1)
+			return
+		}
+	}
+}
+
+// removeAll deletes every matching item and returns them
+func (t *memoryTable[T]) removeAll(match func(T) bool) []T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var removed []T
+	t.items = slices.DeleteFunc(t.items, func(item T) bool {
+		if match(item) {
+			removed = append(removed, item)
+			return true
+		}
+		return false
+	})
+	return removed
+}
+
+type tombstoned interface {
+	IsDeleted() bool
+}
+
+// alive narrows match to records without a tombstone, a nil match selects all of them
+func alive[T tombstoned](match func(T) bool) func(T) bool {
+	return func(item T) bool {
+		return !item.IsDeleted() && (match == nil || match(item))
+	}
+}
+
+// inTrash selects the live records, or only the deleted ones when deleted is set
+func inTrash[T tombstoned](item T, deleted bool) bool {
+	return item.IsDeleted() == deleted
+}
+
+// replaceVersion overwrites the live record matching match with item, provided
+// the stored record is still at version
+func replaceVersion[T tombstoned](t *memoryTable[T], match func(T) bool, versionOf func(T) int64, version int64, item T) error {
+	conflict := false
+	found := t.update(alive(match), func(current *T) {
+		if versionOf(*current) != version {
+			conflict = true
+			return
+		}
+		*current = item
+	})
+	if !found {
+		return ErrNotFound
+	}
+	if conflict {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// deletedBefore selects the records tombstoned before the given time
+func deletedBefore(tombstone Tombstone, before time.Time) bool {
+	return tombstone.DeletedAt != nil && tombstone.DeletedAt.Before(before)
+}
+
+func cloneHospital(hospital Hospital) Hospital {
+	hospital.Fields = slices.Clone(hospital.Fields)
+	return hospital
+}
+
+func cloneAuditEntry(entry AuditEntry) AuditEntry {
+	entry.Changes = slices.Clone(entry.Changes)
+	return entry
+}
+
+// memoryUserStore keeps users as AdminUser records since both share the users collection
+type memoryUserStore struct {
+	table memoryTable[AdminUser]
+}
+
+func adminToUser(admin AdminUser) User {
+	return User{
+		ID:        admin.ID,
+		UserCode:  admin.UserCode,
+		Email:     admin.Email,
+		Password:  admin.Password,
+		Role:      admin.Role,
+		CreatedAt: admin.CreatedAt,
+		UpdatedAt: admin.UpdatedAt,
+		Tombstone: admin.Tombstone,
+	}
+}
+
+func (s *memoryUserStore) get(match func(AdminUser) bool) (*User, error) {
+	admin, err := s.table.find(alive(match))
+	if err != nil {
+		return nil, err
+	}
+	user := adminToUser(*admin)
+	return &user, nil
+}
+
+func (s *memoryUserStore) GetByCode(ctx context.Context, userCode string) (*User, error) {
+	return s.get(func(a AdminUser) bool { return a.UserCode == userCode })
+}
+
+func (s *memoryUserStore) Lookup(ctx context.Context, userCode string) (*User, error) {
+	admin, err := s.table.find(func(a AdminUser) bool { return a.UserCode == userCode })
+	if err != nil {
+		return nil, err
+	}
+	user := adminToUser(*admin)
+	return &user, nil
+}
+
+func (s *memoryUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return s.get(func(a AdminUser) bool { return a.Email == email })
+}
+
+func (s *memoryUserStore) Insert(ctx context.Context, user User) error {
+	s.table.insert(AdminUser{
+		ID:        user.ID,
+		UserCode:  user.UserCode,
+		Email:     user.Email,
+		Password:  user.Password,
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Tombstone: user.Tombstone,
+	})
+	return nil
+}
+
+func (s *memoryUserStore) SoftDelete(ctx context.Context, userCode string, tombstone Tombstone) error {
+	match := alive(func(a AdminUser) bool { return a.UserCode == userCode })
+	if !s.table.update(match, func(a *AdminUser) { a.Tombstone = tombstone }) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryUserStore) Restore(ctx context.Context, userCode string) (*User, error) {
+	var restored User
+	match := func(a AdminUser) bool { return a.UserCode == userCode && a.IsDeleted() }
+	if !s.table.update(match, func(a *AdminUser) {
+		restored = adminToUser(*a)
+		a.Tombstone = Tombstone{}
+	}) {
+		return nil, ErrNotFound
+	}
+	return &restored, nil
+}
+
+func (s *memoryUserStore) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	var userCodes []string
+	for _, admin := range s.table.removeAll(func(a AdminUser) bool { return deletedBefore(a.Tombstone, before) }) {
+		userCodes = append(userCodes, admin.UserCode)
+	}
+	return userCodes, nil
+}
+
+func (s *memoryUserStore) List(ctx context.Context) ([]User, error) {
+	var users []User
+	for _, admin := range s.table.filter(alive[AdminUser](nil)) {
+		users = append(users, adminToUser(admin))
+	}
+	return users, nil
+}
+
+func (s *memoryUserStore) Find(ctx context.Context, f UserFilter, query listQuery[User]) ([]User, int64, error) {
+	var users []User
+	for _, admin := range s.table.filter(nil) {
+		if inTrash(admin, f.Deleted) && (f.Role == "" || admin.Role == f.Role) {
+			users = append(users, adminToUser(admin))
+		}
+	}
+	items, total := page(users, query)
+	return items, total, nil
+}
+
+func (s *memoryUserStore) CountByRole(ctx context.Context, role string) (int64, error) {
+	return s.table.count(alive(func(a AdminUser) bool { return a.Role == role })), nil
+}
+
+func (s *memoryUserStore) UpdatePassword(ctx context.Context, userCode, passwordHash string) error {
+	s.table.update(func(a AdminUser) bool { return a.UserCode == userCode }, func(a *AdminUser) {
+		a.Password = passwordHash
+		a.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+func (s *memoryUserStore) InsertAdmin(ctx context.Context, admin AdminUser) error {
+	s.table.insert(admin)
+	return nil
+}
+
+func (s *memoryUserStore) ListAdmins(ctx context.Context) ([]AdminUser, error) {
+	return s.table.filter(alive(func(a AdminUser) bool { return a.Role == "admin" })), nil
+}
+
+func (s *memoryUserStore) UpdateAdmin(ctx context.Context, userCode string, admin AdminUser) error {
+	s.table.update(func(a AdminUser) bool { return a.UserCode == userCode }, func(a *AdminUser) {
+		a.Email = admin.Email
+		a.FirstName = admin.FirstName
+		a.LastName = admin.LastName
+		if admin.Password != "" {
+			a.Password = admin.Password
+		}
+		a.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+func (s *memoryUserStore) DeleteAdmin(ctx context.Context, userCode string) error {
+	s.table.remove(func(a AdminUser) bool { return a.UserCode == userCode && a.Role == "admin" })
+	return nil
+}
+
+type memoryUserInfoStore struct {
+	table memoryTable[UserAdditionalInfo]
+}
+
+func (s *memoryUserInfoStore) Get(ctx context.Context, userCode string) (*UserAdditionalInfo, error) {
+	return s.table.find(func(i UserAdditionalInfo) bool { return i.UserCode == userCode })
+}
+
+func (s *memoryUserInfoStore) Insert(ctx context.Context, info UserAdditionalInfo) error {
+	s.table.insert(info)
+	return nil
+}
+
+func (s *memoryUserInfoStore) Update(ctx context.Context, info UserAdditionalInfo) error {
+	s.table.update(func(i UserAdditionalInfo) bool { return i.UserCode == info.UserCode }, func(i *UserAdditionalInfo) {
+		info.ID = i.ID
+		info.CreatedAt = i.CreatedAt
+		*i = info
+	})
+	return nil
+}
+
+func (s *memoryUserInfoStore) Delete(ctx context.Context, userCode string) error {
+	s.table.remove(func(i UserAdditionalInfo) bool { return i.UserCode == userCode })
+	return nil
+}
+
+type memoryDoctorStore struct {
+	table memoryTable[Doctor]
+}
+
+func (s *memoryDoctorStore) Get(ctx context.Context, doctorCode string) (*Doctor, error) {
+	return s.table.find(alive(func(d Doctor) bool { return d.DoctorCode == doctorCode }))
+}
+
+func (s *memoryDoctorStore) Lookup(ctx context.Context, doctorCode string) (*Doctor, error) {
+	return s.table.find(func(d Doctor) bool { return d.DoctorCode == doctorCode })
+}
+
+func (s *memoryDoctorStore) GetByUser(ctx context.Context, userCode string) (*Doctor, error) {
+	return s.table.find(alive(func(d Doctor) bool { return userCode != "" && d.UserCode == userCode }))
+}
+
+// sharesAccount reports whether other is another doctor with the user account of
+// doctor, as the unique userCode index of the doctors collection does
+func sharesAccount(doctor Doctor) func(Doctor) bool {
+	return func(other Doctor) bool {
+		return doctor.UserCode != "" && other.UserCode == doctor.UserCode && other.DoctorCode != doctor.DoctorCode
+	}
+}
+
+func (s *memoryDoctorStore) Insert(ctx context.Context, doctor Doctor) error {
+	if !s.table.insertUnless(sharesAccount(doctor), doctor) {
+		return ErrDoctorAccountTaken
+	}
+	return nil
+}
+
+func (s *memoryDoctorStore) InsertMany(ctx context.Context, doctors []Doctor) error {
+	for _, doctor := range doctors {
+		if err := s.Insert(ctx, doctor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryDoctorStore) Replace(ctx context.Context, doctor Doctor, version int64) error {
+	if _, err := s.table.find(sharesAccount(doctor)); err == nil {
+		return ErrDoctorAccountTaken
+	}
+	match := func(d Doctor) bool { return d.DoctorCode == doctor.DoctorCode }
+	return replaceVersion(&s.table, match, func(d Doctor) int64 { return d.Version }, version, doctor)
+}
+
+func (s *memoryDoctorStore) SoftDelete(ctx context.Context, doctorCode string, tombstone Tombstone) error {
+	match := alive(func(d Doctor) bool { return d.DoctorCode == doctorCode })
+	if !s.table.update(match, func(d *Doctor) { d.Tombstone = tombstone }) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryDoctorStore) Restore(ctx context.Context, doctorCode string) (*Doctor, error) {
+	var restored Doctor
+	match := func(d Doctor) bool { return d.DoctorCode == doctorCode && d.IsDeleted() }
+	if !s.table.update(match, func(d *Doctor) {
+		restored = *d
+		d.Tombstone = Tombstone{}
+	}) {
+		return nil, ErrNotFound
+	}
+	return &restored, nil
+}
+
+func (s *memoryDoctorStore) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	var doctorCodes []string
+	for _, doctor := range s.table.removeAll(func(d Doctor) bool { return deletedBefore(d.Tombstone, before) }) {
+		doctorCodes = append(doctorCodes, doctor.DoctorCode)
+	}
+	return doctorCodes, nil
+}
+
+func (s *memoryDoctorStore) List(ctx context.Context) ([]Doctor, error) {
+	return s.table.filter(alive[Doctor](nil)), nil
+}
+
+func (s *memoryDoctorStore) Find(ctx context.Context, f DoctorFilter, query listQuery[Doctor]) ([]Doctor, int64, error) {
+	doctors := s.table.filter(func(d Doctor) bool {
+		return inTrash(d, f.Deleted) &&
+			(f.HospitalCode == nil || d.HospitalCode == *f.HospitalCode) &&
+			(f.FieldCode == nil || d.FieldCode == *f.FieldCode)
+	})
+	items, total := page(doctors, query)
+	return items, total, nil
+}
+
+func (s *memoryDoctorStore) ListByHospital(ctx context.Context, hospitalCode int) ([]Doctor, error) {
+	return s.table.filter(alive(func(d Doctor) bool { return d.HospitalCode == hospitalCode })), nil
+}
+
+func (s *memoryDoctorStore) ListDeletedByHospital(ctx context.Context, hospitalCode int) ([]Doctor, error) {
+	return s.table.filter(func(d Doctor) bool { return d.HospitalCode == hospitalCode && d.IsDeleted() }), nil
+}
+
+func (s *memoryDoctorStore) Count(ctx context.Context) (int64, error) {
+	return s.table.count(alive[Doctor](nil)), nil
+}
+
+type memoryHospitalStore struct {
+	table memoryTable[Hospital]
+}
+
+func (s *memoryHospitalStore) Get(ctx context.Context, hospitalCode int) (*Hospital, error) {
+	return s.table.find(alive(func(h Hospital) bool { return h.HospitalCode == hospitalCode }))
+}
+
+func (s *memoryHospitalStore) Lookup(ctx context.Context, hospitalCode int) (*Hospital, error) {
+	return s.table.find(func(h Hospital) bool { return h.HospitalCode == hospitalCode })
+}
+
+func (s *memoryHospitalStore) Insert(ctx context.Context, hospital Hospital) error {
+	s.table.insert(hospital)
+	return nil
+}
+
+func (s *memoryHospitalStore) Replace(ctx context.Context, hospital Hospital, version int64) error {
+	match := func(h Hospital) bool { return h.HospitalCode == hospital.HospitalCode }
+	return replaceVersion(&s.table, match, func(h Hospital) int64 { return h.Version }, version, hospital)
+}
+
+func (s *memoryHospitalStore) AddField(ctx context.Context, hospitalCode, fieldCode int) error {
+	match := alive(func(h Hospital) bool { return h.HospitalCode == hospitalCode && !slices.Contains(h.Fields, fieldCode) })
+	s.table.update(match, func(h *Hospital) {
+		h.Fields = append(h.Fields, fieldCode)
+		h.UpdatedAt = time.Now()
+		h.Version++
+	})
+	return nil
+}
+
+func (s *memoryHospitalStore) RemoveField(ctx context.Context, hospitalCode, fieldCode int) error {
+	match := alive(func(h Hospital) bool { return h.HospitalCode == hospitalCode && slices.Contains(h.Fields, fieldCode) })
+	s.table.update(match, func(h *Hospital) {
+		h.Fields = slices.DeleteFunc(h.Fields, func(f int) bool { return f == fieldCode })
+		h.UpdatedAt = time.Now()
+		h.Version++
+	})
+	return nil
+}
+
+func (s *memoryHospitalStore) SoftDelete(ctx context.Context, hospitalCode int, tombstone Tombstone) error {
+	match := alive(func(h Hospital) bool { return h.HospitalCode == hospitalCode })
+	if !s.table.update(match, func(h *Hospital) { h.Tombstone = tombstone }) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryHospitalStore) Restore(ctx context.Context, hospitalCode int) (*Hospital, error) {
+	var restored Hospital
+	match := func(h Hospital) bool { return h.HospitalCode == hospitalCode && h.IsDeleted() }
+	if !s.table.update(match, func(h *Hospital) {
+		restored = cloneHospital(*h)
+		h.Tombstone = Tombstone{}
+	}) {
+		return nil, ErrNotFound
+	}
+	return &restored, nil
+}
+
+func (s *memoryHospitalStore) Purge(ctx context.Context, before time.Time) ([]int, error) {
+	var hospitalCodes []int
+	for _, hospital := range s.table.removeAll(func(h Hospital) bool { return deletedBefore(h.Tombstone, before) }) {
+		hospitalCodes = append(hospitalCodes, hospital.HospitalCode)
+	}
+	return hospitalCodes, nil
+}
+
+func (s *memoryHospitalStore) List(ctx context.Context) ([]Hospital, error) {
+	return s.table.filter(alive[Hospital](nil)), nil
+}
+
+func (s *memoryHospitalStore) Find(ctx context.Context, f HospitalFilter, query listQuery[Hospital]) ([]Hospital, int64, error) {
+	hospitals := s.table.filter(func(h Hospital) bool {
+		return inTrash(h, f.Deleted) &&
+			(f.ProvinceCode == nil || h.ProvinceCode == *f.ProvinceCode) &&
+			(f.DistrictCode == nil || h.DistrictCode == *f.DistrictCode) &&
+			(f.FieldCode == nil || slices.Contains(h.Fields, *f.FieldCode))
+	})
+	items, total := page(hospitals, query)
+	return items, total, nil
+}
+
+func (s *memoryHospitalStore) ListByProvince(ctx context.Context, provinceCode int) ([]Hospital, error) {
+	return s.table.filter(alive(func(h Hospital) bool { return h.ProvinceCode == provinceCode })), nil
+}
+
+func (s *memoryHospitalStore) ListByDistrict(ctx context.Context, districtCode int) ([]Hospital, error) {
+	return s.table.filter(alive(func(h Hospital) bool { return h.DistrictCode == districtCode })), nil
+}
+
+func (s *memoryHospitalStore) Count(ctx context.Context) (int64, error) {
+	return s.table.count(alive[Hospital](nil)), nil
+}
+
+type memoryAppointmentStore struct {
+	table memoryTable[Appointment]
+}
+
+func (s *memoryAppointmentStore) Get(ctx context.Context, appointmentCode string) (*Appointment, error) {
+	return s.table.find(alive(func(a Appointment) bool { return a.AppointmentCode == appointmentCode }))
+}
+
+func (s *memoryAppointmentStore) Insert(ctx context.Context, appointment Appointment) error {
+	s.table.insert(appointment)
+	return nil
+}
+
+func (s *memoryAppointmentStore) Replace(ctx context.Context, appointment Appointment, version int64) error {
+	match := func(a Appointment) bool { return a.AppointmentCode == appointment.AppointmentCode }
+	return replaceVersion(&s.table, match, func(a Appointment) int64 { return a.Version }, version, appointment)
+}
+
+func (s *memoryAppointmentStore) SoftDelete(ctx context.Context, appointmentCode string, tombstone Tombstone) error {
+	match := alive(func(a Appointment) bool { return a.AppointmentCode == appointmentCode })
+	if !s.table.update(match, func(a *Appointment) { a.Tombstone = tombstone }) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryAppointmentStore) Restore(ctx context.Context, appointmentCode string) (*Appointment, error) {
+	var restored Appointment
+	match := func(a Appointment) bool { return a.AppointmentCode == appointmentCode && a.IsDeleted() }
+	if !s.table.update(match, func(a *Appointment) {
+		restored = *a
+		a.Tombstone = Tombstone{}
+	}) {
+		return nil, ErrNotFound
+	}
+	return &restored, nil
+}
+
+func (s *memoryAppointmentStore) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	var appointmentCodes []string
+	for _, appointment := range s.table.removeAll(func(a Appointment) bool { return deletedBefore(a.Tombstone, before) }) {
+		appointmentCodes = append(appointmentCodes, appointment.AppointmentCode)
+	}
+	return appointmentCodes, nil
+}
+
+func (s *memoryAppointmentStore) List(ctx context.Context) ([]Appointment, error) {
+	return s.table.filter(alive[Appointment](nil)), nil
+}
+
+func (s *memoryAppointmentStore) Find(ctx context.Context, f AppointmentFilter, query listQuery[Appointment]) ([]Appointment, int64, error) {
+	appointments := s.table.filter(func(a Appointment) bool {
+		return inTrash(a, f.Deleted) &&
+			(f.DoctorCode == "" || a.DoctorCode == f.DoctorCode) &&
+			(f.UserCode == "" || a.UserCode == f.UserCode) &&
+			(f.DateFrom == "" || a.AppointmentTime.Date >= f.DateFrom) &&
+			(f.DateTo == "" || a.AppointmentTime.Date <= f.DateTo) &&
+			(f.Status == "" || a.CurrentStatus() == f.Status) &&
+			(!f.LateCancelled || a.CancelledLate)
+	})
+	items, total := page(appointments, query)
+	return items, total, nil
+}
+
+func (s *memoryAppointmentStore) ListByDoctor(ctx context.Context, doctorCode string) ([]Appointment, error) {
+	return s.table.filter(alive(func(a Appointment) bool { return a.DoctorCode == doctorCode })), nil
+}
+
+func (s *memoryAppointmentStore) ListByUser(ctx context.Context, userCode string) ([]Appointment, error) {
+	return s.table.filter(alive(func(a Appointment) bool { return a.UserCode == userCode })), nil
+}
+
+func (s *memoryAppointmentStore) ListBySeries(ctx context.Context, seriesCode string) ([]Appointment, error) {
+	return s.table.filter(alive(func(a Appointment) bool { return a.SeriesCode == seriesCode })), nil
+}
+
+func (s *memoryAppointmentStore) ListBetween(ctx context.Context, from, to string) ([]Appointment, error) {
+	return s.table.filter(alive(func(a Appointment) bool {
+		return from <= a.AppointmentTime.Date && a.AppointmentTime.Date <= to
+	})), nil
+}
+
+func (s *memoryAppointmentStore) Count(ctx context.Context) (int64, error) {
+	return s.table.count(alive[Appointment](nil)), nil
+}
+
+func (s *memoryAppointmentStore) CountByDate(ctx context.Context, date string) (int64, error) {
+	return s.table.count(alive(func(a Appointment) bool { return a.AppointmentTime.Date == date })), nil
+}
+
+type memorySlotStore struct {
+	table memoryTable[SlotLock]
+}
+
+func (s *memorySlotStore) Claim(ctx context.Context, locks []SlotLock) error {
+	claimed := s.table.insertUnless(func(l SlotLock) bool {
+		return slices.ContainsFunc(locks, func(lock SlotLock) bool {
+			return l.DoctorCode == lock.DoctorCode && l.Date == lock.Date && l.Time == lock.Time
+		})
+	}, locks...)
+	if !claimed {
+		return ErrSlotTaken
+	}
+	return nil
+}
+
+func (s *memorySlotStore) Release(ctx context.Context, locks []SlotLock) error {
+	s.table.removeAll(func(l SlotLock) bool { return slices.Contains(locks, l) })
+	return nil
+}
+
+func (s *memorySlotStore) Transfer(ctx context.Context, lock SlotLock, heldBy string) error {
+	transferred := false
+	for s.table.update(func(l SlotLock) bool {
+		return l.DoctorCode == lock.DoctorCode && l.Date == lock.Date && l.AppointmentCode == heldBy
+	}, func(l *SlotLock) {
+		l.AppointmentCode = lock.AppointmentCode
+	}) {
+		transferred = true
+	}
+	if !transferred {
+		return ErrSlotTaken
+	}
+	return nil
+}
+
+func (s *memorySlotStore) ListByDoctorDate(ctx context.Context, doctorCode, date string) ([]SlotLock, error) {
+	return s.table.filter(func(l SlotLock) bool { return l.DoctorCode == doctorCode && l.Date == date }), nil
+}
+
+type memoryWaitlistStore struct {
+	table memoryTable[WaitlistEntry]
+}
+
+func cloneWaitlistEntry(entry WaitlistEntry) WaitlistEntry {
+	if entry.Offer != nil {
+		offer := *entry.Offer
+		entry.Offer = &offer
+	}
+	return entry
+}
+
+func (s *memoryWaitlistStore) Insert(ctx context.Context, entry WaitlistEntry) error {
+	s.table.insert(entry)
+	return nil
+}
+
+func (s *memoryWaitlistStore) Get(ctx context.Context, entryCode string) (*WaitlistEntry, error) {
+	return s.table.find(func(e WaitlistEntry) bool { return e.EntryCode == entryCode })
+}
+
+func (s *memoryWaitlistStore) ListByUser(ctx context.Context, userCode string) ([]WaitlistEntry, error) {
+	return s.table.filter(func(e WaitlistEntry) bool { return e.UserCode == userCode }), nil
+}
+
+func (s *memoryWaitlistStore) ListWaiting(ctx context.Context, doctor Doctor, date string) ([]WaitlistEntry, error) {
+	entries := s.table.filter(func(e WaitlistEntry) bool {
+		return e.Status == WaitlistWaiting && e.DateFrom <= date && date <= e.DateTo && e.Serves(doctor)
+	})
+	slices.SortStableFunc(entries, func(a, b WaitlistEntry) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return entries, nil
+}
+
+func (s *memoryWaitlistStore) ListExpiredOffers(ctx context.Context, before time.Time) ([]WaitlistEntry, error) {
+	return s.table.filter(func(e WaitlistEntry) bool {
+		return e.Status == WaitlistOffered && e.Offer != nil && e.Offer.ExpiresAt.Before(before)
+	}), nil
+}
+
+func (s *memoryWaitlistStore) Replace(ctx context.Context, entry WaitlistEntry, status WaitlistStatus) error {
+	replaced := s.table.update(func(e WaitlistEntry) bool {
+		return e.EntryCode == entry.EntryCode && e.Status == status
+	}, func(e *WaitlistEntry) {
+		*e = entry
+	})
+	if !replaced {
+		if _, err := s.Get(ctx, entry.EntryCode); err != nil {
+			return err
+		}
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (s *memoryWaitlistStore) ExpireWaiting(ctx context.Context, date string) (int64, error) {
+	var expired int64
+	for s.table.update(func(e WaitlistEntry) bool {
+		return e.Status == WaitlistWaiting && e.DateTo < date
+	}, func(e *WaitlistEntry) {
+		e.Status = WaitlistExpired
+		e.UpdatedAt = time.Now()
+	}) {
+		expired++
+	}
+	return expired, nil
+}
+
+type memoryBlackoutStore struct {
+	table memoryTable[Blackout]
+}
+
+func (s *memoryBlackoutStore) Insert(ctx context.Context, blackout Blackout) error {
+	s.table.insert(blackout)
+	return nil
+}
+
+func (s *memoryBlackoutStore) Get(ctx context.Context, blackoutCode string) (*Blackout, error) {
+	return s.table.find(func(b Blackout) bool { return b.BlackoutCode == blackoutCode })
+}
+
+func (s *memoryBlackoutStore) Delete(ctx context.Context, blackoutCode string) error {
+	if len(s.table.removeAll(func(b Blackout) bool { return b.BlackoutCode == blackoutCode })) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryBlackoutStore) List(ctx context.Context, f BlackoutFilter) ([]Blackout, error) {
+	blackouts := s.table.filter(func(b Blackout) bool {
+		return (f.Kind == "" || b.Kind == f.Kind) &&
+			(f.DoctorCode == "" || b.DoctorCode == f.DoctorCode) &&
+			(f.HospitalCode == 0 || b.HospitalCode == f.HospitalCode) &&
+			(f.DateFrom == "" || b.DateTo >= f.DateFrom) &&
+			(f.DateTo == "" || b.DateFrom <= f.DateTo)
+	})
+	slices.SortStableFunc(blackouts, func(a, b Blackout) int { return strings.Compare(a.DateFrom, b.DateFrom) })
+	return blackouts, nil
+}
+
+func (s *memoryBlackoutStore) ListFor(ctx context.Context, doctor Doctor, from, to string) ([]Blackout, error) {
+	return s.table.filter(func(b Blackout) bool {
+		return b.DateFrom <= to && b.DateTo >= from && b.AppliesTo(doctor)
+	}), nil
+}
+
+func (s *memoryBlackoutStore) ReplaceHolidays(ctx context.Context, year int, holidays []Blackout) ([]Blackout, error) {
+	prefix := fmt.Sprintf("%04d-", year)
+	replaced := s.table.removeAll(func(b Blackout) bool {
+		return b.Kind == BlackoutHoliday && strings.HasPrefix(b.DateFrom, prefix)
+	})
+	s.table.insert(holidays...)
+	return replaced, nil
+}
+
+type memoryPolicyStore struct {
+	mu     sync.Mutex
+	policy *BookingPolicy
+}
+
+func (s *memoryPolicyStore) Get(ctx context.Context) (*BookingPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.policy == nil {
+		return nil, ErrNotFound
+	}
+	policy := *s.policy
+	policy.Hospitals = slices.Clone(s.policy.Hospitals)
+	return &policy, nil
+}
+
+func (s *memoryPolicyStore) Replace(ctx context.Context, policy BookingPolicy, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := int64(0)
+	if s.policy != nil {
+		stored = s.policy.Version
+	}
+	if stored != version {
+		return ErrVersionConflict
+	}
+	policy.Hospitals = slices.Clone(policy.Hospitals)
+	s.policy = &policy
+	return nil
+}
+
+type memoryAttendanceStore struct {
+	table memoryTable[Attendance]
+}
+
+func cloneAttendance(attendance Attendance) Attendance {
+	attendance.Penalties = slices.Clone(attendance.Penalties)
+	return attendance
+}
+
+func (s *memoryAttendanceStore) Get(ctx context.Context, userCode string) (*Attendance, error) {
+	return s.table.find(func(a Attendance) bool { return a.UserCode == userCode })
+}
+
+func (s *memoryAttendanceStore) Replace(ctx context.Context, attendance Attendance, version int64) error {
+	match := func(a Attendance) bool { return a.UserCode == attendance.UserCode }
+	if version == 0 {
+		if !s.table.insertUnless(match, attendance) {
+			return ErrVersionConflict
+		}
+		return nil
+	}
+	if !s.table.update(func(a Attendance) bool { return match(a) && a.Version == version }, func(a *Attendance) { *a = attendance }) {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+type memoryHoldStore struct {
+	table memoryTable[SlotHold]
+}
+
+func (s *memoryHoldStore) Insert(ctx context.Context, hold SlotHold) error {
+	s.table.insert(hold)
+	return nil
+}
+
+func (s *memoryHoldStore) Get(ctx context.Context, holdCode string) (*SlotHold, error) {
+	return s.table.find(func(h SlotHold) bool { return h.HoldCode == holdCode })
+}
+
+func (s *memoryHoldStore) ListByUser(ctx context.Context, userCode string) ([]SlotHold, error) {
+	return s.table.filter(func(h SlotHold) bool { return h.UserCode == userCode }), nil
+}
+
+func (s *memoryHoldStore) ListExpired(ctx context.Context, before time.Time) ([]SlotHold, error) {
+	return s.table.filter(func(h SlotHold) bool { return h.ExpiresAt.Before(before) }), nil
+}
+
+func (s *memoryHoldStore) Delete(ctx context.Context, holdCode string) error {
+	if len(s.table.removeAll(func(h SlotHold) bool { return h.HoldCode == holdCode })) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryEncounterStore struct {
+	table memoryTable[EncounterNote]
+}
+
+func cloneEncounterNote(note EncounterNote) EncounterNote {
+	note.DiagnosisCodes = slices.Clone(note.DiagnosisCodes)
+	note.Grants = slices.Clone(note.Grants)
+	note.History = slices.Clone(note.History)
+	for i := range note.History {
+		note.History[i].DiagnosisCodes = slices.Clone(note.History[i].DiagnosisCodes)
+	}
+	return note
+}
+
+func (s *memoryEncounterStore) Get(ctx context.Context, appointmentCode string) (*EncounterNote, error) {
+	return s.table.find(func(n EncounterNote) bool { return n.AppointmentCode == appointmentCode })
+}
+
+func (s *memoryEncounterStore) ListByAppointments(ctx context.Context, appointmentCodes []string) ([]EncounterNote, error) {
+	return s.table.filter(func(n EncounterNote) bool { return slices.Contains(appointmentCodes, n.AppointmentCode) }), nil
+}
+
+func (s *memoryEncounterStore) Replace(ctx context.Context, note EncounterNote, version int64) error {
+	match := func(n EncounterNote) bool { return n.AppointmentCode == note.AppointmentCode }
+	if version == 0 {
+		if !s.table.insertUnless(match, note) {
+			return ErrVersionConflict
+		}
+		return nil
+	}
+	if !s.table.update(func(n EncounterNote) bool { return match(n) && n.Version == version }, func(n *EncounterNote) { *n = note }) {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+type memoryRequestStore struct {
+	table memoryTable[AppointmentDeleteRequest]
+}
+
+func (s *memoryRequestStore) Insert(ctx context.Context, request AppointmentDeleteRequest) error {
+	s.table.insert(request)
+	return nil
+}
+
+func (s *memoryRequestStore) Get(ctx context.Context, requestCode string) (*AppointmentDeleteRequest, error) {
+	return s.table.find(func(r AppointmentDeleteRequest) bool { return r.RequestCode == requestCode })
+}
+
+func (s *memoryRequestStore) List(ctx context.Context) ([]AppointmentDeleteRequest, error) {
+	return s.table.filter(nil), nil
+}
+
+func (s *memoryRequestStore) Find(ctx context.Context, f RequestFilter, query listQuery[AppointmentDeleteRequest]) ([]AppointmentDeleteRequest, int64, error) {
+	requests := s.table.filter(func(r AppointmentDeleteRequest) bool {
+		return (f.DoctorCode == "" || r.DoctorCode == f.DoctorCode) &&
+			(f.Status == "" || r.Status == f.Status)
+	})
+	items, total := page(requests, query)
+	return items, total, nil
+}
+
+func (s *memoryRequestStore) ListByDoctor(ctx context.Context, doctorCode string) ([]AppointmentDeleteRequest, error) {
+	return s.table.filter(func(r AppointmentDeleteRequest) bool { return r.DoctorCode == doctorCode }), nil
+}
+
+func (s *memoryRequestStore) UpdateStatus(ctx context.Context, requestCode, from, status string) error {
+	updated := s.table.update(func(r AppointmentDeleteRequest) bool {
+		return r.RequestCode == requestCode && r.Status == from
+	}, func(r *AppointmentDeleteRequest) {
+		r.Status = status
+		r.UpdatedAt = time.Now()
+	})
+	if !updated {
+		if _, err := s.Get(ctx, requestCode); err != nil {
+			return err
+		}
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (s *memoryRequestStore) Delete(ctx context.Context, requestCode string) error {
+	s.table.remove(func(r AppointmentDeleteRequest) bool { return r.RequestCode == requestCode })
+	return nil
+}
+
+func (s *memoryRequestStore) CountByStatus(ctx context.Context, status string) (int64, error) {
+	return s.table.count(func(r AppointmentDeleteRequest) bool { return r.Status == status }), nil
+}
+
+// MemoryLocationStore serves the province and district reference data from memory.
+// Tests fill Provinces and Districts directly.
+type MemoryLocationStore struct {
+	Provinces []Province
+	Districts []District
+}
+
+func (s *MemoryLocationStore) ListProvinces(ctx context.Context) ([]Province, error) {
+	return slices.Clone(s.Provinces), nil
+}
+
+func (s *MemoryLocationStore) ListDistrictsByProvince(ctx context.Context, provinceCode int) ([]District, error) {
+	var districts []District
+	for _, district := range s.Districts {
+		if district.ProvinceCode == provinceCode {
+			districts = append(districts, district)
+		}
+	}
+	return districts, nil
+}
+
+type memoryAuditStore struct {
+	table memoryTable[AuditEntry]
+}
+
+func (s *memoryAuditStore) Insert(ctx context.Context, entry AuditEntry) error {
+	s.table.insert(entry)
+	return nil
+}
+
+func (s *memoryAuditStore) Find(ctx context.Context, f AuditFilter, query listQuery[AuditEntry]) ([]AuditEntry, int64, error) {
+	entries := s.table.filter(func(e AuditEntry) bool {
+		return (f.Actor == "" || e.Actor == f.Actor) &&
+			(f.TargetType == "" || e.TargetType == f.TargetType) &&
+			(f.TargetCode == "" || e.TargetCode == f.TargetCode) &&
+			(f.From.IsZero() || !e.At.Before(f.From)) &&
+			(f.To.IsZero() || !e.At.After(f.To))
+	})
+	items, total := page(entries, query)
+	return items, total, nil
+}
@@ -0,0 +1,1406 @@
+package api
+
+import (
+	"backend/config"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongoStore returns a Store backed by the healthcare, users and locations databases.
+// Every operation is bounded by cfg.OperationTimeout on top of the caller's context.
+func NewMongoStore(client *mongo.Client, cfg config.MongoConfig) *Store {
+	healthcare := client.Database("healthcare")
+	users := client.Database("users")
+	locations := client.Database("locations")
+	t := mongoTimeout{timeout: time.Duration(cfg.OperationTimeout)}
+	// Changed values are free-form, decode nested documents as maps so they encode back to JSON objects
+	auditOptions := options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+
+	return &Store{
+		Users:        &mongoUserStore{mongoTimeout: t, collection: users.Collection("users")},
+		UserInfo:     &mongoUserInfoStore{mongoTimeout: t, collection: users.Collection("userAdditionalInfo")},
+		Doctors:      &mongoDoctorStore{mongoTimeout: t, collection: healthcare.Collection("doctors")},
+		Hospitals:    &mongoHospitalStore{mongoTimeout: t, collection: healthcare.Collection("hospitals")},
+		Appointments: &mongoAppointmentStore{mongoTimeout: t, collection: healthcare.Collection("appointments")},
+		Requests:     &mongoRequestStore{mongoTimeout: t, collection: healthcare.Collection("requests")},
+		Slots:        &mongoSlotStore{mongoTimeout: t, collection: healthcare.Collection("slotLocks")},
+		Audit:        &mongoAuditStore{mongoTimeout: t, collection: healthcare.Collection("auditLog", auditOptions)},
+		Waitlist:     &mongoWaitlistStore{mongoTimeout: t, collection: healthcare.Collection("waitlist")},
+		Blackouts:    &mongoBlackoutStore{mongoTimeout: t, collection: healthcare.Collection("blackouts")},
+		Policy:       &mongoPolicyStore{mongoTimeout: t, collection: healthcare.Collection("settings")},
+		Attendance:   &mongoAttendanceStore{mongoTimeout: t, collection: healthcare.Collection("attendance")},
+		Holds:        &mongoHoldStore{mongoTimeout: t, collection: healthcare.Collection("slotHolds")},
+		Encounters:   &mongoEncounterStore{mongoTimeout: t, collection: healthcare.Collection("encounterNotes")},
+		Locations: &mongoLocationStore{
+			mongoTimeout: t,
+			provinces:    locations.Collection("provinces"),
+			districts:    locations.Collection("districts"),
+		},
+		ping: func(ctx context.Context) error {
+			return client.Ping(ctx, nil)
+		},
+	}
+}
+
+// mongoTimeout bounds each store operation so a slow node cannot hold a request forever
+type mongoTimeout struct {
+	timeout time.Duration
+}
+
+func (t mongoTimeout) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.timeout)
+}
+
+func findOne[T any](ctx context.Context, collection *mongo.Collection, filter interface{}) (*T, error) {
+	var item T
+	err := collection.FindOne(ctx, filter).Decode(&item)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+func findAll[T any](ctx context.Context, collection *mongo.Collection, filter interface{}) ([]T, error) {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var items []T
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// findPage counts the matches of filter and returns the page of them selected by query
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.D, query listQuery[T]) ([]T, int64, error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort := bson.D{}
+	for _, key := range query.sort {
+		order := 1
+		if key.desc {
+			order = -1
+		}
+		sort = append(sort, bson.E{Key: query.fields[key.field].path, Value: order})
+	}
+
+	opts := options.Find().
+		SetSort(sort).
+		SetSkip(int64(query.offset)).
+		SetLimit(int64(query.limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var items []T
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// tombstoneFilter matches live documents, or only the soft deleted ones when deleted is set
+func tombstoneFilter(deleted bool) bson.E {
+	if deleted {
+		return bson.E{Key: "deletedAt", Value: bson.M{"$ne": nil}}
+	}
+	return bson.E{Key: "deletedAt", Value: nil}
+}
+
+// live restricts filter to documents without a tombstone
+func live(filter bson.D) bson.D {
+	return append(filter, tombstoneFilter(false))
+}
+
+// softDelete tombstones the live document matching filter
+func softDelete(ctx context.Context, collection *mongo.Collection, filter bson.D, tombstone Tombstone) error {
+	update := bson.M{"$set": bson.M{"deletedAt": tombstone.DeletedAt, "deletedBy": tombstone.DeletedBy}}
+	result, err := collection.UpdateOne(ctx, live(filter), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// restore lifts the tombstone of the deleted document matching filter and returns it as it was
+func restore[T any](ctx context.Context, collection *mongo.Collection, filter bson.D) (*T, error) {
+	filter = append(filter, tombstoneFilter(true))
+	update := bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}}
+
+	var item T
+	err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&item)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+// replaceVersioned replaces the live document matching filter with doc, provided
+// the stored document is still at version
+func replaceVersioned(ctx context.Context, collection *mongo.Collection, filter bson.D, version int64, doc interface{}) error {
+	atVersion := append(slices.Clone(filter), bson.E{Key: "version", Value: version})
+	result, err := collection.ReplaceOne(ctx, live(atVersion), doc)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Nothing at that version, tell a stale write from a missing record
+	count, err := collection.CountDocuments(ctx, live(filter))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+// purgeFilter matches the documents deleted before the given time
+func purgeFilter(before time.Time) bson.D {
+	return bson.D{{Key: "deletedAt", Value: bson.M{"$lt": before}}}
+}
+
+// purge removes the documents deleted before the given time and returns their codes.
+// Only the documents found first are removed, one restored meanwhile no longer matches.
+func purge[T any, K any](ctx context.Context, collection *mongo.Collection, before time.Time, codeField string, code func(T) K) ([]K, error) {
+	items, err := findAll[T](ctx, collection, purgeFilter(before))
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	codes := make([]K, len(items))
+	for i, item := range items {
+		codes[i] = code(item)
+	}
+
+	filter := append(purgeFilter(before), bson.E{Key: codeField, Value: bson.M{"$in": codes}})
+	if _, err := collection.DeleteMany(ctx, filter); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+type mongoUserStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoUserStore) GetByCode(ctx context.Context, userCode string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[User](ctx, s.collection, live(bson.D{{Key: "userCode", Value: userCode}}))
+}
+
+func (s *mongoUserStore) Lookup(ctx context.Context, userCode string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[User](ctx, s.collection, bson.D{{Key: "userCode", Value: userCode}})
+}
+
+func (s *mongoUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[User](ctx, s.collection, live(bson.D{{Key: "email", Value: email}}))
+}
+
+func (s *mongoUserStore) Insert(ctx context.Context, user User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, user)
+	return err
+}
+
+func (s *mongoUserStore) SoftDelete(ctx context.Context, userCode string, tombstone Tombstone) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return softDelete(ctx, s.collection, bson.D{{Key: "userCode", Value: userCode}}, tombstone)
+}
+
+func (s *mongoUserStore) Restore(ctx context.Context, userCode string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return restore[User](ctx, s.collection, bson.D{{Key: "userCode", Value: userCode}})
+}
+
+func (s *mongoUserStore) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return purge(ctx, s.collection, before, "userCode", func(u User) string { return u.UserCode })
+}
+
+func (s *mongoUserStore) List(ctx context.Context) ([]User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[User](ctx, s.collection, live(bson.D{}))
+}
+
+func (s *mongoUserStore) Find(ctx context.Context, f UserFilter, query listQuery[User]) ([]User, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{tombstoneFilter(f.Deleted)}
+	if f.Role != "" {
+		filter = append(filter, bson.E{Key: "role", Value: f.Role})
+	}
+	return findPage(ctx, s.collection, filter, query)
+}
+
+func (s *mongoUserStore) CountByRole(ctx context.Context, role string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.collection.CountDocuments(ctx, live(bson.D{{Key: "role", Value: role}}))
+}
+
+func (s *mongoUserStore) UpdatePassword(ctx context.Context, userCode, passwordHash string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "userCode", Value: userCode}}
+	update := bson.M{
+		"$set": bson.M{
+			"password":  passwordHash,
+			"updatedAt": time.Now(),
+		},
+	}
+	_, err := s.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (s *mongoUserStore) InsertAdmin(ctx context.Context, admin AdminUser) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, admin)
+	return err
+}
+
+func (s *mongoUserStore) ListAdmins(ctx context.Context) ([]AdminUser, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[AdminUser](ctx, s.collection, live(bson.D{{Key: "role", Value: "admin"}}))
+}
+
+func (s *mongoUserStore) UpdateAdmin(ctx context.Context, userCode string, admin AdminUser) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "userCode", Value: userCode}}
+	set := bson.M{
+		"email":     admin.Email,
+		"firstName": admin.FirstName,
+		"lastName":  admin.LastName,
+		"updatedAt": time.Now(),
+	}
+	if admin.Password != "" {
+		set["password"] = admin.Password
+	}
+	_, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	return err
+}
+
+func (s *mongoUserStore) DeleteAdmin(ctx context.Context, userCode string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "userCode", Value: userCode},
+		{Key: "role", Value: "admin"},
+	}
+	_, err := s.collection.DeleteOne(ctx, filter)
+	return err
+}
+
+type mongoUserInfoStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoUserInfoStore) Get(ctx context.Context, userCode string) (*UserAdditionalInfo, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[UserAdditionalInfo](ctx, s.collection, bson.D{{Key: "userCode", Value: userCode}})
+}
+
+func (s *mongoUserInfoStore) Insert(ctx context.Context, info UserAdditionalInfo) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, info)
+	return err
+}
+
+func (s *mongoUserInfoStore) Update(ctx context.Context, info UserAdditionalInfo) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "userCode", Value: info.UserCode}}
+	update := bson.M{
+		"$set": bson.M{
+			"firstName":          info.FirstName,
+			"lastName":           info.LastName,
+			"email":              info.Email,
+			"phone":              info.Phone,
+			"address":            info.Address,
+			"birthDate":          info.BirthDate,
+			"gender":             info.Gender,
+			"bloodType":          info.BloodType,
+			"height":             info.Height,
+			"weight":             info.Weight,
+			"allergies":          info.Allergies,
+			"chronicDiseases":    info.ChronicDiseases,
+			"currentMedications": info.CurrentMedications,
+			"updatedAt":          info.UpdatedAt,
+		},
+	}
+	_, err := s.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (s *mongoUserInfoStore) Delete(ctx context.Context, userCode string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.DeleteOne(ctx, bson.D{{Key: "userCode", Value: userCode}})
+	return err
+}
+
+type mongoDoctorStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoDoctorStore) Get(ctx context.Context, doctorCode string) (*Doctor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Doctor](ctx, s.collection, live(bson.D{{Key: "doctorCode", Value: doctorCode}}))
+}
+
+func (s *mongoDoctorStore) Lookup(ctx context.Context, doctorCode string) (*Doctor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Doctor](ctx, s.collection, bson.D{{Key: "doctorCode", Value: doctorCode}})
+}
+
+func (s *mongoDoctorStore) GetByUser(ctx context.Context, userCode string) (*Doctor, error) {
+	if userCode == "" {
+		return nil, ErrNotFound
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Doctor](ctx, s.collection, live(bson.D{{Key: "userCode", Value: userCode}}))
+}
+
+func (s *mongoDoctorStore) Insert(ctx context.Context, doctor Doctor) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, doctor)
+	return doctorAccountTaken(err)
+}
+
+func (s *mongoDoctorStore) InsertMany(ctx context.Context, doctors []Doctor) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if len(doctors) == 0 {
+		return nil
+	}
+	documents := make([]interface{}, len(doctors))
+	for i, doctor := range doctors {
+		documents[i] = doctor
+	}
+	_, err := s.collection.InsertMany(ctx, documents)
+	return doctorAccountTaken(err)
+}
+
+func (s *mongoDoctorStore) Replace(ctx context.Context, doctor Doctor, version int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	err := replaceVersioned(ctx, s.collection, bson.D{{Key: "doctorCode", Value: doctor.DoctorCode}}, version, doctor)
+	return doctorAccountTaken(err)
+}
+
+// doctorAccountTaken maps a write refused by the unique userCode index to ErrDoctorAccountTaken
+func doctorAccountTaken(err error) error {
+	if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "userCode") {
+		return ErrDoctorAccountTaken
+	}
+	return err
+}
+
+func (s *mongoDoctorStore) SoftDelete(ctx context.Context, doctorCode string, tombstone Tombstone) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return softDelete(ctx, s.collection, bson.D{{Key: "doctorCode", Value: doctorCode}}, tombstone)
+}
+
+func (s *mongoDoctorStore) Restore(ctx context.Context, doctorCode string) (*Doctor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return restore[Doctor](ctx, s.collection, bson.D{{Key: "doctorCode", Value: doctorCode}})
+}
+
+func (s *mongoDoctorStore) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return purge(ctx, s.collection, before, "doctorCode", func(d Doctor) string { return d.DoctorCode })
+}
+
+func (s *mongoDoctorStore) List(ctx context.Context) ([]Doctor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Doctor](ctx, s.collection, live(bson.D{}))
+}
+
+func (s *mongoDoctorStore) Find(ctx context.Context, f DoctorFilter, query listQuery[Doctor]) ([]Doctor, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{tombstoneFilter(f.Deleted)}
+	if f.HospitalCode != nil {
+		filter = append(filter, bson.E{Key: "hospitalCode", Value: *f.HospitalCode})
+	}
+	if f.FieldCode != nil {
+		filter = append(filter, bson.E{Key: "field", Value: *f.FieldCode})
+	}
+	return findPage(ctx, s.collection, filter, query)
+}
+
+func (s *mongoDoctorStore) ListByHospital(ctx context.Context, hospitalCode int) ([]Doctor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Doctor](ctx, s.collection, live(bson.D{{Key: "hospitalCode", Value: hospitalCode}}))
+}
+
+func (s *mongoDoctorStore) ListDeletedByHospital(ctx context.Context, hospitalCode int) ([]Doctor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "hospitalCode", Value: hospitalCode}, tombstoneFilter(true)}
+	return findAll[Doctor](ctx, s.collection, filter)
+}
+
+func (s *mongoDoctorStore) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.collection.CountDocuments(ctx, live(bson.D{}))
+}
+
+type mongoHospitalStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoHospitalStore) Get(ctx context.Context, hospitalCode int) (*Hospital, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Hospital](ctx, s.collection, live(bson.D{{Key: "hospitalCode", Value: hospitalCode}}))
+}
+
+func (s *mongoHospitalStore) Lookup(ctx context.Context, hospitalCode int) (*Hospital, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Hospital](ctx, s.collection, bson.D{{Key: "hospitalCode", Value: hospitalCode}})
+}
+
+func (s *mongoHospitalStore) Insert(ctx context.Context, hospital Hospital) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, hospital)
+	return err
+}
+
+func (s *mongoHospitalStore) Replace(ctx context.Context, hospital Hospital, version int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return replaceVersioned(ctx, s.collection, bson.D{{Key: "hospitalCode", Value: hospital.HospitalCode}}, version, hospital)
+}
+
+func (s *mongoHospitalStore) AddField(ctx context.Context, hospitalCode, fieldCode int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// The field filter keeps the version as is when the field is already there
+	filter := live(bson.D{{Key: "hospitalCode", Value: hospitalCode}, {Key: "fields", Value: bson.M{"$ne": fieldCode}}})
+	update := bson.M{
+		"$addToSet": bson.M{"fields": fieldCode},
+		"$set":      bson.M{"updatedAt": time.Now()},
+		"$inc":      bson.M{"version": 1},
+	}
+	_, err := s.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (s *mongoHospitalStore) RemoveField(ctx context.Context, hospitalCode, fieldCode int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := live(bson.D{{Key: "hospitalCode", Value: hospitalCode}, {Key: "fields", Value: fieldCode}})
+	update := bson.M{
+		"$pull": bson.M{"fields": fieldCode},
+		"$set":  bson.M{"updatedAt": time.Now()},
+		"$inc":  bson.M{"version": 1},
+	}
+	_, err := s.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (s *mongoHospitalStore) SoftDelete(ctx context.Context, hospitalCode int, tombstone Tombstone) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return softDelete(ctx, s.collection, bson.D{{Key: "hospitalCode", Value: hospitalCode}}, tombstone)
+}
+
+func (s *mongoHospitalStore) Restore(ctx context.Context, hospitalCode int) (*Hospital, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return restore[Hospital](ctx, s.collection, bson.D{{Key: "hospitalCode", Value: hospitalCode}})
+}
+
+func (s *mongoHospitalStore) Purge(ctx context.Context, before time.Time) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return purge(ctx, s.collection, before, "hospitalCode", func(h Hospital) int { return h.HospitalCode })
+}
+
+func (s *mongoHospitalStore) List(ctx context.Context) ([]Hospital, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Hospital](ctx, s.collection, live(bson.D{}))
+}
+
+func (s *mongoHospitalStore) Find(ctx context.Context, f HospitalFilter, query listQuery[Hospital]) ([]Hospital, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{tombstoneFilter(f.Deleted)}
+	if f.ProvinceCode != nil {
+		filter = append(filter, bson.E{Key: "provinceCode", Value: *f.ProvinceCode})
+	}
+	if f.DistrictCode != nil {
+		filter = append(filter, bson.E{Key: "districtCode", Value: *f.DistrictCode})
+	}
+	if f.FieldCode != nil {
+		filter = append(filter, bson.E{Key: "fields", Value: *f.FieldCode})
+	}
+	return findPage(ctx, s.collection, filter, query)
+}
+
+func (s *mongoHospitalStore) ListByProvince(ctx context.Context, provinceCode int) ([]Hospital, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Hospital](ctx, s.collection, live(bson.D{{Key: "provinceCode", Value: provinceCode}}))
+}
+
+func (s *mongoHospitalStore) ListByDistrict(ctx context.Context, districtCode int) ([]Hospital, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Hospital](ctx, s.collection, live(bson.D{{Key: "districtCode", Value: districtCode}}))
+}
+
+func (s *mongoHospitalStore) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.collection.CountDocuments(ctx, live(bson.D{}))
+}
+
+type mongoAppointmentStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoAppointmentStore) Get(ctx context.Context, appointmentCode string) (*Appointment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Appointment](ctx, s.collection, live(bson.D{{Key: "appointmentCode", Value: appointmentCode}}))
+}
+
+func (s *mongoAppointmentStore) Insert(ctx context.Context, appointment Appointment) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, appointment)
+	return err
+}
+
+func (s *mongoAppointmentStore) Replace(ctx context.Context, appointment Appointment, version int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return replaceVersioned(ctx, s.collection, bson.D{{Key: "appointmentCode", Value: appointment.AppointmentCode}}, version, appointment)
+}
+
+func (s *mongoAppointmentStore) SoftDelete(ctx context.Context, appointmentCode string, tombstone Tombstone) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return softDelete(ctx, s.collection, bson.D{{Key: "appointmentCode", Value: appointmentCode}}, tombstone)
+}
+
+func (s *mongoAppointmentStore) Restore(ctx context.Context, appointmentCode string) (*Appointment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return restore[Appointment](ctx, s.collection, bson.D{{Key: "appointmentCode", Value: appointmentCode}})
+}
+
+func (s *mongoAppointmentStore) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return purge(ctx, s.collection, before, "appointmentCode", func(a Appointment) string { return a.AppointmentCode })
+}
+
+func (s *mongoAppointmentStore) List(ctx context.Context) ([]Appointment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Appointment](ctx, s.collection, live(bson.D{}))
+}
+
+func (s *mongoAppointmentStore) Find(ctx context.Context, f AppointmentFilter, query listQuery[Appointment]) ([]Appointment, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{tombstoneFilter(f.Deleted)}
+	if f.DoctorCode != "" {
+		filter = append(filter, bson.E{Key: "doctorCode", Value: f.DoctorCode})
+	}
+	if f.UserCode != "" {
+		filter = append(filter, bson.E{Key: "userCode", Value: f.UserCode})
+	}
+	dateRange := bson.M{}
+	if f.DateFrom != "" {
+		dateRange["$gte"] = f.DateFrom
+	}
+	if f.DateTo != "" {
+		dateRange["$lte"] = f.DateTo
+	}
+	if len(dateRange) > 0 {
+		filter = append(filter, bson.E{Key: "appointmentTime.date", Value: dateRange})
+	}
+	if f.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: f.Status})
+	}
+	if f.LateCancelled {
+		filter = append(filter, bson.E{Key: "cancelledLate", Value: true})
+	}
+	return findPage(ctx, s.collection, filter, query)
+}
+
+func (s *mongoAppointmentStore) ListByDoctor(ctx context.Context, doctorCode string) ([]Appointment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Appointment](ctx, s.collection, live(bson.D{{Key: "doctorCode", Value: doctorCode}}))
+}
+
+func (s *mongoAppointmentStore) ListByUser(ctx context.Context, userCode string) ([]Appointment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Appointment](ctx, s.collection, live(bson.D{{Key: "userCode", Value: userCode}}))
+}
+
+func (s *mongoAppointmentStore) ListBySeries(ctx context.Context, seriesCode string) ([]Appointment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Appointment](ctx, s.collection, live(bson.D{{Key: "seriesCode", Value: seriesCode}}))
+}
+
+func (s *mongoAppointmentStore) ListBetween(ctx context.Context, from, to string) ([]Appointment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "appointmentTime.date", Value: bson.M{"$gte": from, "$lte": to}}}
+	return findAll[Appointment](ctx, s.collection, live(filter))
+}
+
+func (s *mongoAppointmentStore) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.collection.CountDocuments(ctx, live(bson.D{}))
+}
+
+func (s *mongoAppointmentStore) CountByDate(ctx context.Context, date string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.collection.CountDocuments(ctx, live(bson.D{{Key: "appointmentTime.date", Value: date}}))
+}
+
+// mongoSlotStore relies on the unique doctorCode/date/time index created by the migrations
+type mongoSlotStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoSlotStore) Claim(ctx context.Context, locks []SlotLock) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	docs := make([]interface{}, len(locks))
+	for i, lock := range locks {
+		docs[i] = lock
+	}
+	// Ordered, so the insert stops at the first taken piece and the ones before it are rolled back
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(true))
+	if err == nil {
+		return nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		err = ErrSlotTaken
+	}
+	if releaseErr := s.release(context.WithoutCancel(ctx), locks); releaseErr != nil {
+		log.Println("Error rolling back slot claim:", releaseErr)
+	}
+	return err
+}
+
+func (s *mongoSlotStore) Release(ctx context.Context, locks []SlotLock) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.release(ctx, locks)
+}
+
+func (s *mongoSlotStore) release(ctx context.Context, locks []SlotLock) error {
+	if len(locks) == 0 {
+		return nil
+	}
+	ors := make(bson.A, len(locks))
+	for i, lock := range locks {
+		ors[i] = lock
+	}
+	_, err := s.collection.DeleteMany(ctx, bson.D{{Key: "$or", Value: ors}})
+	return err
+}
+
+func (s *mongoSlotStore) Transfer(ctx context.Context, lock SlotLock, heldBy string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "doctorCode", Value: lock.DoctorCode},
+		{Key: "date", Value: lock.Date},
+		{Key: "appointmentCode", Value: heldBy},
+	}
+	update := bson.M{"$set": bson.M{"appointmentCode": lock.AppointmentCode}}
+	result, err := s.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSlotTaken
+	}
+	return nil
+}
+
+func (s *mongoSlotStore) ListByDoctorDate(ctx context.Context, doctorCode, date string) ([]SlotLock, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "doctorCode", Value: doctorCode},
+		{Key: "date", Value: date},
+	}
+	return findAll[SlotLock](ctx, s.collection, filter)
+}
+
+type mongoWaitlistStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoWaitlistStore) Insert(ctx context.Context, entry WaitlistEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (s *mongoWaitlistStore) Get(ctx context.Context, entryCode string) (*WaitlistEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[WaitlistEntry](ctx, s.collection, bson.D{{Key: "entryCode", Value: entryCode}})
+}
+
+func (s *mongoWaitlistStore) ListByUser(ctx context.Context, userCode string) ([]WaitlistEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[WaitlistEntry](ctx, s.collection, bson.D{{Key: "userCode", Value: userCode}})
+}
+
+func (s *mongoWaitlistStore) ListWaiting(ctx context.Context, doctor Doctor, date string) ([]WaitlistEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "status", Value: WaitlistWaiting},
+		{Key: "dateFrom", Value: bson.M{"$lte": date}},
+		{Key: "dateTo", Value: bson.M{"$gte": date}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "doctorCode", Value: doctor.DoctorCode}},
+			bson.D{
+				{Key: "doctorCode", Value: ""},
+				{Key: "hospitalCode", Value: doctor.HospitalCode},
+				{Key: "fieldCode", Value: doctor.FieldCode},
+			},
+		}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []WaitlistEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *mongoWaitlistStore) ListExpiredOffers(ctx context.Context, before time.Time) ([]WaitlistEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "status", Value: WaitlistOffered},
+		{Key: "offer.expiresAt", Value: bson.M{"$lt": before}},
+	}
+	return findAll[WaitlistEntry](ctx, s.collection, filter)
+}
+
+func (s *mongoWaitlistStore) Replace(ctx context.Context, entry WaitlistEntry, status WaitlistStatus) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "entryCode", Value: entry.EntryCode}, {Key: "status", Value: status}}
+	result, err := s.collection.ReplaceOne(ctx, filter, entry)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := s.collection.CountDocuments(ctx, bson.D{{Key: "entryCode", Value: entry.EntryCode}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+func (s *mongoWaitlistStore) ExpireWaiting(ctx context.Context, date string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "status", Value: WaitlistWaiting},
+		{Key: "dateTo", Value: bson.M{"$lt": date}},
+	}
+	update := bson.M{"$set": bson.M{"status": WaitlistExpired, "updatedAt": time.Now()}}
+	result, err := s.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+type mongoBlackoutStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoBlackoutStore) Insert(ctx context.Context, blackout Blackout) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, blackout)
+	return err
+}
+
+func (s *mongoBlackoutStore) Get(ctx context.Context, blackoutCode string) (*Blackout, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Blackout](ctx, s.collection, bson.D{{Key: "blackoutCode", Value: blackoutCode}})
+}
+
+func (s *mongoBlackoutStore) Delete(ctx context.Context, blackoutCode string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.collection.DeleteOne(ctx, bson.D{{Key: "blackoutCode", Value: blackoutCode}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoBlackoutStore) List(ctx context.Context, f BlackoutFilter) ([]Blackout, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{}
+	if f.Kind != "" {
+		filter = append(filter, bson.E{Key: "kind", Value: f.Kind})
+	}
+	if f.DoctorCode != "" {
+		filter = append(filter, bson.E{Key: "doctorCode", Value: f.DoctorCode})
+	}
+	if f.HospitalCode != 0 {
+		filter = append(filter, bson.E{Key: "hospitalCode", Value: f.HospitalCode})
+	}
+	if f.DateFrom != "" {
+		filter = append(filter, bson.E{Key: "dateTo", Value: bson.M{"$gte": f.DateFrom}})
+	}
+	if f.DateTo != "" {
+		filter = append(filter, bson.E{Key: "dateFrom", Value: bson.M{"$lte": f.DateTo}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "dateFrom", Value: 1}})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var blackouts []Blackout
+	if err := cursor.All(ctx, &blackouts); err != nil {
+		return nil, err
+	}
+	return blackouts, nil
+}
+
+func (s *mongoBlackoutStore) ListFor(ctx context.Context, doctor Doctor, from, to string) ([]Blackout, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "dateFrom", Value: bson.M{"$lte": to}},
+		{Key: "dateTo", Value: bson.M{"$gte": from}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "kind", Value: BlackoutLeave}, {Key: "doctorCode", Value: doctor.DoctorCode}},
+			bson.D{{Key: "kind", Value: BlackoutClosure}, {Key: "hospitalCode", Value: doctor.HospitalCode}},
+			bson.D{{Key: "kind", Value: BlackoutHoliday}},
+		}},
+	}
+	return findAll[Blackout](ctx, s.collection, filter)
+}
+
+func (s *mongoBlackoutStore) ReplaceHolidays(ctx context.Context, year int, holidays []Blackout) ([]Blackout, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "kind", Value: BlackoutHoliday},
+		{Key: "dateFrom", Value: bson.M{"$gte": fmt.Sprintf("%04d-01-01", year), "$lte": fmt.Sprintf("%04d-12-31", year)}},
+	}
+	replaced, err := findAll[Blackout](ctx, s.collection, filter)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.collection.DeleteMany(ctx, filter); err != nil {
+		return nil, err
+	}
+	if len(holidays) == 0 {
+		return replaced, nil
+	}
+	documents := make([]interface{}, len(holidays))
+	for i, holiday := range holidays {
+		documents[i] = holiday
+	}
+	if _, err := s.collection.InsertMany(ctx, documents); err != nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+// bookingPolicyID is the _id of the booking policy in the settings collection
+const bookingPolicyID = "bookingPolicy"
+
+type mongoPolicyStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoPolicyStore) Get(ctx context.Context) (*BookingPolicy, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[BookingPolicy](ctx, s.collection, bson.D{{Key: "_id", Value: bookingPolicyID}})
+}
+
+func (s *mongoPolicyStore) Replace(ctx context.Context, policy BookingPolicy, version int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: bookingPolicyID}, {Key: "version", Value: version}}
+	doc := bson.D{
+		{Key: "_id", Value: bookingPolicyID},
+		{Key: "rules", Value: policy.Rules},
+		{Key: "hospitals", Value: policy.Hospitals},
+		{Key: "noShowPenalty", Value: policy.NoShowPenalty},
+		{Key: "updatedAt", Value: policy.UpdatedAt},
+		{Key: "version", Value: policy.Version},
+	}
+	// The first save inserts the policy, a concurrent first save then hits the _id
+	result, err := s.collection.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(version == 0))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+type mongoAttendanceStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoAttendanceStore) Get(ctx context.Context, userCode string) (*Attendance, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[Attendance](ctx, s.collection, bson.D{{Key: "userCode", Value: userCode}})
+}
+
+func (s *mongoAttendanceStore) Replace(ctx context.Context, attendance Attendance, version int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "userCode", Value: attendance.UserCode}, {Key: "version", Value: version}}
+	// The first record is inserted, a concurrent first record then hits the unique userCode index
+	result, err := s.collection.ReplaceOne(ctx, filter, attendance, options.Replace().SetUpsert(version == 0))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+type mongoHoldStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoHoldStore) Insert(ctx context.Context, hold SlotHold) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, hold)
+	return err
+}
+
+func (s *mongoHoldStore) Get(ctx context.Context, holdCode string) (*SlotHold, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[SlotHold](ctx, s.collection, bson.D{{Key: "holdCode", Value: holdCode}})
+}
+
+func (s *mongoHoldStore) ListByUser(ctx context.Context, userCode string) ([]SlotHold, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[SlotHold](ctx, s.collection, bson.D{{Key: "userCode", Value: userCode}})
+}
+
+func (s *mongoHoldStore) ListExpired(ctx context.Context, before time.Time) ([]SlotHold, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[SlotHold](ctx, s.collection, bson.D{{Key: "expiresAt", Value: bson.M{"$lt": before}}})
+}
+
+func (s *mongoHoldStore) Delete(ctx context.Context, holdCode string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.collection.DeleteOne(ctx, bson.D{{Key: "holdCode", Value: holdCode}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoEncounterStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoEncounterStore) Get(ctx context.Context, appointmentCode string) (*EncounterNote, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[EncounterNote](ctx, s.collection, bson.D{{Key: "appointmentCode", Value: appointmentCode}})
+}
+
+func (s *mongoEncounterStore) ListByAppointments(ctx context.Context, appointmentCodes []string) ([]EncounterNote, error) {
+	if len(appointmentCodes) == 0 {
+		return []EncounterNote{}, nil
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[EncounterNote](ctx, s.collection, bson.D{{Key: "appointmentCode", Value: bson.M{"$in": appointmentCodes}}})
+}
+
+func (s *mongoEncounterStore) Replace(ctx context.Context, note EncounterNote, version int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{{Key: "appointmentCode", Value: note.AppointmentCode}, {Key: "version", Value: version}}
+	// The first note is inserted, a concurrent first note then hits the unique appointmentCode index
+	result, err := s.collection.ReplaceOne(ctx, filter, note, options.Replace().SetUpsert(version == 0))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+type mongoRequestStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoRequestStore) Insert(ctx context.Context, request AppointmentDeleteRequest) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, request)
+	return err
+}
+
+func (s *mongoRequestStore) Get(ctx context.Context, requestCode string) (*AppointmentDeleteRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findOne[AppointmentDeleteRequest](ctx, s.collection, bson.D{{Key: "requestCode", Value: requestCode}})
+}
+
+func (s *mongoRequestStore) List(ctx context.Context) ([]AppointmentDeleteRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[AppointmentDeleteRequest](ctx, s.collection, bson.D{})
+}
+
+func (s *mongoRequestStore) Find(ctx context.Context, f RequestFilter, query listQuery[AppointmentDeleteRequest]) ([]AppointmentDeleteRequest, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{}
+	if f.DoctorCode != "" {
+		filter = append(filter, bson.E{Key: "doctorCode", Value: f.DoctorCode})
+	}
+	if f.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: f.Status})
+	}
+	return findPage(ctx, s.collection, filter, query)
+}
+
+func (s *mongoRequestStore) ListByDoctor(ctx context.Context, doctorCode string) ([]AppointmentDeleteRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[AppointmentDeleteRequest](ctx, s.collection, bson.D{{Key: "doctorCode", Value: doctorCode}})
+}
+
+func (s *mongoRequestStore) UpdateStatus(ctx context.Context, requestCode, from, status string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"requestCode": requestCode, "status": from}
+	if from == "" {
+		// Requests filed before they had a status have none stored
+		filter["status"] = bson.M{"$in": bson.A{"", nil}}
+	}
+	update := bson.M{"$set": bson.M{"status": status, "updatedAt": time.Now()}}
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := s.collection.CountDocuments(ctx, bson.D{{Key: "requestCode", Value: requestCode}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+func (s *mongoRequestStore) Delete(ctx context.Context, requestCode string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.DeleteOne(ctx, bson.D{{Key: "requestCode", Value: requestCode}})
+	return err
+}
+
+func (s *mongoRequestStore) CountByStatus(ctx context.Context, status string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.collection.CountDocuments(ctx, bson.D{{Key: "status", Value: status}})
+}
+
+type mongoLocationStore struct {
+	mongoTimeout
+	provinces *mongo.Collection
+	districts *mongo.Collection
+}
+
+func (s *mongoLocationStore) ListProvinces(ctx context.Context) ([]Province, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[Province](ctx, s.provinces, bson.D{})
+}
+
+func (s *mongoLocationStore) ListDistrictsByProvince(ctx context.Context, provinceCode int) ([]District, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return findAll[District](ctx, s.districts, bson.D{{Key: "provinceCode", Value: provinceCode}})
+}
+
+type mongoAuditStore struct {
+	mongoTimeout
+	collection *mongo.Collection
+}
+
+func (s *mongoAuditStore) Insert(ctx context.Context, entry AuditEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (s *mongoAuditStore) Find(ctx context.Context, f AuditFilter, query listQuery[AuditEntry]) ([]AuditEntry, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{}
+	if f.Actor != "" {
+		filter = append(filter, bson.E{Key: "actor", Value: f.Actor})
+	}
+	if f.TargetType != "" {
+		filter = append(filter, bson.E{Key: "targetType", Value: f.TargetType})
+	}
+	if f.TargetCode != "" {
+		filter = append(filter, bson.E{Key: "targetCode", Value: f.TargetCode})
+	}
+	timeRange := bson.M{}
+	if !f.From.IsZero() {
+		timeRange["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		timeRange["$lte"] = f.To
+	}
+	if len(timeRange) > 0 {
+		filter = append(filter, bson.E{Key: "at", Value: timeRange})
+	}
+	return findPage(ctx, s.collection, filter, query)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrInvalidTimeZone is returned for a hospital time zone that is not an IANA zone name
+var ErrInvalidTimeZone = errors.New("invalid time zone")
+
+// appointmentLayout is how the date and time of an appointment read together
+const appointmentLayout = "2006-01-02 15:04"
+
+// locations caches the loaded zones, time.LoadLocation reads the zone database every time
+var locations sync.Map
+
+// loadLocation returns the IANA zone with the name
+func loadLocation(zone string) (*time.Location, error) {
+	if loc, ok := locations.Load(zone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(zone, loc)
+	return loc, nil
+}
+
+// location returns the zone with the name, the configured default zone for an empty
+// or unknown one
+func location(app *App, zone string) *time.Location {
+	if zone == "" {
+		return defaultLocation(app)
+	}
+	loc, err := loadLocation(zone)
+	if err != nil {
+		return defaultLocation(app)
+	}
+	return loc
+}
+
+// validTimeZone checks the zone a hospital is saved with, empty keeps the configured default
+func validTimeZone(zone string) error {
+	if zone == "" {
+		return nil
+	}
+	if _, err := loadLocation(zone); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidTimeZone, zone)
+	}
+	return nil
+}
+
+// defaultLocation is the zone of hospitals that set none, the server's own zone only
+// when none is configured
+func defaultLocation(app *App) *time.Location {
+	loc, err := loadLocation(app.Config.Scheduling.TimeZone)
+	if app.Config.Scheduling.TimeZone == "" || err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// hospitalZone is the zone the appointment times of the hospital are given in
+func hospitalZone(app *App, hospital *Hospital) string {
+	if hospital != nil && hospital.TimeZone != "" {
+		return hospital.TimeZone
+	}
+	return app.Config.Scheduling.TimeZone
+}
+
+// doctorZone is the zone of the doctor's hospital, the configured default when the
+// hospital cannot be read
+func doctorZone(ctx context.Context, app *App, doctor *Doctor) string {
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		return hospitalZone(app, nil)
+	}
+	return hospitalZone(app, hospital)
+}
+
+// doctorNow is the current time in the zone of the doctor's hospital, the dates and
+// slot times of the doctor read in it
+func doctorNow(ctx context.Context, app *App, doctor *Doctor) time.Time {
+	return time.Now().In(location(app, doctorZone(ctx, app, doctor)))
+}
+
+// Start returns the instant the appointment starts, its date and time read as the
+// wall clock of its time zone. Appointments without one read in the configured default.
+func (a Appointment) Start(app *App) (time.Time, error) {
+	return time.ParseInLocation(appointmentLayout, a.AppointmentTime.Date+" "+a.AppointmentTime.Time, location(app, a.TimeZone))
+}
+
+// placeIn sets the time zone of the appointment and the start instant it gives its
+// date and time
+func (a *Appointment) placeIn(app *App, zone string) error {
+	a.TimeZone = zone
+	start, err := a.Start(app)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrInvalidSlot, a.AppointmentTime.Date, a.AppointmentTime.Time)
+	}
+	a.StartsAt = start.UTC()
+	return nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAppointmentTimeZone(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+
+	date := time.Now().AddDate(0, 0, 5).Format("2006-01-02")
+	booked, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: AppointmentTime{Date: date, Time: "10:00"}})
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	// Istanbul keeps UTC+3 all year
+	if booked.TimeZone != "Europe/Istanbul" || booked.StartsAt.UTC().Format("15:04") != "07:00" {
+		t.Fatalf("want 10:00 Istanbul stored as 07:00 UTC, got %s in %q", booked.StartsAt, booked.TimeZone)
+	}
+	start, _, err := calendarSpan(app, *booked)
+	if err != nil || start.Location().String() != "Europe/Istanbul" || start.Format("15:04") != "10:00" {
+		t.Fatalf("want the calendar event at 10:00 Istanbul, got %s (%v)", start, err)
+	}
+
+	hospital, _ := app.Store.Hospitals.Get(ctx, 1)
+	hospital.TimeZone = "Mars/Olympus_Mons"
+	if _, err := UpdateHospital(ctx, app, *hospital); !errors.Is(err, ErrInvalidTimeZone) {
+		t.Fatalf("want an unknown zone refused, got %v", err)
+	}
+	hospital.TimeZone = "America/New_York"
+	if _, err := UpdateHospital(ctx, app, *hospital); err != nil {
+		t.Fatalf("update hospital: %v", err)
+	}
+
+	// A move takes the zone of the hospital the doctor works at now
+	moved, err := RescheduleAppointment(ctx, app, booked.AppointmentCode, AppointmentTime{Date: date, Time: "11:00"})
+	if err != nil {
+		t.Fatalf("reschedule: %v", err)
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+	want, _ := time.ParseInLocation("2006-01-02 15:04", date+" 11:00", newYork)
+	if moved.TimeZone != "America/New_York" || !moved.StartsAt.Equal(want) {
+		t.Fatalf("want 11:00 New York, got %s in %q", moved.StartsAt, moved.TimeZone)
+	}
+
+	// The same wall clock time has passed east of the date line but not west of it
+	now := time.Now()
+	kiritimati, _ := time.LoadLocation("Pacific/Kiritimati")
+	earlier := now.In(kiritimati).Add(-time.Hour)
+	at := AppointmentTime{Date: earlier.Format("2006-01-02"), Time: earlier.Format("15:04")}
+	if isFuture(app, Appointment{AppointmentTime: at, TimeZone: "Pacific/Kiritimati"}, now) {
+		t.Fatalf("%v has passed in Kiritimati", at)
+	}
+	if !isFuture(app, Appointment{AppointmentTime: at, TimeZone: "Pacific/Pago_Pago"}, now) {
+		t.Fatalf("%v is ahead in Pago Pago", at)
+	}
+}
+
+func TestAppointmentWithoutTimeZone(t *testing.T) {
+	app := newBookingTestApp(t)
+	// The server runs far from the hospital, as with TZ=America/Los_Angeles
+	local := time.Local
+	time.Local, _ = time.LoadLocation("America/Los_Angeles")
+	t.Cleanup(func() { time.Local = local })
+
+	now := time.Now()
+	istanbul, _ := time.LoadLocation("Europe/Istanbul")
+	earlier := now.In(istanbul).Add(-time.Hour)
+	legacy := Appointment{AppointmentTime: AppointmentTime{Date: earlier.Format("2006-01-02"), Time: earlier.Format("15:04")}}
+	if isFuture(app, legacy, now) {
+		t.Fatalf("%v has passed in the hospital zone", legacy.AppointmentTime)
+	}
+	start, err := legacy.Start(app)
+	if err != nil || start.Location().String() != "Europe/Istanbul" {
+		t.Fatalf("want an appointment without a zone read in Istanbul, got %s (%v)", start, err)
+	}
+}
@@ -1,16 +1,14 @@
 package api
 
 import (
+	"backend/config"
 	"backend/helper"
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -22,6 +20,7 @@ type User struct {
 	Role      string    `bson:"role" json:"role"`
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	Tombstone `bson:",inline"`
 }
 
 type LoginRequest struct {
@@ -47,23 +46,8 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-// Secret keys for JWT tokens
-var secretKey = []byte(getSecretKey("JWT_SECRET", "supersecretkey1234"))
-var refreshSecretKey = []byte(getSecretKey("JWT_REFRESH_SECRET", "refreshsupersecretkey1234"))
-
-// getSecretKey gets a secret key from environment variable with fallback
-func getSecretKey(envKey, fallback string) string {
-	if key := os.Getenv(envKey); key != "" {
-		return key
-	}
-	return fallback
-}
-
-func LoginUser(client *mongo.Client, input LoginRequest) (TokenResponse, error) {
-	collection := client.Database("users").Collection("users")
-	filter := bson.D{{Key: "email", Value: input.Email}}
-	var user User
-	err := collection.FindOne(context.TODO(), filter).Decode(&user)
+func LoginUser(ctx context.Context, app *App, input LoginRequest) (TokenResponse, error) {
+	user, err := app.Store.Users.GetByEmail(ctx, input.Email)
 	if err != nil {
 		return TokenResponse{}, errors.New("no such user")
 	}
@@ -75,7 +59,7 @@ func LoginUser(client *mongo.Client, input LoginRequest) (TokenResponse, error)
 		return TokenResponse{}, errors.New("incorrect password")
 	}
 
-	accessToken, refreshToken, expiresIn, err := generateTokens(user.UserCode, user.Role)
+	accessToken, refreshToken, expiresIn, err := generateTokens(app.Config.JWT, user.UserCode, user.Role)
 	if err != nil {
 		return TokenResponse{}, errors.New("could not generate token")
 	}
@@ -89,9 +73,8 @@ func LoginUser(client *mongo.Client, input LoginRequest) (TokenResponse, error)
 	}, nil
 }
 
-func RegisterUser(client *mongo.Client, user User) (TokenResponse, error) {
-	collection := client.Database("users").Collection("users")
-	if err := collection.FindOne(context.TODO(), bson.D{{Key: "email", Value: user.Email}}).Err(); err == nil {
+func RegisterUser(ctx context.Context, app *App, user User) (TokenResponse, error) {
+	if _, err := app.Store.Users.GetByEmail(ctx, user.Email); err == nil {
 		return TokenResponse{}, errors.New("email already exists")
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
@@ -102,16 +85,18 @@ func RegisterUser(client *mongo.Client, user User) (TokenResponse, error) {
 	user.UserCode = helper.GenerateID(8)
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
+	user.Tombstone = Tombstone{}
 
-	accessToken, refreshToken, expiresIn, err := generateTokens(user.UserCode, user.Role)
+	accessToken, refreshToken, expiresIn, err := generateTokens(app.Config.JWT, user.UserCode, user.Role)
 	if err != nil {
 		return TokenResponse{}, errors.New("could not generate token")
 	}
 
-	_, err = collection.InsertOne(context.TODO(), user)
+	err = app.Store.Users.Insert(ctx, user)
 	if err != nil {
 		return TokenResponse{}, err
 	}
+	recordAudit(ctx, app, AuditCreate, TargetUser, user.UserCode, nil, user)
 
 	return TokenResponse{
 		AccessToken:  accessToken,
@@ -122,43 +107,86 @@ func RegisterUser(client *mongo.Client, user User) (TokenResponse, error) {
 	}, nil
 }
 
-func DeleteUser(client *mongo.Client, userCode string) {
-	collection := client.Database("users").Collection("users")
-	collection.FindOneAndDelete(context.TODO(), bson.D{{Key: "userCode", Value: userCode}})
-}
+// DeleteUser soft deletes the user. The email stays taken and the profile is kept
+// until the user is purged. The future appointments of the user are blocked on or
+// cancelled by policy, they cannot be reassigned.
+func DeleteUser(ctx context.Context, app *App, userCode, deletedBy string, policy DeletionPolicy) (*DeletionReport, error) {
+	if policy == PolicyReassign {
+		return nil, fmt.Errorf("%w: appointments of a user cannot be reassigned", ErrInvalidPolicy)
+	}
+	user, err := app.Store.Users.GetByCode(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+	appointments, err := app.Store.Appointments.ListByUser(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+	appointments = onlyFuture(app, appointments)
+	if err := checkPolicy(policy, appointments); err != nil {
+		return nil, err
+	}
 
-func GetAllUsers(client *mongo.Client) []User {
-	collection := client.Database("users").Collection("users")
+	tombstone := newTombstone(deletedBy)
+	if err := app.Store.Users.SoftDelete(ctx, userCode, tombstone); err != nil {
+		return nil, err
+	}
 
-	filter := bson.D{{}}
+	deleted := *user
+	deleted.Tombstone = tombstone
+	recordAudit(ctx, app, AuditDelete, TargetUser, userCode, user, deleted)
 
-	cursor, _ := collection.Find(context.TODO(), filter)
-	defer cursor.Close(context.TODO())
+	report := newDeletionReport(policy)
+	resolveAppointments(ctx, app, policy, appointments, StatusCancelledByPatient, "user deleted", deletedBy, report)
+	return report, nil
+}
 
-	var users []User
-	cursor.All(context.TODO(), &users)
+// RestoreUser brings back a soft deleted user
+func RestoreUser(ctx context.Context, app *App, userCode string) error {
+	user, err := app.Store.Users.Restore(ctx, userCode)
+	if err != nil {
+		return err
+	}
+
+	restored := *user
+	restored.Tombstone = Tombstone{}
+	recordAudit(ctx, app, AuditRestore, TargetUser, userCode, user, restored)
+	return nil
+}
+
+func GetAllUsers(ctx context.Context, app *App) []User {
+	users, _ := app.Store.Users.List(ctx)
 
 	return users
 }
 
-func GetUser(client *mongo.Client, userCode string) (*User, error) {
-	collection := client.Database("users").Collection("users")
+// ListUsers returns one page of users matching filter
+func ListUsers(ctx context.Context, app *App, filter UserFilter, opts ListOptions) (*Page[User], error) {
+	query, err := newListQuery(opts, userSortFields, "createdAt", "userCode")
+	if err != nil {
+		return nil, err
+	}
 
-	filter := bson.D{{Key: "userCode", Value: userCode}}
-	var user User
+	users, total, err := app.Store.Users.Find(ctx, filter, query)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(users, total, query), nil
+}
 
-	err := collection.FindOne(context.TODO(), filter).Decode(&user)
+func GetUser(ctx context.Context, app *App, userCode string) (*User, error) {
+	user, err := app.Store.Users.GetByCode(ctx, userCode)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == ErrNotFound {
 			return nil, errors.New("no such user")
 		}
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func generateJWT(userCode, userRole string) (string, error) {
+func generateJWT(secret []byte, userCode, userRole string) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
 	claims := Claims{
 		UserCode: userCode,
@@ -169,10 +197,10 @@ func generateJWT(userCode, userRole string) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	return token.SignedString(secret)
 }
 
-func generateRefreshToken(userCode, userRole string) (string, error) {
+func generateRefreshToken(secret []byte, userCode, userRole string) (string, error) {
 	expirationTime := time.Now().Add(7 * 24 * time.Hour) // 7 days
 	claims := Claims{
 		UserCode: userCode,
@@ -183,19 +211,19 @@ func generateRefreshToken(userCode, userRole string) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(refreshSecretKey)
+	return token.SignedString(secret)
 }
 
-func generateTokens(userCode, userRole string) (string, string, int64, error) {
+func generateTokens(secrets config.JWTConfig, userCode, userRole string) (string, string, int64, error) {
 	// Generate access token (now with 1-day expiration)
 	expirationTime := time.Now().Add(24 * time.Hour)
-	accessToken, err := generateJWT(userCode, userRole)
+	accessToken, err := generateJWT([]byte(secrets.Secret), userCode, userRole)
 	if err != nil {
 		return "", "", 0, err
 	}
 
 	// Generate refresh token (long-lived)
-	refreshToken, err := generateRefreshToken(userCode, userRole)
+	refreshToken, err := generateRefreshToken([]byte(secrets.RefreshSecret), userCode, userRole)
 	if err != nil {
 		return "", "", 0, err
 	}
@@ -206,9 +234,9 @@ func generateTokens(userCode, userRole string) (string, string, int64, error) {
 	return accessToken, refreshToken, expiresIn, nil
 }
 
-func RefreshToken(refreshTokenString string) (TokenResponse, error) {
+func RefreshToken(ctx context.Context, app *App, refreshTokenString string) (TokenResponse, error) {
 	token, err := jwt.ParseWithClaims(refreshTokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return refreshSecretKey, nil
+		return []byte(app.Config.JWT.RefreshSecret), nil
 	})
 
 	if err != nil {
@@ -220,8 +248,13 @@ func RefreshToken(refreshTokenString string) (TokenResponse, error) {
 		return TokenResponse{}, errors.New("invalid refresh token")
 	}
 
+	// Deleted users cannot extend their session
+	if _, err := app.Store.Users.GetByCode(ctx, claims.UserCode); err != nil {
+		return TokenResponse{}, errors.New("invalid refresh token")
+	}
+
 	// Generate new tokens
-	accessToken, refreshToken, expiresIn, err := generateTokens(claims.UserCode, claims.Role)
+	accessToken, refreshToken, expiresIn, err := generateTokens(app.Config.JWT, claims.UserCode, claims.Role)
 	if err != nil {
 		return TokenResponse{}, err
 	}
@@ -251,17 +284,17 @@ func CheckPasswordHash(password, hash string) bool {
 }
 
 // UpdateUserPassword updates a user's password in the database
-func UpdateUserPassword(client *mongo.Client, userCode string, newPasswordHash string) error {
-	collection := client.Database("users").Collection("users")
-
-	filter := bson.D{{Key: "userCode", Value: userCode}}
-	update := bson.M{
-		"$set": bson.M{
-			"password":  newPasswordHash,
-			"updatedAt": time.Now(),
-		},
+func UpdateUserPassword(ctx context.Context, app *App, userCode string, newPasswordHash string) error {
+	user, err := app.Store.Users.GetByCode(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	if err := app.Store.Users.UpdatePassword(ctx, userCode, newPasswordHash); err != nil {
+		return err
 	}
 
-	_, err := collection.UpdateOne(context.TODO(), filter, update)
-	return err
+	updated := *user
+	updated.Password = newPasswordHash
+	recordAudit(ctx, app, AuditUpdate, TargetUser, userCode, user, updated)
+	return nil
 }
@@ -4,9 +4,6 @@ import (
 	"context"
 	"errors"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // UserAdditionalInfo represents the additional profile information for a user
@@ -31,57 +28,27 @@ type UserAdditionalInfo struct {
 }
 
 // CreateUserAdditionalInfo creates or updates the additional information for a user
-func CreateUserAdditionalInfo(client *mongo.Client, info UserAdditionalInfo) error {
-	collection := client.Database("users").Collection("userAdditionalInfo")
-
+func CreateUserAdditionalInfo(ctx context.Context, app *App, info UserAdditionalInfo) error {
 	// Set timestamps
 	info.UpdatedAt = time.Now()
 
 	// Check if a record already exists for this user
-	filter := bson.D{{Key: "userCode", Value: info.UserCode}}
-	var existingInfo UserAdditionalInfo
-	err := collection.FindOne(context.TODO(), filter).Decode(&existingInfo)
+	existing, err := app.Store.UserInfo.Get(ctx, info.UserCode)
 
 	if err == nil {
 		// Update existing record
-		update := bson.M{
-			"$set": bson.M{
-				"firstName":          info.FirstName,
-				"lastName":           info.LastName,
-				"email":              info.Email,
-				"phone":              info.Phone,
-				"address":            info.Address,
-				"birthDate":          info.BirthDate,
-				"gender":             info.Gender,
-				"bloodType":          info.BloodType,
-				"height":             info.Height,
-				"weight":             info.Weight,
-				"allergies":          info.Allergies,
-				"chronicDiseases":    info.ChronicDiseases,
-				"currentMedications": info.CurrentMedications,
-				"updatedAt":          info.UpdatedAt,
-			},
-		}
-		_, err = collection.UpdateOne(context.TODO(), filter, update)
-		return err
+		return updateUserAdditionalInfo(ctx, app, existing, info)
 	}
 
 	// Create new record
-	info.CreatedAt = time.Now()
-	_, err = collection.InsertOne(context.TODO(), info)
-	return err
+	return insertUserAdditionalInfo(ctx, app, info)
 }
 
 // GetUserAdditionalInfo retrieves the additional profile information for a user
-func GetUserAdditionalInfo(client *mongo.Client, userCode string) (*UserAdditionalInfo, error) {
-	collection := client.Database("users").Collection("userAdditionalInfo")
-
-	filter := bson.D{{Key: "userCode", Value: userCode}}
-	var info UserAdditionalInfo
-
-	err := collection.FindOne(context.TODO(), filter).Decode(&info)
+func GetUserAdditionalInfo(ctx context.Context, app *App, userCode string) (*UserAdditionalInfo, error) {
+	info, err := app.Store.UserInfo.Get(ctx, userCode)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == ErrNotFound {
 			// Return an empty record if not found
 			return &UserAdditionalInfo{
 				UserCode:  userCode,
@@ -92,13 +59,11 @@ func GetUserAdditionalInfo(client *mongo.Client, userCode string) (*UserAddition
 		return nil, err
 	}
 
-	return &info, nil
+	return info, nil
 }
 
 // UpdateUserAdditionalInfo updates the additional profile information for a user
-func UpdateUserAdditionalInfo(client *mongo.Client, info UserAdditionalInfo) error {
-	collection := client.Database("users").Collection("userAdditionalInfo")
-
+func UpdateUserAdditionalInfo(ctx context.Context, app *App, info UserAdditionalInfo) error {
 	if info.UserCode == "" {
 		return errors.New("userCode is required")
 	}
@@ -106,51 +71,50 @@ func UpdateUserAdditionalInfo(client *mongo.Client, info UserAdditionalInfo) err
 	// Set updated timestamp
 	info.UpdatedAt = time.Now()
 
-	filter := bson.D{{Key: "userCode", Value: info.UserCode}}
-
 	// Check if record exists
-	var existingInfo UserAdditionalInfo
-	err := collection.FindOne(context.TODO(), filter).Decode(&existingInfo)
+	existing, err := app.Store.UserInfo.Get(ctx, info.UserCode)
 
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == ErrNotFound {
 			// Create new record if not found
-			info.CreatedAt = time.Now()
-			_, err = collection.InsertOne(context.TODO(), info)
-			return err
+			return insertUserAdditionalInfo(ctx, app, info)
 		}
 		return err
 	}
 
 	// Update existing record
-	update := bson.M{
-		"$set": bson.M{
-			"firstName":          info.FirstName,
-			"lastName":           info.LastName,
-			"email":              info.Email,
-			"phone":              info.Phone,
-			"address":            info.Address,
-			"birthDate":          info.BirthDate,
-			"gender":             info.Gender,
-			"bloodType":          info.BloodType,
-			"height":             info.Height,
-			"weight":             info.Weight,
-			"allergies":          info.Allergies,
-			"chronicDiseases":    info.ChronicDiseases,
-			"currentMedications": info.CurrentMedications,
-			"updatedAt":          info.UpdatedAt,
-		},
+	return updateUserAdditionalInfo(ctx, app, existing, info)
+}
+
+func insertUserAdditionalInfo(ctx context.Context, app *App, info UserAdditionalInfo) error {
+	info.CreatedAt = time.Now()
+	if err := app.Store.UserInfo.Insert(ctx, info); err != nil {
+		return err
 	}
+	recordAudit(ctx, app, AuditCreate, TargetUserProfile, info.UserCode, nil, info)
+	return nil
+}
 
-	_, err = collection.UpdateOne(context.TODO(), filter, update)
-	return err
+func updateUserAdditionalInfo(ctx context.Context, app *App, existing *UserAdditionalInfo, info UserAdditionalInfo) error {
+	if err := app.Store.UserInfo.Update(ctx, info); err != nil {
+		return err
+	}
+	// The store keeps the id and creation time of the existing record
+	info.ID = existing.ID
+	info.CreatedAt = existing.CreatedAt
+	recordAudit(ctx, app, AuditUpdate, TargetUserProfile, info.UserCode, existing, info)
+	return nil
 }
 
 // DeleteUserAdditionalInfo deletes the additional profile information for a user
-func DeleteUserAdditionalInfo(client *mongo.Client, userCode string) error {
-	collection := client.Database("users").Collection("userAdditionalInfo")
-
-	filter := bson.D{{Key: "userCode", Value: userCode}}
-	_, err := collection.DeleteOne(context.TODO(), filter)
-	return err
+func DeleteUserAdditionalInfo(ctx context.Context, app *App, userCode string) error {
+	existing, err := app.Store.UserInfo.Get(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	if err := app.Store.UserInfo.Delete(ctx, userCode); err != nil {
+		return err
+	}
+	recordAudit(ctx, app, AuditDelete, TargetUserProfile, userCode, existing, nil)
+	return nil
 }
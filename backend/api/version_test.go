@@ -0,0 +1,56 @@
+package api
+
+import (
+	"backend/config"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestUpdateDoctorRejectsStaleVersion(t *testing.T) {
+	store := NewMemoryStore()
+	app := &App{Store: store, Config: config.Defaults()}
+	ctx := context.Background()
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 1, Fields: []int{1}})
+	store.Doctors.Insert(ctx, Doctor{DoctorCode: "doc1", DoctorName: "Before", HospitalCode: 1, FieldCode: 1})
+
+	// Two admins read the same doctor
+	first, _ := GetDoctor(ctx, app, "doc1")
+	second, _ := GetDoctor(ctx, app, "doc1")
+
+	first.DoctorName = "First"
+	updated, err := UpdateDoctor(ctx, app, *first)
+	if err != nil || updated.Version != 1 {
+		t.Fatalf("first update: %+v (%v)", updated, err)
+	}
+
+	second.DoctorName = "Second"
+	if _, err := UpdateDoctor(ctx, app, *second); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("want a version conflict, got %v", err)
+	}
+	if doctor, _ := GetDoctor(ctx, app, "doc1"); doctor.DoctorName != "First" {
+		t.Fatalf("stale update overwrote the doctor: %+v", doctor)
+	}
+}
+
+func TestFieldChecksBumpHospitalVersion(t *testing.T) {
+	store := NewMemoryStore()
+	app := &App{Store: store, Config: config.Defaults()}
+	ctx := context.Background()
+	store.Hospitals.Insert(ctx, Hospital{HospitalCode: 1, HospitalName: "Before", Fields: []int{1}})
+
+	edited, _ := GetHospital(ctx, app, 1)
+	CreateDoctor(ctx, app, Doctor{DoctorName: "New", HospitalCode: 1, FieldCode: 2})
+
+	hospital, _ := GetHospital(ctx, app, 1)
+	if !slices.Equal(hospital.Fields, []int{1, 2}) || hospital.Version != 1 {
+		t.Fatalf("field not added in place: %+v", hospital)
+	}
+
+	// An admin edit based on the old field list must not drop the new field
+	edited.HospitalName = "After"
+	if _, err := UpdateHospital(ctx, app, *edited); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("want a version conflict, got %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+package api
+
+import (
+	"backend/helper"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// WaitlistStatus is the state of a waitlist entry
+type WaitlistStatus string
+
+const (
+	// WaitlistWaiting entries are next in line for a freed slot
+	WaitlistWaiting WaitlistStatus = "waiting"
+	// WaitlistOffered entries hold a slot until the offer expires
+	WaitlistOffered WaitlistStatus = "offered"
+	// WaitlistBooked entries accepted their offer
+	WaitlistBooked WaitlistStatus = "booked"
+	// WaitlistExpired entries let their offer run out or outlived their date range
+	WaitlistExpired WaitlistStatus = "expired"
+	// WaitlistCancelled entries were left by the patient
+	WaitlistCancelled WaitlistStatus = "cancelled"
+)
+
+var (
+	// ErrInvalidWaitlist is returned for a waitlist request without a doctor or a
+	// hospital and field, or with a bad date range
+	ErrInvalidWaitlist = errors.New("invalid waitlist request")
+	// ErrAlreadyWaitlisted is returned when the patient already waits for the same doctor or field
+	ErrAlreadyWaitlisted = errors.New("already on the waitlist")
+	// ErrNoOffer is returned when accepting an entry that has no open offer
+	ErrNoOffer = errors.New("no open slot offer")
+	// ErrOfferExpired is returned when the offer ran out before it was accepted
+	ErrOfferExpired = errors.New("slot offer expired")
+)
+
+// WaitlistEntry is a patient waiting for a slot of a doctor, or of any doctor of a
+// field in a hospital, between DateFrom and DateTo
+type WaitlistEntry struct {
+	EntryCode string `bson:"entryCode" json:"entryCode"`
+	UserCode  string `bson:"userCode" json:"userCode"`
+	// DoctorCode is empty when any doctor of the field in the hospital will do
+	DoctorCode   string         `bson:"doctorCode" json:"doctorCode,omitempty"`
+	HospitalCode int            `bson:"hospitalCode" json:"hospitalCode"`
+	FieldCode    int            `bson:"fieldCode" json:"fieldCode"`
+	DateFrom     string         `bson:"dateFrom" json:"dateFrom"`
+	DateTo       string         `bson:"dateTo" json:"dateTo"`
+	Status       WaitlistStatus `bson:"status" json:"status"`
+	// Offer is the slot held for the patient while the entry is offered
+	Offer           *SlotOffer `bson:"offer,omitempty" json:"offer,omitempty"`
+	AppointmentCode string     `bson:"appointmentCode,omitempty" json:"appointmentCode,omitempty"`
+	CreatedAt       time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time  `bson:"updatedAt" json:"updatedAt"`
+}
+
+// SlotOffer is a freed slot held for a waitlisted patient until ExpiresAt
+type SlotOffer struct {
+	DoctorCode      string          `bson:"doctorCode" json:"doctorCode"`
+	AppointmentTime AppointmentTime `bson:"appointmentTime" json:"appointmentTime"`
+	// Minutes is the length the slot is held with
+	Minutes   int       `bson:"minutes" json:"minutes"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// Serves reports whether a slot of doctor fits the entry
+func (e WaitlistEntry) Serves(doctor Doctor) bool {
+	if e.DoctorCode != "" {
+		return e.DoctorCode == doctor.DoctorCode
+	}
+	return e.HospitalCode == doctor.HospitalCode && e.FieldCode == doctor.FieldCode
+}
+
+// active reports whether the entry still waits for or holds a slot
+func (e WaitlistEntry) active() bool {
+	return e.Status == WaitlistWaiting || e.Status == WaitlistOffered
+}
+
+// holdCode is the holder name of the slot lock kept for an offered entry
+func holdCode(entryCode string) string {
+	return "waitlist:" + entryCode
+}
+
+// offeredAppointment is the appointment the offer of the entry books
+func (e WaitlistEntry) offeredAppointment() Appointment {
+	return Appointment{
+		DoctorCode:      e.Offer.DoctorCode,
+		UserCode:        e.UserCode,
+		AppointmentTime: e.Offer.AppointmentTime,
+		Duration:        e.Offer.Minutes,
+	}
+}
+
+// holdLocks are the slot locks held for the offer of the entry
+func (e WaitlistEntry) holdLocks() []SlotLock {
+	hold := e.offeredAppointment()
+	hold.AppointmentCode = holdCode(e.EntryCode)
+	return slotLocksFor(hold)
+}
+
+// WaitlistRequest asks for a place on the waitlist of a doctor, or of a field in a
+// hospital. FieldCode is nil when no field is given, field 0 is General Medicine.
+type WaitlistRequest struct {
+	UserCode     string `json:"userCode"`
+	DoctorCode   string `json:"doctorCode"`
+	HospitalCode int    `json:"hospitalCode"`
+	FieldCode    *int   `json:"fieldCode"`
+	DateFrom     string `json:"dateFrom"`
+	DateTo       string `json:"dateTo"`
+}
+
+// JoinWaitlist puts the patient on the waitlist of a doctor, or of a field in a
+// hospital when request.DoctorCode is empty. DateFrom defaults to today.
+func JoinWaitlist(ctx context.Context, app *App, request WaitlistRequest) (*WaitlistEntry, error) {
+	if request.DoctorCode == "" && (request.HospitalCode == 0 || request.FieldCode == nil) {
+		return nil, fmt.Errorf("%w: a doctor or a hospital and field is required", ErrInvalidWaitlist)
+	}
+	entry := WaitlistEntry{
+		UserCode:     request.UserCode,
+		DoctorCode:   request.DoctorCode,
+		HospitalCode: request.HospitalCode,
+		DateFrom:     request.DateFrom,
+		DateTo:       request.DateTo,
+	}
+	if request.FieldCode != nil {
+		entry.FieldCode = *request.FieldCode
+	}
+	today := time.Now().In(defaultLocation(app)).Format("2006-01-02")
+	if entry.DateFrom == "" || entry.DateFrom < today {
+		entry.DateFrom = today
+	}
+	for _, date := range []string{entry.DateFrom, entry.DateTo} {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("%w: date %q must be YYYY-MM-DD", ErrInvalidWaitlist, date)
+		}
+	}
+	if entry.DateTo < entry.DateFrom {
+		return nil, fmt.Errorf("%w: the date range has already ended or is reversed", ErrInvalidWaitlist)
+	}
+
+	if entry.DoctorCode != "" {
+		doctor, err := GetDoctor(ctx, app, entry.DoctorCode)
+		if err != nil {
+			return nil, err
+		}
+		entry.HospitalCode = doctor.HospitalCode
+		entry.FieldCode = doctor.FieldCode
+	} else {
+		hospital, err := GetHospital(ctx, app, entry.HospitalCode)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(hospital.Fields, entry.FieldCode) {
+			return nil, fmt.Errorf("%w: the hospital has no doctor of field %d", ErrInvalidWaitlist, entry.FieldCode)
+		}
+	}
+
+	existing, err := app.Store.Waitlist.ListByUser(ctx, entry.UserCode)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range existing {
+		if other.active() && other.DoctorCode == entry.DoctorCode && other.HospitalCode == entry.HospitalCode && other.FieldCode == entry.FieldCode {
+			return nil, ErrAlreadyWaitlisted
+		}
+	}
+
+	entry.EntryCode = helper.GenerateID(8)
+	entry.Status = WaitlistWaiting
+	entry.Offer = nil
+	entry.AppointmentCode = ""
+	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = entry.CreatedAt
+	if err := app.Store.Waitlist.Insert(ctx, entry); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, app, AuditCreate, TargetWaitlist, entry.EntryCode, nil, entry)
+	return &entry, nil
+}
+
+// GetWaitlistEntry returns one waitlist entry
+func GetWaitlistEntry(ctx context.Context, app *App, entryCode string) (*WaitlistEntry, error) {
+	return app.Store.Waitlist.Get(ctx, entryCode)
+}
+
+// GetWaitlistByUserCode lists the waitlist entries of a patient
+func GetWaitlistByUserCode(ctx context.Context, app *App, userCode string) ([]WaitlistEntry, error) {
+	return app.Store.Waitlist.ListByUser(ctx, userCode)
+}
+
+// LeaveWaitlist takes the patient off the waitlist. A held slot is offered to the
+// next patient right away.
+func LeaveWaitlist(ctx context.Context, app *App, entryCode string) error {
+	entry, err := app.Store.Waitlist.Get(ctx, entryCode)
+	if err != nil {
+		return err
+	}
+	if !entry.active() {
+		return nil
+	}
+	return endEntry(ctx, app, *entry, WaitlistCancelled)
+}
+
+// AcceptWaitlistOffer books the slot offered to the entry. The slot is taken over
+// from the hold, so nobody else can book it meanwhile. When the booking fails, for
+// example on the booking policy, the slot moves on to the next patient.
+func AcceptWaitlistOffer(ctx context.Context, app *App, entryCode string) (*Appointment, error) {
+	entry, err := app.Store.Waitlist.Get(ctx, entryCode)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status != WaitlistOffered || entry.Offer == nil {
+		return nil, ErrNoOffer
+	}
+	if !entry.Offer.ExpiresAt.After(time.Now()) {
+		return nil, ErrOfferExpired
+	}
+
+	// Take the offer first so the sweeper cannot expire it under the booking
+	accepted := *entry
+	accepted.Status = WaitlistBooked
+	accepted.UpdatedAt = time.Now()
+	if err := app.Store.Waitlist.Replace(ctx, accepted, WaitlistOffered); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			return nil, ErrOfferExpired
+		}
+		return nil, err
+	}
+
+	offered := entry.offeredAppointment()
+	offered.Duration = appointmentMinutes(offered)
+	appointment, err := createAppointment(ctx, app, offered, holdCode(entry.EntryCode))
+	if err != nil {
+		failed := accepted
+		failed.Status = WaitlistExpired
+		if replaceErr := app.Store.Waitlist.Replace(context.WithoutCancel(ctx), failed, WaitlistBooked); replaceErr != nil {
+			log.Println("Error expiring waitlist entry:", replaceErr)
+		}
+		rollOver(ctx, app, *entry)
+		return nil, err
+	}
+
+	accepted.AppointmentCode = appointment.AppointmentCode
+	if err := app.Store.Waitlist.Replace(ctx, accepted, WaitlistBooked); err != nil {
+		log.Println("Error linking waitlist entry to its appointment:", err)
+	}
+	recordAudit(ctx, app, AuditUpdate, TargetWaitlist, entryCode, entry, accepted)
+	return appointment, nil
+}
+
+// ExpireWaitlistOffers ends the offers that ran out before now and offers their slots
+// to the next patients. Waiting entries whose date range is over expire as well.
+// It returns how many offers expired.
+func ExpireWaitlistOffers(ctx context.Context, app *App, now time.Time) (int, error) {
+	if _, err := app.Store.Waitlist.ExpireWaiting(ctx, now.In(defaultLocation(app)).Format("2006-01-02")); err != nil {
+		return 0, err
+	}
+
+	entries, err := app.Store.Waitlist.ListExpiredOffers(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, entry := range entries {
+		if err := endEntry(ctx, app, entry, WaitlistExpired); err != nil {
+			if !errors.Is(err, ErrVersionConflict) {
+				log.Printf("Error expiring waitlist offer %s: %v", entry.EntryCode, err)
+			}
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// endEntry moves an active entry to status and passes a held slot on
+func endEntry(ctx context.Context, app *App, entry WaitlistEntry, status WaitlistStatus) error {
+	ended := entry
+	ended.Status = status
+	ended.UpdatedAt = time.Now()
+	if err := app.Store.Waitlist.Replace(ctx, ended, entry.Status); err != nil {
+		return err
+	}
+	recordAudit(ctx, app, AuditUpdate, TargetWaitlist, entry.EntryCode, entry, ended)
+
+	if entry.Status == WaitlistOffered && entry.Offer != nil {
+		rollOver(ctx, app, entry)
+	}
+	return nil
+}
+
+// rollOver frees the slot held for an entry and offers it to the next patient
+func rollOver(ctx context.Context, app *App, entry WaitlistEntry) {
+	if err := app.Store.Slots.Release(context.WithoutCancel(ctx), entry.holdLocks()); err != nil {
+		log.Println("Error releasing held slot:", err)
+		return
+	}
+	offerFreedSlot(ctx, app, entry.Offer.DoctorCode, entry.Offer.AppointmentTime)
+}
+
+type heldOffersKey struct{}
+
+// freedSlot is a slot of the doctor whose waitlist offer is held back
+type freedSlot struct {
+	doctorCode string
+	at         AppointmentTime
+}
+
+// holdOffers returns a copy of ctx under which freed slots are collected instead of
+// offered to the waitlist, and the function that offers them. A move of several
+// appointments uses it so that a slot freed by one is still free for the next.
+func holdOffers(ctx context.Context, app *App) (context.Context, func()) {
+	held := &[]freedSlot{}
+	return context.WithValue(ctx, heldOffersKey{}, held), func() {
+		for _, slot := range *held {
+			offerFreedSlot(ctx, app, slot.doctorCode, slot.at)
+		}
+	}
+}
+
+// offerFreedSlot holds a slot that has just become free for the first waitlisted
+// patient it serves and notifies them. Nothing happens when nobody waits or the
+// slot was booked again in the meantime.
+func offerFreedSlot(ctx context.Context, app *App, doctorCode string, at AppointmentTime) {
+	if held, ok := ctx.Value(heldOffersKey{}).(*[]freedSlot); ok {
+		*held = append(*held, freedSlot{doctorCode: doctorCode, at: at})
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	doctor, err := app.Store.Doctors.Get(ctx, doctorCode)
+	if err != nil {
+		// A deleted doctor has no slots to offer
+		return
+	}
+	now := time.Now()
+	if !isFuture(app, Appointment{AppointmentTime: at, TimeZone: doctorZone(ctx, app, doctor)}, now) {
+		return
+	}
+	// A slot the doctor no longer works at, such as one under a new leave, is not offered
+	if err := checkWorkingTime(ctx, app, doctor, Appointment{AppointmentTime: at, Duration: slotLength(app, doctor)}); err != nil {
+		return
+	}
+	entries, err := app.Store.Waitlist.ListWaiting(ctx, *doctor, at.Date)
+	if err != nil {
+		log.Println("Error listing waitlist:", err)
+		return
+	}
+
+	for _, entry := range entries {
+		offered := entry
+		offered.Status = WaitlistOffered
+		offered.Offer = &SlotOffer{
+			DoctorCode:      doctorCode,
+			AppointmentTime: at,
+			Minutes:         slotLength(app, doctor),
+			ExpiresAt:       now.Add(time.Duration(app.Config.Waitlist.OfferTTL)).UTC().Truncate(time.Millisecond),
+		}
+		offered.UpdatedAt = now
+
+		hold := offered.holdLocks()
+		if err := app.Store.Slots.Claim(ctx, hold); err != nil {
+			if !errors.Is(err, ErrSlotTaken) {
+				log.Println("Error holding slot for waitlist:", err)
+			}
+			return
+		}
+		if err := app.Store.Waitlist.Replace(ctx, offered, WaitlistWaiting); err != nil {
+			// The patient left or got another offer meanwhile, try the next one
+			if releaseErr := app.Store.Slots.Release(ctx, hold); releaseErr != nil {
+				log.Println("Error releasing held slot:", releaseErr)
+				return
+			}
+			continue
+		}
+		recordAudit(ctx, app, AuditUpdate, TargetWaitlist, entry.EntryCode, entry, offered)
+		notifyOffer(ctx, app, offered, doctor)
+		return
+	}
+}
+
+// notifyOffer tells the patient about the held slot over WebSocket and email
+func notifyOffer(ctx context.Context, app *App, entry WaitlistEntry, doctor *Doctor) {
+	offer := entry.Offer
+	expiresAt := offer.ExpiresAt.In(location(app, doctorZone(ctx, app, doctor))).Format("15:04")
+
+	if app.Notifier != nil {
+		notification, _ := json.Marshal(map[string]interface{}{
+			"type":      "waitlistOffer",
+			"message":   "Bekleme listesinde olduğunuz Dr. " + doctor.DoctorName + " için bir randevu saati açıldı",
+			"entryCode": entry.EntryCode,
+			"date":      offer.AppointmentTime.Date,
+			"time":      offer.AppointmentTime.Time,
+			"expiresAt": offer.ExpiresAt,
+			"title":     "Randevu Teklifi",
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+		app.Notifier.SendToUser(entry.UserCode, notification)
+	}
+
+	if app.Mailer == nil {
+		return
+	}
+	user, err := app.Store.Users.Lookup(ctx, entry.UserCode)
+	if err != nil {
+		log.Println("Error getting user:", err)
+		return
+	}
+	hospital, err := app.Store.Hospitals.Lookup(ctx, doctor.HospitalCode)
+	if err != nil {
+		log.Println("Error getting hospital:", err)
+		return
+	}
+	err = app.Mailer.SendWaitlistOfferEmail(
+		ctx,
+		user.Email,
+		user.UserCode,
+		doctor.DoctorName,
+		hospital.HospitalName,
+		displayDate(offer.AppointmentTime.Date),
+		offer.AppointmentTime.Time,
+		expiresAt,
+	)
+	if err != nil {
+		log.Println("Error sending waitlist offer email:", err)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitlistOfferRollsOver(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	for _, userCode := range []string{"patient1", "patient2", "patient3", "patient4"} {
+		app.Store.Users.Insert(ctx, User{UserCode: userCode, Role: "patient"})
+	}
+
+	day := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	slot := AppointmentTime{Date: day, Time: "10:00"}
+	booked, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: slot})
+	if err != nil {
+		t.Fatalf("booking: %v", err)
+	}
+
+	var entries []*WaitlistEntry
+	for _, userCode := range []string{"patient2", "patient3"} {
+		entry, err := JoinWaitlist(ctx, app, WaitlistRequest{UserCode: userCode, DoctorCode: "doc1", DateTo: day})
+		if err != nil {
+			t.Fatalf("join waitlist: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := DeleteAppointment(ctx, app, booked.AppointmentCode, "patient1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	first, _ := GetWaitlistEntry(ctx, app, entries[0].EntryCode)
+	if first.Status != WaitlistOffered || first.Offer.AppointmentTime != slot {
+		t.Fatalf("freed slot not offered to the first patient: %+v", first)
+	}
+	// The slot is held for the offer
+	var conflict *SlotConflictError
+	if _, err := CreateAppointment(ctx, app, Appointment{DoctorCode: "doc1", UserCode: "patient4", AppointmentTime: slot}); !errors.As(err, &conflict) {
+		t.Fatalf("held slot was bookable: %v", err)
+	}
+
+	expired, err := ExpireWaitlistOffers(ctx, app, first.Offer.ExpiresAt.Add(time.Second))
+	if err != nil || expired != 1 {
+		t.Fatalf("want 1 expired offer, got %d (%v)", expired, err)
+	}
+	if _, err := AcceptWaitlistOffer(ctx, app, entries[0].EntryCode); !errors.Is(err, ErrNoOffer) {
+		t.Fatalf("expired offer accepted: %v", err)
+	}
+
+	appointment, err := AcceptWaitlistOffer(ctx, app, entries[1].EntryCode)
+	if err != nil || appointment.UserCode != "patient3" || appointment.AppointmentTime != slot {
+		t.Fatalf("rolled over offer not booked: %+v (%v)", appointment, err)
+	}
+	second, _ := GetWaitlistEntry(ctx, app, entries[1].EntryCode)
+	if second.Status != WaitlistBooked || second.AppointmentCode != appointment.AppointmentCode {
+		t.Fatalf("entry not marked booked: %+v", second)
+	}
+}
+
+func TestWaitlistGeneralMedicine(t *testing.T) {
+	app := newBookingTestApp(t)
+	ctx := context.Background()
+	app.Store.Users.Insert(ctx, User{UserCode: "patient1", Role: "patient"})
+	app.Store.Hospitals.Insert(ctx, Hospital{HospitalCode: 2, HospitalName: "Genel Hastane", Fields: []int{0}})
+	day := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+
+	if _, err := JoinWaitlist(ctx, app, WaitlistRequest{UserCode: "patient1", HospitalCode: 2, DateTo: day}); !errors.Is(err, ErrInvalidWaitlist) {
+		t.Fatalf("want a missing field refused, got %v", err)
+	}
+	general := 0
+	entry, err := JoinWaitlist(ctx, app, WaitlistRequest{UserCode: "patient1", HospitalCode: 2, FieldCode: &general, DateTo: day})
+	if err != nil {
+		t.Fatalf("join the General Medicine waitlist: %v", err)
+	}
+	if entry.FieldCode != 0 || entry.DoctorCode != "" {
+		t.Fatalf("want a field 0 entry, got %+v", entry)
+	}
+}
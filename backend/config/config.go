@@ -0,0 +1,456 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// Development-only fallbacks. Validate refuses them outside development mode.
+const (
+	defaultJWTSecret        = "supersecretkey1234"
+	defaultJWTRefreshSecret = "refreshsupersecretkey1234"
+	defaultMongoURI         = "mongodb://localhost:27017"
+)
+
+// Config holds every setting the server needs. It is loaded once at startup
+// and handed to the packages that need it.
+type Config struct {
+	Env         string   `json:"env"`
+	Port        string   `json:"port"`
+	CORSOrigins []string `json:"corsOrigins"`
+
+	Mongo  MongoConfig  `json:"mongo"`
+	JWT    JWTConfig    `json:"jwt"`
+	Mail   MailConfig   `json:"mail"`
+	Google GoogleConfig `json:"google"`
+
+	Retention RetentionConfig `json:"retention"`
+	Waitlist  WaitlistConfig  `json:"waitlist"`
+	Holds     HoldConfig      `json:"holds"`
+
+	Scheduling SchedulingConfig `json:"scheduling"`
+}
+
+type MongoConfig struct {
+	URI string `json:"uri"`
+	// MigrateOnStartup applies pending schema migrations before the server starts
+	MigrateOnStartup bool `json:"migrateOnStartup"`
+	// ConnectTimeout bounds the initial connection and ping
+	ConnectTimeout Duration `json:"connectTimeout"`
+	// OperationTimeout bounds every single database operation
+	OperationTimeout Duration `json:"operationTimeout"`
+}
+
+type JWTConfig struct {
+	Secret        string `json:"secret"`
+	RefreshSecret string `json:"refreshSecret"`
+}
+
+// MailConfig holds the MailerSend API and SMTP settings
+type MailConfig struct {
+	APIKey    string `json:"apiKey"`
+	FromName  string `json:"fromName"`
+	FromEmail string `json:"fromEmail"`
+
+	SMTPHost      string `json:"smtpHost"`
+	SMTPPort      string `json:"smtpPort"`
+	SMTPUsername  string `json:"smtpUsername"`
+	SMTPPassword  string `json:"smtpPassword"`
+	SMTPFromName  string `json:"smtpFromName"`
+	SMTPFromEmail string `json:"smtpFromEmail"`
+
+	// Timeout bounds sending one email
+	Timeout Duration `json:"timeout"`
+}
+
+type GoogleConfig struct {
+	Enabled         bool   `json:"enabled"`
+	CalendarID      string `json:"calendarId"`
+	Credentials     string `json:"credentials"`
+	CredentialsFile string `json:"credentialsFile"`
+	TokenFile       string `json:"tokenFile"`
+
+	// Timeout bounds one Calendar API call
+	Timeout Duration `json:"timeout"`
+}
+
+// RetentionConfig controls how long soft deleted records are kept
+type RetentionConfig struct {
+	// Deleted is how long a deleted record can still be restored before it is purged
+	Deleted Duration `json:"deleted"`
+	// PurgeInterval is how often the server purges expired records, zero disables the job
+	PurgeInterval Duration `json:"purgeInterval"`
+}
+
+// WaitlistConfig controls the slot offers made to waitlisted patients
+type WaitlistConfig struct {
+	// OfferTTL is how long a patient has to accept an offered slot
+	OfferTTL Duration `json:"offerTTL"`
+	// SweepInterval is how often expired offers roll over to the next patient, zero disables the job
+	SweepInterval Duration `json:"sweepInterval"`
+}
+
+// HoldConfig controls the slot holds patients take while they fill in a booking
+type HoldConfig struct {
+	// TTL is how long a held slot stays reserved for the patient
+	TTL Duration `json:"ttl"`
+	// SweepInterval is how often expired holds are released, zero disables the job
+	SweepInterval Duration `json:"sweepInterval"`
+}
+
+// SlotStep is the grid appointment lengths and start times are aligned to, in minutes
+const SlotStep = 5
+
+// DefaultTimeZone is the zone of hospitals that set none, unless TIME_ZONE says otherwise
+const DefaultTimeZone = "Europe/Istanbul"
+
+// SchedulingConfig controls how long appointments are. A doctor's own slot length
+// wins over the field default, which wins over SlotMinutes.
+type SchedulingConfig struct {
+	// SlotMinutes is the appointment length used when neither the doctor nor the field sets one
+	SlotMinutes int `json:"slotMinutes"`
+	// FieldSlotMinutes is the default appointment length per field code
+	FieldSlotMinutes map[int]int `json:"fieldSlotMinutes"`
+	// TimeZone is the IANA zone appointment times are given in for hospitals without their own
+	TimeZone string `json:"timeZone"`
+}
+
+// ValidSlotMinutes reports whether minutes is a usable appointment length
+func ValidSlotMinutes(minutes int) bool {
+	return minutes >= SlotStep && minutes <= 240 && minutes%SlotStep == 0
+}
+
+// Duration is a time.Duration written as a string such as "5s" in JSON files and the environment
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// IsDevelopment reports whether the server runs in development mode
+func (c *Config) IsDevelopment() bool {
+	return c.Env == EnvDevelopment
+}
+
+// Defaults returns the configuration used before any file, environment variable or flag is applied
+func Defaults() *Config {
+	return &Config{
+		Env:  EnvProduction,
+		Port: "8080",
+		Mongo: MongoConfig{
+			MigrateOnStartup: true,
+			ConnectTimeout:   Duration(10 * time.Second),
+			OperationTimeout: Duration(5 * time.Second),
+		},
+		Mail: MailConfig{
+			FromName:     "e-pulse",
+			SMTPHost:     "smtp.mailersend.net",
+			SMTPPort:     "587",
+			SMTPFromName: "e-pulse Randevu Sistemi",
+			Timeout:      Duration(10 * time.Second),
+		},
+		Google: GoogleConfig{
+			CalendarID:      "primary",
+			CredentialsFile: "credentials.json",
+			TokenFile:       "token.json",
+			Timeout:         Duration(10 * time.Second),
+		},
+		Retention: RetentionConfig{
+			Deleted:       Duration(30 * 24 * time.Hour),
+			PurgeInterval: Duration(6 * time.Hour),
+		},
+		Waitlist: WaitlistConfig{
+			OfferTTL:      Duration(30 * time.Minute),
+			SweepInterval: Duration(time.Minute),
+		},
+		Holds: HoldConfig{
+			TTL:           Duration(5 * time.Minute),
+			SweepInterval: Duration(30 * time.Second),
+		},
+		Scheduling: SchedulingConfig{
+			SlotMinutes: 15,
+			TimeZone:    DefaultTimeZone,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, an optional JSON file, the
+// environment and finally the command line flags in args, then validates it.
+// The file is taken from the -config flag or the CONFIG_FILE variable.
+func Load(args []string) (*Config, error) {
+	cfg := Defaults()
+
+	fs := flag.NewFlagSet("backend", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON configuration file")
+	env := fs.String("env", "", "runtime environment (development or production)")
+	port := fs.String("port", "", "HTTP port to listen on")
+	mongoURI := fs.String("mongo-uri", "", "MongoDB connection string")
+	corsOrigins := fs.String("cors-origins", "", "comma separated list of allowed CORS origins")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.LoadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.LoadEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			cfg.Env = *env
+		case "port":
+			cfg.Port = *port
+		case "mongo-uri":
+			cfg.Mongo.URI = *mongoURI
+		case "cors-origins":
+			cfg.CORSOrigins = splitList(*corsOrigins)
+		}
+	})
+
+	cfg.applyDevelopmentDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile overlays the values found in a JSON configuration file
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %v", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// LoadEnv overlays the values set in the environment. It fails only on malformed durations.
+func (c *Config) LoadEnv() error {
+	var errs []error
+	setString(&c.Env, "APP_ENV")
+	setString(&c.Port, "PORT")
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		c.CORSOrigins = splitList(origins)
+	}
+
+	setString(&c.Mongo.URI, "MONGODB_URI")
+	if migrate := os.Getenv("MIGRATE_ON_STARTUP"); migrate != "" {
+		c.Mongo.MigrateOnStartup = migrate == "true"
+	}
+	errs = append(errs, setDuration(&c.Mongo.ConnectTimeout, "MONGODB_CONNECT_TIMEOUT"))
+	errs = append(errs, setDuration(&c.Mongo.OperationTimeout, "MONGODB_OPERATION_TIMEOUT"))
+
+	setString(&c.JWT.Secret, "JWT_SECRET")
+	setString(&c.JWT.RefreshSecret, "JWT_REFRESH_SECRET")
+
+	setString(&c.Mail.APIKey, "MAILERSEND_API_KEY")
+	setString(&c.Mail.FromName, "MAILERSEND_FROM_NAME")
+	setString(&c.Mail.FromEmail, "MAILERSEND_FROM_EMAIL")
+	setString(&c.Mail.SMTPHost, "SMTP_HOST")
+	setString(&c.Mail.SMTPPort, "SMTP_PORT")
+	setString(&c.Mail.SMTPUsername, "SMTP_USERNAME")
+	setString(&c.Mail.SMTPPassword, "EMAIL_PASSWORD")
+	setString(&c.Mail.SMTPPassword, "MAILERSEND_SMTP_PASSWORD")
+	setString(&c.Mail.SMTPFromEmail, "EMAIL_FROM")
+	errs = append(errs, setDuration(&c.Mail.Timeout, "MAIL_TIMEOUT"))
+
+	if enabled := os.Getenv("USE_GOOGLE_CALENDAR"); enabled != "" {
+		c.Google.Enabled = enabled == "true"
+	}
+	setString(&c.Google.CalendarID, "GOOGLE_CALENDAR_ID")
+	setString(&c.Google.Credentials, "GOOGLE_CREDENTIALS")
+	setString(&c.Google.CredentialsFile, "GOOGLE_CREDENTIALS_FILE")
+	setString(&c.Google.TokenFile, "GOOGLE_TOKEN_FILE")
+	errs = append(errs, setDuration(&c.Google.Timeout, "GOOGLE_CALENDAR_TIMEOUT"))
+
+	errs = append(errs, setDuration(&c.Retention.Deleted, "DELETED_RETENTION"))
+	errs = append(errs, setDuration(&c.Retention.PurgeInterval, "PURGE_INTERVAL"))
+
+	errs = append(errs, setDuration(&c.Waitlist.OfferTTL, "WAITLIST_OFFER_TTL"))
+	errs = append(errs, setDuration(&c.Waitlist.SweepInterval, "WAITLIST_SWEEP_INTERVAL"))
+
+	errs = append(errs, setDuration(&c.Holds.TTL, "SLOT_HOLD_TTL"))
+	errs = append(errs, setDuration(&c.Holds.SweepInterval, "SLOT_HOLD_SWEEP_INTERVAL"))
+
+	errs = append(errs, setInt(&c.Scheduling.SlotMinutes, "SLOT_MINUTES"))
+	errs = append(errs, setFieldMinutes(&c.Scheduling.FieldSlotMinutes, "FIELD_SLOT_MINUTES"))
+	setString(&c.Scheduling.TimeZone, "TIME_ZONE")
+
+	return errors.Join(errs...)
+}
+
+// applyDevelopmentDefaults fills in local fallbacks so a development server starts without any setup
+func (c *Config) applyDevelopmentDefaults() {
+	if !c.IsDevelopment() {
+		return
+	}
+	if c.Mongo.URI == "" {
+		c.Mongo.URI = defaultMongoURI
+	}
+	if c.JWT.Secret == "" {
+		c.JWT.Secret = defaultJWTSecret
+	}
+	if c.JWT.RefreshSecret == "" {
+		c.JWT.RefreshSecret = defaultJWTRefreshSecret
+	}
+	if len(c.CORSOrigins) == 0 {
+		c.CORSOrigins = []string{"*"}
+	}
+}
+
+// Validate checks that the required values are present and that no
+// development secret is used outside development mode
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		errs = append(errs, fmt.Errorf("env must be %q or %q, got %q", EnvDevelopment, EnvProduction, c.Env))
+	}
+	if _, err := strconv.Atoi(c.Port); err != nil {
+		errs = append(errs, fmt.Errorf("port must be a number, got %q", c.Port))
+	}
+	if c.Mongo.URI == "" {
+		errs = append(errs, errors.New("MONGODB_URI is required"))
+	}
+	if c.JWT.Secret == "" {
+		errs = append(errs, errors.New("JWT_SECRET is required"))
+	}
+	if c.JWT.RefreshSecret == "" {
+		errs = append(errs, errors.New("JWT_REFRESH_SECRET is required"))
+	}
+	if c.Mongo.ConnectTimeout <= 0 || c.Mongo.OperationTimeout <= 0 {
+		errs = append(errs, errors.New("MongoDB timeouts must be positive"))
+	}
+	if c.Mail.Timeout <= 0 || c.Google.Timeout <= 0 {
+		errs = append(errs, errors.New("mail and Google Calendar timeouts must be positive"))
+	}
+	if c.Retention.Deleted <= 0 || c.Retention.PurgeInterval < 0 {
+		errs = append(errs, errors.New("DELETED_RETENTION must be positive and PURGE_INTERVAL must not be negative"))
+	}
+	if c.Waitlist.OfferTTL <= 0 || c.Waitlist.SweepInterval < 0 {
+		errs = append(errs, errors.New("WAITLIST_OFFER_TTL must be positive and WAITLIST_SWEEP_INTERVAL must not be negative"))
+	}
+	if c.Holds.TTL <= 0 || c.Holds.SweepInterval < 0 {
+		errs = append(errs, errors.New("SLOT_HOLD_TTL must be positive and SLOT_HOLD_SWEEP_INTERVAL must not be negative"))
+	}
+	if !ValidSlotMinutes(c.Scheduling.SlotMinutes) {
+		errs = append(errs, fmt.Errorf("SLOT_MINUTES must be a multiple of %d between %d and 240, got %d", SlotStep, SlotStep, c.Scheduling.SlotMinutes))
+	}
+	for field, minutes := range c.Scheduling.FieldSlotMinutes {
+		if !ValidSlotMinutes(minutes) {
+			errs = append(errs, fmt.Errorf("slot length of field %d must be a multiple of %d between %d and 240, got %d", field, SlotStep, SlotStep, minutes))
+		}
+	}
+	if _, err := time.LoadLocation(c.Scheduling.TimeZone); c.Scheduling.TimeZone == "" || err != nil {
+		errs = append(errs, fmt.Errorf("TIME_ZONE must be an IANA time zone, got %q", c.Scheduling.TimeZone))
+	}
+	if c.Google.Enabled && c.Google.Credentials == "" && c.Google.CredentialsFile == "" {
+		errs = append(errs, errors.New("GOOGLE_CREDENTIALS or GOOGLE_CREDENTIALS_FILE is required when Google Calendar is enabled"))
+	}
+
+	if !c.IsDevelopment() {
+		if c.JWT.Secret == defaultJWTSecret || c.JWT.RefreshSecret == defaultJWTRefreshSecret {
+			errs = append(errs, errors.New("default JWT secrets are only allowed in development mode"))
+		}
+		if c.JWT.Secret != "" && c.JWT.Secret == c.JWT.RefreshSecret {
+			errs = append(errs, errors.New("JWT_SECRET and JWT_REFRESH_SECRET must differ"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func setString(target *string, key string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+func setDuration(target *Duration, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a duration such as 5s: %v", key, err)
+	}
+	*target = Duration(parsed)
+	return nil
+}
+
+func setInt(target *int, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a number: %v", key, err)
+	}
+	*target = parsed
+	return nil
+}
+
+// setFieldMinutes reads a list such as "9=30,3=10" of field codes and their slot lengths
+func setFieldMinutes(target *map[int]int, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	minutes := map[int]int{}
+	for _, item := range splitList(value) {
+		field, length, ok := strings.Cut(item, "=")
+		fieldCode, fieldErr := strconv.Atoi(strings.TrimSpace(field))
+		slotMinutes, lengthErr := strconv.Atoi(strings.TrimSpace(length))
+		if !ok || fieldErr != nil || lengthErr != nil {
+			return fmt.Errorf("%s must list field=minutes pairs such as 9=30,3=10, got %q", key, item)
+		}
+		minutes[fieldCode] = slotMinutes
+	}
+	*target = minutes
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
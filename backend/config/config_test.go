@@ -0,0 +1,62 @@
+package config
+
+import "testing"
+
+func TestLoadRejectsDefaultSecretsInProduction(t *testing.T) {
+	t.Setenv("APP_ENV", EnvProduction)
+	t.Setenv("MONGODB_URI", "mongodb://db:27017")
+	t.Setenv("JWT_SECRET", defaultJWTSecret)
+	t.Setenv("JWT_REFRESH_SECRET", "another-secret")
+
+	if _, err := Load(nil); err == nil {
+		t.Fatal("expected production config with the default JWT secret to be rejected")
+	}
+}
+
+func TestLoadDevelopmentDefaults(t *testing.T) {
+	t.Setenv("APP_ENV", "")
+	t.Setenv("MONGODB_URI", "")
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_REFRESH_SECRET", "")
+	t.Setenv("PORT", "")
+
+	cfg, err := Load([]string{"-env", EnvDevelopment, "-port", "9090"})
+	if err != nil {
+		t.Fatalf("development config should load without any setup: %v", err)
+	}
+	if cfg.Mongo.URI != defaultMongoURI {
+		t.Errorf("expected local MongoDB fallback, got %q", cfg.Mongo.URI)
+	}
+	if cfg.Port != "9090" {
+		t.Errorf("flag should override the default port, got %q", cfg.Port)
+	}
+}
+
+func TestLoadRequiresMongoURIInProduction(t *testing.T) {
+	t.Setenv("APP_ENV", EnvProduction)
+	t.Setenv("MONGODB_URI", "")
+	t.Setenv("JWT_SECRET", "secret-one")
+	t.Setenv("JWT_REFRESH_SECRET", "secret-two")
+
+	if _, err := Load(nil); err == nil {
+		t.Fatal("expected missing MONGODB_URI to be rejected")
+	}
+}
+
+func TestLoadFieldSlotMinutes(t *testing.T) {
+	t.Setenv("APP_ENV", EnvDevelopment)
+	t.Setenv("FIELD_SLOT_MINUTES", "9=30, 3=10")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Scheduling.FieldSlotMinutes[9] != 30 || cfg.Scheduling.FieldSlotMinutes[3] != 10 {
+		t.Fatalf("unexpected field slot lengths %v", cfg.Scheduling.FieldSlotMinutes)
+	}
+
+	t.Setenv("FIELD_SLOT_MINUTES", "9=7")
+	if _, err := Load(nil); err == nil {
+		t.Fatal("expected a slot length off the 5 minute grid to be rejected")
+	}
+}
@@ -3,14 +3,13 @@ package data
 import (
 	"backend/api"
 	"backend/helper"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var names []string
@@ -34,7 +33,7 @@ type Appointment struct {
 }
 
 // a function to fill every single empty position
-/* func FillHospitals(client *mongo.Client) {
+/* func FillHospitals(app *api.App) {
 	namesFile, err := ioutil.ReadFile("helper/names/isimler.json")
 	if err != nil {
 		fmt.Println(err)
@@ -46,9 +45,9 @@ type Appointment struct {
 	json.Unmarshal(namesFile, &names)
 	json.Unmarshal(surnamesFile, &surnames)
 
-	hospitals := api.GetHospitalsByProvince(client, 34)
+	hospitals := api.GetHospitalsByProvince(app, 34)
 	for _, hospital := range hospitals {
-		doctors, _ := api.GetDoctorsByHospitalCode(client, hospital.HospitalCode)
+		doctors, _ := api.GetDoctorsByHospitalCode(app, hospital.HospitalCode)
 		var fieldCheck [10]bool
 		for _, doctor := range doctors {
 			fieldCheck[doctor.FieldCode] = true
@@ -62,13 +61,13 @@ type Appointment struct {
 					WorkHours:    api.WorkHours{Start: "09:00", End: "17:00"},
 				}
 
-				api.CreateDoctor(client, doctor)
+				api.CreateDoctor(app, doctor)
 			}
 		}
 	}
 } */
 
-func FillHospitals(client *mongo.Client) {
+func FillHospitals(ctx context.Context, app *api.App) {
 	namesFile, err := ioutil.ReadFile("helper/names/isimler.json")
 	if err != nil {
 		log.Fatalf("Failed to read isimler.json: %v", err)
@@ -80,11 +79,11 @@ func FillHospitals(client *mongo.Client) {
 	json.Unmarshal(namesFile, &names)
 	json.Unmarshal(surnamesFile, &surnames)
 
-	var allDoctors []interface{}
+	var allDoctors []api.Doctor
 
-	hospitals := api.GetAllHospitals(client)
+	hospitals := api.GetAllHospitals(ctx, app)
 	for _, hospital := range hospitals {
-		doctors, _ := api.GetDoctorsByHospitalCode(client, hospital.HospitalCode)
+		doctors, _ := api.GetDoctorsByHospitalCode(ctx, app, hospital.HospitalCode)
 		var fieldCheck [10]bool
 		for _, doctor := range doctors {
 			fieldCheck[doctor.FieldCode] = true
@@ -101,12 +100,12 @@ func FillHospitals(client *mongo.Client) {
 					UpdatedAt:    time.Now(),
 				})
 				//allDoctors = append(allDoctors, doctor)
-				//api.CreateDoctor(client, doctor)
+				//api.CreateDoctor(app, doctor)
 			}
 		}
 	}
 
-	api.InsertManyDoctors(client, allDoctors)
+	api.InsertManyDoctors(ctx, app, allDoctors)
 }
 
 func CreateName() string {
@@ -122,14 +121,14 @@ func CreateName() string {
 	fmt.Println(prepName)
 	return prepName
 }
-func RemoveAllDoctorsInProvince(client *mongo.Client, provinceCode int) {
-	hospitals := api.GetHospitalsByProvince(client, provinceCode)
+func RemoveAllDoctorsInProvince(ctx context.Context, app *api.App, provinceCode int) {
+	hospitals := api.GetHospitalsByProvince(ctx, app, provinceCode)
 	for _, hospital := range hospitals {
 		fmt.Println(hospital.HospitalName)
-		doctors, _ := api.GetDoctorsByHospitalCode(client, hospital.HospitalCode)
+		doctors, _ := api.GetDoctorsByHospitalCode(ctx, app, hospital.HospitalCode)
 		for _, doctor := range doctors {
-			fmt.Println(client, doctor.DoctorName)
-			api.DeleteDoctor(client, doctor.DoctorCode)
+			fmt.Println(doctor.DoctorName)
+			api.DeleteDoctor(ctx, app, doctor.DoctorCode, "", api.PolicyCancel)
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package google
 
 import (
+	"backend/config"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -18,22 +19,17 @@ import (
 // GoogleCalendarService provides Google Calendar integration
 type GoogleCalendarService struct {
 	service *calendar.Service
+	timeout time.Duration
 }
 
 // NewGoogleCalendarService creates a new GoogleCalendarService
-func NewGoogleCalendarService() (*GoogleCalendarService, error) {
+func NewGoogleCalendarService(cfg config.GoogleConfig) (*GoogleCalendarService, error) {
 	ctx := context.Background()
 
-	// Load credentials from environment variable or file
-	credentialsJSON := os.Getenv("GOOGLE_CREDENTIALS")
+	// Use inline credentials when given, otherwise load them from file
+	credentialsJSON := cfg.Credentials
 	if credentialsJSON == "" {
-		// Try to load from file
-		credFile := os.Getenv("GOOGLE_CREDENTIALS_FILE")
-		if credFile == "" {
-			credFile = "credentials.json"
-		}
-
-		b, err := os.ReadFile(credFile)
+		b, err := os.ReadFile(cfg.CredentialsFile)
 		if err != nil {
 			return nil, fmt.Errorf("unable to read credentials file: %v", err)
 		}
@@ -47,7 +43,7 @@ func NewGoogleCalendarService() (*GoogleCalendarService, error) {
 	}
 
 	// Create HTTP client
-	client := getClient(config)
+	client := getClient(config, cfg.TokenFile)
 
 	// Create Calendar service
 	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
@@ -57,23 +53,32 @@ func NewGoogleCalendarService() (*GoogleCalendarService, error) {
 
 	return &GoogleCalendarService{
 		service: srv,
+		timeout: time.Duration(cfg.Timeout),
 	}, nil
 }
 
+// eventDateTime is the event time t in the time zone of t, so the event keeps its
+// wall clock time across daylight saving changes. The server's own zone has no IANA
+// name and is sent as an offset only.
+func eventDateTime(t time.Time) *calendar.EventDateTime {
+	zone := t.Location().String()
+	if t.Location() == time.Local {
+		zone = ""
+	}
+	return &calendar.EventDateTime{
+		DateTime: t.Format(time.RFC3339),
+		TimeZone: zone,
+	}
+}
+
 // AddAppointmentToCalendar adds an appointment to the Google Calendar
-func (g *GoogleCalendarService) AddAppointmentToCalendar(calendarID, summary, description, location string, startTime, endTime time.Time) (*calendar.Event, error) {
+func (g *GoogleCalendarService) AddAppointmentToCalendar(ctx context.Context, calendarID, summary, description, location string, startTime, endTime time.Time) (*calendar.Event, error) {
 	event := &calendar.Event{
 		Summary:     summary,
 		Description: description,
 		Location:    location,
-		Start: &calendar.EventDateTime{
-			DateTime: startTime.Format(time.RFC3339),
-			TimeZone: "UTC",
-		},
-		End: &calendar.EventDateTime{
-			DateTime: endTime.Format(time.RFC3339),
-			TimeZone: "UTC",
-		},
+		Start:       eventDateTime(startTime),
+		End:         eventDateTime(endTime),
 		Reminders: &calendar.EventReminders{
 			UseDefault: false,
 			Overrides: []*calendar.EventReminder{
@@ -83,7 +88,10 @@ func (g *GoogleCalendarService) AddAppointmentToCalendar(calendarID, summary, de
 		},
 	}
 
-	event, err := g.service.Events.Insert(calendarID, event).Do()
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	event, err := g.service.Events.Insert(calendarID, event).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("unable to create event: %v", err)
 	}
@@ -91,9 +99,30 @@ func (g *GoogleCalendarService) AddAppointmentToCalendar(calendarID, summary, de
 	return event, nil
 }
 
+// MoveAppointmentInCalendar moves an existing appointment event to a new time
+func (g *GoogleCalendarService) MoveAppointmentInCalendar(ctx context.Context, calendarID, eventID string, startTime, endTime time.Time) (*calendar.Event, error) {
+	patch := &calendar.Event{
+		Start: eventDateTime(startTime),
+		End:   eventDateTime(endTime),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	event, err := g.service.Events.Patch(calendarID, eventID, patch).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to move event: %v", err)
+	}
+
+	return event, nil
+}
+
 // DeleteAppointmentFromCalendar deletes an appointment from the Google Calendar
-func (g *GoogleCalendarService) DeleteAppointmentFromCalendar(calendarID, eventID string) error {
-	err := g.service.Events.Delete(calendarID, eventID).Do()
+func (g *GoogleCalendarService) DeleteAppointmentFromCalendar(ctx context.Context, calendarID, eventID string) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	err := g.service.Events.Delete(calendarID, eventID).Context(ctx).Do()
 	if err != nil {
 		return fmt.Errorf("unable to delete event: %v", err)
 	}
@@ -143,11 +172,10 @@ func saveToken(path string, token *oauth2.Token) {
 }
 
 // Get client with token
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
+func getClient(config *oauth2.Config, tokFile string) *http.Client {
+	// The token file stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
-	tokFile := "token.json"
 	tok, err := tokenFromFile(tokFile)
 	if err != nil {
 		tok = getTokenFromWeb(config)
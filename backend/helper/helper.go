@@ -1,9 +1,10 @@
 package helper
 
 import (
+	"context"
 	"math/big"
 	"net/smtp"
-	"os"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -39,11 +40,11 @@ func RemoveFromSlice(slice []int, value any) []int {
 }
 
 // SendEmail sends an email using SMTP
-func SendEmail(to []string, subject, body string) error {
-	from := os.Getenv("EMAIL_FROM")
-	password := os.Getenv("EMAIL_PASSWORD")
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
+func (m *Mailer) SendEmail(ctx context.Context, to []string, subject, body string) error {
+	from := m.config.SMTPFromEmail
+	password := m.config.SMTPPassword
+	smtpHost := m.config.SMTPHost
+	smtpPort := m.config.SMTPPort
 
 	// Message
 	message := []byte("Subject: " + subject + "\r\n" +
@@ -54,15 +55,18 @@ func SendEmail(to []string, subject, body string) error {
 		body)
 
 	// Authentication
-	auth := smtp.PlainAuth("", from, password, smtpHost)
+	auth := smtp.PlainAuth("", m.config.SMTPUsername, password, smtpHost)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(m.config.Timeout))
+	defer cancel()
 
 	// Send email
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, message)
+	err := sendMailContext(ctx, smtpHost, smtpPort, auth, from, to, message)
 	return err
 }
 
 // SendAppointmentConfirmation sends an email confirmation for a new appointment
-func SendAppointmentConfirmation(email, patientName, doctorName, hospitalName, date, time string) error {
+func (m *Mailer) SendAppointmentConfirmation(ctx context.Context, email, patientName, doctorName, hospitalName, date, time string) error {
 	subject := "Your Appointment Confirmation"
 	body := `
 	<html>
@@ -80,11 +84,11 @@ func SendAppointmentConfirmation(email, patientName, doctorName, hospitalName, d
 	</body>
 	</html>
 	`
-	return SendEmail([]string{email}, subject, body)
+	return m.SendEmail(ctx, []string{email}, subject, body)
 }
 
 // SendAppointmentCancellation sends an email about an appointment cancellation
-func SendAppointmentCancellation(email, patientName, doctorName, hospitalName, date, time string) error {
+func (m *Mailer) SendAppointmentCancellation(ctx context.Context, email, patientName, doctorName, hospitalName, date, time string) error {
 	subject := "Your Appointment Cancellation"
 	body := `
 	<html>
@@ -102,11 +106,11 @@ func SendAppointmentCancellation(email, patientName, doctorName, hospitalName, d
 	</body>
 	</html>
 	`
-	return SendEmail([]string{email}, subject, body)
+	return m.SendEmail(ctx, []string{email}, subject, body)
 }
 
 // SendDoctorAppointmentNotification notifies the doctor about a new appointment
-func SendDoctorAppointmentNotification(email, doctorName, patientName, hospitalName, date, time string) error {
+func (m *Mailer) SendDoctorAppointmentNotification(ctx context.Context, email, doctorName, patientName, hospitalName, date, time string) error {
 	subject := "New Appointment Scheduled"
 	body := `
 	<html>
@@ -123,5 +127,5 @@ func SendDoctorAppointmentNotification(email, doctorName, patientName, hospitalN
 	</body>
 	</html>
 	`
-	return SendEmail([]string{email}, subject, body)
+	return m.SendEmail(ctx, []string{email}, subject, body)
 }
@@ -1,107 +1,55 @@
 package helper
 
 import (
+	"backend/config"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/smtp"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/mailersend/mailersend-go"
 )
 
-// MailerSendConfig holds the configuration for MailerSend
-type MailerSendConfig struct {
-	APIKey    string
-	FromName  string
-	FromEmail string
+// Mailer sends notification emails through MailerSend, either over its API or its SMTP relay
+type Mailer struct {
+	config config.MailConfig
 }
 
-// GetMailerSendConfig returns the MailerSend configuration from environment variables
-func GetMailerSendConfig() MailerSendConfig {
-	// For development, hardcode the API key
-	apiKey := os.Getenv("MAILERSEND_API_KEY")
-	if apiKey == "" {
-		// If not set in environment, use the provided key
-		apiKey = "mlsn.e8037a22dfb79211b6c58915d958459758b67dd111309d723757ea8043a5df66"
-	}
-
-	fromName := os.Getenv("MAILERSEND_FROM_NAME")
-	if fromName == "" {
-		fromName = "e-pulse"
-	}
-
-	fromEmail := os.Getenv("MAILERSEND_FROM_EMAIL")
-	if fromEmail == "" {
-		// Using the SMTP user from the MailerSend dashboard
-		fromEmail = "MS_GvXVNm@test-r83ql3ppdmvgzw1j.mlsender.net"
-	}
-
-	return MailerSendConfig{
-		APIKey:    apiKey,
-		FromName:  fromName,
-		FromEmail: fromEmail,
-	}
-}
-
-// GetSMTPConfig returns SMTP configuration for MailerSend
-func GetSMTPConfig() struct {
-	Host      string
-	Port      string
-	Username  string
-	Password  string
-	FromName  string
-	FromEmail string
-} {
-	// Get password from environment variable, or use the one from MailerSend dashboard
-	password := os.Getenv("MAILERSEND_SMTP_PASSWORD")
-	if password == "" {
-		// Use the password from your MailerSend SMTP configuration
-		password = "mssp.u07tr2q.0p7kx4xn9eeg9yjr.9pmDH3B"
-	}
-
-	return struct {
-		Host      string
-		Port      string
-		Username  string
-		Password  string
-		FromName  string
-		FromEmail string
-	}{
-		Host:      "smtp.mailersend.net",
-		Port:      "587",
-		Username:  "MS_GvXVNm@test-r83ql3ppdmvgzw1j.mlsender.net", // From your screenshot
-		Password:  password,
-		FromName:  "e-pulse Randevu Sistemi",
-		FromEmail: "MS_GvXVNm@test-r83ql3ppdmvgzw1j.mlsender.net",
+// NewMailer creates a Mailer from the mail section of the configuration
+func NewMailer(cfg config.MailConfig) *Mailer {
+	if cfg.SMTPFromEmail == "" {
+		cfg.SMTPFromEmail = cfg.SMTPUsername
 	}
+	return &Mailer{config: cfg}
 }
 
 // SendMailerSendEmail sends an email using MailerSend API
-func SendMailerSendEmail(recipients []string, subject, htmlContent, textContent string, templateVariables map[string]interface{}) error {
-	config := GetMailerSendConfig()
-	if config.APIKey == "" {
-		return fmt.Errorf("MAILERSEND_API_KEY environment variable is not set")
+func (m *Mailer) SendMailerSendEmail(ctx context.Context, recipients []string, subject, htmlContent, textContent string, templateVariables map[string]interface{}) error {
+	cfg := m.config
+	if cfg.APIKey == "" {
+		return fmt.Errorf("MAILERSEND_API_KEY is not configured")
 	}
 
 	// Enhanced logging for debugging
 	log.Printf("=== EMAIL DEBUG START ===")
 	log.Printf("Attempting to send email to: %v", recipients)
-	log.Printf("From email: %s", config.FromEmail)
+	log.Printf("From email: %s", cfg.FromEmail)
 	log.Printf("Subject: %s", subject)
-	log.Printf("API Key (first 10 chars): %s...", config.APIKey[:10])
+	log.Printf("API Key (first 10 chars): %s...", cfg.APIKey[:10])
 
-	ms := mailersend.NewMailersend(config.APIKey)
+	ms := mailersend.NewMailersend(cfg.APIKey)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
 	defer cancel()
 
 	from := mailersend.From{
-		Name:  config.FromName,
-		Email: config.FromEmail,
+		Name:  cfg.FromName,
+		Email: cfg.FromEmail,
 	}
 
 	// Convert recipients to MailerSend format
@@ -203,28 +151,81 @@ func extractDomain(email string) string {
 	return ""
 }
 
+// sendMailContext works like smtp.SendMail but gives up when ctx is done
+func sendMailContext(ctx context.Context, host, port string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock the SMTP exchange if ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(msg); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
 // Helper function to check if string contains substring (case insensitive)
 func contains(s, substr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
 
 // SendSMTPEmail sends an email using SMTP (more reliable than MailerSend API for test accounts)
-func SendSMTPEmail(recipients []string, subject, htmlContent string) error {
-	config := GetSMTPConfig()
+func (m *Mailer) SendSMTPEmail(ctx context.Context, recipients []string, subject, htmlContent string) error {
+	cfg := m.config
 
 	// Enhanced logging for debugging
 	log.Printf("=== SMTP EMAIL DEBUG START ===")
 	log.Printf("Attempting to send email via SMTP to: %v", recipients)
-	log.Printf("SMTP Host: %s:%s", config.Host, config.Port)
-	log.Printf("SMTP Username: %s", config.Username)
-	log.Printf("From email: %s", config.FromEmail)
-	log.Printf("From name: %s", config.FromName)
+	log.Printf("SMTP Host: %s:%s", cfg.SMTPHost, cfg.SMTPPort)
+	log.Printf("SMTP Username: %s", cfg.SMTPUsername)
+	log.Printf("From email: %s", cfg.SMTPFromEmail)
+	log.Printf("From name: %s", cfg.SMTPFromName)
 	log.Printf("Subject: %s", subject)
-	log.Printf("Password length: %d characters", len(config.Password))
+	log.Printf("Password length: %d characters", len(cfg.SMTPPassword))
 
 	// Validate SMTP password
-	if config.Password == "" {
-		log.Printf("ERROR: MAILERSEND_SMTP_PASSWORD environment variable not set and fallback password is empty")
+	if cfg.SMTPPassword == "" {
+		log.Printf("ERROR: SMTP password is not configured")
 		return fmt.Errorf("SMTP password not configured. Please set MAILERSEND_SMTP_PASSWORD environment variable")
 	}
 
@@ -234,6 +235,10 @@ func SendSMTPEmail(recipients []string, subject, htmlContent string) error {
 		return fmt.Errorf("no recipients provided")
 	}
 
+	// One deadline covers every recipient
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+
 	// Prepare email message
 	for _, recipient := range recipients {
 		if recipient == "" {
@@ -244,7 +249,7 @@ func SendSMTPEmail(recipients []string, subject, htmlContent string) error {
 		log.Printf("Processing recipient: %s", recipient)
 
 		// Create email message
-		message := fmt.Sprintf("From: %s <%s>\r\n", config.FromName, config.FromEmail)
+		message := fmt.Sprintf("From: %s <%s>\r\n", cfg.SMTPFromName, cfg.SMTPFromEmail)
 		message += fmt.Sprintf("To: %s\r\n", recipient)
 		message += fmt.Sprintf("Subject: %s\r\n", subject)
 		message += "MIME-Version: 1.0\r\n"
@@ -253,27 +258,29 @@ func SendSMTPEmail(recipients []string, subject, htmlContent string) error {
 		message += htmlContent
 
 		log.Printf("Email message length: %d characters", len(message))
-		log.Printf("Connecting to SMTP server: %s:%s", config.Host, config.Port)
+		log.Printf("Connecting to SMTP server: %s:%s", cfg.SMTPHost, cfg.SMTPPort)
 
 		// SMTP authentication
-		auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
+		auth := smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
 		log.Printf("SMTP Auth created successfully")
 
 		// Send email
 		log.Printf("Attempting to send email to %s...", recipient)
-		err := smtp.SendMail(
-			config.Host+":"+config.Port,
+		err := sendMailContext(
+			ctx,
+			cfg.SMTPHost,
+			cfg.SMTPPort,
 			auth,
-			config.FromEmail,
+			cfg.SMTPFromEmail,
 			[]string{recipient},
 			[]byte(message),
 		)
 
 		if err != nil {
 			log.Printf("❌ SMTP Error sending to %s: %v", recipient, err)
-			log.Printf("   Host: %s:%s", config.Host, config.Port)
-			log.Printf("   Username: %s", config.Username)
-			log.Printf("   From: %s", config.FromEmail)
+			log.Printf("   Host: %s:%s", cfg.SMTPHost, cfg.SMTPPort)
+			log.Printf("   Username: %s", cfg.SMTPUsername)
+			log.Printf("   From: %s", cfg.SMTPFromEmail)
 			return fmt.Errorf("failed to send email via SMTP to %s: %w", recipient, err)
 		}
 
@@ -285,7 +292,7 @@ func SendSMTPEmail(recipients []string, subject, htmlContent string) error {
 }
 
 // TestSMTPConnection - Test function to verify SMTP configuration
-func TestSMTPConnection() error {
+func (m *Mailer) TestSMTPConnection(ctx context.Context) error {
 	log.Printf("=== TESTING SMTP CONNECTION ===")
 
 	testRecipient := "test@example.com"
@@ -300,11 +307,11 @@ func TestSMTPConnection() error {
 	</html>
 	`
 
-	return SendSMTPEmail([]string{testRecipient}, testSubject, testHTML)
+	return m.SendSMTPEmail(ctx, []string{testRecipient}, testSubject, testHTML)
 }
 
 // SendAppointmentConfirmationEmail sends an appointment confirmation email using SMTP
-func SendAppointmentConfirmationEmail(email, patientName, doctorName, hospitalName, date, time string) error {
+func (m *Mailer) SendAppointmentConfirmationEmail(ctx context.Context, email, patientName, doctorName, hospitalName, date, time string) error {
 	subject := "Randevu Onayı - e-pulse"
 
 	htmlContent := `
@@ -337,11 +344,11 @@ func SendAppointmentConfirmationEmail(email, patientName, doctorName, hospitalNa
 	`
 
 	// Use SMTP instead of MailerSend API
-	return SendSMTPEmail([]string{email}, subject, htmlContent)
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
 }
 
 // SendAppointmentCancellationEmail sends an appointment cancellation email using SMTP
-func SendAppointmentCancellationEmail(email, patientName, doctorName, hospitalName, date, time string) error {
+func (m *Mailer) SendAppointmentCancellationEmail(ctx context.Context, email, patientName, doctorName, hospitalName, date, time string) error {
 	subject := "Randevu İptali - e-pulse"
 
 	htmlContent := `
@@ -374,11 +381,232 @@ func SendAppointmentCancellationEmail(email, patientName, doctorName, hospitalNa
 	`
 
 	// Use SMTP instead of MailerSend API
-	return SendSMTPEmail([]string{email}, subject, htmlContent)
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
+}
+
+// SendAppointmentReassignmentEmail tells the patient that the appointment moved to another doctor
+func (m *Mailer) SendAppointmentReassignmentEmail(ctx context.Context, email, patientName, previousDoctorName, doctorName, hospitalName, date, time string) error {
+	subject := "Randevu Değişikliği - e-pulse"
+
+	htmlContent := `
+	<html>
+	<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto;">
+		<div style="background-color: #f59e0b; padding: 20px; text-align: center; color: white;">
+			<h1 style="margin: 0;">Randevu Değişikliği</h1>
+		</div>
+		<div style="padding: 20px; border: 1px solid #e5e7eb; border-top: none;">
+			<p>Sayın ` + patientName + `,</p>
+			<p>Dr. ` + previousDoctorName + ` ile olan randevunuz aynı tarih ve saatte başka bir doktorumuza aktarılmıştır:</p>
+			
+			<div style="background-color: #f3f4f6; padding: 15px; border-radius: 5px; margin: 15px 0;">
+				<p style="margin: 5px 0;"><strong>Doktor:</strong> Dr. ` + doctorName + `</p>
+				<p style="margin: 5px 0;"><strong>Hastane:</strong> ` + hospitalName + `</p>
+				<p style="margin: 5px 0;"><strong>Tarih:</strong> ` + date + `</p>
+				<p style="margin: 5px 0;"><strong>Saat:</strong> ` + time + `</p>
+			</div>
+			
+			<p>Bu değişiklik size uymuyorsa randevunuzu uygulamamız üzerinden iptal edebilirsiniz.</p>
+			<p>Sorularınız için lütfen <a href="mailto:info@e-pulse.com">info@e-pulse.com</a> adresine e-posta gönderin veya 0850 123 4567 numaralı telefondan bizi arayın.</p>
+			
+			<p>e-pulse Randevu Sistemi</p>
+		</div>
+		<div style="background-color: #f3f4f6; padding: 10px; text-align: center; font-size: 12px; color: #6b7280;">
+			<p>Bu e-posta otomatik olarak gönderilmiştir, lütfen yanıtlamayınız.</p>
+		</div>
+	</body>
+	</html>
+	`
+
+	// Use SMTP instead of MailerSend API
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
+}
+
+// SendAppointmentRescheduleEmail tells the patient that the appointment moved to a new date and time
+func (m *Mailer) SendAppointmentRescheduleEmail(ctx context.Context, email, patientName, doctorName, hospitalName, previousDate, previousTime, date, time string) error {
+	subject := "Randevu Saati Değişikliği - e-pulse"
+
+	htmlContent := `
+	<html>
+	<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto;">
+		<div style="background-color: #3b82f6; padding: 20px; text-align: center; color: white;">
+			<h1 style="margin: 0;">Randevu Saati Değişikliği</h1>
+		</div>
+		<div style="padding: 20px; border: 1px solid #e5e7eb; border-top: none;">
+			<p>Sayın ` + patientName + `,</p>
+			<p>` + previousDate + ` ` + previousTime + ` tarihli randevunuz yeni bir saate taşınmıştır:</p>
+			
+			<div style="background-color: #f3f4f6; padding: 15px; border-radius: 5px; margin: 15px 0;">
+				<p style="margin: 5px 0;"><strong>Doktor:</strong> Dr. ` + doctorName + `</p>
+				<p style="margin: 5px 0;"><strong>Hastane:</strong> ` + hospitalName + `</p>
+				<p style="margin: 5px 0;"><strong>Tarih:</strong> ` + date + `</p>
+				<p style="margin: 5px 0;"><strong>Saat:</strong> ` + time + `</p>
+			</div>
+			
+			<p>Lütfen randevunuzdan 15 dakika önce hastanede olunuz.</p>
+			<p>Sorularınız için lütfen <a href="mailto:info@e-pulse.com">info@e-pulse.com</a> adresine e-posta gönderin veya 0850 123 4567 numaralı telefondan bizi arayın.</p>
+			
+			<p>e-pulse Randevu Sistemi</p>
+		</div>
+		<div style="background-color: #f3f4f6; padding: 10px; text-align: center; font-size: 12px; color: #6b7280;">
+			<p>Bu e-posta otomatik olarak gönderilmiştir, lütfen yanıtlamayınız.</p>
+		</div>
+	</body>
+	</html>
+	`
+
+	// Use SMTP instead of MailerSend API
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
+}
+
+// SendWaitlistOfferEmail tells a waitlisted patient that a slot is held for them until expiresAt
+func (m *Mailer) SendWaitlistOfferEmail(ctx context.Context, email, patientName, doctorName, hospitalName, date, time, expiresAt string) error {
+	subject := "Randevu Teklifi - e-pulse"
+
+	htmlContent := `
+	<html>
+	<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto;">
+		<div style="background-color: #10b981; padding: 20px; text-align: center; color: white;">
+			<h1 style="margin: 0;">Randevu Teklifi</h1>
+		</div>
+		<div style="padding: 20px; border: 1px solid #e5e7eb; border-top: none;">
+			<p>Sayın ` + patientName + `,</p>
+			<p>Bekleme listesinde olduğunuz doktor için bir randevu saati açıldı ve sizin için ayrıldı:</p>
+			
+			<div style="background-color: #f3f4f6; padding: 15px; border-radius: 5px; margin: 15px 0;">
+				<p style="margin: 5px 0;"><strong>Doktor:</strong> Dr. ` + doctorName + `</p>
+				<p style="margin: 5px 0;"><strong>Hastane:</strong> ` + hospitalName + `</p>
+				<p style="margin: 5px 0;"><strong>Tarih:</strong> ` + date + `</p>
+				<p style="margin: 5px 0;"><strong>Saat:</strong> ` + time + `</p>
+			</div>
+			
+			<p>Randevuyu almak için teklifi saat <strong>` + expiresAt + `</strong> kadar uygulamamız üzerinden onaylayın. Onaylanmayan teklif listedeki bir sonraki hastaya aktarılır.</p>
+			<p>Sorularınız için lütfen <a href="mailto:info@e-pulse.com">info@e-pulse.com</a> adresine e-posta gönderin veya 0850 123 4567 numaralı telefondan bizi arayın.</p>
+			
+			<p>e-pulse Randevu Sistemi</p>
+		</div>
+		<div style="background-color: #f3f4f6; padding: 10px; text-align: center; font-size: 12px; color: #6b7280;">
+			<p>Bu e-posta otomatik olarak gönderilmiştir, lütfen yanıtlamayınız.</p>
+		</div>
+	</body>
+	</html>
+	`
+
+	// Use SMTP instead of MailerSend API
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
+}
+
+// SendBookingSuspensionEmail tells a patient that missed appointments suspended their bookings until until
+func (m *Mailer) SendBookingSuspensionEmail(ctx context.Context, email, patientName string, noShows int, until string) error {
+	subject := "Randevu Alma Kısıtlaması - e-pulse"
+
+	htmlContent := `
+	<html>
+	<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto;">
+		<div style="background-color: #dc2626; padding: 20px; text-align: center; color: white;">
+			<h1 style="margin: 0;">Randevu Alma Kısıtlaması</h1>
+		</div>
+		<div style="padding: 20px; border: 1px solid #e5e7eb; border-top: none;">
+			<p>Sayın ` + patientName + `,</p>
+			<p>Son dönemde ` + fmt.Sprint(noShows) + ` randevunuza gelmediğiniz için yeni randevu alma hakkınız <strong>` + until + `</strong> tarihine kadar askıya alınmıştır.</p>
+			<p>Mevcut randevularınız geçerliliğini korumaktadır. Gelemeyeceğiniz randevuları lütfen önceden iptal ederek başka hastaların kullanmasına imkan tanıyın.</p>
+			<p>Sorularınız için lütfen <a href="mailto:info@e-pulse.com">info@e-pulse.com</a> adresine e-posta gönderin veya 0850 123 4567 numaralı telefondan bizi arayın.</p>
+			
+			<p>e-pulse Randevu Sistemi</p>
+		</div>
+		<div style="background-color: #f3f4f6; padding: 10px; text-align: center; font-size: 12px; color: #6b7280;">
+			<p>Bu e-posta otomatik olarak gönderilmiştir, lütfen yanıtlamayınız.</p>
+		</div>
+	</body>
+	</html>
+	`
+
+	// Use SMTP instead of MailerSend API
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
+}
+
+// SendAppointmentSeriesEmail tells the patient about several occurrences of a recurring
+// appointment at once, heading says what happened to them
+func (m *Mailer) SendAppointmentSeriesEmail(ctx context.Context, email, patientName, doctorName, hospitalName, heading string, occurrences []string) error {
+	subject := heading + " - e-pulse"
+
+	items := ""
+	for _, occurrence := range occurrences {
+		items += `<li style="margin: 5px 0;">` + occurrence + `</li>`
+	}
+
+	htmlContent := `
+	<html>
+	<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto;">
+		<div style="background-color: #3b82f6; padding: 20px; text-align: center; color: white;">
+			<h1 style="margin: 0;">` + heading + `</h1>
+		</div>
+		<div style="padding: 20px; border: 1px solid #e5e7eb; border-top: none;">
+			<p>Sayın ` + patientName + `,</p>
+			<p>Tekrarlayan randevunuzun aşağıdaki tarihleri için işlem yapılmıştır:</p>
+			
+			<div style="background-color: #f3f4f6; padding: 15px; border-radius: 5px; margin: 15px 0;">
+				<p style="margin: 5px 0;"><strong>Doktor:</strong> Dr. ` + doctorName + `</p>
+				<p style="margin: 5px 0;"><strong>Hastane:</strong> ` + hospitalName + `</p>
+				<ul style="margin: 5px 0;">` + items + `</ul>
+			</div>
+			
+			<p>Sorularınız için lütfen <a href="mailto:info@e-pulse.com">info@e-pulse.com</a> adresine e-posta gönderin veya 0850 123 4567 numaralı telefondan bizi arayın.</p>
+			
+			<p>e-pulse Randevu Sistemi</p>
+		</div>
+		<div style="background-color: #f3f4f6; padding: 10px; text-align: center; font-size: 12px; color: #6b7280;">
+			<p>Bu e-posta otomatik olarak gönderilmiştir, lütfen yanıtlamayınız.</p>
+		</div>
+	</body>
+	</html>
+	`
+
+	// Use SMTP instead of MailerSend API
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
+}
+
+// SendAppointmentChangesEmail lists the cancelled and reassigned appointments of a patient with a doctor in one email
+func (m *Mailer) SendAppointmentChangesEmail(ctx context.Context, email, patientName, doctorName, hospitalName string, changes []string) error {
+	subject := "Randevu Değişikliği - e-pulse"
+
+	items := ""
+	for _, change := range changes {
+		items += `<li style="margin: 5px 0;">` + change + `</li>`
+	}
+
+	htmlContent := `
+	<html>
+	<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto;">
+		<div style="background-color: #f59e0b; padding: 20px; text-align: center; color: white;">
+			<h1 style="margin: 0;">Randevu Değişikliği</h1>
+		</div>
+		<div style="padding: 20px; border: 1px solid #e5e7eb; border-top: none;">
+			<p>Sayın ` + patientName + `,</p>
+			<p>Dr. ` + doctorName + ` ile olan randevularınızda aşağıdaki değişiklikler yapılmıştır:</p>
+			
+			<div style="background-color: #f3f4f6; padding: 15px; border-radius: 5px; margin: 15px 0;">
+				<p style="margin: 5px 0;"><strong>Hastane:</strong> ` + hospitalName + `</p>
+				<ul style="margin: 5px 0;">` + items + `</ul>
+			</div>
+			
+			<p>İptal edilen randevularınız için sistemimiz üzerinden yeni bir randevu alabilirsiniz.</p>
+			<p>Sorularınız için lütfen <a href="mailto:info@e-pulse.com">info@e-pulse.com</a> adresine e-posta gönderin veya 0850 123 4567 numaralı telefondan bizi arayın.</p>
+			
+			<p>e-pulse Randevu Sistemi</p>
+		</div>
+		<div style="background-color: #f3f4f6; padding: 10px; text-align: center; font-size: 12px; color: #6b7280;">
+			<p>Bu e-posta otomatik olarak gönderilmiştir, lütfen yanıtlamayınız.</p>
+		</div>
+	</body>
+	</html>
+	`
+
+	// Use SMTP instead of MailerSend API
+	return m.SendSMTPEmail(ctx, []string{email}, subject, htmlContent)
 }
 
 // SendAppointmentReminderEmail sends an appointment reminder email
-func SendAppointmentReminderEmail(email, patientName, doctorName, hospitalName, date, time string) error {
+func (m *Mailer) SendAppointmentReminderEmail(ctx context.Context, email, patientName, doctorName, hospitalName, date, time string) error {
 	subject := "Randevu Hatırlatması - e-pulse"
 
 	variables := map[string]interface{}{
@@ -439,7 +667,7 @@ func SendAppointmentReminderEmail(email, patientName, doctorName, hospitalName,
 	e-pulse Randevu Sistemi
 	`, patientName, doctorName, hospitalName, date, time)
 
-	return SendMailerSendEmail([]string{email}, subject, htmlContent, textContent, variables)
+	return m.SendMailerSendEmail(ctx, []string{email}, subject, htmlContent, textContent, variables)
 }
 
 // SendSMS sends an SMS using MailerSend API (if they have SMS capabilities)
@@ -1,11 +1,25 @@
 package helper
 
 import (
+	"backend/config"
+	"context"
 	"log"
 	"testing"
 )
 
+// testMailer builds a Mailer from the MailerSend settings in the environment
+func testMailer() *Mailer {
+	cfg := config.Defaults()
+	cfg.LoadEnv()
+	return NewMailer(cfg.Mail)
+}
+
 func TestSendEmail(t *testing.T) {
+	mailer := testMailer()
+	if mailer.config.SMTPPassword == "" {
+		t.Skip("MailerSend credentials not configured, set EMAIL_PASSWORD to send a test email")
+	}
+
 	// Test recipient
 	recipientEmail := "isotuncel4@gmail.com"
 
@@ -17,7 +31,8 @@ func TestSendEmail(t *testing.T) {
 	time := "14:30"
 
 	// Send a test confirmation email
-	err := SendAppointmentConfirmationEmail(
+	err := mailer.SendAppointmentConfirmationEmail(
+		context.Background(),
 		recipientEmail,
 		patientName,
 		doctorName,
@@ -48,7 +63,8 @@ func SendTestEmail() error {
 	log.Printf("Sending test email to %s...", recipientEmail)
 
 	// Send a test confirmation email
-	err := SendAppointmentConfirmationEmail(
+	err := testMailer().SendAppointmentConfirmationEmail(
+		context.Background(),
 		recipientEmail,
 		patientName,
 		doctorName,
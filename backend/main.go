@@ -2,29 +2,34 @@ package main
 
 import (
 	"backend/api"
+	"backend/config"
 	"backend/middleware"
 	"backend/mongodb"
+	"backend/scheduling"
 	wsManager "backend/websocket"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+	// Hospital time zones must load on hosts without a zone database
+	_ "time/tzdata"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var client *mongo.Client
+var app *api.App
 var wsClientManager *wsManager.ClientManager
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
@@ -43,18 +48,94 @@ func init() {
 }
 
 func main() {
-	client = mongodb.ConnectToDB()
+	// An optional leading command selects a maintenance task instead of the server
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	switch command {
+	case "":
+	case "migrate", "migrate-status":
+		runMigrateCommand(cfg, command == "migrate-status")
+		return
+	case "purge":
+		runPurgeCommand(cfg)
+		return
+	default:
+		log.Fatalf("Unknown command %q, expected migrate, migrate-status or purge", command)
+	}
+
+	log.Printf("Starting in %s mode", cfg.Env)
+
+	client := mongodb.ConnectToDB(cfg.Mongo)
+	if cfg.Mongo.MigrateOnStartup {
+		if err := mongodb.Migrate(context.Background(), client, mongodb.Migrations); err != nil {
+			log.Fatalf("Failed to apply migrations: %v", err)
+		}
+	}
+	app = api.NewApp(cfg, api.NewMongoStore(client, cfg.Mongo))
 	defer func() {
-		if err := client.Disconnect(context.TODO()); err != nil {
+		if err := client.Disconnect(context.Background()); err != nil {
 			log.Println("Error disconnecting MongoDB:", err)
 		}
 	}()
 
+	if cfg.Retention.PurgeInterval > 0 {
+		go runPurgeJob(app, time.Duration(cfg.Retention.PurgeInterval), time.Duration(cfg.Retention.Deleted))
+	}
+
 	// Initialize WebSocket Manager
 	wsClientManager = wsManager.NewManager()
 	go wsClientManager.Start()
+	app.Notifier = wsClientManager
+
+	if cfg.Waitlist.SweepInterval > 0 {
+		go runWaitlistJob(app, time.Duration(cfg.Waitlist.SweepInterval))
+	}
+	if cfg.Holds.SweepInterval > 0 {
+		go runHoldJob(app, time.Duration(cfg.Holds.SweepInterval))
+	}
+
+	mux := newRouter(cfg)
+
+	// Configure CORS with improved handling
+	allowedOrigins := cfg.CORSOrigins
+
+	if len(allowedOrigins) == 0 || allowedOrigins[0] == "*" {
+		allowedOrigins = []string{"*"}
+		log.Println("CORS: Using wildcard (*) for all origins - suitable for development only")
+	} else {
+		log.Printf("CORS: Using specific origins: %v", allowedOrigins)
+	}
+
+	c := cors.New(cors.Options{
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-Requested-With", "If-Match"},
+		ExposedHeaders:   []string{"ETag"},
+		AllowCredentials: true,
+		// Enable preflight for all routes
+		OptionsPassthrough: false,
+		// Add debug mode for development
+		Debug: cfg.IsDevelopment(),
+	})
+
+	handler := c.Handler(mux)
+
+	startServer(handler, cfg.Port)
+}
 
+// newRouter registers the API and WebSocket routes, with tokens signed by the configured secret
+func newRouter(cfg *config.Config) *mux.Router {
 	mux := mux.NewRouter()
+	mux.Use(auditActor)
 
 	// Public routes (no authentication required)
 	mux.HandleFunc("/api/auth/register", handleRegisterUser).Methods("POST")
@@ -62,9 +143,48 @@ func main() {
 	mux.HandleFunc("/api/auth/refresh", handleRefreshToken).Methods("POST")
 	mux.HandleFunc("/api/admin/create", handleCreateAdminUser).Methods("POST")
 
+	// Admin routes come first, their fixed paths such as /appointment/cancelRequests and
+	// /appointments/enhanced would otherwise match {appointmentCode} and {doctorCode} below
+	adminRoutes := mux.PathPrefix("/api").Subrouter()
+	adminRoutes.Use(middleware.JWTMiddleware([]byte(cfg.JWT.Secret)))
+	adminRoutes.Use(middleware.RoleMiddleware("admin"))
+	adminRoutes.Use(auditActor)
+	adminRoutes.HandleFunc("/admin/stats", handleGetDashboardStats).Methods("GET")
+	adminRoutes.HandleFunc("/admin/audit", handleGetAuditEntries).Methods("GET")
+	adminRoutes.HandleFunc("/users", handleGetAllUsers).Methods("GET")
+	adminRoutes.HandleFunc("/user/{userCode}", handleDeleteUser).Methods("DELETE")
+	adminRoutes.HandleFunc("/user/{userCode}/restore", handleRestoreUser).Methods("POST")
+	adminRoutes.HandleFunc("/user/{userCode}/suspension", handleLiftSuspension).Methods("DELETE")
+	adminRoutes.HandleFunc("/hospital", handleCreateHospital).Methods("POST")
+	adminRoutes.HandleFunc("/hospital", handleUpdateHospital).Methods("PUT")
+	adminRoutes.HandleFunc("/hospital/{hospitalCode}", handleDeleteHospital).Methods("DELETE")
+	adminRoutes.HandleFunc("/hospital/{hospitalCode}/restore", handleRestoreHospital).Methods("POST")
+	adminRoutes.HandleFunc("/hospital/{hospitalCode}/closure", handleAddHospitalClosure).Methods("POST")
+	adminRoutes.HandleFunc("/admin/holidays/import", handleImportHolidays).Methods("POST")
+	adminRoutes.HandleFunc("/admin/booking-policy", handleGetBookingPolicy).Methods("GET")
+	adminRoutes.HandleFunc("/admin/booking-policy", handleUpdateBookingPolicy).Methods("PUT")
+	adminRoutes.HandleFunc("/doctor", handleCreateDoctor).Methods("POST")
+	adminRoutes.HandleFunc("/doctor", handleUpdateDoctor).Methods("PUT")
+	adminRoutes.HandleFunc("/doctor/{doctorCode}", handleDeleteDoctor).Methods("DELETE")
+	adminRoutes.HandleFunc("/doctor/{doctorCode}/restore", handleRestoreDoctor).Methods("POST")
+	adminRoutes.HandleFunc("/doctor/{doctorCode}/appointments/bulk", handleClearDoctorDays).Methods("POST")
+	adminRoutes.HandleFunc("/appointments/enhanced", handleGetAllAppointmentsEnhanced).Methods("GET")
+	adminRoutes.HandleFunc("/appointments/test", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("=== TEST ROUTE CALLED ===")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "test route works"})
+	}).Methods("GET")
+	adminRoutes.HandleFunc("/appointments", handleGetAllAppointments).Methods("GET")
+	adminRoutes.HandleFunc("/appointment", handleUpdateAppointment).Methods("PUT")
+	adminRoutes.HandleFunc("/appointment/{appointmentCode}/restore", handleRestoreAppointment).Methods("POST")
+	adminRoutes.HandleFunc("/appointment/cancelRequests", handleGetAllAppointmentCancelRequests).Methods("GET")
+	adminRoutes.HandleFunc("/appointment/cancelRequests/{requestCode}", handleUpdateCancelRequestStatus).Methods("PATCH")
+	adminRoutes.HandleFunc("/appointment/cancelRequest", handleDeleteAppointmentCancelRequest).Methods("DELETE")
+
 	// Protected routes
 	protected := mux.PathPrefix("/api").Subrouter()
-	protected.Use(middleware.JWTMiddleware)
+	protected.Use(middleware.JWTMiddleware([]byte(cfg.JWT.Secret)))
+	protected.Use(auditActor)
 
 	// User routes (any authenticated user)
 	protected.HandleFunc("/user/{userCode}", handleGetUser).Methods("GET")
@@ -83,91 +203,150 @@ func main() {
 	protected.HandleFunc("/doctor/{doctorCode}", handleGetDoctor).Methods("GET")
 	protected.HandleFunc("/doctors/{hospitalCode}", handleGetDoctorsByHospitalCode).Methods("GET")
 	protected.HandleFunc("/doctor/{doctorCode}/timeslots", handleGetDoctorTimeSlots).Methods("GET")
+	protected.HandleFunc("/doctor/{doctorCode}/slots", handleGetDoctorFreeSlots).Methods("GET")
+	protected.HandleFunc("/slots/search", handleSearchSlots).Methods("GET")
 	protected.HandleFunc("/appointment", handleCreateAppointment).Methods("POST")
+	protected.HandleFunc("/appointment/hold", handleHoldSlot).Methods("POST")
+	protected.HandleFunc("/appointment/hold/{holdCode}", handleReleaseSlotHold).Methods("DELETE")
+	protected.HandleFunc("/appointment/series", handleCreateSeries).Methods("POST")
+	protected.HandleFunc("/appointment/series/{seriesCode}", handleGetSeries).Methods("GET")
+	protected.HandleFunc("/appointment/series/{seriesCode}", handleCancelSeries).Methods("DELETE")
+	protected.HandleFunc("/appointment/series/{seriesCode}/reschedule", handleRescheduleSeries).Methods("POST")
 	protected.HandleFunc("/appointment/{appointmentCode}", handleGetAppointment).Methods("GET")
 	protected.HandleFunc("/user/{userCode}/appointments", handleGetAppointmentsByUserCode).Methods("GET")
 	protected.HandleFunc("/user/{userCode}/appointments/future", handleGetFutureAppointmentsByUserCode).Methods("GET")
 	protected.HandleFunc("/user/{userCode}/appointments/past", handleGetPastAppointmentsByUserCode).Methods("GET")
 	protected.HandleFunc("/appointment/{appointmentCode}", handleDeleteAppointment).Methods("DELETE")
+	protected.HandleFunc("/appointment/{appointmentCode}/status", handleUpdateAppointmentStatus).Methods("PATCH")
+	protected.HandleFunc("/appointment/{appointmentCode}/reschedule", handleRescheduleAppointment).Methods("POST")
+	protected.HandleFunc("/appointment/{appointmentCode}/notes", handleGetEncounterNote).Methods("GET")
+	protected.HandleFunc("/appointment/{appointmentCode}/notes", handleWriteEncounterNote).Methods("PUT")
+	protected.HandleFunc("/appointment/{appointmentCode}/notes/grants", handleGrantEncounterNote).Methods("POST")
+	protected.HandleFunc("/appointment/{appointmentCode}/notes/grants/{doctorCode}", handleRevokeEncounterNote).Methods("DELETE")
+	protected.HandleFunc("/user/{userCode}/attendance", handleGetAttendance).Methods("GET")
+	protected.HandleFunc("/waitlist", handleJoinWaitlist).Methods("POST")
+	protected.HandleFunc("/user/{userCode}/waitlist", handleGetWaitlistByUserCode).Methods("GET")
+	protected.HandleFunc("/waitlist/{entryCode}", handleLeaveWaitlist).Methods("DELETE")
+	protected.HandleFunc("/waitlist/{entryCode}/accept", handleAcceptWaitlistOffer).Methods("POST")
 
 	// Doctor routes
 	doctorRoutes := mux.PathPrefix("/api").Subrouter()
-	doctorRoutes.Use(middleware.JWTMiddleware)
+	doctorRoutes.Use(middleware.JWTMiddleware([]byte(cfg.JWT.Secret)))
 	doctorRoutes.Use(middleware.RoleMiddleware("doctor", "admin"))
+	doctorRoutes.Use(auditActor)
 	doctorRoutes.HandleFunc("/appointments/{doctorCode}", handleGetAppointmentsByDoctorCode).Methods("GET")
 	doctorRoutes.HandleFunc("/appointment/cancelRequest", handleCreateAppointmentCancelRequest).Methods("POST")
 	doctorRoutes.HandleFunc("/appointment/cancelRequests/{doctorCode}", handleGetAppointmentCancelRequestsByDoctorCode).Methods("GET")
-
-	// Admin routes
-	adminRoutes := mux.PathPrefix("/api").Subrouter()
-	adminRoutes.Use(middleware.JWTMiddleware)
-	adminRoutes.Use(middleware.RoleMiddleware("admin"))
-	adminRoutes.HandleFunc("/admin/stats", handleGetDashboardStats).Methods("GET")
-	adminRoutes.HandleFunc("/users", handleGetAllUsers).Methods("GET")
-	adminRoutes.HandleFunc("/user/{userCode}", handleDeleteUser).Methods("DELETE")
-	adminRoutes.HandleFunc("/hospital", handleCreateHospital).Methods("POST")
-	adminRoutes.HandleFunc("/hospital", handleUpdateHospital).Methods("PUT")
-	adminRoutes.HandleFunc("/hospital/{hospitalCode}", handleDeleteHospital).Methods("DELETE")
-	adminRoutes.HandleFunc("/doctor", handleCreateDoctor).Methods("POST")
-	adminRoutes.HandleFunc("/doctor", handleUpdateDoctor).Methods("PUT")
-	adminRoutes.HandleFunc("/doctor/{doctorCode}", handleDeleteDoctor).Methods("DELETE")
-	adminRoutes.HandleFunc("/appointments/enhanced", handleGetAllAppointmentsEnhanced).Methods("GET")
-	adminRoutes.HandleFunc("/appointments/test", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("=== TEST ROUTE CALLED ===")
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "test route works"})
-	}).Methods("GET")
-	adminRoutes.HandleFunc("/appointments", handleGetAllAppointments).Methods("GET")
-	adminRoutes.HandleFunc("/appointment", handleUpdateAppointment).Methods("PUT")
-	adminRoutes.HandleFunc("/appointment/cancelRequests", handleGetAllAppointmentCancelRequests).Methods("GET")
-	adminRoutes.HandleFunc("/appointment/cancelRequests/{requestCode}", handleUpdateCancelRequestStatus).Methods("PATCH")
-	adminRoutes.HandleFunc("/appointment/cancelRequest", handleDeleteAppointmentCancelRequest).Methods("DELETE")
+	doctorRoutes.HandleFunc("/doctor/{doctorCode}/availability", handleSetDoctorAvailability).Methods("PUT")
+	doctorRoutes.HandleFunc("/doctor/{doctorCode}/leave", handleAddDoctorLeave).Methods("POST")
+	doctorRoutes.HandleFunc("/blackouts", handleGetBlackouts).Methods("GET")
+	doctorRoutes.HandleFunc("/blackout/{blackoutCode}", handleDeleteBlackout).Methods("DELETE")
 
 	// WebSocket
 	mux.HandleFunc("/ws/user/{userCode}", handleUserWebSocket)
 	mux.HandleFunc("/ws/doctor/{doctorCode}", handleDoctorWebSocket)
 	mux.HandleFunc("/ws/admin", handleAdminWebSocket)
 
-	// Configure CORS with improved handling
-	corsOrigins := os.Getenv("CORS_ORIGINS")
-	var allowedOrigins []string
+	return mux
+}
 
-	if corsOrigins == "" {
-		// Default for development
-		allowedOrigins = []string{"*"}
-		log.Println("CORS: Using wildcard (*) for all origins - suitable for development only")
-	} else {
-		// Split comma-separated origins for production
-		allowedOrigins = strings.Split(corsOrigins, ",")
-		// Trim whitespace from each origin
-		for i, origin := range allowedOrigins {
-			allowedOrigins[i] = strings.TrimSpace(origin)
+// runMigrateCommand applies the pending migrations, or only lists them when statusOnly is set
+func runMigrateCommand(cfg *config.Config, statusOnly bool) {
+	client := mongodb.ConnectToDB(cfg.Mongo)
+	defer client.Disconnect(context.Background())
+
+	if !statusOnly {
+		if err := mongodb.Migrate(context.Background(), client, mongodb.Migrations); err != nil {
+			log.Fatalf("Failed to apply migrations: %v", err)
 		}
-		log.Printf("CORS: Using specific origins: %v", allowedOrigins)
 	}
 
-	c := cors.New(cors.Options{
-		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-Requested-With"},
-		AllowCredentials: true,
-		// Enable preflight for all routes
-		OptionsPassthrough: false,
-		// Add debug mode for development
-		Debug: corsOrigins == "",
-	})
+	applied, err := mongodb.AppliedMigrations(context.Background(), client)
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+	for _, migration := range applied {
+		fmt.Printf("applied  %3d  %s (%s)\n", migration.Version, migration.Description, migration.AppliedAt.Format(time.RFC3339))
+	}
 
-	handler := c.Handler(mux)
+	pending, err := mongodb.PendingMigrations(context.Background(), client, mongodb.Migrations)
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+	for _, migration := range pending {
+		fmt.Printf("pending  %3d  %s\n", migration.Version, migration.Description)
+	}
+}
+
+// runPurgeCommand hard-deletes the records whose retention window has passed
+func runPurgeCommand(cfg *config.Config) {
+	client := mongodb.ConnectToDB(cfg.Mongo)
+	defer client.Disconnect(context.Background())
+
+	purgeApp := api.NewApp(cfg, api.NewMongoStore(client, cfg.Mongo))
+	ctx := api.WithActor(context.Background(), api.SystemActor)
+	report, err := api.PurgeDeleted(ctx, purgeApp, time.Now().Add(-time.Duration(cfg.Retention.Deleted)))
+	if err != nil {
+		log.Fatalf("Failed to purge deleted records: %v", err)
+	}
+	fmt.Printf("purged %d users, %d doctors, %d hospitals, %d appointments\n",
+		report.Users, report.Doctors, report.Hospitals, report.Appointments)
+}
+
+// runPurgeJob purges the records deleted longer than retention ago, once every interval
+func runPurgeJob(app *api.App, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := api.WithActor(context.Background(), api.SystemActor)
+		report, err := api.PurgeDeleted(ctx, app, time.Now().Add(-retention))
+		if err != nil {
+			log.Println("Error purging deleted records:", err)
+			continue
+		}
+		log.Printf("Purged %d users, %d doctors, %d hospitals, %d appointments",
+			report.Users, report.Doctors, report.Hospitals, report.Appointments)
+	}
+}
 
-	startServer(handler)
+// runWaitlistJob rolls unclaimed waitlist offers over to the next patient, once every interval
+func runWaitlistJob(app *api.App, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := api.WithActor(context.Background(), api.SystemActor)
+		expired, err := api.ExpireWaitlistOffers(ctx, app, time.Now())
+		if err != nil {
+			log.Println("Error expiring waitlist offers:", err)
+			continue
+		}
+		if expired > 0 {
+			log.Printf("Rolled over %d expired waitlist offers", expired)
+		}
+	}
 }
 
-func startServer(handler http.Handler) {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// runHoldJob releases the slot holds that expired, once every interval
+func runHoldJob(app *api.App, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := api.WithActor(context.Background(), api.SystemActor)
+		expired, err := api.ExpireSlotHolds(ctx, app, time.Now())
+		if err != nil {
+			log.Println("Error expiring slot holds:", err)
+			continue
+		}
+		if expired > 0 {
+			log.Printf("Released %d expired slot holds", expired)
+		}
 	}
+}
 
+func startServer(handler http.Handler, port string) {
 	log.Printf("Server started at http://localhost:%s", port)
 	if err := http.ListenAndServe(":"+port, handler); err != nil {
 		log.Fatal(err)
@@ -180,14 +359,14 @@ func handleLoginUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	tokenResponse, err := api.LoginUser(client, input)
+	tokenResponse, err := api.LoginUser(r.Context(), app, input)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
 	// Try to get user profile data to include in response
-	if profile, err := api.GetUserAdditionalInfo(client, tokenResponse.UserCode); err == nil && profile != nil {
+	if profile, err := api.GetUserAdditionalInfo(r.Context(), app, tokenResponse.UserCode); err == nil && profile != nil {
 		// Create an enhanced response that includes both token data and profile data
 		type EnhancedResponse struct {
 			api.TokenResponse
@@ -224,7 +403,7 @@ func handleRegisterUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	tokenResponse, err := api.RegisterUser(client, user)
+	tokenResponse, err := api.RegisterUser(r.Context(), app, user)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -238,7 +417,7 @@ func handleRegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Ignore errors since this is just an initialization
-	api.CreateUserAdditionalInfo(client, initialProfile)
+	api.CreateUserAdditionalInfo(r.Context(), app, initialProfile)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -255,7 +434,7 @@ func handleRefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenResponse, err := api.RefreshToken(request.RefreshToken)
+	tokenResponse, err := api.RefreshToken(r.Context(), app, request.RefreshToken)
 	if err != nil {
 		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
 		return
@@ -268,31 +447,46 @@ func handleRefreshToken(w http.ResponseWriter, r *http.Request) {
 func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	userCode := mux.Vars(r)["userCode"]
 
-	api.DeleteUser(client, userCode)
+	policy, err := api.ParseDeletionPolicy(r.URL.Query().Get("policy"))
+	var report *api.DeletionReport
+	if err == nil {
+		report, err = api.DeleteUser(r.Context(), app, userCode, requestUserCode(r), policy)
+	}
+	writeCascadeResult(w, report, err, "User deleted successfully")
+}
+
+func handleRestoreUser(w http.ResponseWriter, r *http.Request) {
+	userCode := mux.Vars(r)["userCode"]
+
+	err := api.RestoreUser(r.Context(), app, userCode)
+	writeRestoreResult(w, err, "User restored successfully")
 }
 
 func handleGetAllProvinces(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	provinces := api.GetAllProvinces(client)
+	provinces := api.GetAllProvinces(r.Context(), app)
 	if err := json.NewEncoder(w).Encode(provinces); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func handleGetAllUsers(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	users := api.GetAllUsers(client)
-	if err := json.NewEncoder(w).Encode(users); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+
+	filter := api.UserFilter{Role: r.URL.Query().Get("role"), Deleted: r.URL.Query().Get("deleted") == "true"}
+	page, err := api.ListUsers(r.Context(), app, filter, opts)
+	writePage(w, page, err)
 }
 
 func handleGetUser(w http.ResponseWriter, r *http.Request) {
 	userCode := mux.Vars(r)["userCode"]
 
-	user, err := api.GetUser(client, userCode)
+	user, err := api.GetUser(r.Context(), app, userCode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -307,7 +501,7 @@ func handleGetUser(w http.ResponseWriter, r *http.Request) {
 func handleGetUserProfile(w http.ResponseWriter, r *http.Request) {
 	userCode := mux.Vars(r)["userCode"]
 
-	profile, err := api.GetUserAdditionalInfo(client, userCode)
+	profile, err := api.GetUserAdditionalInfo(r.Context(), app, userCode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -331,7 +525,7 @@ func handleUpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 	// Ensure the userCode in the URL matches the one in the request body
 	profile.UserCode = userCode
 
-	err := api.UpdateUserAdditionalInfo(client, profile)
+	err := api.UpdateUserAdditionalInfo(r.Context(), app, profile)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -343,7 +537,7 @@ func handleUpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 func handleGetDistrictsByProvince(w http.ResponseWriter, r *http.Request) {
 	provinceCode, _ := strconv.Atoi(mux.Vars(r)["provinceCode"])
 
-	districts := api.GetDistrictsByProvince(client, provinceCode)
+	districts := api.GetDistrictsByProvince(r.Context(), app, provinceCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(districts); err != nil {
@@ -354,7 +548,7 @@ func handleGetDistrictsByProvince(w http.ResponseWriter, r *http.Request) {
 func handleGetHospitalsByProvince(w http.ResponseWriter, r *http.Request) {
 	provinceCode, _ := strconv.Atoi(mux.Vars(r)["provinceCode"])
 
-	hospitals := api.GetHospitalsByProvince(client, provinceCode)
+	hospitals := api.GetHospitalsByProvince(r.Context(), app, provinceCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(hospitals); err != nil {
@@ -364,7 +558,7 @@ func handleGetHospitalsByProvince(w http.ResponseWriter, r *http.Request) {
 
 func handleGetHospitalsByDistrict(w http.ResponseWriter, r *http.Request) {
 	districtCode, _ := strconv.Atoi(mux.Vars(r)["districtCode"])
-	hospitals := api.GetHospitalsByDistrict(client, districtCode)
+	hospitals := api.GetHospitalsByDistrict(r.Context(), app, districtCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(hospitals); err != nil {
@@ -375,49 +569,89 @@ func handleGetHospitalsByDistrict(w http.ResponseWriter, r *http.Request) {
 func handleDeleteHospital(w http.ResponseWriter, r *http.Request) {
 	hospitalCode, _ := strconv.Atoi(mux.Vars(r)["hospitalCode"])
 
-	api.DeleteHospital(client, hospitalCode)
+	policy, err := api.ParseDeletionPolicy(r.URL.Query().Get("policy"))
+	var report *api.DeletionReport
+	if err == nil {
+		report, err = api.DeleteHospital(r.Context(), app, hospitalCode, requestUserCode(r), policy)
+	}
+	writeCascadeResult(w, report, err, "Hospital deleted successfully")
+}
+
+func handleRestoreHospital(w http.ResponseWriter, r *http.Request) {
+	hospitalCode, _ := strconv.Atoi(mux.Vars(r)["hospitalCode"])
+
+	err := api.RestoreHospital(r.Context(), app, hospitalCode)
+	writeRestoreResult(w, err, "Hospital restored successfully")
 }
 
 func handleCreateHospital(w http.ResponseWriter, r *http.Request) {
 	var hospital api.Hospital
 	json.NewDecoder(r.Body).Decode(&hospital)
-	api.CreateHospital(client, hospital)
+	if err := api.CheckHospital(hospital); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.CreateHospital(r.Context(), app, hospital)
 }
 
 func handleUpdateHospital(w http.ResponseWriter, r *http.Request) {
 	var hospital api.Hospital
 	json.NewDecoder(r.Body).Decode(&hospital)
-	api.UpdateHospital(client, hospital)
+	if !applyIfMatch(w, r, &hospital.Version) {
+		return
+	}
+
+	updated, err := api.UpdateHospital(r.Context(), app, hospital)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+	writeVersioned(w, updated.Version, updated)
 }
 
 func handleGetAllHospitals(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	hospitals := api.GetAllHospitals(client)
-	if err := json.NewEncoder(w).Encode(hospitals); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var filter api.HospitalFilter
+	filter.ProvinceCode, err = queryInt(r, "provinceCode")
+	if err == nil {
+		filter.DistrictCode, err = queryInt(r, "districtCode")
+	}
+	if err == nil {
+		filter.FieldCode, err = queryInt(r, "field")
+	}
+	if err == nil {
+		filter.Deleted, err = queryDeleted(r)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+
+	page, err := api.ListHospitals(r.Context(), app, filter, opts)
+	writePage(w, page, err)
 }
 
 func handleGetHospital(w http.ResponseWriter, r *http.Request) {
 	hospitalCode, _ := strconv.Atoi(mux.Vars(r)["hospitalCode"])
 
-	hospital, err := api.GetHospital(client, hospitalCode)
+	hospital, err := api.GetHospital(r.Context(), app, hospitalCode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(hospital); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	writeVersioned(w, hospital.Version, hospital)
 }
 
 func handleGetFieldsByProvince(w http.ResponseWriter, r *http.Request) {
 	provinceCode, _ := strconv.Atoi(mux.Vars(r)["provinceCode"])
 
-	fields := api.GetFieldsByProvince(client, provinceCode)
+	fields := api.GetFieldsByProvince(r.Context(), app, provinceCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(fields); err != nil {
@@ -428,7 +662,7 @@ func handleGetFieldsByProvince(w http.ResponseWriter, r *http.Request) {
 func handleGetFieldsByDistrict(w http.ResponseWriter, r *http.Request) {
 	districtCode, _ := strconv.Atoi(mux.Vars(r)["districtCode"])
 
-	fields := api.GetFieldsByProvince(client, districtCode)
+	fields := api.GetFieldsByProvince(r.Context(), app, districtCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(fields); err != nil {
@@ -444,42 +678,66 @@ func handleCreateDoctor(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error parsing doctor data: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := api.CheckDoctorSchedule(doctor); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	api.CreateDoctor(client, doctor)
+	created, err := api.CreateDoctor(r.Context(), app, doctor)
+	if errors.Is(err, api.ErrDoctorAccountTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Return the created doctor
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(doctor)
+	json.NewEncoder(w).Encode(created)
 }
 
 func handleGetAllDoctors(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	doctors := api.GetAllDoctors(client)
-	if err := json.NewEncoder(w).Encode(doctors); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var filter api.DoctorFilter
+	filter.HospitalCode, err = queryInt(r, "hospitalCode")
+	if err == nil {
+		filter.FieldCode, err = queryInt(r, "field")
+	}
+	if err == nil {
+		filter.Deleted, err = queryDeleted(r)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+
+	page, err := api.ListDoctors(r.Context(), app, filter, opts)
+	writePage(w, page, err)
 }
 
 func handleGetDoctor(w http.ResponseWriter, r *http.Request) {
 	doctorCode := mux.Vars(r)["doctorCode"]
 
-	doctor, err := api.GetDoctor(client, doctorCode)
+	doctor, err := api.GetDoctor(r.Context(), app, doctorCode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(doctor); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	writeVersioned(w, doctor.Version, doctor)
 }
 
 func handleGetDoctorsByHospitalCode(w http.ResponseWriter, r *http.Request) {
 	hospitalCode, _ := strconv.Atoi(mux.Vars(r)["hospitalCode"])
 
-	doctors, _ := api.GetDoctorsByHospitalCode(client, hospitalCode)
+	doctors, _ := api.GetDoctorsByHospitalCode(r.Context(), app, hospitalCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(doctors); err != nil {
@@ -488,31 +746,45 @@ func handleGetDoctorsByHospitalCode(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleDeleteDoctor(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	doctorCode := mux.Vars(r)["doctorCode"]
 
-	api.DeleteDoctor(client, doctorCode)
+	policy, err := api.ParseDeletionPolicy(r.URL.Query().Get("policy"))
+	var report *api.DeletionReport
+	if err == nil {
+		report, err = api.DeleteDoctor(r.Context(), app, doctorCode, requestUserCode(r), policy)
+	}
+	writeCascadeResult(w, report, err, "Doctor deleted successfully")
+}
 
-	// Return success message
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Doctor deleted successfully",
-	})
+func handleRestoreDoctor(w http.ResponseWriter, r *http.Request) {
+	doctorCode := mux.Vars(r)["doctorCode"]
+
+	err := api.RestoreDoctor(r.Context(), app, doctorCode)
+	writeRestoreResult(w, err, "Doctor restored successfully")
 }
 
 func handleUpdateDoctor(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	var doctor api.Doctor
 	if err := json.NewDecoder(r.Body).Decode(&doctor); err != nil {
 		http.Error(w, "Error parsing doctor data: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !applyIfMatch(w, r, &doctor.Version) {
+		return
+	}
 
-	api.UpdateDoctor(client, doctor)
+	updated, err := api.UpdateDoctor(r.Context(), app, doctor)
+	if errors.Is(err, api.ErrDoctorAccountTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
 
 	// Return the updated doctor
-	json.NewEncoder(w).Encode(doctor)
+	writeVersioned(w, updated.Version, updated)
 }
 
 func handleCreateAppointment(w http.ResponseWriter, r *http.Request) {
@@ -563,15 +835,28 @@ func handleCreateAppointment(w http.ResponseWriter, r *http.Request) {
 		appointment.AppointmentTime.Date,
 		appointment.AppointmentTime.Time)
 
-	err = api.CreateAppointment(client, appointment)
+	created, err := api.CreateAppointment(r.Context(), app, appointment)
 	if err != nil {
 		log.Println("Error creating appointment:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if writeSlotConflict(w, err) || writePolicyViolation(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, api.ErrInvalidSlot):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, api.ErrHoldExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, api.ErrHoldMismatch):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
+	appointment = *created
 
 	// Get doctor information for the notification
-	doctor, err := api.GetDoctor(client, appointment.DoctorCode)
+	doctor, err := api.GetDoctor(r.Context(), app, appointment.DoctorCode)
 	if err != nil {
 		log.Println("Error getting doctor:", err)
 		// Continue despite error
@@ -619,22 +904,43 @@ func handleDeleteAppointment(w http.ResponseWriter, r *http.Request) {
 	appointmentCode := mux.Vars(r)["appointmentCode"]
 
 	// Get appointment details before deletion
-	appointment, err := api.GetAppointment(client, appointmentCode)
+	appointment, err := api.GetAppointment(r.Context(), app, appointmentCode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	// Get doctor information for the notification
-	doctor, err := api.GetDoctor(client, appointment.DoctorCode)
+	doctor, err := api.GetDoctor(r.Context(), app, appointment.DoctorCode)
 	if err != nil {
 		log.Println("Error getting doctor:", err)
 		// Continue despite error
 	}
 
-	// Delete the appointment
-	err = api.DeleteAppointment(client, appointmentCode)
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !authorizeAppointment(w, r, appointmentCode) {
+		return
+	}
+
+	// Patients cancel within the cancellation window of the hospital, doctors and admins
+	// may cancel for them inside it. Admins delete the appointments that are over.
+	staff := claims.Role == "doctor" || claims.Role == "admin"
+	_, err = api.CancelAppointment(r.Context(), app, appointmentCode, claims.UserCode, r.URL.Query().Get("reason"), staff)
+	if errors.Is(err, api.ErrCannotCancel) && claims.Role == "admin" {
+		err = api.DeleteAppointment(r.Context(), app, appointmentCode, claims.UserCode)
+	}
 	if err != nil {
+		if writeLateCancellation(w, err) {
+			return
+		}
+		if errors.Is(err, api.ErrCannotCancel) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -675,36 +981,1076 @@ func handleDeleteAppointment(w http.ResponseWriter, r *http.Request) {
 func handleUpdateAppointment(w http.ResponseWriter, r *http.Request) {
 	var appointment api.Appointment
 	json.NewDecoder(r.Body).Decode(&appointment)
-	api.UpdateAppointment(client, appointment)
-}
+	if !applyIfMatch(w, r, &appointment.Version) {
+		return
+	}
 
-func handleGetAllAppointments(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	updated, err := api.UpdateAppointment(r.Context(), app, appointment)
+	if err != nil {
+		if writeSlotConflict(w, err) {
+			return
+		}
+		if errors.Is(err, api.ErrCannotReschedule) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeUpdateError(w, err)
+		return
+	}
+	writeVersioned(w, updated.Version, updated)
+}
 
-	appointments := api.GetAllAppointments(client)
-	if err := json.NewEncoder(w).Encode(appointments); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// applyIfMatch takes the expected version from the If-Match header when there is
+// one, otherwise the version in the request body stands. An If-Match that names
+// no version answers 412 right away.
+func applyIfMatch(w http.ResponseWriter, r *http.Request, version *int64) bool {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+	n, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+	if err != nil {
+		http.Error(w, "If-Match does not match the current version", http.StatusPreconditionFailed)
+		return false
 	}
+	*version = n
+	return true
 }
 
-func handleGetAllAppointmentsEnhanced(w http.ResponseWriter, r *http.Request) {
-	log.Println("=== ENHANCED HANDLER CALLED ===")
+// writeVersioned writes record as JSON with its version as the ETag
+func writeVersioned(w http.ResponseWriter, version int64, record interface{}) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
 	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	log.Println("handleGetAllAppointmentsEnhanced called")
+// writeUpdateError answers a failed update, 412 when the record changed since the client read it
+func writeUpdateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, api.ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, api.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
 
-	// Get limit parameter from query string
-	limitStr := r.URL.Query().Get("limit")
-	limit := 0
-	if limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil {
-			limit = parsedLimit
+// parseListOptions reads the limit, pageToken and sort parameters shared by the list endpoints
+func parseListOptions(r *http.Request) (api.ListOptions, error) {
+	query := r.URL.Query()
+	opts := api.ListOptions{
+		PageToken: query.Get("pageToken"),
+		Sort:      query.Get("sort"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("%w: limit must be a positive number", api.ErrInvalidListOptions)
 		}
+		opts.Limit = n
 	}
+	return opts, nil
+}
 
-	log.Printf("Limit parameter: %s, parsed limit: %d", limitStr, limit)
-
-	// Test response first
+// queryInt reads an optional integer filter, nil means the parameter was not given
+func queryInt(r *http.Request, name string) (*int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be a number", api.ErrInvalidListOptions, name)
+	}
+	return &n, nil
+}
+
+// writePage encodes one page of a list endpoint, bad list parameters answer 400
+func writePage[T any](w http.ResponseWriter, page *api.Page[T], err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, api.ErrInvalidListOptions) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func handleRestoreAppointment(w http.ResponseWriter, r *http.Request) {
+	appointmentCode := mux.Vars(r)["appointmentCode"]
+
+	err := api.RestoreAppointment(r.Context(), app, appointmentCode)
+	if writeSlotConflict(w, err) {
+		return
+	}
+	writeRestoreResult(w, err, "Appointment restored successfully")
+}
+
+func handleGetAuditEntries(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := api.AuditFilter{
+		Actor:      query.Get("actor"),
+		TargetType: query.Get("targetType"),
+		TargetCode: query.Get("targetCode"),
+	}
+	filter.From, err = parseAuditTime(query.Get("from"), false)
+	if err == nil {
+		filter.To, err = parseAuditTime(query.Get("to"), true)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := api.ListAuditEntries(r.Context(), app, filter, opts)
+	writePage(w, page, err)
+}
+
+// parseAuditTime reads an audit date bound, either RFC3339 or YYYY-MM-DD. A plain
+// date used as the upper bound covers the whole day.
+func parseAuditTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid date %q", api.ErrInvalidListOptions, value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// auditActor attaches the caller to the request context, the api records it on
+// every write. Routes behind the JWT middleware add the user, others only the IP.
+func auditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := api.Actor{IP: clientIP(r)}
+		if claims, err := middleware.GetUserFromContext(r); err == nil {
+			actor.UserCode = claims.UserCode
+			actor.Role = claims.Role
+		}
+		next.ServeHTTP(w, r.WithContext(api.WithActor(r.Context(), actor)))
+	})
+}
+
+// clientIP is the first address in X-Forwarded-For when behind a proxy, else the remote address
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestUserCode is the code of the authenticated caller, recorded as deletedBy on tombstones
+func requestUserCode(r *http.Request) string {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		return ""
+	}
+	return claims.UserCode
+}
+
+// queryDeleted reads the deleted list filter, only admins may list deleted records
+func queryDeleted(r *http.Request) (bool, error) {
+	if r.URL.Query().Get("deleted") != "true" {
+		return false, nil
+	}
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil || claims.Role != "admin" {
+		return false, fmt.Errorf("%w: only admins can list deleted records", api.ErrInvalidListOptions)
+	}
+	return true, nil
+}
+
+// writeDeleteResult answers a soft delete, 404 when there was nothing to delete
+func writeDeleteResult(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, api.ErrNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	writeResult(w, err, message)
+}
+
+// writeCascadeResult answers a deletion that handles dependent appointments. It
+// returns the report, or 409 with the blocking appointments under the block policy.
+func writeCascadeResult(w http.ResponseWriter, report *api.DeletionReport, err error, message string) {
+	var blocked *api.DeletionBlockedError
+	switch {
+	case errors.As(err, &blocked):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":        "has_future_appointments",
+			"message":      blocked.Error(),
+			"appointments": blocked.Appointments,
+		})
+	case err != nil:
+		writeDeleteResult(w, err, message)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": message, "report": report})
+	}
+}
+
+// writeRestoreResult answers a restore, 404 when there is no deleted record to restore
+func writeRestoreResult(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, api.ErrNotFound) {
+		http.Error(w, "No deleted record found", http.StatusNotFound)
+		return
+	}
+	writeResult(w, err, message)
+}
+
+func writeResult(w http.ResponseWriter, err error, message string) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// writeSlotConflict answers 409 with alternative slots when err is a booking conflict
+func writeSlotConflict(w http.ResponseWriter, err error) bool {
+	var conflict *api.SlotConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":        "slot_taken",
+		"message":      conflict.Error(),
+		"alternatives": conflict.Alternatives,
+	})
+	return true
+}
+
+// writeLateCancellation answers 202 with the filed cancel request when err is a
+// cancellation inside the cancellation window, and tells the doctor and the admins
+func writeLateCancellation(w http.ResponseWriter, err error) bool {
+	var late *api.LateCancellationError
+	if !errors.As(err, &late) {
+		return false
+	}
+
+	notificationContent := map[string]interface{}{
+		"type":            "cancelRequest",
+		"message":         "Hasta son dakika iptal talebinde bulundu",
+		"appointmentCode": late.Request.AppointmentCode,
+		"requestCode":     late.Request.RequestCode,
+		"title":           "İptal Talebi",
+		"timestamp":       time.Now().Format(time.RFC3339),
+	}
+	jsonNotification, _ := json.Marshal(notificationContent)
+	wsClientManager.SendToDoctor(late.Request.DoctorCode, jsonNotification)
+	wsClientManager.SendToAdmin(jsonNotification)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       "late_cancellation",
+		"message":     late.Error(),
+		"cutoffHours": late.CutoffHours,
+		"request":     late.Request,
+	})
+	return true
+}
+
+// writePolicyViolation answers 422 with the reason code when err is a booking policy
+// violation
+func writePolicyViolation(w http.ResponseWriter, err error) bool {
+	var violation *api.PolicyViolation
+	if !errors.As(err, &violation) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   violation.Code,
+		"message": violation.Message,
+	})
+	return true
+}
+
+func handleGetAllAppointments(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := api.AppointmentFilter{
+		DoctorCode:    query.Get("doctorCode"),
+		UserCode:      query.Get("userCode"),
+		DateFrom:      query.Get("dateFrom"),
+		DateTo:        query.Get("dateTo"),
+		LateCancelled: query.Get("lateCancelled") == "true",
+		Deleted:       query.Get("deleted") == "true",
+	}
+	if status := query.Get("status"); status != "" {
+		filter.Status, err = api.ParseAppointmentStatus(status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := api.ListAppointments(r.Context(), app, filter, opts)
+	writePage(w, page, err)
+}
+
+// handleUpdateAppointmentStatus moves an appointment through its lifecycle. Doctors
+// and admins run the visit, patients may only cancel their own appointments.
+func handleUpdateAppointmentStatus(w http.ResponseWriter, r *http.Request) {
+	appointmentCode := mux.Vars(r)["appointmentCode"]
+
+	var body struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Error parsing status: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, err := api.ParseAppointmentStatus(body.Status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !api.StatusAllowedForRole(claims.Role, status) {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+	if !authorizeAppointment(w, r, appointmentCode) {
+		return
+	}
+	staff := claims.Role == "doctor" || claims.Role == "admin"
+
+	var updated *api.Appointment
+	if status == api.StatusCancelledByPatient {
+		// Doctors and admins may cancel for the patient inside the cancellation window
+		updated, err = api.CancelAppointment(r.Context(), app, appointmentCode, claims.UserCode, body.Note, staff)
+	} else {
+		updated, err = api.TransitionAppointment(r.Context(), app, appointmentCode, status, claims.UserCode, body.Note)
+	}
+	if writeLateCancellation(w, err) {
+		return
+	}
+	if errors.Is(err, api.ErrInvalidTransition) || errors.Is(err, api.ErrCannotCancel) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+	writeVersioned(w, updated.Version, updated)
+}
+
+// authorizeAppointment lets admins act on any appointment, doctors on the appointments
+// they see and patients on their own. It answers the request itself when access is denied.
+func authorizeAppointment(w http.ResponseWriter, r *http.Request, appointmentCode string) bool {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if claims.Role == "admin" {
+		return true
+	}
+
+	appointment, err := api.GetAppointment(r.Context(), app, appointmentCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if claims.Role == "doctor" {
+		if !api.IsDoctorAccount(r.Context(), app, claims.UserCode, appointment.DoctorCode) {
+			http.Error(w, "Forbidden: not your patient's appointment", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if appointment.UserCode != claims.UserCode {
+		http.Error(w, "Forbidden: not your appointment", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleRescheduleAppointment moves an appointment to a new slot of the same doctor,
+// keeping its code. Doctors move only their own appointments, patients theirs.
+func handleRescheduleAppointment(w http.ResponseWriter, r *http.Request) {
+	appointmentCode := mux.Vars(r)["appointmentCode"]
+
+	var to api.AppointmentTime
+	if err := json.NewDecoder(r.Body).Decode(&to); err != nil {
+		http.Error(w, "Error parsing appointment time: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.Date == "" || to.Time == "" {
+		http.Error(w, "Missing appointment date or time", http.StatusBadRequest)
+		return
+	}
+	if !authorizeAppointment(w, r, appointmentCode) {
+		return
+	}
+
+	previous, err := api.GetAppointment(r.Context(), app, appointmentCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	updated, err := api.RescheduleAppointment(r.Context(), app, appointmentCode, to)
+	if err != nil {
+		if writeSlotConflict(w, err) || writePolicyViolation(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, api.ErrInvalidSlot):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, api.ErrCannotReschedule):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeUpdateError(w, err)
+		}
+		return
+	}
+
+	if updated.AppointmentTime != previous.AppointmentTime {
+		notificationContent := map[string]interface{}{
+			"type":            "appointmentRescheduled",
+			"message":         "Randevunuzun saati değiştirildi",
+			"appointmentCode": updated.AppointmentCode,
+			"previousDate":    previous.AppointmentTime.Date,
+			"previousTime":    previous.AppointmentTime.Time,
+			"date":            updated.AppointmentTime.Date,
+			"time":            updated.AppointmentTime.Time,
+			"title":           "Randevu Saati Değişikliği",
+			"timestamp":       time.Now().Format(time.RFC3339),
+		}
+		if doctor, err := api.GetDoctor(r.Context(), app, updated.DoctorCode); err == nil {
+			notificationContent["doctorName"] = doctor.DoctorName
+		}
+
+		// Notify user
+		jsonNotification, _ := json.Marshal(notificationContent)
+		wsClientManager.SendToUser(updated.UserCode, jsonNotification)
+
+		// Notify doctor
+		notificationContent["message"] = "Randevu saati değiştirildi"
+		jsonNotification, _ = json.Marshal(notificationContent)
+		wsClientManager.SendToDoctor(updated.DoctorCode, jsonNotification)
+
+		// Notify admin
+		jsonNotification, _ = json.Marshal(notificationContent)
+		wsClientManager.SendToAdmin(jsonNotification)
+	}
+
+	writeVersioned(w, updated.Version, updated)
+}
+
+// handleHoldSlot keeps a free slot for the patient while they fill in the booking and
+// returns the hold token to book it with
+func handleHoldSlot(w http.ResponseWriter, r *http.Request) {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var hold api.SlotHold
+	if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+		http.Error(w, "Error parsing slot hold: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Doctors and admins may hold a slot for a patient, patients hold for themselves
+	if (claims.Role != "doctor" && claims.Role != "admin") || hold.UserCode == "" {
+		hold.UserCode = claims.UserCode
+	}
+	if hold.DoctorCode == "" || hold.AppointmentTime.Date == "" || hold.AppointmentTime.Time == "" {
+		http.Error(w, "Missing doctor code or appointment date or time", http.StatusBadRequest)
+		return
+	}
+
+	held, err := api.HoldSlot(r.Context(), app, hold)
+	if err != nil {
+		if writeSlotConflict(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, api.ErrInvalidSlot):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, api.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(held)
+}
+
+// handleReleaseSlotHold gives up a slot hold before it expires. Patients release only
+// their own holds.
+func handleReleaseSlotHold(w http.ResponseWriter, r *http.Request) {
+	holdCode := mux.Vars(r)["holdCode"]
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	hold, err := api.GetSlotHold(r.Context(), app, holdCode)
+	if err != nil {
+		writeDeleteResult(w, err, "")
+		return
+	}
+	if claims.Role != "doctor" && claims.Role != "admin" && hold.UserCode != claims.UserCode {
+		http.Error(w, "Forbidden: not your slot hold", http.StatusForbidden)
+		return
+	}
+	writeDeleteResult(w, api.ReleaseSlotHold(r.Context(), app, holdCode), "Slot hold released")
+}
+
+// handleCreateSeries books a weekly or biweekly series of appointments. Patients book
+// for themselves, conflicting dates answer 409 unless skipConflicts is set.
+func handleCreateSeries(w http.ResponseWriter, r *http.Request) {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var request api.SeriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Error parsing series: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Doctors and admins may book a series for a patient, patients book for themselves
+	if (claims.Role != "doctor" && claims.Role != "admin") || request.UserCode == "" {
+		request.UserCode = claims.UserCode
+	}
+	if request.DoctorCode == "" || request.Start.Date == "" || request.Start.Time == "" {
+		http.Error(w, "Missing doctor code or start date and time", http.StatusBadRequest)
+		return
+	}
+
+	series, err := api.CreateSeries(r.Context(), app, request)
+	if err != nil {
+		log.Println("Error creating appointment series:", err)
+		if writeSeriesConflict(w, err) || writeSlotConflict(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, api.ErrInvalidSeries), errors.Is(err, api.ErrInvalidSlot):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, api.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	notifySeriesChange("appointmentSeriesCreated", "Tekrarlayan randevunuz oluşturuldu", "Tekrarlayan Randevu", series.SeriesCode, series.Appointments)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(series)
+}
+
+// handleGetSeries returns the occurrences of a series in order
+func handleGetSeries(w http.ResponseWriter, r *http.Request) {
+	occurrences, ok := authorizeSeries(w, r, mux.Vars(r)["seriesCode"])
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(occurrences)
+}
+
+// handleCancelSeries cancels the upcoming occurrences of a series. Patients cancel
+// within the cancellation window and get cancel requests for the rest, doctors and
+// admins cancel for the hospital.
+func handleCancelSeries(w http.ResponseWriter, r *http.Request) {
+	seriesCode := mux.Vars(r)["seriesCode"]
+	occurrences, ok := authorizeSeries(w, r, seriesCode)
+	if !ok {
+		return
+	}
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	status := api.StatusCancelledByPatient
+	if claims.Role == "doctor" || claims.Role == "admin" {
+		status = api.StatusCancelledByHospital
+	}
+	result, err := api.CancelSeries(r.Context(), app, seriesCode, status, claims.UserCode, r.URL.Query().Get("reason"))
+	if err != nil {
+		if errors.Is(err, api.ErrCannotCancel) || errors.Is(err, api.ErrInvalidTransition) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeUpdateError(w, err)
+		return
+	}
+
+	notifySeriesChange("appointmentSeriesCancelled", "Tekrarlayan randevunuz iptal edildi", "Randevu İptali", seriesCode, result.Cancelled)
+	if len(result.Requests) > 0 {
+		notificationContent := map[string]interface{}{
+			"type":       "cancelRequest",
+			"message":    "Hasta son dakika iptal talebinde bulundu",
+			"seriesCode": seriesCode,
+			"requests":   result.Requests,
+			"title":      "İptal Talebi",
+			"timestamp":  time.Now().Format(time.RFC3339),
+		}
+		jsonNotification, _ := json.Marshal(notificationContent)
+		wsClientManager.SendToDoctor(occurrences[0].DoctorCode, jsonNotification)
+		wsClientManager.SendToAdmin(jsonNotification)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleRescheduleSeries moves the upcoming occurrences of a series, the next one to
+// the given date and time and the later ones along with it
+func handleRescheduleSeries(w http.ResponseWriter, r *http.Request) {
+	seriesCode := mux.Vars(r)["seriesCode"]
+
+	var to api.AppointmentTime
+	if err := json.NewDecoder(r.Body).Decode(&to); err != nil {
+		http.Error(w, "Error parsing appointment time: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.Date == "" || to.Time == "" {
+		http.Error(w, "Missing appointment date or time", http.StatusBadRequest)
+		return
+	}
+	if _, ok := authorizeSeries(w, r, seriesCode); !ok {
+		return
+	}
+
+	updated, err := api.RescheduleSeries(r.Context(), app, seriesCode, to)
+	if err != nil {
+		if writeSeriesConflict(w, err) || writeSlotConflict(w, err) || writePolicyViolation(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, api.ErrInvalidSlot):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, api.ErrCannotReschedule):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeUpdateError(w, err)
+		}
+		return
+	}
+
+	notifySeriesChange("appointmentSeriesRescheduled", "Tekrarlayan randevunuzun saati değiştirildi", "Randevu Saati Değişikliği", seriesCode, updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// authorizeSeries returns the occurrences of the series when the user may act on it,
+// doctors and admins on any and patients only on their own. It answers the request
+// itself otherwise.
+func authorizeSeries(w http.ResponseWriter, r *http.Request, seriesCode string) ([]api.Appointment, bool) {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	occurrences, err := api.GetSeries(r.Context(), app, seriesCode)
+	if err != nil {
+		writeUpdateError(w, err)
+		return nil, false
+	}
+	if claims.Role != "doctor" && claims.Role != "admin" && occurrences[0].UserCode != claims.UserCode {
+		http.Error(w, "Forbidden: not your appointment series", http.StatusForbidden)
+		return nil, false
+	}
+	return occurrences, true
+}
+
+// notifySeriesChange tells the patient, the doctor and the admins about occurrences
+// of a series that were booked, cancelled or moved
+func notifySeriesChange(kind, message, title, seriesCode string, occurrences []api.Appointment) {
+	if len(occurrences) == 0 {
+		return
+	}
+	times := make([]api.AppointmentTime, len(occurrences))
+	for i, occurrence := range occurrences {
+		times[i] = occurrence.AppointmentTime
+	}
+	notificationContent := map[string]interface{}{
+		"type":        kind,
+		"message":     message,
+		"seriesCode":  seriesCode,
+		"occurrences": times,
+		"title":       title,
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}
+
+	jsonNotification, _ := json.Marshal(notificationContent)
+	wsClientManager.SendToUser(occurrences[0].UserCode, jsonNotification)
+	wsClientManager.SendToDoctor(occurrences[0].DoctorCode, jsonNotification)
+	wsClientManager.SendToAdmin(jsonNotification)
+}
+
+// writeSeriesConflict answers 409 with the conflicting dates when err is a series conflict
+func writeSeriesConflict(w http.ResponseWriter, err error) bool {
+	var conflict *api.SeriesConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     "series_conflict",
+		"message":   conflict.Error(),
+		"conflicts": conflict.Conflicts,
+	})
+	return true
+}
+
+func handleJoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var request api.WaitlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Error parsing waitlist entry: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Admins may put a patient on the waitlist, everybody else joins it themselves
+	if claims.Role != "admin" || request.UserCode == "" {
+		request.UserCode = claims.UserCode
+	}
+
+	created, err := api.JoinWaitlist(r.Context(), app, request)
+	switch {
+	case errors.Is(err, api.ErrInvalidWaitlist):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, api.ErrAlreadyWaitlisted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, api.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+// handleGetAttendance returns the attendance counters and penalties of a patient.
+// Patients see only their own, doctors and admins any.
+func handleGetAttendance(w http.ResponseWriter, r *http.Request) {
+	userCode := mux.Vars(r)["userCode"]
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims.Role != "admin" && claims.Role != "doctor" && claims.UserCode != userCode {
+		http.Error(w, "Forbidden: not your attendance", http.StatusForbidden)
+		return
+	}
+
+	attendance, err := api.GetAttendance(r.Context(), app, userCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeVersioned(w, attendance.Version, attendance)
+}
+
+// handleLiftSuspension ends the booking suspension of a patient early
+func handleLiftSuspension(w http.ResponseWriter, r *http.Request) {
+	userCode := mux.Vars(r)["userCode"]
+
+	attendance, err := api.LiftSuspension(r.Context(), app, userCode, requestUserCode(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeVersioned(w, attendance.Version, attendance)
+}
+
+// noteReader is the authenticated caller as a reader of encounter notes
+func noteReader(r *http.Request) api.NoteReader {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		return api.NoteReader{}
+	}
+	return api.NoteReader{UserCode: claims.UserCode, Role: claims.Role}
+}
+
+// writeNoteError answers a failed encounter note request
+func writeNoteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, api.ErrNoteAccess):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, api.ErrInvalidNote):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, api.ErrVersionConflict), errors.Is(err, api.ErrNotFound):
+		writeUpdateError(w, err)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleGetEncounterNote returns the encounter note of an appointment to its patient,
+// its doctor, the doctors it is shared with and admins
+func handleGetEncounterNote(w http.ResponseWriter, r *http.Request) {
+	appointmentCode := mux.Vars(r)["appointmentCode"]
+
+	note, err := api.GetEncounterNote(r.Context(), app, appointmentCode, noteReader(r))
+	if err != nil {
+		writeNoteError(w, err)
+		return
+	}
+	writeVersioned(w, note.Version, note)
+}
+
+// handleWriteEncounterNote writes the encounter note of an appointment or a new version
+// of it. The version read, zero for a new note, comes in the body or in If-Match.
+func handleWriteEncounterNote(w http.ResponseWriter, r *http.Request) {
+	appointmentCode := mux.Vars(r)["appointmentCode"]
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims.Role != "doctor" {
+		http.Error(w, "Forbidden: only doctors write encounter notes", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		api.Encounter
+		Version int64 `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Error parsing encounter note: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !applyIfMatch(w, r, &request.Version) {
+		return
+	}
+
+	note, err := api.WriteEncounterNote(r.Context(), app, appointmentCode, request.Encounter, request.Version, claims.UserCode)
+	if err != nil {
+		writeNoteError(w, err)
+		return
+	}
+	writeVersioned(w, note.Version, note)
+}
+
+// handleGrantEncounterNote shares the encounter note of an appointment with another doctor
+func handleGrantEncounterNote(w http.ResponseWriter, r *http.Request) {
+	appointmentCode := mux.Vars(r)["appointmentCode"]
+
+	var request struct {
+		DoctorCode string `json:"doctorCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Error parsing grant: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.DoctorCode == "" {
+		http.Error(w, "Missing doctor code", http.StatusBadRequest)
+		return
+	}
+
+	note, err := api.GrantEncounterNote(r.Context(), app, appointmentCode, request.DoctorCode, noteReader(r))
+	if err != nil {
+		writeNoteError(w, err)
+		return
+	}
+	writeVersioned(w, note.Version, note)
+}
+
+// handleRevokeEncounterNote stops sharing the encounter note of an appointment with a doctor
+func handleRevokeEncounterNote(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	note, err := api.RevokeEncounterNote(r.Context(), app, vars["appointmentCode"], vars["doctorCode"], noteReader(r))
+	if err != nil {
+		writeNoteError(w, err)
+		return
+	}
+	writeVersioned(w, note.Version, note)
+}
+
+func handleGetWaitlistByUserCode(w http.ResponseWriter, r *http.Request) {
+	userCode := mux.Vars(r)["userCode"]
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims.Role != "admin" && claims.UserCode != userCode {
+		http.Error(w, "Forbidden: not your waitlist", http.StatusForbidden)
+		return
+	}
+
+	entries, err := api.GetWaitlistByUserCode(r.Context(), app, userCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []api.WaitlistEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// authorizeWaitlistEntry lets admins act on any waitlist entry and patients only on
+// their own. It answers the request itself when access is denied.
+func authorizeWaitlistEntry(w http.ResponseWriter, r *http.Request, entryCode string) bool {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	entry, err := api.GetWaitlistEntry(r.Context(), app, entryCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if claims.Role != "admin" && entry.UserCode != claims.UserCode {
+		http.Error(w, "Forbidden: not your waitlist entry", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func handleLeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	entryCode := mux.Vars(r)["entryCode"]
+	if !authorizeWaitlistEntry(w, r, entryCode) {
+		return
+	}
+
+	if err := api.LeaveWaitlist(r.Context(), app, entryCode); err != nil {
+		if errors.Is(err, api.ErrVersionConflict) {
+			http.Error(w, "waitlist entry changed meanwhile, try again", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAcceptWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	entryCode := mux.Vars(r)["entryCode"]
+	if !authorizeWaitlistEntry(w, r, entryCode) {
+		return
+	}
+
+	appointment, err := api.AcceptWaitlistOffer(r.Context(), app, entryCode)
+	if writePolicyViolation(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, api.ErrNoOffer):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, api.ErrOfferExpired), errors.Is(err, api.ErrSlotTaken):
+		http.Error(w, api.ErrOfferExpired.Error(), http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	notificationContent := map[string]interface{}{
+		"type":            "appointmentCreated",
+		"message":         "Bekleme listesinden yeni randevu oluşturuldu",
+		"appointmentCode": appointment.AppointmentCode,
+		"date":            appointment.AppointmentTime.Date,
+		"time":            appointment.AppointmentTime.Time,
+		"title":           "Yeni Randevu",
+		"timestamp":       time.Now().Format(time.RFC3339),
+	}
+	jsonNotification, _ := json.Marshal(notificationContent)
+	wsClientManager.SendToDoctor(appointment.DoctorCode, jsonNotification)
+	wsClientManager.SendToAdmin(jsonNotification)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(appointment)
+}
+
+func handleGetAllAppointmentsEnhanced(w http.ResponseWriter, r *http.Request) {
+	log.Println("=== ENHANCED HANDLER CALLED ===")
+	w.Header().Set("Content-Type", "application/json")
+
+	log.Println("handleGetAllAppointmentsEnhanced called")
+
+	// Get limit parameter from query string
+	limitStr := r.URL.Query().Get("limit")
+	limit := 0
+	if limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil {
+			limit = parsedLimit
+		}
+	}
+
+	log.Printf("Limit parameter: %s, parsed limit: %d", limitStr, limit)
+
+	// Test response first
 	testResponse := []map[string]interface{}{
 		{
 			"appointmentId":    "test123",
@@ -728,22 +2074,19 @@ func handleGetAllAppointmentsEnhanced(w http.ResponseWriter, r *http.Request) {
 func handleGetAppointment(w http.ResponseWriter, r *http.Request) {
 	appointmentCode := mux.Vars(r)["appointmentCode"]
 
-	appointment, err := api.GetAppointment(client, appointmentCode)
+	appointment, err := api.GetAppointment(r.Context(), app, appointmentCode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(appointment); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	writeVersioned(w, appointment.Version, appointment)
 }
 
 func handleGetAppointmentsByDoctorCode(w http.ResponseWriter, r *http.Request) {
 	doctorCode := mux.Vars(r)["doctorCode"]
 
-	appointments := api.GetAppointmentsByDoctorCode(client, doctorCode)
+	appointments := api.GetAppointmentsByDoctorCode(r.Context(), app, doctorCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(appointments); err != nil {
@@ -754,7 +2097,7 @@ func handleGetAppointmentsByDoctorCode(w http.ResponseWriter, r *http.Request) {
 func handleGetAppointmentsByUserCode(w http.ResponseWriter, r *http.Request) {
 	userCode := mux.Vars(r)["userCode"]
 
-	appointments := api.GetAppointmentsByUserCode(client, userCode)
+	appointments := api.GetAppointmentsByUserCode(r.Context(), app, userCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(appointments); err != nil {
@@ -765,22 +2108,29 @@ func handleGetAppointmentsByUserCode(w http.ResponseWriter, r *http.Request) {
 func handleCreateAppointmentCancelRequest(w http.ResponseWriter, r *http.Request) {
 	var request api.AppointmentDeleteRequest
 	json.NewDecoder(r.Body).Decode(&request)
-	api.CreateAppointmentCancelRequest(client, request)
+	api.CreateAppointmentCancelRequest(r.Context(), app, request)
 }
 
 func handleGetAllAppointmentCancelRequests(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	requests := api.GetAllAppointmentCancelRequests(client)
-	if err := json.NewEncoder(w).Encode(requests); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	query := r.URL.Query()
+	filter := api.RequestFilter{
+		DoctorCode: query.Get("doctorCode"),
+		Status:     query.Get("status"),
 	}
+	page, err := api.ListAppointmentCancelRequests(r.Context(), app, filter, opts)
+	writePage(w, page, err)
 }
 
 func handleGetAppointmentCancelRequestsByDoctorCode(w http.ResponseWriter, r *http.Request) {
 	userCode := mux.Vars(r)["userCode"]
 
-	appointments := api.GetAppointmentCancelRequestsByDoctorCode(client, userCode)
+	appointments := api.GetAppointmentCancelRequestsByDoctorCode(r.Context(), app, userCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(appointments); err != nil {
@@ -789,13 +2139,29 @@ func handleGetAppointmentCancelRequestsByDoctorCode(w http.ResponseWriter, r *ht
 }
 
 func handleUpdateCancelRequestStatus(w http.ResponseWriter, r *http.Request) {
-	userCode := mux.Vars(r)["requestCode"]
+	requestCode := mux.Vars(r)["requestCode"]
 
 	var updateData struct {
 		Status string `json:"status"`
 	}
+	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+		http.Error(w, "Error parsing request status: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if updateData.Status != api.RequestApproved && updateData.Status != api.RequestRejected {
+		http.Error(w, "status must be approved or rejected", http.StatusBadRequest)
+		return
+	}
 
-	if err := api.UpdateCancelRequestStatus(client, userCode, updateData.Status); err != nil {
+	err := api.UpdateCancelRequestStatus(r.Context(), app, requestCode, updateData.Status, requestUserCode(r))
+	switch {
+	case errors.Is(err, api.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, api.ErrRequestClosed), errors.Is(err, api.ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
 		http.Error(w, "Failed to update request status", http.StatusInternalServerError)
 		return
 	}
@@ -806,7 +2172,7 @@ func handleUpdateCancelRequestStatus(w http.ResponseWriter, r *http.Request) {
 func handleDeleteAppointmentCancelRequest(w http.ResponseWriter, r *http.Request) {
 	doctorCode := mux.Vars(r)["doctorCode"]
 
-	api.DeleteAppointmentCancelRequest(client, doctorCode)
+	api.DeleteAppointmentCancelRequest(r.Context(), app, doctorCode)
 }
 
 func handleUserWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -897,7 +2263,7 @@ func handleAdminWebSocket(w http.ResponseWriter, r *http.Request) {
 
 func handleGetFutureAppointmentsByUserCode(w http.ResponseWriter, r *http.Request) {
 	userCode := mux.Vars(r)["userCode"]
-	appointments := api.GetFutureAppointmentsByUserCode(client, userCode)
+	appointments := api.GetFutureAppointmentsByUserCode(r.Context(), app, userCode)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(appointments); err != nil {
@@ -907,7 +2273,7 @@ func handleGetFutureAppointmentsByUserCode(w http.ResponseWriter, r *http.Reques
 
 func handleGetPastAppointmentsByUserCode(w http.ResponseWriter, r *http.Request) {
 	userCode := mux.Vars(r)["userCode"]
-	appointments := api.GetPastAppointmentsByUserCode(client, userCode)
+	appointments := api.GetPastAppointmentsByUserCode(r.Context(), app, userCode, noteReader(r))
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(appointments); err != nil {
@@ -925,117 +2291,380 @@ func handleGetDoctorTimeSlots(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get doctor info to get working hours
-	doctor, err := api.GetDoctor(client, doctorCode)
+	schedule, err := api.GetDoctorTimeSlots(r.Context(), app, doctorCode, date)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	// Get all appointments for this doctor on this date
-	appointments := api.GetAppointmentsByDoctorCode(client, doctorCode)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	// Filter appointments for the requested date
-	bookedSlots := make(map[string]bool)
-	for _, appointment := range appointments {
-		if appointment.AppointmentTime.Date == date {
-			bookedSlots[appointment.AppointmentTime.Time] = true
+// handleGetDoctorFreeSlots lists the free slots of a doctor between the from and to dates
+func handleGetDoctorFreeSlots(w http.ResponseWriter, r *http.Request) {
+	doctorCode := mux.Vars(r)["doctorCode"]
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = from
+	}
+
+	slots, err := api.GetDoctorFreeSlots(r.Context(), app, doctorCode, from, to)
+	if err != nil {
+		if errors.Is(err, api.ErrInvalidSlot) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusNotFound)
 		}
+		return
 	}
 
-	// Generate time slots based on doctor's working hours
-	workStart := doctor.WorkHours.Start
-	workEnd := doctor.WorkHours.End
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(slots); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	// Default values if not specified - ensure 9:00 AM to 5:00 PM
-	if workStart == "" {
-		workStart = "09:00"
+// handleSearchSlots finds the earliest free slots of a field around a province,
+// district or hospital
+func handleSearchSlots(w http.ResponseWriter, r *http.Request) {
+	search := api.SlotSearch{
+		DateFrom: r.URL.Query().Get("from"),
+		DateTo:   r.URL.Query().Get("to"),
+	}
+	field, err := queryInt(r, "field")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	search.FieldCode = field
+	for name, target := range map[string]*int{
+		"provinceCode": &search.ProvinceCode,
+		"districtCode": &search.DistrictCode,
+		"hospitalCode": &search.HospitalCode,
+		"limit":        &search.Limit,
+	} {
+		value, err := queryInt(r, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if value != nil {
+			*target = *value
+		}
 	}
-	if workEnd == "" {
-		workEnd = "17:00"
+
+	matches, err := api.FindFirstAvailable(r.Context(), app, search)
+	switch {
+	case errors.Is(err, api.ErrInvalidSearch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, api.ErrNotFound):
+		http.Error(w, "Hospital not found", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matches)
 	}
+}
 
-	// Parse start and end times
-	startHour, startMin := 9, 0
-	endHour, endMin := 17, 0
+// handleSetDoctorAvailability replaces the weekly template and overrides of a doctor,
+// a null body goes back to the doctor's work hours
+func handleSetDoctorAvailability(w http.ResponseWriter, r *http.Request) {
+	doctorCode := mux.Vars(r)["doctorCode"]
 
-	fmt.Sscanf(workStart, "%d:%d", &startHour, &startMin)
-	fmt.Sscanf(workEnd, "%d:%d", &endHour, &endMin)
+	var availability *scheduling.Availability
+	if err := json.NewDecoder(r.Body).Decode(&availability); err != nil {
+		http.Error(w, "Error parsing availability: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	version := int64(-1)
+	if !applyIfMatch(w, r, &version) {
+		return
+	}
 
-	// Ensure working hours are between 9:00-17:00 regardless of what's stored
-	if startHour < 9 {
-		startHour = 9
-		startMin = 0
+	updated, err := api.SetDoctorAvailability(r.Context(), app, doctorCode, availability, version)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
 	}
-	if endHour > 17 {
-		endHour = 17
-		endMin = 0
+	writeVersioned(w, updated.Version, updated)
+}
+
+// handleAddDoctorLeave records a leave of the doctor and flags the appointments it falls on
+func handleAddDoctorLeave(w http.ResponseWriter, r *http.Request) {
+	var blackout api.Blackout
+	if err := json.NewDecoder(r.Body).Decode(&blackout); err != nil {
+		http.Error(w, "Error parsing leave: "+err.Error(), http.StatusBadRequest)
+		return
 	}
+	blackout.Kind = api.BlackoutLeave
+	blackout.DoctorCode = mux.Vars(r)["doctorCode"]
+	blackout.HospitalCode = 0
+	blackout.CreatedBy = requestUserCode(r)
+
+	created, conflicts, err := api.AddBlackout(r.Context(), app, blackout)
+	writeBlackoutResult(w, r, created, conflicts, err)
+}
 
-	// Check if selected date is today
-	isToday := false
-	today := time.Now().Format("2006-01-02")
-	if date == today {
-		isToday = true
+// handleAddHospitalClosure closes a hospital for a date range or, with closedFrom,
+// for the rest of one day
+func handleAddHospitalClosure(w http.ResponseWriter, r *http.Request) {
+	hospitalCode, err := strconv.Atoi(mux.Vars(r)["hospitalCode"])
+	if err != nil {
+		http.Error(w, "Invalid hospital code", http.StatusBadRequest)
+		return
 	}
-	currentHour, currentMin := time.Now().Hour(), time.Now().Minute()
 
-	// Generate slots - assuming 15 minute intervals
-	var timeSlots []map[string]interface{}
-	slotId := 1
+	var blackout api.Blackout
+	if err := json.NewDecoder(r.Body).Decode(&blackout); err != nil {
+		http.Error(w, "Error parsing closure: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	blackout.Kind = api.BlackoutClosure
+	blackout.HospitalCode = hospitalCode
+	blackout.DoctorCode = ""
+	blackout.CreatedBy = requestUserCode(r)
 
-	// For the end hour (17:00), we need to include slots up to endMin
-	for hour := startHour; hour <= endHour; hour++ {
-		for minute := 0; minute < 60; minute += 15 {
-			// Skip if we've gone past the end time
-			if hour > endHour || (hour == endHour && minute > 0) {
-				continue
-			}
+	created, conflicts, err := api.AddBlackout(r.Context(), app, blackout)
+	writeBlackoutResult(w, r, created, conflicts, err)
+}
 
-			// Skip minutes before start time for first hour
-			if hour == startHour && minute < startMin {
-				continue
-			}
+// handleImportHolidays replaces the public holidays of a year, with the built-in
+// Turkish calendar when the body lists none
+func handleImportHolidays(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Year     int                  `json:"year"`
+		Holidays []scheduling.Holiday `json:"holidays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Error parsing holidays: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Year < 2000 || body.Year > 2100 {
+		http.Error(w, "year must be between 2000 and 2100", http.StatusBadRequest)
+		return
+	}
 
-			// Skip past time slots if booking for today
-			if isToday && (hour < currentHour || (hour == currentHour && minute <= currentMin)) {
-				continue
-			}
+	holidays, conflicts, err := api.ImportHolidays(r.Context(), app, body.Year, body.Holidays, requestUserCode(r))
+	if err != nil {
+		writeBlackoutError(w, err)
+		return
+	}
+	notifyBlackoutConflicts(r.Context(), conflicts)
 
-			// Format time as HH:MM
-			timeStr := fmt.Sprintf("%02d:%02d", hour, minute)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"holidays":  holidays,
+		"conflicts": conflicts,
+	})
+}
 
-			// Check if slot is already booked
-			_, isBooked := bookedSlots[timeStr]
+// handleGetBookingPolicy returns the booking policy with its version in the ETag
+func handleGetBookingPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := api.GetBookingPolicy(r.Context(), app)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeVersioned(w, policy.Version, policy)
+}
 
-			// Add slot to response
-			timeSlots = append(timeSlots, map[string]interface{}{
-				"slotId":     slotId,
-				"startTime":  timeStr,
-				"endTime":    fmt.Sprintf("%02d:%02d", hour+(minute+15)/60, (minute+15)%60),
-				"available":  !isBooked,
-				"isBooked":   isBooked,
-				"doctorName": doctor.DoctorName,
-			})
+// handleUpdateBookingPolicy replaces the booking policy, honouring If-Match
+func handleUpdateBookingPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy api.BookingPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Error parsing booking policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !applyIfMatch(w, r, &policy.Version) {
+		return
+	}
 
-			slotId++
+	updated, err := api.UpdateBookingPolicy(r.Context(), app, policy)
+	if err != nil {
+		if errors.Is(err, api.ErrInvalidBookingPolicy) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		writeUpdateError(w, err)
+		return
 	}
+	writeVersioned(w, updated.Version, updated)
+}
 
-	// Add doctor info to the response
-	response := map[string]interface{}{
-		"timeSlots": timeSlots,
-		"doctorInfo": map[string]string{
-			"doctorName": doctor.DoctorName,
-			"workStart":  workStart,
-			"workEnd":    workEnd,
-		},
+// handleGetBlackouts lists leave, closures and holidays filtered by kind, doctorCode,
+// hospitalCode and the from and to dates
+func handleGetBlackouts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := api.BlackoutFilter{
+		Kind:       api.BlackoutKind(query.Get("kind")),
+		DoctorCode: query.Get("doctorCode"),
+		DateFrom:   query.Get("from"),
+		DateTo:     query.Get("to"),
+	}
+	hospitalCode, err := queryInt(r, "hospitalCode")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hospitalCode != nil {
+		filter.HospitalCode = *hospitalCode
+	}
+
+	blackouts, err := api.ListBlackouts(r.Context(), app, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blackouts)
+}
+
+// handleDeleteBlackout removes a blackout, doctors may only remove leave
+func handleDeleteBlackout(w http.ResponseWriter, r *http.Request) {
+	claims, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	blackoutCode := mux.Vars(r)["blackoutCode"]
+
+	blackout, err := api.GetBlackout(r.Context(), app, blackoutCode)
+	if err != nil {
+		writeBlackoutError(w, err)
+		return
+	}
+	if claims.Role != "admin" && blackout.Kind != api.BlackoutLeave {
+		http.Error(w, "Forbidden: only admins remove closures and holidays", http.StatusForbidden)
+		return
 	}
 
+	if _, err := api.DeleteBlackout(r.Context(), app, blackoutCode); err != nil {
+		writeBlackoutError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Blackout deleted successfully"})
+}
+
+// writeBlackoutResult answers a created blackout with the appointments it conflicts with
+func writeBlackoutResult(w http.ResponseWriter, r *http.Request, blackout *api.Blackout, conflicts []string, err error) {
+	if err != nil {
+		writeBlackoutError(w, err)
+		return
+	}
+	notifyBlackoutConflicts(r.Context(), conflicts)
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"blackout":  blackout,
+		"conflicts": conflicts,
+	})
+}
+
+func writeBlackoutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, api.ErrInvalidBlackout):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, api.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// notifyBlackoutConflicts tells the doctors and the admins which appointments now
+// fall on a blackout, so they can be rescheduled
+func notifyBlackoutConflicts(ctx context.Context, conflicts []string) {
+	if len(conflicts) == 0 {
+		return
+	}
+
+	byDoctor := map[string][]string{}
+	for _, appointmentCode := range conflicts {
+		appointment, err := api.GetAppointment(ctx, app, appointmentCode)
+		if err != nil {
+			continue
+		}
+		byDoctor[appointment.DoctorCode] = append(byDoctor[appointment.DoctorCode], appointmentCode)
+	}
+
+	notification := func(codes []string) []byte {
+		jsonNotification, _ := json.Marshal(map[string]interface{}{
+			"type":             "appointmentConflict",
+			"message":          "Bazı randevular izin veya tatil gününe denk geliyor",
+			"appointmentCodes": codes,
+			"title":            "Randevu Çakışması",
+			"timestamp":        time.Now().Format(time.RFC3339),
+		})
+		return jsonNotification
+	}
+	for doctorCode, codes := range byDoctor {
+		wsClientManager.SendToDoctor(doctorCode, notification(codes))
+	}
+	wsClientManager.SendToAdmin(notification(conflicts))
+}
+
+// handleClearDoctorDays cancels or reassigns the appointments of a doctor over a date
+// range, or only previews it with dryRun
+func handleClearDoctorDays(w http.ResponseWriter, r *http.Request) {
+	var request api.BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Error parsing request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	request.DoctorCode = mux.Vars(r)["doctorCode"]
+
+	result, err := api.ClearDoctorDays(r.Context(), app, request, requestUserCode(r))
+	switch {
+	case errors.Is(err, api.ErrInvalidBulkRequest), errors.Is(err, api.ErrInvalidBlackout):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, api.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	case err != nil:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !result.DryRun {
+		notifyBulkResult(result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// notifyBulkResult sends the doctor, the doctors who took over appointments and the
+// admins one summary of a bulk cancellation or reassignment
+func notifyBulkResult(result *api.BulkResult) {
+	if len(result.Cancelled) == 0 && len(result.Reassigned) == 0 {
+		return
+	}
+	jsonNotification, _ := json.Marshal(map[string]interface{}{
+		"type":       "appointmentsCleared",
+		"message":    fmt.Sprintf("%s - %s arası %d randevu iptal edildi, %d randevu aktarıldı", result.DateFrom, result.DateTo, len(result.Cancelled), len(result.Reassigned)),
+		"doctorCode": result.DoctorCode,
+		"cancelled":  result.Cancelled,
+		"reassigned": result.Reassigned,
+		"title":      "Toplu Randevu Değişikliği",
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
+
+	wsClientManager.SendToDoctor(result.DoctorCode, jsonNotification)
+	notified := map[string]bool{result.DoctorCode: true}
+	for _, change := range result.Reassigned {
+		if !notified[change.ToDoctor] {
+			notified[change.ToDoctor] = true
+			wsClientManager.SendToDoctor(change.ToDoctor, jsonNotification)
+		}
 	}
+	wsClientManager.SendToAdmin(jsonNotification)
 }
 
 func handleChangePassword(w http.ResponseWriter, r *http.Request) {
@@ -1052,7 +2681,7 @@ func handleChangePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the user to verify current password
-	user, err := api.GetUser(client, userCode)
+	user, err := api.GetUser(r.Context(), app, userCode)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
@@ -1072,7 +2701,7 @@ func handleChangePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update the password
-	err = api.UpdateUserPassword(client, userCode, newPasswordHash)
+	err = api.UpdateUserPassword(r.Context(), app, userCode, newPasswordHash)
 	if err != nil {
 		http.Error(w, "Error updating password", http.StatusInternalServerError)
 		return
@@ -1084,7 +2713,7 @@ func handleChangePassword(w http.ResponseWriter, r *http.Request) {
 func handleGetDashboardStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	stats, err := api.GetDashboardStats(client)
+	stats, err := api.GetDashboardStats(r.Context(), app)
 	if err != nil {
 		http.Error(w, "Failed to get dashboard stats: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -1112,7 +2741,7 @@ func handleCreateAdminUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := api.CreateAdminUser(client, adminData)
+	err := api.CreateAdminUser(r.Context(), app, adminData)
 	if err != nil {
 		http.Error(w, "Failed to create admin user: "+err.Error(), http.StatusInternalServerError)
 		return
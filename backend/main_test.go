@@ -0,0 +1,160 @@
+package main
+
+import (
+	"backend/api"
+	"backend/config"
+	"backend/middleware"
+	wsManager "backend/websocket"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// newHandlerTestApp points the handlers at a memory store with doc1, signed in as
+// drone, and its appointment visit with patient1 two days ahead
+func newHandlerTestApp(t *testing.T) {
+	t.Helper()
+
+	store := api.NewMemoryStore()
+	ctx := context.Background()
+	store.Hospitals.Insert(ctx, api.Hospital{HospitalCode: 1, HospitalName: "Test Hastanesi"})
+	for _, doctor := range []api.Doctor{
+		{DoctorCode: "doc1", DoctorName: "Test Doctor", UserCode: "drone"},
+		{DoctorCode: "doc2", DoctorName: "Other Doctor", UserCode: "drtwo"},
+	} {
+		doctor.HospitalCode = 1
+		doctor.WorkHours = api.WorkHours{Start: "09:00", End: "17:00"}
+		store.Doctors.Insert(ctx, doctor)
+	}
+	store.Users.Insert(ctx, api.User{UserCode: "patient1", Role: "patient"})
+	date := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	store.Appointments.Insert(ctx, api.Appointment{AppointmentCode: "visit", DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: api.AppointmentTime{Date: date, Time: "10:00"}})
+
+	app = &api.App{Store: store, Config: config.Defaults()}
+	wsClientManager = wsManager.NewManager()
+}
+
+// serveAs calls the handler as the signed in user, with the route variables
+func serveAs(handler http.HandlerFunc, claims middleware.Claims, method, body string, vars map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	r = mux.SetURLVars(r.WithContext(context.WithValue(r.Context(), "userClaims", &claims)), vars)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestUpdateAppointmentStatusOwnership(t *testing.T) {
+	newHandlerTestApp(t)
+	vars := map[string]string{"appointmentCode": "visit"}
+
+	w := serveAs(handleUpdateAppointmentStatus, middleware.Claims{UserCode: "drtwo", Role: "doctor"}, "PATCH", `{"status": "confirmed"}`, vars)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("want another doctor refused, got %d %s", w.Code, w.Body)
+	}
+	w = serveAs(handleUpdateAppointmentStatus, middleware.Claims{UserCode: "drone", Role: "doctor"}, "PATCH", `{"status": "confirmed"}`, vars)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want the appointment's doctor allowed, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRescheduleAppointmentOwnership(t *testing.T) {
+	newHandlerTestApp(t)
+	vars := map[string]string{"appointmentCode": "visit"}
+	body := `{"date": "` + time.Now().AddDate(0, 0, 2).Format("2006-01-02") + `", "time": "11:00"}`
+
+	w := serveAs(handleRescheduleAppointment, middleware.Claims{UserCode: "drtwo", Role: "doctor"}, "POST", body, vars)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("want another doctor refused, got %d %s", w.Code, w.Body)
+	}
+	w = serveAs(handleRescheduleAppointment, middleware.Claims{UserCode: "drone", Role: "doctor"}, "POST", body, vars)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want the appointment's doctor allowed, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDeleteAppointmentLateByStaff(t *testing.T) {
+	newHandlerTestApp(t)
+	ctx := context.Background()
+	istanbul, _ := time.LoadLocation("Europe/Istanbul")
+	soon := time.Now().In(istanbul).Add(2 * time.Hour)
+	app.Store.Appointments.Insert(ctx, api.Appointment{AppointmentCode: "soon", DoctorCode: "doc1", UserCode: "patient1", TimeZone: "Europe/Istanbul", AppointmentTime: api.AppointmentTime{Date: soon.Format("2006-01-02"), Time: soon.Format("15:04")}})
+	vars := map[string]string{"appointmentCode": "soon"}
+
+	w := serveAs(handleDeleteAppointment, middleware.Claims{UserCode: "patient1", Role: "patient"}, "DELETE", "", vars)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("want the patient's late cancellation filed as a request, got %d %s", w.Code, w.Body)
+	}
+	w = serveAs(handleDeleteAppointment, middleware.Claims{UserCode: "drtwo", Role: "doctor"}, "DELETE", "", vars)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("want another doctor refused, got %d %s", w.Code, w.Body)
+	}
+	w = serveAs(handleDeleteAppointment, middleware.Claims{UserCode: "drone", Role: "doctor"}, "DELETE", "", vars)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want the doctor to override the window, got %d %s", w.Code, w.Body)
+	}
+	cancelled, err := app.Store.Appointments.Get(ctx, "soon")
+	if err != nil || cancelled.CurrentStatus() != api.StatusCancelledByPatient || !cancelled.CancelledLate {
+		t.Fatalf("want the appointment kept as a late cancellation, got %+v (%v)", cancelled, err)
+	}
+}
+
+func TestRouterCancelRequestList(t *testing.T) {
+	newHandlerTestApp(t)
+	router := newRouter(app.Config)
+	request := func(method, path, userCode, role string) *httptest.ResponseRecorder {
+		t.Helper()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{UserCode: userCode, Role: role}).SignedString([]byte(app.Config.JWT.Secret))
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("GET", "/api/appointment/cancelRequests", "admin", "admin")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"items"`) {
+		t.Fatalf("want the admin to get the cancel request list, got %d %s", w.Code, w.Body)
+	}
+	if w := request("GET", "/api/appointment/cancelRequests", "patient1", "patient"); w.Code != http.StatusForbidden {
+		t.Fatalf("want the list kept from patients, got %d %s", w.Code, w.Body)
+	}
+	if w := request("GET", "/api/appointment/visit", "patient1", "patient"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"appointmentCode":"visit"`) {
+		t.Fatalf("want appointments still served by code, got %d %s", w.Code, w.Body)
+	}
+	if w := request("GET", "/api/user/patient1", "patient1", "patient"); w.Code != http.StatusOK {
+		t.Fatalf("want the protected routes still reached past the admin group, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDeletePastAppointmentByStaff(t *testing.T) {
+	newHandlerTestApp(t)
+	ctx := context.Background()
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	app.Store.Appointments.Insert(ctx, api.Appointment{AppointmentCode: "past", DoctorCode: "doc1", UserCode: "patient1", AppointmentTime: api.AppointmentTime{Date: yesterday, Time: "10:00"}})
+	vars := map[string]string{"appointmentCode": "past"}
+
+	w := serveAs(handleDeleteAppointment, middleware.Claims{UserCode: "drone", Role: "doctor"}, "DELETE", "", vars)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("want the doctor unable to cancel an appointment that is over, got %d %s", w.Code, w.Body)
+	}
+	if kept, err := app.Store.Appointments.Get(ctx, "past"); err != nil || kept.CurrentStatus() != api.StatusBooked || kept.CancelledLate {
+		t.Fatalf("want the appointment left for a no-show, got %+v (%v)", kept, err)
+	}
+	w = serveAs(handleDeleteAppointment, middleware.Claims{UserCode: "admin", Role: "admin"}, "DELETE", "", vars)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want the admin to delete it, got %d %s", w.Code, w.Body)
+	}
+	if _, err := app.Store.Appointments.Get(ctx, "past"); !errors.Is(err, api.ErrNotFound) {
+		t.Fatalf("want the appointment deleted, not cancelled, got %v", err)
+	}
+}
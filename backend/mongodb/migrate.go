@@ -0,0 +1,157 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a single versioned schema change. Up must be safe to run again
+// if a previous attempt failed half way, since the version is only recorded
+// once Up returns without error.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, client *mongo.Client) error
+}
+
+// AppliedMigration is the record kept for every migration that ran
+type AppliedMigration struct {
+	Version     int       `bson:"version" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"appliedAt" json:"appliedAt"`
+}
+
+// migrationLockTTL is how long a lock left behind by a crashed runner blocks other runners
+const migrationLockTTL = 10 * time.Minute
+
+const migrationLockID = "lock"
+
+func migrationsCollection(client *mongo.Client) *mongo.Collection {
+	return client.Database("healthcare").Collection("schemaMigrations")
+}
+
+// Migrate applies every migration whose version is not recorded yet, in version order
+func Migrate(ctx context.Context, client *mongo.Client, migrations []Migration) error {
+	collection := migrationsCollection(client)
+
+	if err := acquireMigrationLock(ctx, collection); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := collection.DeleteOne(context.Background(), bson.M{"_id": migrationLockID}); err != nil {
+			log.Println("Error releasing migration lock:", err)
+		}
+	}()
+
+	applied, err := AppliedMigrations(ctx, client)
+	if err != nil {
+		return err
+	}
+	done := make(map[int]bool)
+	for _, migration := range applied {
+		done[migration.Version] = true
+	}
+
+	for _, migration := range sortedMigrations(migrations) {
+		if done[migration.Version] {
+			continue
+		}
+
+		log.Printf("Applying migration %d: %s", migration.Version, migration.Description)
+		if err := migration.Up(ctx, client); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+		}
+
+		record := AppliedMigration{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		}
+		if _, err := collection.InsertOne(ctx, record); err != nil {
+			return fmt.Errorf("recording migration %d failed: %v", migration.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// AppliedMigrations returns the recorded migrations ordered by version
+func AppliedMigrations(ctx context.Context, client *mongo.Client) ([]AppliedMigration, error) {
+	collection := migrationsCollection(client)
+
+	filter := bson.M{"version": bson.M{"$exists": true}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var applied []AppliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet
+func PendingMigrations(ctx context.Context, client *mongo.Client, migrations []Migration) ([]Migration, error) {
+	applied, err := AppliedMigrations(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool)
+	for _, migration := range applied {
+		done[migration.Version] = true
+	}
+
+	var pending []Migration
+	for _, migration := range sortedMigrations(migrations) {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// acquireMigrationLock makes sure only one server instance migrates at a time
+func acquireMigrationLock(ctx context.Context, collection *mongo.Collection) error {
+	now := time.Now()
+
+	// Clear a lock left behind by a runner that died
+	_, err := collection.DeleteOne(ctx, bson.M{
+		"_id":      migrationLockID,
+		"lockedAt": bson.M{"$lt": now.Add(-migrationLockTTL)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.InsertOne(ctx, bson.M{"_id": migrationLockID, "lockedAt": now})
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("another migration is already running")
+	}
+	return err
+}
+
+func sortedMigrations(migrations []Migration) []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
+
+// createIndexes is a helper for migrations that only add indexes to one collection
+func createIndexes(ctx context.Context, collection *mongo.Collection, indexes ...mongo.IndexModel) error {
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
@@ -0,0 +1,440 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"backend/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migrations lists every schema change in the order it was introduced.
+// Never edit or renumber a migration that has shipped, add a new one instead.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "unique indexes on users",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			db := client.Database("users")
+			err := createIndexes(ctx, db.Collection("users"),
+				mongo.IndexModel{Keys: bson.D{{Key: "userCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "role", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, db.Collection("userAdditionalInfo"),
+				mongo.IndexModel{Keys: bson.D{{Key: "userCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+			)
+		},
+	},
+	{
+		Version:     2,
+		Description: "unique and lookup indexes on doctors and hospitals",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			db := client.Database("healthcare")
+			err := createIndexes(ctx, db.Collection("doctors"),
+				mongo.IndexModel{Keys: bson.D{{Key: "doctorCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "hospitalCode", Value: 1}, {Key: "field", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, db.Collection("hospitals"),
+				mongo.IndexModel{Keys: bson.D{{Key: "hospitalCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "provinceCode", Value: 1}, {Key: "districtCode", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "districtCode", Value: 1}}},
+			)
+		},
+	},
+	{
+		Version:     3,
+		Description: "unique and lookup indexes on appointments and cancel requests",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			db := client.Database("healthcare")
+			err := createIndexes(ctx, db.Collection("appointments"),
+				mongo.IndexModel{Keys: bson.D{{Key: "appointmentCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "doctorCode", Value: 1}, {Key: "appointmentTime.date", Value: 1}, {Key: "appointmentTime.time", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "userCode", Value: 1}, {Key: "createdAt", Value: -1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "appointmentTime.date", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+			// requestCode is not unique yet, older requests were stored without one
+			return createIndexes(ctx, db.Collection("requests"),
+				mongo.IndexModel{Keys: bson.D{{Key: "requestCode", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "doctorCode", Value: 1}, {Key: "status", Value: 1}}},
+			)
+		},
+	},
+	{
+		Version:     4,
+		Description: "store district codes as integers and index locations",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			db := client.Database("locations")
+
+			// Districts were imported with string codes while hospitals reference them as integers
+			update := mongo.Pipeline{
+				{{Key: "$set", Value: bson.D{{Key: "districtCode", Value: bson.D{{Key: "$toInt", Value: "$districtCode"}}}}}},
+			}
+			_, err := db.Collection("districts").UpdateMany(ctx, bson.M{"districtCode": bson.M{"$type": "string"}}, update)
+			if err != nil {
+				return err
+			}
+
+			err = createIndexes(ctx, db.Collection("districts"),
+				mongo.IndexModel{Keys: bson.D{{Key: "districtCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "provinceCode", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, db.Collection("provinces"),
+				mongo.IndexModel{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+			)
+		},
+	},
+	{
+		Version:     5,
+		Description: "slot locks guaranteeing one appointment per doctor slot",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			db := client.Database("healthcare")
+			locks := db.Collection("slotLocks")
+			err := createIndexes(ctx, locks,
+				mongo.IndexModel{Keys: bson.D{{Key: "doctorCode", Value: 1}, {Key: "date", Value: 1}, {Key: "time", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "appointmentCode", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+
+			// Lock the slots of the appointments booked before locks existed
+			cursor, err := db.Collection("appointments").Find(ctx, bson.M{})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			for cursor.Next(ctx) {
+				var appointment struct {
+					AppointmentCode string `bson:"appointmentCode"`
+					DoctorCode      string `bson:"doctorCode"`
+					AppointmentTime struct {
+						Date string `bson:"date"`
+						Time string `bson:"time"`
+					} `bson:"appointmentTime"`
+				}
+				if err := cursor.Decode(&appointment); err != nil {
+					return err
+				}
+
+				_, err := locks.InsertOne(ctx, bson.M{
+					"doctorCode":      appointment.DoctorCode,
+					"date":            appointment.AppointmentTime.Date,
+					"time":            appointment.AppointmentTime.Time,
+					"appointmentCode": appointment.AppointmentCode,
+				})
+				if mongo.IsDuplicateKeyError(err) {
+					log.Printf("Slot of appointment %s is already locked, skipping", appointment.AppointmentCode)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+	},
+	{
+		Version:     6,
+		Description: "indexes backing list filters and sorting",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			healthcare := client.Database("healthcare")
+			err := createIndexes(ctx, healthcare.Collection("doctors"),
+				mongo.IndexModel{Keys: bson.D{{Key: "doctorName", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "field", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+			err = createIndexes(ctx, healthcare.Collection("hospitals"),
+				mongo.IndexModel{Keys: bson.D{{Key: "hospitalName", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "fields", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+			err = createIndexes(ctx, healthcare.Collection("appointments"),
+				mongo.IndexModel{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+			)
+			if err != nil {
+				return err
+			}
+			err = createIndexes(ctx, healthcare.Collection("requests"),
+				mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}}},
+			)
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, client.Database("users").Collection("users"),
+				mongo.IndexModel{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+			)
+		},
+	},
+	{
+		Version:     7,
+		Description: "deletedAt indexes for soft delete reads and the purge job",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			deletedAt := mongo.IndexModel{Keys: bson.D{{Key: "deletedAt", Value: 1}}}
+			healthcare := client.Database("healthcare")
+			for _, name := range []string{"doctors", "hospitals", "appointments"} {
+				if err := createIndexes(ctx, healthcare.Collection(name), deletedAt); err != nil {
+					return err
+				}
+			}
+			return createIndexes(ctx, client.Database("users").Collection("users"), deletedAt)
+		},
+	},
+	{
+		Version:     8,
+		Description: "indexes on the append-only audit log",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndexes(ctx, client.Database("healthcare").Collection("auditLog"),
+				mongo.IndexModel{Keys: bson.D{{Key: "at", Value: -1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "at", Value: -1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "targetType", Value: 1}, {Key: "targetCode", Value: 1}, {Key: "at", Value: -1}}},
+			)
+		},
+	},
+	{
+		Version:     9,
+		Description: "version field on hospitals, doctors and appointments for optimistic concurrency",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			healthcare := client.Database("healthcare")
+			missing := bson.M{"version": bson.M{"$exists": false}}
+			for _, name := range []string{"hospitals", "doctors", "appointments"} {
+				if _, err := healthcare.Collection(name).UpdateMany(ctx, missing, bson.M{"$set": bson.M{"version": 0}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}, {
+		Version:     10,
+		Description: "status lifecycle on appointments",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			appointments := client.Database("healthcare").Collection("appointments")
+			missing := bson.M{"status": bson.M{"$exists": false}}
+			set := bson.M{"$set": bson.M{"status": "booked", "statusHistory": bson.A{}}}
+			if _, err := appointments.UpdateMany(ctx, missing, set); err != nil {
+				return err
+			}
+			return createIndexes(ctx, appointments,
+				mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "appointmentTime.date", Value: 1}}},
+			)
+		},
+	}, {
+		Version:     11,
+		Description: "waitlist indexes",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndexes(ctx, client.Database("healthcare").Collection("waitlist"),
+				mongo.IndexModel{Keys: bson.D{{Key: "entryCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "userCode", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "doctorCode", Value: 1}, {Key: "createdAt", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "hospitalCode", Value: 1}, {Key: "fieldCode", Value: 1}, {Key: "createdAt", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "offer.expiresAt", Value: 1}}},
+			)
+		},
+	}, {
+		Version:     12,
+		Description: "appointment lengths and five minute slot locks",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			healthcare := client.Database("healthcare")
+			missing := bson.M{"duration": bson.M{"$exists": false}}
+			if _, err := healthcare.Collection("appointments").UpdateMany(ctx, missing, bson.M{"$set": bson.M{"duration": 15}}); err != nil {
+				return err
+			}
+
+			// Every lock held one 15 minute slot, it now needs the two five minute pieces after it
+			slotLocks := healthcare.Collection("slotLocks")
+			cursor, err := slotLocks.Find(ctx, bson.D{})
+			if err != nil {
+				return err
+			}
+			var locks []bson.M
+			if err := cursor.All(ctx, &locks); err != nil {
+				return err
+			}
+			for _, lock := range locks {
+				start, err := time.Parse("15:04", fmt.Sprint(lock["time"]))
+				if err != nil {
+					continue
+				}
+				for _, offset := range []time.Duration{5 * time.Minute, 10 * time.Minute} {
+					piece := bson.M{
+						"doctorCode":      lock["doctorCode"],
+						"date":            lock["date"],
+						"time":            start.Add(offset).Format("15:04"),
+						"appointmentCode": lock["appointmentCode"],
+					}
+					if _, err := slotLocks.InsertOne(ctx, piece); err != nil && !mongo.IsDuplicateKeyError(err) {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}, {
+		Version:     13,
+		Description: "blackout indexes",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndexes(ctx, client.Database("healthcare").Collection("blackouts"),
+				mongo.IndexModel{Keys: bson.D{{Key: "blackoutCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "dateFrom", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "doctorCode", Value: 1}, {Key: "dateFrom", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "hospitalCode", Value: 1}, {Key: "dateFrom", Value: 1}}},
+			)
+		},
+	}, {
+		Version:     14,
+		Description: "attendance index",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndexes(ctx, client.Database("healthcare").Collection("attendance"),
+				mongo.IndexModel{Keys: bson.D{{Key: "userCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+			)
+		},
+	}, {
+		Version:     15,
+		Description: "appointment series index",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndexes(ctx, client.Database("healthcare").Collection("appointments"),
+				mongo.IndexModel{Keys: bson.D{{Key: "seriesCode", Value: 1}}, Options: options.Index().SetSparse(true)},
+			)
+		},
+	}, {
+		Version:     16,
+		Description: "slot hold indexes",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndexes(ctx, client.Database("healthcare").Collection("slotHolds"),
+				mongo.IndexModel{Keys: bson.D{{Key: "holdCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "userCode", Value: 1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "expiresAt", Value: 1}}},
+			)
+		},
+	}, {
+		Version:     17,
+		Description: "hospital time zones and appointment start instants",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			healthcare := client.Database("healthcare")
+
+			// Existing times were all entered as Istanbul wall clock times
+			noZone := bson.M{"timeZone": bson.M{"$in": bson.A{nil, ""}}}
+			if _, err := healthcare.Collection("hospitals").UpdateMany(ctx, noZone, bson.M{"$set": bson.M{"timeZone": config.DefaultTimeZone}}); err != nil {
+				return err
+			}
+			zones, err := hospitalZonesByDoctor(ctx, healthcare)
+			if err != nil {
+				return err
+			}
+
+			appointments := healthcare.Collection("appointments")
+			cursor, err := appointments.Find(ctx, bson.M{"startsAt": bson.M{"$exists": false}})
+			if err != nil {
+				return err
+			}
+			var missing []bson.M
+			if err := cursor.All(ctx, &missing); err != nil {
+				return err
+			}
+			for _, appointment := range missing {
+				zone, ok := zones[fmt.Sprint(appointment["doctorCode"])]
+				if !ok {
+					zone = config.DefaultTimeZone
+				}
+				loc, err := time.LoadLocation(zone)
+				if err != nil {
+					return fmt.Errorf("appointment %v: %w", appointment["appointmentCode"], err)
+				}
+				at, _ := appointment["appointmentTime"].(bson.M)
+				start, err := time.ParseInLocation("2006-01-02 15:04", fmt.Sprint(at["date"])+" "+fmt.Sprint(at["time"]), loc)
+				if err != nil {
+					log.Printf("Skipping appointment %v with malformed time %v", appointment["appointmentCode"], at)
+					continue
+				}
+				set := bson.M{"$set": bson.M{"startsAt": start.UTC(), "timeZone": zone}}
+				if _, err := appointments.UpdateOne(ctx, bson.M{"_id": appointment["_id"]}, set); err != nil {
+					return err
+				}
+			}
+			return createIndexes(ctx, appointments,
+				mongo.IndexModel{Keys: bson.D{{Key: "startsAt", Value: 1}}},
+			)
+		},
+	}, {
+		Version:     18,
+		Description: "encounter note indexes and unique doctor accounts",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			healthcare := client.Database("healthcare")
+			err := createIndexes(ctx, healthcare.Collection("encounterNotes"),
+				mongo.IndexModel{Keys: bson.D{{Key: "appointmentCode", Value: 1}}, Options: options.Index().SetUnique(true)},
+				mongo.IndexModel{Keys: bson.D{{Key: "userCode", Value: 1}}},
+			)
+			if err != nil {
+				return err
+			}
+			// Note access follows the doctor signed in as the user, so an account belongs to one doctor
+			return createIndexes(ctx, healthcare.Collection("doctors"),
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "userCode", Value: 1}},
+					Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"userCode": bson.M{"$exists": true}}),
+				},
+			)
+		},
+	},
+}
+
+// hospitalZonesByDoctor maps the code of every doctor, deleted ones included, to the
+// time zone of their hospital
+func hospitalZonesByDoctor(ctx context.Context, healthcare *mongo.Database) (map[string]string, error) {
+	cursor, err := healthcare.Collection("hospitals").Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var hospitals []struct {
+		HospitalCode int    `bson:"hospitalCode"`
+		TimeZone     string `bson:"timeZone"`
+	}
+	if err := cursor.All(ctx, &hospitals); err != nil {
+		return nil, err
+	}
+	byHospital := map[int]string{}
+	for _, hospital := range hospitals {
+		byHospital[hospital.HospitalCode] = hospital.TimeZone
+	}
+
+	cursor, err = healthcare.Collection("doctors").Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var doctors []struct {
+		DoctorCode   string `bson:"doctorCode"`
+		HospitalCode int    `bson:"hospitalCode"`
+	}
+	if err := cursor.All(ctx, &doctors); err != nil {
+		return nil, err
+	}
+	zones := map[string]string{}
+	for _, doctor := range doctors {
+		if zone := byHospital[doctor.HospitalCode]; zone != "" {
+			zones[doctor.DoctorCode] = zone
+		}
+	}
+	return zones, nil
+}
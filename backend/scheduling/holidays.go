@@ -0,0 +1,74 @@
+package scheduling
+
+import (
+	"fmt"
+	"time"
+)
+
+// HalfDayFrom is the time the offices close on the eve of a holiday
+const HalfDayFrom = "13:00"
+
+// Holiday is a public holiday. Half days close from HalfDayFrom on.
+type Holiday struct {
+	Date    string `json:"date"`
+	Name    string `json:"name"`
+	HalfDay bool   `json:"halfDay,omitempty"`
+}
+
+// religiousHolidays holds the first day of Ramazan Bayramı and Kurban Bayramı as
+// announced by Diyanet. They follow the lunar calendar, later years are imported
+// with an explicit list.
+var religiousHolidays = map[int][2]string{
+	2025: {"2025-03-30", "2025-06-06"},
+	2026: {"2026-03-20", "2026-05-27"},
+	2027: {"2027-03-09", "2027-05-16"},
+}
+
+// TurkishHolidays lists the public holidays of Turkey in year. The religious holidays
+// are only known for the years in religiousHolidays, ok is false for other years.
+func TurkishHolidays(year int) (holidays []Holiday, ok bool) {
+	fixed := []struct {
+		month time.Month
+		day   int
+		name  string
+	}{
+		{time.January, 1, "Yılbaşı"},
+		{time.April, 23, "Ulusal Egemenlik ve Çocuk Bayramı"},
+		{time.May, 1, "Emek ve Dayanışma Günü"},
+		{time.May, 19, "Atatürk'ü Anma, Gençlik ve Spor Bayramı"},
+		{time.July, 15, "Demokrasi ve Milli Birlik Günü"},
+		{time.August, 30, "Zafer Bayramı"},
+		{time.October, 29, "Cumhuriyet Bayramı"},
+	}
+	for _, f := range fixed {
+		date := time.Date(year, f.month, f.day, 0, 0, 0, 0, time.UTC)
+		if f.month == time.October {
+			holidays = append(holidays, Holiday{Date: date.AddDate(0, 0, -1).Format(DateLayout), Name: "Cumhuriyet Bayramı Arifesi", HalfDay: true})
+		}
+		holidays = append(holidays, Holiday{Date: date.Format(DateLayout), Name: f.name})
+	}
+
+	religious, ok := religiousHolidays[year]
+	if !ok {
+		return holidays, false
+	}
+	holidays = append(holidays, feast(religious[0], "Ramazan Bayramı", 3)...)
+	holidays = append(holidays, feast(religious[1], "Kurban Bayramı", 4)...)
+	return holidays, true
+}
+
+// feast lists the eve and the days of a religious holiday starting at first
+func feast(first, name string, days int) []Holiday {
+	start, err := time.Parse(DateLayout, first)
+	if err != nil {
+		return nil
+	}
+	holidays := []Holiday{{Date: start.AddDate(0, 0, -1).Format(DateLayout), Name: name + " Arifesi", HalfDay: true}}
+	for i := 0; i < days; i++ {
+		holidays = append(holidays, Holiday{
+			Date: start.AddDate(0, 0, i).Format(DateLayout),
+			Name: fmt.Sprintf("%s %d. Gün", name, i+1),
+		})
+	}
+	return holidays
+}
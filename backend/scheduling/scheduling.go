@@ -0,0 +1,310 @@
+// Package scheduling computes when a doctor can be booked. It knows nothing about
+// storage or HTTP: an Availability describes the working hours, and Free turns it
+// into bookable slots around the busy parts of each day.
+package scheduling
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrInvalid is returned by Validate for malformed or overlapping hours
+var ErrInvalid = errors.New("invalid availability")
+
+// Interval is a part of a day from Start up to End, as "15:04" times
+type Interval struct {
+	Start string `bson:"start" json:"start"`
+	End   string `bson:"end" json:"end"`
+}
+
+// WeekdayHours are the working intervals of one day of the week. Breaks are taken
+// out of them.
+type WeekdayHours struct {
+	Weekday   time.Weekday `bson:"weekday" json:"weekday"`
+	Intervals []Interval   `bson:"intervals" json:"intervals"`
+	Breaks    []Interval   `bson:"breaks,omitempty" json:"breaks,omitempty"`
+}
+
+// Override replaces the weekly hours on one date, no intervals make it a day off
+type Override struct {
+	Date      string     `bson:"date" json:"date"`
+	Intervals []Interval `bson:"intervals" json:"intervals"`
+	Breaks    []Interval `bson:"breaks,omitempty" json:"breaks,omitempty"`
+	Reason    string     `bson:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// Availability is a weekly template plus date specific overrides. Days missing from
+// Weekly are days off.
+type Availability struct {
+	Weekly    []WeekdayHours `bson:"weekly" json:"weekly"`
+	Overrides []Override     `bson:"overrides,omitempty" json:"overrides,omitempty"`
+}
+
+// Span is a part of a day in minutes since midnight, Start included and End excluded
+type Span struct {
+	Start int
+	End   int
+}
+
+// Overlaps reports whether the spans share at least one minute
+func (s Span) Overlaps(other Span) bool {
+	return s.Start < other.End && other.Start < s.End
+}
+
+// Slot is a bookable appointment slot
+type Slot struct {
+	Date  string `json:"date"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// DateLayout is the layout of the dates used throughout the package
+const DateLayout = "2006-01-02"
+
+// ParseClock reads a "15:04" time of day as minutes since midnight
+func ParseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// FormatClock writes minutes since midnight as a "15:04" time of day
+func FormatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// Daily is the availability of a doctor working the same hours every day
+func Daily(start, end string) Availability {
+	weekly := make([]WeekdayHours, 0, 7)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		weekly = append(weekly, WeekdayHours{Weekday: day, Intervals: []Interval{{Start: start, End: end}}})
+	}
+	return Availability{Weekly: weekly}
+}
+
+// Validate checks every time, that each day is listed once and that the intervals
+// of a day do not overlap
+func (a Availability) Validate() error {
+	seen := map[time.Weekday]bool{}
+	for _, day := range a.Weekly {
+		if day.Weekday < time.Sunday || day.Weekday > time.Saturday {
+			return fmt.Errorf("%w: weekday %d", ErrInvalid, day.Weekday)
+		}
+		if seen[day.Weekday] {
+			return fmt.Errorf("%w: %s is listed twice", ErrInvalid, day.Weekday)
+		}
+		seen[day.Weekday] = true
+		if err := validateDay(day.Weekday.String(), day.Intervals, day.Breaks); err != nil {
+			return err
+		}
+	}
+
+	dates := map[string]bool{}
+	for _, override := range a.Overrides {
+		if _, err := time.Parse(DateLayout, override.Date); err != nil {
+			return fmt.Errorf("%w: override date %q", ErrInvalid, override.Date)
+		}
+		if dates[override.Date] {
+			return fmt.Errorf("%w: %s is overridden twice", ErrInvalid, override.Date)
+		}
+		dates[override.Date] = true
+		if err := validateDay(override.Date, override.Intervals, override.Breaks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateDay(name string, intervals, breaks []Interval) error {
+	spans, err := toSpans(intervals)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalid, name, err)
+	}
+	for i := 1; i < len(spans); i++ {
+		if spans[i].Start < spans[i-1].End {
+			return fmt.Errorf("%w: %s: intervals overlap", ErrInvalid, name)
+		}
+	}
+	if _, err := toSpans(breaks); err != nil {
+		return fmt.Errorf("%w: %s break: %v", ErrInvalid, name, err)
+	}
+	return nil
+}
+
+// toSpans converts intervals to spans sorted by start
+func toSpans(intervals []Interval) ([]Span, error) {
+	spans := make([]Span, 0, len(intervals))
+	for _, interval := range intervals {
+		start, err := ParseClock(interval.Start)
+		if err != nil {
+			return nil, fmt.Errorf("start %q is not a 15:04 time", interval.Start)
+		}
+		end, err := ParseClock(interval.End)
+		if err != nil {
+			return nil, fmt.Errorf("end %q is not a 15:04 time", interval.End)
+		}
+		if end <= start {
+			return nil, fmt.Errorf("%s-%s ends before it starts", interval.Start, interval.End)
+		}
+		spans = append(spans, Span{Start: start, End: end})
+	}
+	slices.SortFunc(spans, func(a, b Span) int { return a.Start - b.Start })
+	return spans, nil
+}
+
+// Hours returns the working spans on date with the breaks taken out. An override of
+// the date wins over the weekly template.
+func (a Availability) Hours(date time.Time) []Span {
+	intervals, breaks := a.day(date)
+	spans, err := toSpans(intervals)
+	if err != nil {
+		return nil
+	}
+	pauses, err := toSpans(breaks)
+	if err != nil {
+		return nil
+	}
+	return subtract(spans, pauses)
+}
+
+func (a Availability) day(date time.Time) ([]Interval, []Interval) {
+	key := date.Format(DateLayout)
+	for _, override := range a.Overrides {
+		if override.Date == key {
+			return override.Intervals, override.Breaks
+		}
+	}
+	for _, day := range a.Weekly {
+		if day.Weekday == date.Weekday() {
+			return day.Intervals, day.Breaks
+		}
+	}
+	return nil, nil
+}
+
+// subtract removes every part of spans that one of cuts covers
+func subtract(spans, cuts []Span) []Span {
+	var result []Span
+	for _, span := range spans {
+		pieces := []Span{span}
+		for _, cut := range cuts {
+			var next []Span
+			for _, piece := range pieces {
+				if !piece.Overlaps(cut) {
+					next = append(next, piece)
+					continue
+				}
+				if piece.Start < cut.Start {
+					next = append(next, Span{Start: piece.Start, End: cut.Start})
+				}
+				if cut.End < piece.End {
+					next = append(next, Span{Start: cut.End, End: piece.End})
+				}
+			}
+			pieces = next
+		}
+		result = append(result, pieces...)
+	}
+	return result
+}
+
+// SlotsIn cuts each span into back to back slots of length minutes, starting at
+// the beginning of the span. A remainder shorter than length is dropped.
+func SlotsIn(hours []Span, length int) []Span {
+	if length <= 0 {
+		return nil
+	}
+	var slots []Span
+	for _, span := range hours {
+		for start := span.Start; start+length <= span.End; start += length {
+			slots = append(slots, Span{Start: start, End: start + length})
+		}
+	}
+	return slots
+}
+
+// Covers reports whether the slot lies within the hours of date
+func (a Availability) Covers(date time.Time, slot Span) bool {
+	for _, span := range a.Hours(date) {
+		if span.Start <= slot.Start && slot.End <= span.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Free lists the slots of length minutes from the first to the last date that are
+// neither busy nor starting at or before now. busy returns the booked spans of a
+// date and may be nil. Dates are taken in the location of now.
+func Free(a Availability, from, to string, length int, busy func(date string) []Span, now time.Time) ([]Slot, error) {
+	first, err := time.ParseInLocation(DateLayout, from, now.Location())
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", from)
+	}
+	last, err := time.ParseInLocation(DateLayout, to, now.Location())
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", to)
+	}
+
+	slots := []Slot{}
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		date := day.Format(DateLayout)
+		var booked []Span
+		if busy != nil {
+			booked = busy(date)
+		}
+		for _, slot := range SlotsIn(a.Hours(day), length) {
+			start := day.Add(time.Duration(slot.Start) * time.Minute)
+			if !start.After(now) || slices.ContainsFunc(booked, slot.Overlaps) {
+				continue
+			}
+			slots = append(slots, Slot{Date: date, Start: FormatClock(slot.Start), End: FormatClock(slot.End)})
+		}
+	}
+	return slots, nil
+}
+
+// Closure takes a date out of the hours, or only the part of it from From on
+type Closure struct {
+	Date string
+	// From is the time the closure starts, empty closes the whole day
+	From   string
+	Reason string
+}
+
+// Without returns the availability with the closures taken out. Each closed date
+// becomes an override holding what remains of its hours.
+func (a Availability) Without(closures []Closure) Availability {
+	result := Availability{Weekly: a.Weekly, Overrides: slices.Clone(a.Overrides)}
+	for _, closure := range closures {
+		day, err := time.Parse(DateLayout, closure.Date)
+		if err != nil {
+			continue
+		}
+		var remaining []Interval
+		if from, err := ParseClock(closure.From); err == nil {
+			for _, span := range result.Hours(day) {
+				if span.Start < from {
+					remaining = append(remaining, Interval{Start: FormatClock(span.Start), End: FormatClock(min(span.End, from))})
+				}
+			}
+		}
+		result.override(Override{Date: closure.Date, Intervals: remaining, Reason: closure.Reason})
+	}
+	return result
+}
+
+// override replaces the override of the same date or adds it
+func (a *Availability) override(override Override) {
+	for i := range a.Overrides {
+		if a.Overrides[i].Date == override.Date {
+			a.Overrides[i] = override
+			return
+		}
+	}
+	a.Overrides = append(a.Overrides, override)
+}
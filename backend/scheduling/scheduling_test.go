@@ -0,0 +1,188 @@
+package scheduling
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// week works 09:00-12:00 and 13:00-17:00 on Mondays with a coffee break, only
+// mornings on Wednesdays and not at all on the other days
+var week = Availability{
+	Weekly: []WeekdayHours{
+		{
+			Weekday:   time.Monday,
+			Intervals: []Interval{{Start: "13:00", End: "17:00"}, {Start: "09:00", End: "12:00"}},
+			Breaks:    []Interval{{Start: "10:30", End: "10:45"}},
+		},
+		{Weekday: time.Wednesday, Intervals: []Interval{{Start: "08:00", End: "12:00"}}},
+	},
+	Overrides: []Override{
+		{Date: "2030-01-14", Reason: "conference"},
+		{Date: "2030-01-16", Intervals: []Interval{{Start: "14:00", End: "15:00"}}},
+	},
+}
+
+func day(date string) time.Time {
+	t, err := time.Parse(DateLayout, date)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestHours(t *testing.T) {
+	tests := []struct {
+		name string
+		date string
+		want []Span
+	}{
+		{"monday with a break", "2030-01-07", []Span{{540, 630}, {645, 720}, {780, 1020}}},
+		{"wednesday", "2030-01-09", []Span{{480, 720}}},
+		{"weekend", "2030-01-12", nil},
+		{"day off override", "2030-01-14", nil},
+		{"special hours override", "2030-01-16", []Span{{840, 900}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := week.Hours(day(tt.date)); !slices.Equal(got, tt.want) {
+				t.Fatalf("Hours(%s) = %v, want %v", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlotsIn(t *testing.T) {
+	got := SlotsIn([]Span{{540, 630}, {645, 720}}, 30)
+	want := []Span{{540, 570}, {570, 600}, {600, 630}, {645, 675}, {675, 705}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("SlotsIn = %v, want %v", got, want)
+	}
+	if got := SlotsIn([]Span{{540, 560}}, 30); len(got) != 0 {
+		t.Fatalf("a span shorter than a slot has no slots, got %v", got)
+	}
+}
+
+func TestFree(t *testing.T) {
+	now := day("2030-01-07").Add(9*time.Hour + 10*time.Minute)
+	busy := func(date string) []Span {
+		if date == "2030-01-07" {
+			// A 15 minute appointment at 11:05 takes the 10:45 slot
+			return []Span{{665, 680}}
+		}
+		return nil
+	}
+
+	slots, err := Free(week, "2030-01-07", "2030-01-09", 60, busy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Slot{
+		{Date: "2030-01-07", Start: "13:00", End: "14:00"},
+		{Date: "2030-01-07", Start: "14:00", End: "15:00"},
+		{Date: "2030-01-07", Start: "15:00", End: "16:00"},
+		{Date: "2030-01-07", Start: "16:00", End: "17:00"},
+		{Date: "2030-01-09", Start: "08:00", End: "09:00"},
+		{Date: "2030-01-09", Start: "09:00", End: "10:00"},
+		{Date: "2030-01-09", Start: "10:00", End: "11:00"},
+		{Date: "2030-01-09", Start: "11:00", End: "12:00"},
+	}
+	if !slices.Equal(slots, want) {
+		t.Fatalf("Free = %v, want %v", slots, want)
+	}
+
+	if _, err := Free(week, "2030-01-07", "07.01.2030", 60, nil, now); err == nil {
+		t.Fatal("a malformed date must fail")
+	}
+}
+
+func TestCovers(t *testing.T) {
+	monday := day("2030-01-07")
+	if !week.Covers(monday, Span{Start: 600, End: 630}) {
+		t.Fatal("10:00-10:30 is within the hours")
+	}
+	if week.Covers(monday, Span{Start: 615, End: 645}) {
+		t.Fatal("10:15-10:45 runs into the break")
+	}
+	if week.Covers(monday, Span{Start: 705, End: 795}) {
+		t.Fatal("11:45-13:15 spans the lunch break")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := week.Validate(); err != nil {
+		t.Fatalf("valid availability: %v", err)
+	}
+	if err := Daily("09:00", "17:00").Validate(); err != nil {
+		t.Fatalf("daily availability: %v", err)
+	}
+
+	invalid := map[string]Availability{
+		"malformed time": {Weekly: []WeekdayHours{{Weekday: time.Monday, Intervals: []Interval{{Start: "9", End: "17:00"}}}}},
+		"backwards":      {Weekly: []WeekdayHours{{Weekday: time.Monday, Intervals: []Interval{{Start: "17:00", End: "09:00"}}}}},
+		"overlap": {Weekly: []WeekdayHours{{Weekday: time.Monday, Intervals: []Interval{
+			{Start: "09:00", End: "12:00"}, {Start: "11:00", End: "14:00"},
+		}}}},
+		"day twice":  {Weekly: []WeekdayHours{{Weekday: time.Monday}, {Weekday: time.Monday}}},
+		"weekday":    {Weekly: []WeekdayHours{{Weekday: 7}}},
+		"break":      {Weekly: []WeekdayHours{{Weekday: time.Monday, Breaks: []Interval{{Start: "12:00", End: "12:00"}}}}},
+		"date":       {Overrides: []Override{{Date: "14.01.2030"}}},
+		"date twice": {Overrides: []Override{{Date: "2030-01-14"}, {Date: "2030-01-14"}}},
+	}
+	for name, availability := range invalid {
+		if err := availability.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestWithout(t *testing.T) {
+	closed := week.Without([]Closure{
+		{Date: "2030-01-07", From: "11:00", Reason: "half day"},
+		{Date: "2030-01-09", Reason: "leave"},
+		{Date: "2030-01-16", From: "14:30"},
+	})
+	tests := []struct {
+		date string
+		want []Span
+	}{
+		{"2030-01-07", []Span{{540, 630}, {645, 660}}},
+		{"2030-01-09", nil},
+		{"2030-01-16", []Span{{840, 870}}},
+		{"2030-01-21", []Span{{540, 630}, {645, 720}, {780, 1020}}},
+	}
+	for _, tt := range tests {
+		if got := closed.Hours(day(tt.date)); !slices.Equal(got, tt.want) {
+			t.Errorf("Hours(%s) = %v, want %v", tt.date, got, tt.want)
+		}
+	}
+	if len(week.Overrides) != 2 {
+		t.Fatalf("Without must not change the availability it was called on: %v", week.Overrides)
+	}
+}
+
+func TestTurkishHolidays(t *testing.T) {
+	holidays, ok := TurkishHolidays(2026)
+	if !ok {
+		t.Fatal("2026 has known religious holidays")
+	}
+	byDate := map[string]Holiday{}
+	for _, holiday := range holidays {
+		byDate[holiday.Date] = holiday
+	}
+	for _, date := range []string{"2026-01-01", "2026-04-23", "2026-10-29", "2026-03-20", "2026-03-22", "2026-05-30"} {
+		if h, found := byDate[date]; !found || h.HalfDay {
+			t.Errorf("%s must be a full holiday, got %+v", date, h)
+		}
+	}
+	for _, date := range []string{"2026-10-28", "2026-03-19", "2026-05-26"} {
+		if !byDate[date].HalfDay {
+			t.Errorf("%s must be a half day", date)
+		}
+	}
+
+	if holidays, ok := TurkishHolidays(2040); ok || len(holidays) != 8 {
+		t.Fatalf("2040 has only the fixed holidays, got %d (%v)", len(holidays), ok)
+	}
+}
@@ -26,6 +26,7 @@ const DoctorSchedule = () => {
   const [doctorTimeInfo, setDoctorTimeInfo] = useState(null);
   const [expandedHours, setExpandedHours] = useState([]);
   const [selectedSlot, setSelectedSlot] = useState(null);
+  const [slotHold, setSlotHold] = useState(null);
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
   const [dateRange, setDateRange] = useState({start: null, end: null});
@@ -163,12 +164,26 @@ const DoctorSchedule = () => {
   };
   
   // Handle slot selection
-  const handleSlotSelect = (slot) => {
-    // If same slot selected, deselect it
+  const handleSlotSelect = async (slot) => {
+    // If same slot selected, deselect it and give up its hold
     if (selectedSlot && selectedSlot.slotId === slot.slotId) {
       setSelectedSlot(null);
-    } else {
+      if (slotHold) {
+        AppointmentService.releaseSlotHold(slotHold.holdCode).catch(err => console.error('Error releasing slot hold:', err));
+        setSlotHold(null);
+      }
+      return;
+    }
+
+    // Hold the slot so nobody else books it while the user confirms
+    try {
+      const hold = await AppointmentService.holdSlot(doctorId, selectedDate, slot.startTime);
+      setSlotHold(hold);
       setSelectedSlot(slot);
+      setError('');
+    } catch (err) {
+      setError('This time slot is no longer available. Please choose another time.');
+      console.error('Error holding slot:', err);
     }
   };
   
@@ -185,7 +200,8 @@ const DoctorSchedule = () => {
       const appointmentData = {
         doctorId: doctorId,
         timeSlot: selectedSlot,
-        date: selectedDate
+        date: selectedDate,
+        holdCode: slotHold ? slotHold.holdCode : undefined
       };
       
       console.log("Sending appointment data:", appointmentData);
@@ -202,6 +218,9 @@ const DoctorSchedule = () => {
         }
       });
     } catch (err) {
+      // A failed booking uses up the hold, the slot has to be chosen again
+      setSlotHold(null);
+      setSelectedSlot(null);
       setError(err.message || 'Failed to book appointment. Please try again later.');
       console.error('Error booking appointment:', err);
     } finally {
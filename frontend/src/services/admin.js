@@ -1,4 +1,4 @@
-import apiClient from './api';
+import apiClient, { getAllPages } from './api';
 
 const AdminService = {
   // Location data
@@ -101,7 +101,7 @@ const AdminService = {
       hospitals = response.data;
       console.log('Fetched hospitals by province:', hospitals.length);
     } else {
-      const response = await apiClient.get('/hospitals');
+      const response = await getAllPages('/hospitals');
       hospitals = response.data;
       console.log('Fetched all hospitals:', hospitals.length);
     }
@@ -193,7 +193,7 @@ const AdminService = {
       doctors = response.data;
       console.log('Fetched doctors by hospital:', doctors.length);
     } else {
-      const response = await apiClient.get('/doctors');
+      const response = await getAllPages('/doctors');
       doctors = response.data;
       console.log('Fetched all doctors:', doctors.length);
     }
@@ -209,7 +209,7 @@ const AdminService = {
     // Fetch all hospitals once for mapping
     let hospitalsMap = {};
     try {
-      const hospitalsResponse = await apiClient.get('/hospitals');
+      const hospitalsResponse = await getAllPages('/hospitals');
       if (Array.isArray(hospitalsResponse.data)) {
         hospitalsResponse.data.forEach(hospital => {
           hospitalsMap[hospital.hospitalCode] = hospital.hospitalName;
@@ -385,17 +385,13 @@ const AdminService = {
   // Appointment Management
   getAllAppointments: async (limit = 10) => {
     try {
-      // Get basic appointments
-      const response = await apiClient.get('/appointments');
-      let appointments = response.data;
-
-      // Sort by creation date (newest first)
-      appointments.sort((a, b) => new Date(b.createdAt) - new Date(a.createdAt));
-
-      // Apply limit
+      // Get the newest appointments, the server sorts and limits the page
+      const params = { sort: '-createdAt' };
       if (limit > 0) {
-        appointments = appointments.slice(0, limit);
+        params.limit = limit;
       }
+      const response = await apiClient.get('/appointments', { params });
+      let appointments = response.data.items || [];
 
       // Enhance appointments with user and doctor details
       const enhancedAppointments = await Promise.all(
@@ -552,7 +548,7 @@ const AdminService = {
       const response = await apiClient.get(`/appointment/cancelRequests/${doctorCode}`);
       return response.data;
     } else {
-      const response = await apiClient.get('/appointment/cancelRequests');
+      const response = await getAllPages('/appointment/cancelRequests');
       return response.data;
     }
   },
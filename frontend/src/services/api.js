@@ -197,4 +197,24 @@ export const changePassword = async (userCode, currentPassword, newPassword) =>
   }
 };
 
+// List endpoints return one page at a time ({ items, total, nextPageToken }).
+// getAllPages follows the page tokens and resolves to { data: [...all items], total }.
+export const getAllPages = async (url, params = {}) => {
+  const items = [];
+  let total = 0;
+  let pageToken;
+
+  do {
+    const response = await apiClient.get(url, {
+      params: { ...params, limit: 200, ...(pageToken ? { pageToken } : {}) }
+    });
+    const page = response.data || {};
+    items.push(...(page.items || []));
+    total = page.total || 0;
+    pageToken = page.nextPageToken;
+  } while (pageToken);
+
+  return { data: items, total };
+};
+
 export default apiClient; 
\ No newline at end of file
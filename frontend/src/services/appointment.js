@@ -1,4 +1,4 @@
-import apiClient from './api';
+import apiClient, { getAllPages } from './api';
 
 const AppointmentService = {
   // Get all cities/provinces
@@ -42,7 +42,7 @@ const AppointmentService = {
   
   // Get all hospitals
   getAllHospitals: async () => {
-    return await apiClient.get('/hospitals');
+    return await getAllPages('/hospitals');
   },
   
   // Get doctors by various parameters
@@ -74,7 +74,10 @@ const AppointmentService = {
       }
       
       console.log("Making API request to:", url);
-      const response = await apiClient.get(url);
+      // The unfiltered doctors list is paged, the per-hospital one is not
+      const response = hospitalCode
+        ? await apiClient.get(url)
+        : await getAllPages('/doctors', fieldCode ? { field: fieldCode } : {});
       
       // If fieldCode is provided but server doesn't handle filtering,
       // filter the results on the client side
@@ -97,7 +100,7 @@ const AppointmentService = {
   
   // Get all doctors  
   getAllDoctors: async () => {
-    return await apiClient.get('/doctors');
+    return await getAllPages('/doctors');
   },
   
   // Get doctor by ID
@@ -109,6 +112,20 @@ const AppointmentService = {
   getDoctorTimeSlots: async (doctorId, date) => {
     return await apiClient.get(`/doctor/${doctorId}/timeslots?date=${date}`);
   },
+
+  // Hold a slot for the user while they confirm the booking
+  holdSlot: async (doctorCode, date, time) => {
+    const response = await apiClient.post('/appointment/hold', {
+      doctorCode,
+      appointmentTime: { date, time }
+    });
+    return response.data;
+  },
+
+  // Release a slot hold the user no longer needs
+  releaseSlotHold: async (holdCode) => {
+    return await apiClient.delete(`/appointment/hold/${holdCode}`);
+  },
   
   // Check if user can make a new appointment (weekly limit validation)
   checkUserAppointmentLimit: async (userCode) => {
@@ -194,6 +211,10 @@ const AppointmentService = {
         time: timeSlot.startTime || appointmentData.time
       }
     };
+    // Take over the slot held while the user was booking
+    if (appointmentData.holdCode) {
+      formattedAppointment.holdCode = appointmentData.holdCode;
+    }
     
     console.log("Formatted appointment data:", formattedAppointment);
     
@@ -208,6 +229,19 @@ const AppointmentService = {
         if (typeof errorMessage === 'string' && errorMessage.includes('appointment limit exceeded')) {
           throw new Error('Weekly appointment limit reached (3 appointments). You need to wait one week to book a new appointment.');
         }
+        // The slot was booked by someone else in the meantime
+        if (error.response.status === 409 && Array.isArray(errorMessage.alternatives)) {
+          const suggestions = errorMessage.alternatives
+            .map(slot => `${slot.date} ${slot.time}`)
+            .join(', ');
+          const conflictError = new Error(
+            suggestions
+              ? `This time slot has just been booked. Available alternatives: ${suggestions}`
+              : 'This time slot has just been booked. Please choose another time.'
+          );
+          conflictError.alternatives = errorMessage.alternatives;
+          throw conflictError;
+        }
       }
       throw error;
     }